@@ -1,9 +1,11 @@
 package handler
 
 import (
+	"crypto-wallet/blockchain"
 	"crypto-wallet/config"
 	"crypto-wallet/db"
 	"crypto-wallet/handlers"
+	"crypto-wallet/keymanager"
 	"crypto-wallet/middleware"
 	"log"
 	"net/http"
@@ -27,10 +29,15 @@ func initRouter() {
 	}
 
 	// Initialize genesis block if needed
-	if err := db.InitializeGenesisBlock(); err != nil {
+	if err := blockchain.InitializeGenesisBlock(); err != nil {
 		log.Fatal("Failed to initialize genesis block:", err)
 	}
 
+	// Load the master keys that protect custodial wallet keys
+	if err := keymanager.Init(); err != nil {
+		log.Fatal("Failed to initialize key manager:", err)
+	}
+
 	// Setup Gin router
 	gin.SetMode(gin.ReleaseMode)
 	router = gin.Default()
@@ -62,7 +69,11 @@ func initRouter() {
 			blockchain.GET("/block/:index", handlers.GetBlockByIndex)
 			blockchain.GET("/validate", handlers.ValidateBlockchain)
 			blockchain.GET("/stats", handlers.GetBlockchainStats)
+			blockchain.GET("/supply", handlers.GetSupply)
 		}
+
+		// Public transaction proofs
+		public.GET("/transaction/:txId/proof", handlers.GetTransactionProof)
 	}
 
 	// Protected routes
@@ -74,6 +85,11 @@ func initRouter() {
 		{
 			user.GET("/profile", handlers.GetProfile)
 			user.PUT("/profile", handlers.UpdateProfile)
+			user.GET("/keystore", handlers.ExportKeystore)
+			user.POST("/keystore/import", handlers.ImportKeystore)
+			user.PUT("/passphrase", handlers.ChangePassphrase)
+			user.POST("/custody", handlers.EnableCustody)
+			user.DELETE("/custody", handlers.DisableCustody)
 		}
 
 		// Wallet routes
@@ -82,12 +98,17 @@ func initRouter() {
 			wallet.GET("/balance", handlers.GetMyBalance)
 			wallet.GET("/info", handlers.GetMyWalletInfo)
 			wallet.GET("/utxos", handlers.GetMyUTXOs)
+			wallet.GET("/hd", handlers.GetHDWallets)
+			wallet.POST("/hd/create", handlers.CreateHDWallet)
+			wallet.POST("/hd/restore", handlers.RestoreHDWallet)
 		}
 
 		// Transaction routes
 		transaction := protected.Group("/transaction")
 		{
 			transaction.POST("/send", handlers.SendMoney)
+			transaction.POST("/build", handlers.BuildTransaction)
+			transaction.POST("/submit", handlers.SubmitTransaction)
 			transaction.GET("/history", handlers.GetTransactionHistory)
 			transaction.GET("/pending", handlers.GetPendingTransactions)
 		}
@@ -95,10 +116,21 @@ func initRouter() {
 		// Blockchain protected routes
 		blockchain := protected.Group("/blockchain")
 		{
-			blockchain.POST("/mine", handlers.MineBlock)
+			// A serverless invocation ends with its response, so mining
+			// cannot run as a background job here
+			blockchain.POST("/mine", handlers.MineBlockNow)
 			blockchain.GET("/latest", handlers.GetLatestBlock)
 		}
 
+		// External mining. Templates are held in the instance's memory, so
+		// a solution that reaches another instance gets a 404 and the miner
+		// fetches a new template.
+		mining := protected.Group("/mining")
+		{
+			mining.GET("/template", handlers.GetBlockTemplate)
+			mining.POST("/submit", handlers.SubmitBlock)
+		}
+
 		// Reports routes
 		reports := protected.Group("/reports")
 		{
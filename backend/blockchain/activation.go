@@ -0,0 +1,77 @@
+package blockchain
+
+import (
+	"crypto-wallet/db"
+	"crypto-wallet/models"
+)
+
+// Blocks mined before versioned headers ("legacy" blocks) carry no
+// difficulty target and predate the subsidy, maturity and block limit rules.
+// They stay valid where they are already stored below the activation height;
+// every block received since must be versioned, whatever it claims.
+
+// ActivationHeight returns the height of the first versioned block on the
+// stored chain, or the height of the next block if every stored block is
+// legacy. A chain started from a versioned genesis activates at 0.
+func ActivationHeight() (int, error) {
+	tip, err := db.GetLastBlock()
+	if err != nil {
+		return 0, err
+	}
+	if tip.Version < BlockVersion {
+		return tip.Index + 1, nil
+	}
+
+	// Legacy blocks only ever precede versioned ones, so search for the first
+	lo, hi := 0, tip.Index
+	for lo < hi {
+		mid := (lo + hi) / 2
+		block, err := db.GetBlockByIndex(mid)
+		if err != nil {
+			return 0, err
+		}
+		if block.Version >= BlockVersion {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return lo, nil
+}
+
+// isStoredLegacy reports whether block is a legacy block already stored, on
+// the active chain or a side branch, below the activation height. The legacy
+// hash does not cover the version or difficulty, so those must match too.
+func isStoredLegacy(block models.Block) bool {
+	if block.Version >= BlockVersion {
+		return false
+	}
+	height, err := ActivationHeight()
+	if err != nil || block.Index >= height {
+		return false
+	}
+
+	stored, err := db.GetBlockByHash(block.Hash)
+	if err != nil {
+		side, serr := db.GetSideBlock(block.Hash)
+		if serr != nil {
+			return false
+		}
+		stored = &side.Block
+	}
+	return stored.Index == block.Index &&
+		stored.Version == block.Version &&
+		stored.Bits == block.Bits &&
+		stored.Difficulty == block.Difficulty
+}
+
+// legacyPrefix returns how many blocks at the start of a stored chain are
+// legacy, i.e. the chain's activation height
+func legacyPrefix(blocks []models.Block) int {
+	for i, block := range blocks {
+		if block.Version >= BlockVersion {
+			return i
+		}
+	}
+	return len(blocks)
+}
@@ -0,0 +1,53 @@
+package blockchain
+
+import (
+	"crypto-wallet/crypto"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// WalletAddress encodes a wallet ID as an address of the active network.
+// IDs that are not public key hashes, such as the zakat pool, have no
+// address and are returned unchanged.
+func WalletAddress(walletID string) string {
+	params, err := ActiveNetwork()
+	if err != nil {
+		return walletID
+	}
+	address, err := crypto.EncodeAddress(params.AddressVersion, walletID)
+	if err != nil {
+		return walletID
+	}
+	return address
+}
+
+// ParseWalletID accepts an address of the active network, or a legacy
+// 64-character hex wallet ID, and returns the wallet ID it stands for
+func ParseWalletID(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", errors.New("wallet address is required")
+	}
+	if crypto.IsLegacyWalletID(s) {
+		return strings.ToLower(s), nil
+	}
+
+	version, walletID, err := crypto.DecodeAddress(s)
+	if err != nil {
+		return "", err
+	}
+	params, err := ActiveNetwork()
+	if err != nil {
+		return "", err
+	}
+	if version != params.AddressVersion {
+		for _, other := range Networks {
+			if other.AddressVersion == version {
+				return "", fmt.Errorf("address is for the %s network, not %s", other.Name, params.Name)
+			}
+		}
+		return "", errors.New("unknown address version")
+	}
+	return walletID, nil
+}
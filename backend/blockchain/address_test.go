@@ -0,0 +1,38 @@
+package blockchain
+
+import (
+	"crypto-wallet/crypto"
+	"strings"
+	"testing"
+)
+
+func TestParseWalletIDNetworks(t *testing.T) {
+	newTestChain(t)
+	walletID := strings.Repeat("0f", 32)
+
+	address := WalletAddress(walletID)
+	if !strings.HasPrefix(address, "DW") {
+		t.Fatalf("dev address = %s", address)
+	}
+	for _, s := range []string{address, " " + address + "\n", walletID, strings.ToUpper(walletID)} {
+		if got, err := ParseWalletID(s); err != nil || got != walletID {
+			t.Errorf("ParseWalletID(%q) = %s, %v", s, got, err)
+		}
+	}
+
+	// A valid address for another network is refused by name
+	mainAddress, err := crypto.EncodeAddress(Networks["main"].AddressVersion, walletID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseWalletID(mainAddress); err == nil || !strings.Contains(err.Error(), "main network") {
+		t.Fatalf("main address on dev = %v", err)
+	}
+	unknown, err := crypto.EncodeAddress(0x0001, walletID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseWalletID(unknown); err == nil {
+		t.Fatal("address with an unknown version was accepted")
+	}
+}
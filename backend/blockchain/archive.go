@@ -0,0 +1,227 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"crypto-wallet/models"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// Chain archive file format (integers little-endian):
+//
+//	magic "CWCHAIN\x00" |
+//	record { length u32 | archive header } |
+//	record { length u32 | block (SerializeBlock) } ... |
+//	end marker u32 0 | sha256 of every preceding byte (32 bytes)
+//
+// The archive header is: format version u16 | network str | genesis hash str |
+// block count u64 | tip hash str. Blocks are in chain order from genesis.
+
+// ArchiveFormatVersion is the chain archive format written by ArchiveWriter
+const ArchiveFormatVersion = 1
+
+// maxArchiveRecord bounds a single record read from an archive
+const maxArchiveRecord = 64 << 20
+
+var archiveMagic = []byte("CWCHAIN\x00")
+
+// ArchiveHeader describes the chain stored in an archive
+type ArchiveHeader struct {
+	FormatVersion int    `json:"format_version"`
+	Network       string `json:"network"`
+	GenesisHash   string `json:"genesis_hash"`
+	Blocks        int    `json:"blocks"`
+	TipHash       string `json:"tip_hash"`
+}
+
+// ArchiveWriter streams blocks to a chain archive
+type ArchiveWriter struct {
+	w      *bufio.Writer
+	sum    hash.Hash
+	blocks int
+	header ArchiveHeader
+}
+
+// NewArchiveWriter writes the archive preamble. Close must be called after
+// the last block to write the checksum.
+func NewArchiveWriter(w io.Writer, header ArchiveHeader) (*ArchiveWriter, error) {
+	header.FormatVersion = ArchiveFormatVersion
+	a := &ArchiveWriter{w: bufio.NewWriter(w), sum: sha256.New(), header: header}
+
+	e := &encoder{}
+	e.uint16(uint16(header.FormatVersion))
+	e.string(header.Network)
+	e.string(header.GenesisHash)
+	e.uint64(uint64(header.Blocks))
+	e.string(header.TipHash)
+
+	if err := a.write(archiveMagic); err != nil {
+		return nil, err
+	}
+	if err := a.writeRecord(e.buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// WriteBlock appends the next block of the chain
+func (a *ArchiveWriter) WriteBlock(b models.Block) error {
+	if b.Index != a.blocks {
+		return fmt.Errorf("block %d written at position %d", b.Index, a.blocks)
+	}
+	a.blocks++
+	return a.writeRecord(SerializeBlock(b))
+}
+
+// Close writes the end marker and checksum and flushes the archive. It fails
+// if the number of blocks written differs from the header.
+func (a *ArchiveWriter) Close() error {
+	if a.blocks != a.header.Blocks {
+		return fmt.Errorf("archive header promises %d blocks, %d written", a.header.Blocks, a.blocks)
+	}
+	if err := a.write(binary.LittleEndian.AppendUint32(nil, 0)); err != nil {
+		return err
+	}
+	if _, err := a.w.Write(a.sum.Sum(nil)); err != nil {
+		return err
+	}
+	return a.w.Flush()
+}
+
+func (a *ArchiveWriter) writeRecord(data []byte) error {
+	if err := a.write(binary.LittleEndian.AppendUint32(nil, uint32(len(data)))); err != nil {
+		return err
+	}
+	return a.write(data)
+}
+
+func (a *ArchiveWriter) write(data []byte) error {
+	a.sum.Write(data)
+	_, err := a.w.Write(data)
+	return err
+}
+
+// ArchiveReader reads blocks from a chain archive
+type ArchiveReader struct {
+	Header ArchiveHeader
+
+	r      *bufio.Reader
+	sum    hash.Hash
+	blocks int
+	done   bool
+}
+
+// NewArchiveReader reads and checks the archive preamble
+func NewArchiveReader(r io.Reader) (*ArchiveReader, error) {
+	a := &ArchiveReader{r: bufio.NewReader(r), sum: sha256.New()}
+
+	magic, err := a.read(len(archiveMagic))
+	if err != nil || !bytes.Equal(magic, archiveMagic) {
+		return nil, errors.New("not a chain archive")
+	}
+
+	data, err := a.readRecord()
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, errors.New("archive header is missing")
+	}
+	d := &decoder{r: bytes.NewReader(data)}
+	a.Header.FormatVersion = int(d.uint16())
+	if d.err == nil && a.Header.FormatVersion != ArchiveFormatVersion {
+		return nil, fmt.Errorf("unsupported archive format version %d", a.Header.FormatVersion)
+	}
+	a.Header.Network = d.string()
+	a.Header.GenesisHash = d.string()
+	a.Header.Blocks = int(d.uint64())
+	a.Header.TipHash = d.string()
+	if err := d.finish(); err != nil {
+		return nil, fmt.Errorf("invalid archive header: %w", err)
+	}
+	return a, nil
+}
+
+// Next returns the next block. After the last block it verifies the block
+// count and checksum and returns io.EOF.
+func (a *ArchiveReader) Next() (models.Block, error) {
+	if a.done {
+		return models.Block{}, io.EOF
+	}
+
+	data, err := a.readRecord()
+	if err != nil {
+		return models.Block{}, err
+	}
+	if data == nil {
+		if err := a.finish(); err != nil {
+			return models.Block{}, err
+		}
+		a.done = true
+		return models.Block{}, io.EOF
+	}
+
+	block, err := DeserializeBlock(data)
+	if err != nil {
+		return block, fmt.Errorf("archive block %d: %w", a.blocks, err)
+	}
+	if block.Index != a.blocks {
+		return block, fmt.Errorf("archive block %d has index %d", a.blocks, block.Index)
+	}
+	a.blocks++
+	return block, nil
+}
+
+// finish checks the trailer after the end marker
+func (a *ArchiveReader) finish() error {
+	want := a.sum.Sum(nil)
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(a.r, got); err != nil {
+		return errors.New("archive checksum is missing")
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("archive checksum mismatch: file says %s, contents hash to %s", hex.EncodeToString(got), hex.EncodeToString(want))
+	}
+	if _, err := a.r.ReadByte(); err != io.EOF {
+		return errors.New("unexpected data after archive checksum")
+	}
+	if a.blocks != a.Header.Blocks {
+		return fmt.Errorf("archive header promises %d blocks, found %d", a.Header.Blocks, a.blocks)
+	}
+	return nil
+}
+
+// readRecord reads one length-prefixed record, or nil at the end marker
+func (a *ArchiveReader) readRecord() ([]byte, error) {
+	prefix, err := a.read(4)
+	if err != nil {
+		return nil, errors.New("archive is truncated")
+	}
+	n := binary.LittleEndian.Uint32(prefix)
+	if n == 0 {
+		return nil, nil
+	}
+	if n > maxArchiveRecord {
+		return nil, fmt.Errorf("archive record of %d bytes exceeds the limit", n)
+	}
+	data, err := a.read(int(n))
+	if err != nil {
+		return nil, errors.New("archive is truncated")
+	}
+	return data, nil
+}
+
+func (a *ArchiveReader) read(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(a.r, buf); err != nil {
+		return nil, err
+	}
+	a.sum.Write(buf)
+	return buf, nil
+}
@@ -0,0 +1,61 @@
+package blockchain
+
+import (
+	"crypto-wallet/models"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// MedianTimeSpan is how many blocks the median time past is taken over
+	MedianTimeSpan = 11
+
+	// MaxFutureBlockTime is how far, in seconds, a block's timestamp may be
+	// ahead of the local clock
+	MaxFutureBlockTime = 2 * 60 * 60
+)
+
+// MedianTimePast returns the median timestamp of prev and the blocks before
+// it, up to MedianTimeSpan blocks. A block must be stamped after it, so
+// miners cannot wind the clock back, and retargeting cannot be skewed by a
+// few early timestamps.
+func MedianTimePast(prev models.Block, lookup BlockLookup) (int64, error) {
+	timestamps := []int64{prev.Timestamp}
+	for index := prev.Index - 1; index >= 0 && len(timestamps) < MedianTimeSpan; index-- {
+		block, err := lookup(index)
+		if err != nil {
+			return 0, fmt.Errorf("median time: block %d not found: %w", index, err)
+		}
+		timestamps = append(timestamps, block.Timestamp)
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2], nil
+}
+
+// checkBlockTime returns why a block's timestamp is not after the median
+// time past of prevBlock or too far in the future, or "" if it is acceptable
+func checkBlockTime(block models.Block, prevBlock models.Block, lookup BlockLookup) string {
+	median, err := MedianTimePast(prevBlock, lookup)
+	if err != nil {
+		return err.Error()
+	}
+	if block.Timestamp <= median {
+		return fmt.Sprintf("Block timestamp %d is not after the median time %d of the previous blocks", block.Timestamp, median)
+	}
+	if limit := time.Now().Unix() + MaxFutureBlockTime; block.Timestamp > limit {
+		return fmt.Sprintf("Block timestamp %d is more than %d seconds ahead of the local clock", block.Timestamp, MaxFutureBlockTime)
+	}
+	return ""
+}
+
+// NextBlockTime returns the timestamp for a new block on top of prev: the
+// current time, or just after the median time past if the clock is behind it
+func NextBlockTime(prev models.Block, lookup BlockLookup) (int64, error) {
+	median, err := MedianTimePast(prev, lookup)
+	if err != nil {
+		return 0, err
+	}
+	return max(time.Now().Unix(), median+1), nil
+}
@@ -0,0 +1,109 @@
+package blockchain
+
+import (
+	"crypto-wallet/db"
+	"crypto-wallet/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+// extendChain stores n blocks on top of prev, a minute apart
+func extendChain(t *testing.T, prev models.Block, n int) models.Block {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		block := testBlock(t, prev, testCoinbase(prev.Index+1, 0, "miner"))
+		if err := db.InsertBlock(&block); err != nil {
+			t.Fatal(err)
+		}
+		prev = block
+	}
+	return prev
+}
+
+// restamp gives a block a new timestamp and mines it again
+func restamp(block models.Block, timestamp int64) models.Block {
+	block.Timestamp = timestamp
+	RunProofOfWork(&block)
+	return block
+}
+
+// expectHeaderRejected fails unless the header is rejected with reason
+func expectHeaderRejected(t *testing.T, header, prev models.Block, reason string) {
+	t.Helper()
+	err := ValidateHeader(header, prev, LookupStoredBlock)
+	if err == nil || !strings.Contains(err.Error(), reason) {
+		t.Fatalf("header rejected with %v, want %q", err, reason)
+	}
+}
+
+func TestMedianTimePast(t *testing.T) {
+	genesis := newTestChain(t)
+	tip := extendChain(t, genesis, 12)
+
+	// Blocks 2 to 12 are the last eleven; block 7 is their median
+	median, err := MedianTimePast(tip, LookupStoredBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := genesis.Timestamp + 7*60; median != want {
+		t.Fatalf("median time = %d, want %d", median, want)
+	}
+
+	// Near genesis the median is over the blocks there are
+	if median, err := MedianTimePast(genesis, LookupStoredBlock); err != nil || median != genesis.Timestamp {
+		t.Fatalf("median time at genesis = %d, %v", median, err)
+	}
+}
+
+func TestHeaderTimestampMustPassMedianTime(t *testing.T) {
+	genesis := newTestChain(t)
+	tip := extendChain(t, genesis, 12)
+	median, err := MedianTimePast(tip, LookupStoredBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := testBlock(t, tip, testCoinbase(tip.Index+1, 0, "miner"))
+
+	expectHeaderRejected(t, restamp(next, median), tip, "is not after the median time")
+	expectHeaderRejected(t, restamp(next, genesis.Timestamp), tip, "is not after the median time")
+
+	// Earlier than its parent is still allowed while after the median
+	if err := ValidateHeader(restamp(next, median+1), tip, LookupStoredBlock); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHeaderTimestampMustNotBeFarAhead(t *testing.T) {
+	genesis := newTestChain(t)
+	tip := extendChain(t, genesis, 2)
+	next := testBlock(t, tip, testCoinbase(tip.Index+1, 0, "miner"))
+	now := time.Now().Unix()
+
+	future := restamp(next, now+MaxFutureBlockTime+60)
+	expectHeaderRejected(t, future, tip, "ahead of the local clock")
+	if verr := ValidateBlockWithState(future, tip, LookupStoredBlock, NewStoredUTXOView()); verr == nil {
+		t.Fatal("block from the future was accepted")
+	}
+
+	if err := ValidateHeader(restamp(next, now+MaxFutureBlockTime-60), tip, LookupStoredBlock); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNextBlockTimeFollowsMedianTime(t *testing.T) {
+	genesis := newTestChain(t)
+	tip := extendChain(t, genesis, 12)
+
+	now := time.Now().Unix()
+	if timestamp, err := NextBlockTime(tip, LookupStoredBlock); err != nil || timestamp < now {
+		t.Fatalf("next block time = %d, %v, want the current time", timestamp, err)
+	}
+
+	// A chain stamped ahead of the local clock is built on from its median time
+	ahead := models.Block{Index: tip.Index, Timestamp: now + 3600}
+	lookup := func(index int) (models.Block, error) { return models.Block{Index: index, Timestamp: now + 3600}, nil }
+	if timestamp, err := NextBlockTime(ahead, lookup); err != nil || timestamp != now+3601 {
+		t.Fatalf("next block time = %d, %v, want %d", timestamp, err, now+3601)
+	}
+}
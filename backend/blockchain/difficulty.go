@@ -0,0 +1,210 @@
+package blockchain
+
+import (
+	"crypto-wallet/config"
+	"crypto-wallet/db"
+	"crypto-wallet/models"
+	"fmt"
+	"math/big"
+)
+
+// MaxTarget is the easiest allowed proof-of-work target (one leading zero hex digit)
+var MaxTarget = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 252), big.NewInt(1))
+
+// BlockLookup returns the block at an index on the chain being validated or extended
+type BlockLookup func(index int) (models.Block, error)
+
+// LookupStoredBlock is a BlockLookup over the blocks in the database
+func LookupStoredBlock(index int) (models.Block, error) {
+	block, err := db.GetBlockByIndex(index)
+	if err != nil {
+		return models.Block{}, err
+	}
+	return *block, nil
+}
+
+// CompactToBig expands a compact target ("bits") into a 256-bit number.
+// The top byte is the length in bytes, the low 23 bits are the mantissa.
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	exponent := uint(compact >> 24)
+
+	var target *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		target = big.NewInt(int64(mantissa))
+	} else {
+		target = big.NewInt(int64(mantissa))
+		target.Lsh(target, 8*(exponent-3))
+	}
+
+	if compact&0x00800000 != 0 {
+		target.Neg(target)
+	}
+	return target
+}
+
+// BigToCompact encodes a target in compact form, truncating to 3 significant bytes
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(target.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(new(big.Int).Abs(target).Uint64())
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		shifted := new(big.Int).Rsh(new(big.Int).Abs(target), 8*(exponent-3))
+		mantissa = uint32(shifted.Uint64())
+	}
+
+	// The sign bit must stay clear, so move a high mantissa bit into the exponent
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	compact := uint32(exponent<<24) | mantissa
+	if target.Sign() < 0 {
+		compact |= 0x00800000
+	}
+	return compact
+}
+
+// BitsFromLeadingZeros converts a legacy difficulty (leading zero hex digits) to compact bits
+func BitsFromLeadingZeros(difficulty int) uint32 {
+	if difficulty < 1 {
+		difficulty = 1
+	}
+	if difficulty > 63 {
+		difficulty = 63
+	}
+	target := new(big.Int).Lsh(big.NewInt(1), uint(256-4*difficulty))
+	target.Sub(target, big.NewInt(1))
+	if target.Cmp(MaxTarget) > 0 {
+		target = MaxTarget
+	}
+	return BigToCompact(target)
+}
+
+// InitialBits is the target used for the first block after a legacy chain
+func InitialBits() uint32 {
+	return BitsFromLeadingZeros(config.AppConfig.POWDifficulty)
+}
+
+// HashMeetsTarget reports whether a hex block hash is at or below the target
+func HashMeetsTarget(hash string, bits uint32) bool {
+	value, ok := new(big.Int).SetString(hash, 16)
+	if !ok {
+		return false
+	}
+	target := CompactToBig(bits)
+	return target.Sign() > 0 && value.Cmp(target) <= 0
+}
+
+// NextBits returns the target the block after prev must use. The target is
+// kept for RetargetInterval blocks, then scaled by how long the last interval
+// actually took compared with TargetBlockTime, limited to a factor of 4 either way.
+func NextBits(prev models.Block, lookup BlockLookup) (uint32, error) {
+	// The first targeted block after legacy blocks starts from POW_DIFFICULTY
+	if prev.Bits == 0 {
+		return InitialBits(), nil
+	}
+
+	height := prev.Index + 1
+	interval := config.AppConfig.RetargetInterval
+	if interval <= 0 || height%interval != 0 {
+		return prev.Bits, nil
+	}
+
+	firstIndex := height - interval - 1
+	if firstIndex < 0 {
+		firstIndex = 0
+	}
+	first, err := lookup(firstIndex)
+	if err != nil {
+		return 0, fmt.Errorf("retarget: block %d not found: %w", firstIndex, err)
+	}
+
+	targetSpan := int64(prev.Index-first.Index) * config.AppConfig.TargetBlockTime
+	if targetSpan <= 0 {
+		return prev.Bits, nil
+	}
+	actualSpan := prev.Timestamp - first.Timestamp
+	if actualSpan < targetSpan/4 {
+		actualSpan = targetSpan / 4
+	}
+	if actualSpan > targetSpan*4 {
+		actualSpan = targetSpan * 4
+	}
+
+	target := CompactToBig(prev.Bits)
+	target.Mul(target, big.NewInt(actualSpan))
+	target.Div(target, big.NewInt(targetSpan))
+	if target.Cmp(MaxTarget) > 0 {
+		target = MaxTarget
+	}
+	return BigToCompact(target), nil
+}
+
+// chainLookup is a BlockLookup over a slice of blocks ordered from genesis
+func chainLookup(blocks []models.Block) BlockLookup {
+	return func(index int) (models.Block, error) {
+		if index >= 0 && index < len(blocks) && blocks[index].Index == index {
+			return blocks[index], nil
+		}
+		for _, block := range blocks {
+			if block.Index == index {
+				return block, nil
+			}
+		}
+		return models.Block{}, fmt.Errorf("block %d not in chain", index)
+	}
+}
+
+// BranchLookup is a BlockLookup over the blocks of a chain up to a fork
+// point (ordered from genesis) followed by the blocks of a branch
+func BranchLookup(prefix []models.Block, branch []models.Block) BlockLookup {
+	return func(index int) (models.Block, error) {
+		for _, block := range branch {
+			if block.Index == index {
+				return block, nil
+			}
+		}
+		if index >= 0 && index < len(prefix) && prefix[index].Index == index {
+			return prefix[index], nil
+		}
+		return models.Block{}, fmt.Errorf("block %d not in branch", index)
+	}
+}
+
+// BlockWork is the expected number of hashes needed to mine a block,
+// 2^256 / (target + 1). Legacy blocks use the target implied by their difficulty.
+func BlockWork(block models.Block) *big.Int {
+	var target *big.Int
+	switch {
+	case block.Bits != 0:
+		target = CompactToBig(block.Bits)
+	case block.Difficulty > 0:
+		target = CompactToBig(BitsFromLeadingZeros(block.Difficulty))
+	default:
+		target = MaxTarget
+	}
+	if target.Sign() <= 0 {
+		return new(big.Int)
+	}
+
+	work := new(big.Int).Lsh(big.NewInt(1), 256)
+	return work.Div(work, new(big.Int).Add(target, big.NewInt(1)))
+}
+
+// ChainWork is the cumulative work of a chain of blocks
+func ChainWork(blocks []models.Block) *big.Int {
+	total := new(big.Int)
+	for _, block := range blocks {
+		total.Add(total, BlockWork(block))
+	}
+	return total
+}
@@ -0,0 +1,75 @@
+package blockchain
+
+import (
+	"crypto-wallet/config"
+	"crypto-wallet/models"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// blockHeaderReserve is the part of the block size limit kept free for the
+// header and transaction count when assembling a block
+const blockHeaderReserve = 512
+
+// TransactionSize returns the bytes a transaction occupies in a serialized block
+func TransactionSize(tx models.Transaction) int {
+	n := len(SerializeTransaction(tx))
+	size := len(binary.AppendUvarint(nil, uint64(n))) + n
+	if tx.Version < txVersionCanonical {
+		size += len(binary.AppendUvarint(nil, uint64(len(tx.ID)))) + len(tx.ID)
+	}
+	return size
+}
+
+// FeeRate returns a transaction's fee in smallest units per byte
+func FeeRate(tx models.Transaction) float64 {
+	return float64(tx.Fee) / float64(TransactionSize(tx))
+}
+
+// BlockSpace tracks the room left under the block size and transaction count
+// limits while a block is assembled
+type BlockSpace struct {
+	bytes int
+	txs   int
+}
+
+// NewBlockSpace returns the room available in an empty block
+func NewBlockSpace() *BlockSpace {
+	space := &BlockSpace{bytes: math.MaxInt, txs: math.MaxInt}
+	if max := config.AppConfig.MaxBlockSize; max > 0 {
+		space.bytes = max - blockHeaderReserve
+	}
+	if max := config.AppConfig.MaxBlockTxs; max > 0 {
+		space.txs = max
+	}
+	return space
+}
+
+// Add reserves room for tx, reporting false if it does not fit
+func (s *BlockSpace) Add(tx models.Transaction) bool {
+	size := TransactionSize(tx)
+	if s.txs < 1 || size > s.bytes {
+		return false
+	}
+	s.bytes -= size
+	s.txs--
+	return true
+}
+
+// checkBlockLimits enforces the block size and transaction count limits on
+// every block but stored legacy ones
+func checkBlockLimits(block models.Block, legacy bool) string {
+	if legacy {
+		return ""
+	}
+	if max := config.AppConfig.MaxBlockTxs; max > 0 && len(block.Transactions) > max {
+		return fmt.Sprintf("block has %d transactions, more than the limit of %d", len(block.Transactions), max)
+	}
+	if max := config.AppConfig.MaxBlockSize; max > 0 {
+		if size := len(SerializeBlock(block)); size > max {
+			return fmt.Sprintf("block is %d bytes, more than the limit of %d", size, max)
+		}
+	}
+	return ""
+}
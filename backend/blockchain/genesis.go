@@ -0,0 +1,94 @@
+package blockchain
+
+import (
+	"crypto-wallet/config"
+	"crypto-wallet/db"
+	"crypto-wallet/models"
+	"fmt"
+)
+
+// NetworkParams are the constants that give each network its own genesis
+// block and address prefix
+type NetworkParams struct {
+	Name             string
+	GenesisTimestamp int64
+	GenesisBits      uint32 // Starting proof-of-work target
+	GenesisNonce     int
+	GenesisHash      string // Expected hash, checked when the block is built
+	AddressVersion   uint16 // Address version bytes, chosen so addresses start with a network prefix
+}
+
+// Networks lists the supported networks by name
+var Networks = map[string]NetworkParams{
+	"main": {
+		Name:             "main",
+		GenesisTimestamp: 1767225600, // 2026-01-01 00:00:00 UTC
+		GenesisBits:      0x1f00ffff,
+		GenesisNonce:     14105,
+		GenesisHash:      "0000e90dd40cf3e5893fed59d8f7c8d93883c5a1bc68f0020daf6c01b0758e4c",
+		AddressVersion:   0x4dbe, // "CW..."
+	},
+	"test": {
+		Name:             "test",
+		GenesisTimestamp: 1767225601,
+		GenesisBits:      0x1f0fffff,
+		GenesisNonce:     448,
+		GenesisHash:      "000403cdf64bb31b1ee84c4c1446961a8e938c53b9627118964f0056d3cffe07",
+		AddressVersion:   0xb325, // "TW..."
+	},
+	"dev": {
+		Name:             "dev",
+		GenesisTimestamp: 1767225602,
+		GenesisBits:      0x200fffff,
+		GenesisNonce:     17,
+		GenesisHash:      "01d3482cb7bc7fbab426d4e483b3997f63e5ea4d469161e24ad6a3ed6b52c7ad",
+		AddressVersion:   0x5481, // "DW..."
+	},
+}
+
+// ActiveNetwork returns the parameters of the network selected by NETWORK
+func ActiveNetwork() (NetworkParams, error) {
+	params, ok := Networks[config.AppConfig.Network]
+	if !ok {
+		return NetworkParams{}, fmt.Errorf("unknown network %q", config.AppConfig.Network)
+	}
+	return params, nil
+}
+
+// GenesisBlock builds the hard-coded genesis block of a network. Every node
+// on the network derives the same block and hash.
+func GenesisBlock(params NetworkParams) (models.Block, error) {
+	genesis := models.Block{
+		Version:      BlockVersion,
+		Index:        0,
+		Timestamp:    params.GenesisTimestamp,
+		Transactions: []models.Transaction{},
+		PrevHash:     "0",
+		MerkleRoot:   CalculateMerkleRoot(nil),
+		Bits:         params.GenesisBits,
+		Nonce:        params.GenesisNonce,
+		MinedBy:      "genesis",
+	}
+	genesis.Hash = CalculateHash(genesis)
+
+	if genesis.Hash != params.GenesisHash {
+		return genesis, fmt.Errorf("%s genesis hash mismatch: built %s, expected %s", params.Name, genesis.Hash, params.GenesisHash)
+	}
+	if !MeetsProofOfWork(genesis) {
+		return genesis, fmt.Errorf("%s genesis block does not meet its target", params.Name)
+	}
+	return genesis, nil
+}
+
+// InitializeGenesisBlock stores the active network's genesis block on an empty chain
+func InitializeGenesisBlock() error {
+	params, err := ActiveNetwork()
+	if err != nil {
+		return err
+	}
+	genesis, err := GenesisBlock(params)
+	if err != nil {
+		return err
+	}
+	return db.InitializeGenesisBlock(genesis)
+}
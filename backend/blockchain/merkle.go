@@ -0,0 +1,127 @@
+package blockchain
+
+import (
+	"crypto-wallet/models"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+// MerkleProofStep is one sibling hash on the path from a leaf to the root
+type MerkleProofStep struct {
+	Hash     string `json:"hash"`
+	Position string `json:"position"` // "left" or "right" of the running hash
+}
+
+// MerkleProof proves that a transaction is included under a merkle root
+type MerkleProof struct {
+	TxID       string            `json:"tx_id"`
+	LeafIndex  int               `json:"leaf_index"`
+	Branch     []MerkleProofStep `json:"branch"`
+	MerkleRoot string            `json:"merkle_root"`
+}
+
+// CalculateMerkleRoot calculates the merkle root of all transactions in a block.
+// Leaves are the content-addressed transaction IDs.
+func CalculateMerkleRoot(transactions []models.Transaction) string {
+	if len(transactions) == 0 {
+		return ""
+	}
+
+	levels := merkleLevels(transactionLeaves(transactions))
+	return levels[len(levels)-1][0]
+}
+
+// BuildMerkleProof returns the branch proving txID is part of transactions
+func BuildMerkleProof(transactions []models.Transaction, txID string) (*MerkleProof, error) {
+	leaves := transactionLeaves(transactions)
+
+	index := -1
+	for i, leaf := range leaves {
+		if leaf == txID {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, errors.New("transaction not found in block")
+	}
+
+	levels := merkleLevels(leaves)
+	proof := &MerkleProof{
+		TxID:       txID,
+		LeafIndex:  index,
+		Branch:     []MerkleProofStep{},
+		MerkleRoot: levels[len(levels)-1][0],
+	}
+
+	position := index
+	for _, level := range levels[:len(levels)-1] {
+		if position%2 == 0 {
+			// An odd last node is paired with itself
+			sibling := level[len(level)-1]
+			if position+1 < len(level) {
+				sibling = level[position+1]
+			}
+			proof.Branch = append(proof.Branch, MerkleProofStep{Hash: sibling, Position: "right"})
+		} else {
+			proof.Branch = append(proof.Branch, MerkleProofStep{Hash: level[position-1], Position: "left"})
+		}
+		position /= 2
+	}
+
+	return proof, nil
+}
+
+// VerifyMerkleProof checks that the branch hashes the transaction ID up to the merkle root
+func VerifyMerkleProof(proof MerkleProof) bool {
+	hash := proof.TxID
+	for _, step := range proof.Branch {
+		switch step.Position {
+		case "left":
+			hash = hashMerklePair(step.Hash, hash)
+		case "right":
+			hash = hashMerklePair(hash, step.Hash)
+		default:
+			return false
+		}
+	}
+	return hash == proof.MerkleRoot
+}
+
+func transactionLeaves(transactions []models.Transaction) []string {
+	var leaves []string
+	for _, tx := range transactions {
+		leaves = append(leaves, TransactionID(tx))
+	}
+	return leaves
+}
+
+// merkleLevels builds every level of the tree, from the leaves up to the root
+func merkleLevels(leaves []string) [][]string {
+	levels := [][]string{leaves}
+	hashes := leaves
+
+	for len(hashes) > 1 {
+		var newLevel []string
+
+		for i := 0; i < len(hashes); i += 2 {
+			// If odd number of hashes, duplicate the last one
+			right := hashes[i]
+			if i+1 < len(hashes) {
+				right = hashes[i+1]
+			}
+			newLevel = append(newLevel, hashMerklePair(hashes[i], right))
+		}
+
+		levels = append(levels, newLevel)
+		hashes = newLevel
+	}
+
+	return levels
+}
+
+func hashMerklePair(left, right string) string {
+	hash := sha256.Sum256([]byte(left + right))
+	return hex.EncodeToString(hash[:])
+}
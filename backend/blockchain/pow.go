@@ -1,47 +1,24 @@
 package blockchain
 
 import (
+	"context"
 	"crypto-wallet/models"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
-// CalculateMerkleRoot calculates the merkle root of all transactions in a block
-func CalculateMerkleRoot(transactions []models.Transaction) string {
-	if len(transactions) == 0 {
-		return ""
-	}
-
-	var hashes []string
-	for _, tx := range transactions {
-		hashes = append(hashes, tx.ID)
-	}
-
-	// Build merkle tree
-	for len(hashes) > 1 {
-		var newLevel []string
-		
-		// If odd number of hashes, duplicate the last one
-		if len(hashes)%2 != 0 {
-			hashes = append(hashes, hashes[len(hashes)-1])
-		}
-
-		for i := 0; i < len(hashes); i += 2 {
-			combined := hashes[i] + hashes[i+1]
-			hash := sha256.Sum256([]byte(combined))
-			newLevel = append(newLevel, hex.EncodeToString(hash[:]))
-		}
-		
-		hashes = newLevel
+// CalculateHash computes the SHA256 hash of a block header. Versioned blocks
+// hash the canonical header encoding; legacy blocks keep the original format.
+func CalculateHash(b models.Block) string {
+	if b.Version >= BlockVersion {
+		hash := sha256.Sum256(SerializeBlockHeader(b))
+		return hex.EncodeToString(hash[:])
 	}
 
-	return hashes[0]
-}
-
-// CalculateHash computes the SHA256 hash of a block
-func CalculateHash(b models.Block) string {
 	record := fmt.Sprintf("%d%d%s%s%d%s",
 		b.Index,
 		b.Timestamp,
@@ -57,93 +34,91 @@ func CalculateHash(b models.Block) string {
 
 // RunProofOfWork performs the Proof of Work algorithm
 func RunProofOfWork(b *models.Block) {
-	target := strings.Repeat("0", b.Difficulty)
 	b.Nonce = 0
-	
+
 	for {
 		b.Hash = CalculateHash(*b)
-		if strings.HasPrefix(b.Hash, target) {
+		if MeetsProofOfWork(*b) {
 			break
 		}
 		b.Nonce++
 	}
 }
 
-// ValidateBlock validates a block's hash and structure
-func ValidateBlock(block models.Block, prevBlock models.Block) bool {
-	// Check index
-	if block.Index != prevBlock.Index+1 {
-		return false
+// SolveProofOfWork searches for a nonce on workers goroutines, worker i trying
+// nonces i, i+workers, i+2*workers and so on. hashes, if not nil, is
+// incremented as nonces are tried. On success the block's Nonce and Hash are
+// set; if ctx is cancelled first its error is returned and b is unchanged.
+func SolveProofOfWork(ctx context.Context, b *models.Block, workers int, hashes *atomic.Uint64) error {
+	if workers < 1 {
+		workers = 1
 	}
-
-	// Check previous hash
-	if block.PrevHash != prevBlock.Hash {
-		return false
-	}
-
-	// Check hash validity
-	calculatedHash := CalculateHash(block)
-	if calculatedHash != block.Hash {
-		return false
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	found := make(chan models.Block, 1)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		candidate := *b
+		candidate.Nonce = i
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tried := uint64(0)
+			defer func() {
+				if hashes != nil {
+					hashes.Add(tried % 4096)
+				}
+			}()
+			for ; ; tried++ {
+				// Check for cancellation every few thousand hashes
+				if tried%4096 == 0 && tried > 0 {
+					if hashes != nil {
+						hashes.Add(4096)
+					}
+					if ctx.Err() != nil {
+						return
+					}
+				}
+				candidate.Hash = CalculateHash(candidate)
+				if MeetsProofOfWork(candidate) {
+					tried++
+					select {
+					case found <- candidate:
+						cancel()
+					default:
+					}
+					return
+				}
+				candidate.Nonce += workers
+			}
+		}()
 	}
-
-	// Check proof of work
-	target := strings.Repeat("0", block.Difficulty)
-	if !strings.HasPrefix(block.Hash, target) {
-		return false
+	wg.Wait()
+
+	select {
+	case solved := <-found:
+		b.Nonce = solved.Nonce
+		b.Hash = solved.Hash
+		return nil
+	default:
+		return ctx.Err()
 	}
-
-	return true
 }
 
-// ValidateChain validates the entire blockchain
-func ValidateChain(blocks []models.Block) bool {
-	if len(blocks) == 0 {
-		return false
+// MeetsProofOfWork checks the block hash against its compact target, or the
+// leading-zero difficulty for legacy blocks
+func MeetsProofOfWork(b models.Block) bool {
+	if b.Bits != 0 {
+		return HashMeetsTarget(b.Hash, b.Bits)
 	}
-
-	// Skip genesis block
-	for i := 1; i < len(blocks); i++ {
-		if !ValidateBlock(blocks[i], blocks[i-1]) {
-			return false
-		}
-	}
-
-	return true
+	return strings.HasPrefix(b.Hash, strings.Repeat("0", b.Difficulty))
 }
 
-// ValidateChainWithDetails validates the blockchain and returns the first problematic block index
-// Returns -1 if chain is valid, otherwise returns the index of the first invalid block
-func ValidateChainWithDetails(blocks []models.Block) (bool, int, string) {
-	if len(blocks) == 0 {
-		return false, -1, "Blockchain is empty"
-	}
-
-	// Skip genesis block (index 0)
-	for i := 1; i < len(blocks); i++ {
-		if !ValidateBlock(blocks[i], blocks[i-1]) {
-			reason := ""
-			
-			// Determine the specific reason for failure
-			if blocks[i].Index != blocks[i-1].Index+1 {
-				reason = fmt.Sprintf("Invalid index: expected %d, got %d", blocks[i-1].Index+1, blocks[i].Index)
-			} else if blocks[i].PrevHash != blocks[i-1].Hash {
-				reason = "Previous hash mismatch"
-			} else {
-				calculatedHash := CalculateHash(blocks[i])
-				if calculatedHash != blocks[i].Hash {
-					reason = "Hash mismatch - block has been tampered with"
-				} else {
-					target := strings.Repeat("0", blocks[i].Difficulty)
-					if !strings.HasPrefix(blocks[i].Hash, target) {
-						reason = "Proof of work validation failed"
-					}
-				}
-			}
-			
-			return false, i, reason
-		}
-	}
-
-	return true, -1, "Blockchain is valid"
-}
\ No newline at end of file
+// ValidateBlock validates a block's header, difficulty, proof of work and
+// merkle root against the previous block. lookup resolves earlier blocks for
+// the retarget window. Transactions are checked by ValidateBlockWithState.
+func ValidateBlock(block models.Block, prevBlock models.Block, lookup BlockLookup) bool {
+	return checkBlockHeader(block, prevBlock, lookup, isStoredLegacy(block)) == ""
+}
@@ -0,0 +1,396 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto-wallet/models"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// Serialization versions. Version 0 marks records created before the canonical
+// encoding existed: their IDs and hashes are kept as stored.
+const (
+	TransactionVersion = 3
+	BlockVersion       = 2
+
+	// txVersionCanonical is the first transaction version whose ID is derived
+	// from the canonical encoding; txVersionFee the first committing to a fee;
+	// txVersionInputSig the first signing each input separately
+	txVersionCanonical = 1
+	txVersionFee       = 2
+	txVersionInputSig  = 3
+
+	// blockVersionBits is the first header version carrying a compact target
+	blockVersionBits = 2
+
+	// SigHashAll commits a signature to every input and output of a transaction
+	SigHashAll uint32 = 1
+
+	// maxFieldLength bounds strings and counts read by the decoder
+	maxFieldLength = 1 << 20
+
+	flagWitness byte = 0x01
+)
+
+// Canonical transaction encoding (integers little-endian, str = uvarint length + bytes):
+//
+//	version u16 | flags u8 | type str | is_zakat u8 | sender str | receiver str |
+//	amount i64 | [version >= 2: fee i64] | timestamp i64 | note str |
+//	uvarint n_in  { prev_txid str | vout u32 } |
+//	uvarint n_out { value i64 | pub_key_hash str } |
+//	[flags&1: for each input { signature str | pub_key str }]
+//
+// The ID, and spend state of outputs, are not part of the encoding. The txid
+// and signature hash are computed over the encoding without the witness
+// section, so signing a transaction never changes its ID.
+
+// SerializeTransaction returns the canonical encoding of a transaction including signatures
+func SerializeTransaction(tx models.Transaction) []byte {
+	return encodeTransaction(tx, true)
+}
+
+// DeserializeTransaction decodes a transaction and derives its ID
+func DeserializeTransaction(data []byte) (models.Transaction, error) {
+	d := &decoder{r: bytes.NewReader(data)}
+	var tx models.Transaction
+
+	tx.Version = int(d.uint16())
+	flags := d.byte()
+	if d.err == nil && flags&^flagWitness != 0 {
+		return tx, fmt.Errorf("unknown transaction flags %#x", flags)
+	}
+	tx.Type = d.string()
+	tx.IsZakat = d.bool()
+	tx.SenderID = d.string()
+	tx.ReceiverID = d.string()
+	tx.Amount = models.Amount(d.int64())
+	if tx.Version >= txVersionFee {
+		tx.Fee = models.Amount(d.int64())
+	}
+	tx.Timestamp = d.int64()
+	tx.Note = d.string()
+
+	tx.Vin = make([]models.TXInput, d.count())
+	for i := range tx.Vin {
+		tx.Vin[i].TxID = d.string()
+		tx.Vin[i].Vout = int(d.uint32())
+	}
+	tx.Vout = make([]models.TXOutput, d.count())
+	for i := range tx.Vout {
+		tx.Vout[i].Value = models.Amount(d.int64())
+		tx.Vout[i].PubKeyHash = d.string()
+	}
+
+	if flags&flagWitness != 0 {
+		for i := range tx.Vin {
+			tx.Vin[i].Signature = d.string()
+			tx.Vin[i].PubKey = d.string()
+		}
+	}
+
+	if err := d.finish(); err != nil {
+		return tx, fmt.Errorf("invalid transaction encoding: %w", err)
+	}
+	tx.ID = TransactionID(tx)
+	return tx, nil
+}
+
+// TransactionID returns the content-addressed ID of a transaction. Legacy
+// (version 0) transactions keep the ID they were created with.
+func TransactionID(tx models.Transaction) string {
+	if tx.Version < txVersionCanonical {
+		return tx.ID
+	}
+	hash := sha256.Sum256(encodeTransaction(tx, false))
+	return hex.EncodeToString(hash[:])
+}
+
+// SignatureHash returns the hex digest signed by every input of a version 1
+// or 2 transaction
+func SignatureHash(tx models.Transaction) string {
+	data := encodeTransaction(tx, false)
+	data = binary.LittleEndian.AppendUint32(data, SigHashAll)
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// InputSignatureHash returns the hex digest input index signs. Besides every
+// input and output of the transaction it commits to the position of the input
+// and the owner and value of the output it spends, so a signature cannot be
+// reused for another input.
+func InputSignatureHash(tx models.Transaction, index int, spent models.UTXO) string {
+	e := &encoder{}
+	e.buf.Write(encodeTransaction(tx, false))
+	e.uint32(SigHashAll)
+	e.uint32(uint32(index))
+	e.string(spent.WalletID)
+	e.int64(int64(spent.Amount))
+	hash := sha256.Sum256(e.buf.Bytes())
+	return hex.EncodeToString(hash[:])
+}
+
+func encodeTransaction(tx models.Transaction, withWitness bool) []byte {
+	e := &encoder{}
+	flags := byte(0)
+	if withWitness {
+		flags |= flagWitness
+	}
+
+	e.uint16(uint16(tx.Version))
+	e.byte(flags)
+	e.string(tx.Type)
+	e.bool(tx.IsZakat)
+	e.string(tx.SenderID)
+	e.string(tx.ReceiverID)
+	e.int64(int64(tx.Amount))
+	if tx.Version >= txVersionFee {
+		e.int64(int64(tx.Fee))
+	}
+	e.int64(tx.Timestamp)
+	e.string(tx.Note)
+
+	e.count(len(tx.Vin))
+	for _, input := range tx.Vin {
+		e.string(input.TxID)
+		e.uint32(uint32(input.Vout))
+	}
+	e.count(len(tx.Vout))
+	for _, output := range tx.Vout {
+		e.int64(int64(output.Value))
+		e.string(output.PubKeyHash)
+	}
+
+	if withWitness {
+		for _, input := range tx.Vin {
+			e.string(input.Signature)
+			e.string(input.PubKey)
+		}
+	}
+
+	return e.buf.Bytes()
+}
+
+// Canonical block header encoding:
+//
+//	version u16 | index u64 | timestamp i64 | prev_hash str | merkle_root str |
+//	target u32 | nonce u64 | mined_by str
+//
+// target is the compact bits from version 2, and the leading-zero difficulty in version 1.
+
+// SerializeBlockHeader returns the canonical encoding of a block header
+func SerializeBlockHeader(b models.Block) []byte {
+	e := &encoder{}
+	e.uint16(uint16(b.Version))
+	e.uint64(uint64(b.Index))
+	e.int64(b.Timestamp)
+	e.string(b.PrevHash)
+	e.string(b.MerkleRoot)
+	if b.Version >= blockVersionBits {
+		e.uint32(b.Bits)
+	} else {
+		e.uint32(uint32(b.Difficulty))
+	}
+	e.uint64(uint64(b.Nonce))
+	e.string(b.MinedBy)
+	return e.buf.Bytes()
+}
+
+// HeaderNonceOffset returns the byte offset of the little-endian u64 nonce in
+// a versioned block's serialized header, so external miners can vary it in place
+func HeaderNonceOffset(b models.Block) int {
+	e := &encoder{}
+	e.string(b.MinedBy)
+	return len(SerializeBlockHeader(b)) - e.buf.Len() - 8
+}
+
+// DeserializeBlockHeader decodes a block header (without transactions) and derives its hash
+func DeserializeBlockHeader(data []byte) (models.Block, error) {
+	d := &decoder{r: bytes.NewReader(data)}
+	var b models.Block
+
+	b.Version = int(d.uint16())
+	b.Index = int(d.uint64())
+	b.Timestamp = d.int64()
+	b.PrevHash = d.string()
+	b.MerkleRoot = d.string()
+	if b.Version >= blockVersionBits {
+		b.Bits = d.uint32()
+	} else {
+		b.Difficulty = int(d.uint32())
+	}
+	b.Nonce = int(d.uint64())
+	b.MinedBy = d.string()
+
+	if err := d.finish(); err != nil {
+		return b, fmt.Errorf("invalid block header encoding: %w", err)
+	}
+	b.Hash = CalculateHash(b)
+	return b, nil
+}
+
+// Full block encoding, used for chain archives:
+//
+//	header str | uvarint n_tx { tx str | [version 0: id str] }
+//
+// Legacy transactions carry their stored ID, since it cannot be derived.
+
+// SerializeBlock returns the canonical encoding of a block with its transactions
+func SerializeBlock(b models.Block) []byte {
+	e := &encoder{}
+	e.bytes(SerializeBlockHeader(b))
+	e.count(len(b.Transactions))
+	for _, tx := range b.Transactions {
+		e.bytes(SerializeTransaction(tx))
+		if tx.Version < txVersionCanonical {
+			e.string(tx.ID)
+		}
+	}
+	return e.buf.Bytes()
+}
+
+// DeserializeBlock decodes a block and derives its hash and transaction IDs
+func DeserializeBlock(data []byte) (models.Block, error) {
+	d := &decoder{r: bytes.NewReader(data)}
+
+	header := d.fixed(d.count())
+	if d.err != nil {
+		return models.Block{}, fmt.Errorf("invalid block encoding: %w", d.err)
+	}
+	b, err := DeserializeBlockHeader(header)
+	if err != nil {
+		return b, err
+	}
+
+	b.Transactions = make([]models.Transaction, d.count())
+	for i := range b.Transactions {
+		raw := d.fixed(d.count())
+		if d.err != nil {
+			break
+		}
+		tx, err := DeserializeTransaction(raw)
+		if err != nil {
+			return b, fmt.Errorf("transaction %d: %w", i, err)
+		}
+		if tx.Version < txVersionCanonical {
+			tx.ID = d.string()
+		}
+		b.Transactions[i] = tx
+	}
+
+	if err := d.finish(); err != nil {
+		return b, fmt.Errorf("invalid block encoding: %w", err)
+	}
+	return b, nil
+}
+
+// encoder appends canonical fields to a buffer
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) byte(v byte) { e.buf.WriteByte(v) }
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.byte(1)
+	} else {
+		e.byte(0)
+	}
+}
+
+func (e *encoder) uint16(v uint16) { e.buf.Write(binary.LittleEndian.AppendUint16(nil, v)) }
+func (e *encoder) uint32(v uint32) { e.buf.Write(binary.LittleEndian.AppendUint32(nil, v)) }
+func (e *encoder) uint64(v uint64) { e.buf.Write(binary.LittleEndian.AppendUint64(nil, v)) }
+func (e *encoder) int64(v int64)   { e.uint64(uint64(v)) }
+func (e *encoder) count(n int)     { e.buf.Write(binary.AppendUvarint(nil, uint64(n))) }
+
+func (e *encoder) string(s string) {
+	e.count(len(s))
+	e.buf.WriteString(s)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.count(len(b))
+	e.buf.Write(b)
+}
+
+// decoder reads canonical fields, remembering the first error
+type decoder struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (d *decoder) byte() byte {
+	b, err := d.r.ReadByte()
+	if err != nil && d.err == nil {
+		d.err = errors.New("unexpected end of data")
+	}
+	return b
+}
+
+func (d *decoder) bool() bool {
+	switch v := d.byte(); v {
+	case 0:
+		return false
+	case 1:
+		return true
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("invalid boolean %d", v)
+		}
+		return false
+	}
+}
+
+func (d *decoder) uint16() uint16 { return binary.LittleEndian.Uint16(d.fixed(2)) }
+func (d *decoder) uint32() uint32 { return binary.LittleEndian.Uint32(d.fixed(4)) }
+func (d *decoder) uint64() uint64 { return binary.LittleEndian.Uint64(d.fixed(8)) }
+func (d *decoder) int64() int64   { return int64(d.uint64()) }
+
+// fixed reads exactly n bytes
+func (d *decoder) fixed(n int) []byte {
+	buf := make([]byte, n)
+	if d.err != nil {
+		return buf
+	}
+	if d.r.Len() < n {
+		d.err = errors.New("unexpected end of data")
+		return buf
+	}
+	d.r.Read(buf)
+	return buf
+}
+
+func (d *decoder) count() int {
+	if d.err != nil {
+		return 0
+	}
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		d.err = errors.New("unexpected end of data")
+		return 0
+	}
+	if n > maxFieldLength || n > uint64(d.r.Len()) {
+		d.err = fmt.Errorf("length %d exceeds remaining data", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) string() string {
+	return string(d.fixed(d.count()))
+}
+
+// finish reports the first decoding error, or trailing bytes after the record
+func (d *decoder) finish() error {
+	if d.err != nil {
+		return d.err
+	}
+	if d.r.Len() != 0 {
+		return fmt.Errorf("%d trailing bytes", d.r.Len())
+	}
+	return nil
+}
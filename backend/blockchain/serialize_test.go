@@ -0,0 +1,158 @@
+package blockchain
+
+import (
+	"crypto-wallet/models"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// vectorTx is the transaction behind the golden vectors, at a given version
+func vectorTx(version int) models.Transaction {
+	tx := models.Transaction{
+		Version:    version,
+		Type:       "transfer",
+		SenderID:   "a",
+		ReceiverID: "b",
+		Amount:     5,
+		Timestamp:  1700000000,
+		Vin:        []models.TXInput{{TxID: "t", Vout: 1, Signature: "s", PubKey: "p"}},
+		Vout:       []models.TXOutput{{Value: 4, PubKeyHash: "b"}},
+	}
+	if version >= txVersionFee {
+		tx.Fee = 1
+	}
+	tx.ID = TransactionID(tx)
+	return tx
+}
+
+// vectorHeader is the block header behind the golden vector
+func vectorHeader() models.Block {
+	b := models.Block{
+		Version:    2,
+		Index:      1,
+		Timestamp:  1700000000,
+		PrevHash:   "00ab",
+		MerkleRoot: "cd",
+		Bits:       0x1f0fffff,
+		Nonce:      42,
+		MinedBy:    "m",
+	}
+	b.Hash = CalculateHash(b)
+	return b
+}
+
+// golden joins hex fields, one per encoded value
+func golden(t *testing.T, fields ...string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(strings.Join(fields, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestSerializeTransactionGolden(t *testing.T) {
+	tests := []struct {
+		version int
+		fee     string // empty before txVersionFee
+		id      string
+	}{
+		{1, "", "e7cd3518b09db1ed6f3ce9d911f0b99132caf0d39c87889c8d45cbcf0ac95889"},
+		{2, "0100000000000000", "35ea42e18499fc8aa0e6faaeb5197a726170ab6cced1ba7aeaf9d348eb750bed"},
+		{3, "0100000000000000", "28501d6b42aba743c770d00f47b4bb98bd465b86b58462e60cadf78092d2a516"},
+	}
+
+	for _, tt := range tests {
+		tx := vectorTx(tt.version)
+		want := golden(t,
+			hex.EncodeToString([]byte{byte(tt.version), 0}), // version
+			"01",                     // flags: witness
+			"087472616e73666572",     // type "transfer"
+			"00",                     // is_zakat
+			"0161",                   // sender "a"
+			"0162",                   // receiver "b"
+			"0500000000000000",       // amount
+			tt.fee,                   // fee
+			"00f1536500000000",       // timestamp
+			"00",                     // note ""
+			"01", "0174", "01000000", // one input: txid "t", vout 1
+			"01", "0400000000000000", "0162", // one output: 4 to "b"
+			"0173", "0170", // witness: signature "s", pub key "p"
+		)
+
+		if got := SerializeTransaction(tx); !reflect.DeepEqual(got, want) {
+			t.Errorf("v%d encoding:\n got %x\nwant %x", tt.version, got, want)
+		}
+		if tx.ID != tt.id {
+			t.Errorf("v%d ID = %s, want %s", tt.version, tx.ID, tt.id)
+		}
+	}
+}
+
+func TestSerializeBlockHeaderGolden(t *testing.T) {
+	b := vectorHeader()
+	want := golden(t,
+		"0200",             // version
+		"0100000000000000", // index
+		"00f1536500000000", // timestamp
+		"0430306162",       // prev_hash "00ab"
+		"026364",           // merkle_root "cd"
+		"ffff0f1f",         // bits
+		"2a00000000000000", // nonce
+		"016d",             // mined_by "m"
+	)
+
+	if got := SerializeBlockHeader(b); !reflect.DeepEqual(got, want) {
+		t.Errorf("header encoding:\n got %x\nwant %x", got, want)
+	}
+	if want := "a7abe081ae4648dafe88c9b67b7069bdf8aa2e48bea633042e22f482d0f2b270"; b.Hash != want {
+		t.Errorf("header hash = %s, want %s", b.Hash, want)
+	}
+	if got, want := HeaderNonceOffset(b), len(SerializeBlockHeader(b))-2-8; got != want {
+		t.Errorf("nonce offset = %d, want %d", got, want)
+	}
+}
+
+func TestTransactionRoundTrip(t *testing.T) {
+	for _, version := range []int{1, 2, 3} {
+		tx := vectorTx(version)
+		got, err := DeserializeTransaction(SerializeTransaction(tx))
+		if err != nil {
+			t.Fatalf("v%d: %v", version, err)
+		}
+		if !reflect.DeepEqual(got, tx) {
+			t.Errorf("v%d round trip:\n got %+v\nwant %+v", version, got, tx)
+		}
+	}
+}
+
+func TestBlockRoundTrip(t *testing.T) {
+	legacy := models.Transaction{Type: "transfer", SenderID: "a", ReceiverID: "b", Amount: 3, Vin: []models.TXInput{}, Vout: []models.TXOutput{}}
+	legacy.ID = "legacy-id" // legacy IDs cannot be derived and travel with the block
+
+	b := vectorHeader()
+	b.Transactions = []models.Transaction{vectorTx(3), legacy}
+	b.MerkleRoot = CalculateMerkleRoot(b.Transactions)
+	b.Hash = CalculateHash(b)
+
+	got, err := DeserializeBlock(SerializeBlock(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, b) {
+		t.Errorf("round trip:\n got %+v\nwant %+v", got, b)
+	}
+}
+
+func TestDeserializeRejectsTrailingBytes(t *testing.T) {
+	data := append(SerializeTransaction(vectorTx(3)), 0)
+	if _, err := DeserializeTransaction(data); err == nil {
+		t.Error("transaction with trailing bytes was accepted")
+	}
+	header := append(SerializeBlockHeader(vectorHeader()), 0)
+	if _, err := DeserializeBlockHeader(header); err == nil {
+		t.Error("header with trailing bytes was accepted")
+	}
+}
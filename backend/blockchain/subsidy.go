@@ -0,0 +1,102 @@
+package blockchain
+
+import (
+	"crypto-wallet/config"
+	"crypto-wallet/models"
+	"math"
+)
+
+// Subsidy schedule: the first block after genesis earns MINING_REWARD, and
+// the reward halves every HALVING_INTERVAL blocks until it rounds down to
+// zero. Issuance stops early if MAX_SUPPLY is reached, with the block that
+// crosses the cap earning only what is left.
+
+// halvings returns how many times the subsidy has halved by height
+func halvings(height int) int {
+	interval := config.AppConfig.HalvingInterval
+	if interval <= 0 {
+		return 0
+	}
+	return height / interval
+}
+
+// scheduledReward returns the subsidy for height ignoring the supply cap
+func scheduledReward(height int) models.Amount {
+	n := halvings(height)
+	if n >= 63 {
+		return 0
+	}
+	return config.AppConfig.MiningReward >> n
+}
+
+// ScheduledSupply returns the coins created by the subsidies of every block
+// below height
+func ScheduledSupply(height int) models.Amount {
+	var supply models.Amount
+	interval := config.AppConfig.HalvingInterval
+
+	// Genesis carries no subsidy, so issuance starts at height 1
+	for start := 1; start < height; {
+		reward := scheduledReward(start)
+		if reward == 0 {
+			break
+		}
+		end := height
+		if interval > 0 {
+			if eraEnd := (halvings(start) + 1) * interval; eraEnd < end {
+				end = eraEnd
+			}
+		}
+		supply += models.Amount(end-start) * reward
+		if max := config.AppConfig.MaxSupply; max > 0 && supply >= max {
+			return max
+		}
+		start = end
+	}
+	return supply
+}
+
+// BlockSubsidy returns the new coins the coinbase of the block at height may
+// create on top of the fees it collects
+func BlockSubsidy(height int) models.Amount {
+	if height < 1 {
+		return 0
+	}
+	reward := scheduledReward(height)
+	if max := config.AppConfig.MaxSupply; max > 0 {
+		if remaining := max - ScheduledSupply(height); reward > remaining {
+			reward = remaining
+		}
+	}
+	return reward
+}
+
+// NextHalvingHeight returns the first height above height at which the
+// subsidy halves, or -1 if it never will or issuance has already ended
+func NextHalvingHeight(height int) int {
+	interval := config.AppConfig.HalvingInterval
+	if interval <= 0 || BlockSubsidy(height+1) == 0 {
+		return -1
+	}
+	return (halvings(height) + 1) * interval
+}
+
+// MaxMoney returns the most a single output, or any sum of amounts in a
+// transaction or block, may hold: MAX_SUPPLY if set, otherwise the largest
+// Amount
+func MaxMoney() models.Amount {
+	if max := config.AppConfig.MaxSupply; max > 0 {
+		return max
+	}
+	return math.MaxInt64
+}
+
+// AddAmounts adds two non-negative amounts, reporting false if either is
+// negative or the sum would overflow or exceed MaxMoney
+func AddAmounts(a, b models.Amount) (models.Amount, bool) {
+	max := MaxMoney()
+	if a < 0 || b < 0 || a > max || b > max-a {
+		return 0, false
+	}
+	return a + b, true
+}
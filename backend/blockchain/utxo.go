@@ -1,32 +1,26 @@
 package blockchain
 
 import (
+	"crypto-wallet/config"
 	"crypto-wallet/db"
 	"crypto-wallet/models"
-	"crypto/sha256"
-	"encoding/hex"
 	"errors"
+	"fmt"
 )
 
-// GenerateWalletID generates a wallet ID by hashing the public key
-func GenerateWalletID(pubKey string) string {
-	hash := sha256.Sum256([]byte(pubKey))
-	return hex.EncodeToString(hash[:])
-}
-
 // FindUTXOs finds all unspent transaction outputs for a wallet (from database)
 func FindUTXOs(walletID string) ([]models.UTXO, error) {
 	return db.GetUnspentUTXOs(walletID)
 }
 
 // GetBalance calculates the total balance for a wallet
-func GetBalance(walletID string) (float64, error) {
+func GetBalance(walletID string) (models.Amount, error) {
 	utxos, err := FindUTXOs(walletID)
 	if err != nil {
 		return 0, err
 	}
 
-	balance := 0.0
+	var balance models.Amount
 	for _, utxo := range utxos {
 		if !utxo.IsSpent {
 			balance += utxo.Amount
@@ -35,17 +29,70 @@ func GetBalance(walletID string) (float64, error) {
 	return balance, nil
 }
 
-// SelectUTXOs selects UTXOs to cover the required amount (with change)
-func SelectUTXOs(walletID string, amount float64) ([]models.UTXO, float64, error) {
+// MaturityHeight returns the first block height that may spend a UTXO.
+// Coinbase outputs must wait CoinbaseMaturity blocks; others are spendable
+// in the next block.
+func MaturityHeight(utxo models.UTXO) int {
+	if !utxo.IsCoinbase {
+		return utxo.BlockIndex + 1
+	}
+	return utxo.BlockIndex + max(config.AppConfig.CoinbaseMaturity, 1)
+}
+
+// NextBlockHeight returns the height of the block that would extend the tip
+func NextBlockHeight() (int, error) {
+	lastBlock, err := db.GetLastBlock()
+	if err != nil {
+		return 0, err
+	}
+	return lastBlock.Index + 1, nil
+}
+
+// GetSpendableBalance splits a wallet's balance into the part that can be
+// spent in the next block and mining rewards that have not yet matured
+func GetSpendableBalance(walletID string) (spendable, immature models.Amount, err error) {
+	utxos, err := FindUTXOs(walletID)
+	if err != nil {
+		return 0, 0, err
+	}
+	height, err := NextBlockHeight()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, utxo := range utxos {
+		if utxo.IsSpent {
+			continue
+		}
+		if MaturityHeight(utxo) > height {
+			immature += utxo.Amount
+		} else {
+			spendable += utxo.Amount
+		}
+	}
+	return spendable, immature, nil
+}
+
+// SelectUTXOs selects UTXOs to cover the required amount (with change).
+// Mining rewards that have not matured are skipped.
+func SelectUTXOs(walletID string, amount models.Amount) ([]models.UTXO, models.Amount, error) {
 	utxos, err := FindUTXOs(walletID)
 	if err != nil {
 		return nil, 0, err
 	}
+	height, err := NextBlockHeight()
+	if err != nil {
+		return nil, 0, err
+	}
 
 	var selectedUTXOs []models.UTXO
-	var total float64
+	var total, immature models.Amount
 
 	for _, utxo := range utxos {
+		if !utxo.IsSpent && MaturityHeight(utxo) > height {
+			immature += utxo.Amount
+			continue
+		}
 		// Only select UTXOs that are not spent AND not locked
 		if !utxo.IsSpent && !utxo.IsLocked {
 			selectedUTXOs = append(selectedUTXOs, utxo)
@@ -58,6 +105,9 @@ func SelectUTXOs(walletID string, amount float64) ([]models.UTXO, float64, error
 		}
 	}
 
+	if total+immature >= amount {
+		return nil, 0, fmt.Errorf("insufficient balance - %s of mining rewards have not matured yet", immature)
+	}
 	return nil, 0, errors.New("insufficient balance - all available UTXOs are spent or locked in pending transactions")
 }
 
@@ -81,6 +131,7 @@ func CreateUTXOsFromTransaction(tx models.Transaction, blockIndex int) error {
 			WalletID:   output.PubKeyHash,
 			Amount:     output.Value,
 			IsSpent:    false,
+			IsCoinbase: IsCoinbase(tx),
 			BlockIndex: blockIndex,
 		}
 		
@@ -135,5 +186,33 @@ func ValidateUTXONotSpent(txID string, vout int) error {
 		return errors.New("UTXO already spent - double spend attempt detected")
 	}
 
+	return nil
+}
+
+// SpentOutputs looks up the stored output each input of tx consumes, spent
+// or not, in input order
+func SpentOutputs(tx models.Transaction) ([]models.UTXO, error) {
+	spent := make([]models.UTXO, 0, len(tx.Vin))
+	for _, input := range tx.Vin {
+		utxo, err := db.GetUTXO(input.TxID, input.Vout)
+		if err != nil {
+			return nil, fmt.Errorf("input %s:%d does not exist", input.TxID, input.Vout)
+		}
+		spent = append(spent, *utxo)
+	}
+	return spent, nil
+}
+
+// ValidateUTXOMature checks that a UTXO may be spent in the block at height
+func ValidateUTXOMature(txID string, vout int, height int) error {
+	utxo, err := db.GetUTXO(txID, vout)
+	if err != nil {
+		return errors.New("UTXO not found")
+	}
+
+	if maturity := MaturityHeight(*utxo); maturity > height {
+		return fmt.Errorf("UTXO is an immature mining reward - spendable from block %d", maturity)
+	}
+
 	return nil
 }
\ No newline at end of file
@@ -0,0 +1,447 @@
+package blockchain
+
+import (
+	"crypto-wallet/config"
+	"crypto-wallet/crypto"
+	"crypto-wallet/db"
+	"crypto-wallet/models"
+	"fmt"
+)
+
+// TxValidationError describes why a transaction in a block is invalid
+type TxValidationError struct {
+	Position int    `json:"position"`
+	TxID     string `json:"tx_id"`
+	Reason   string `json:"reason"`
+}
+
+// BlockValidationError describes why a block is invalid. Transaction-level
+// failures are listed individually.
+type BlockValidationError struct {
+	BlockIndex   int                 `json:"block_index"`
+	BlockHash    string              `json:"block_hash"`
+	Reason       string              `json:"reason"`
+	Transactions []TxValidationError `json:"transactions,omitempty"`
+}
+
+func (e *BlockValidationError) Error() string {
+	if len(e.Transactions) == 0 {
+		return fmt.Sprintf("block %d: %s", e.BlockIndex, e.Reason)
+	}
+	first := e.Transactions[0]
+	return fmt.Sprintf("block %d: %s (transaction %s: %s)", e.BlockIndex, e.Reason, first.TxID, first.Reason)
+}
+
+// UTXOView is an in-memory set of unspent outputs used to validate blocks
+// against the state of the chain at their height. A view may fall back to
+// the stored UTXO set for outputs it has not seen.
+type UTXOView struct {
+	outputs map[string]models.UTXO
+	spent   map[string]bool
+	fetch   func(txID string, vout int) (models.UTXO, bool)
+}
+
+// NewUTXOView creates an empty UTXO view
+func NewUTXOView() *UTXOView {
+	return &UTXOView{
+		outputs: make(map[string]models.UTXO),
+		spent:   make(map[string]bool),
+	}
+}
+
+// NewStoredUTXOView creates a view over the UTXO set in the database,
+// i.e. the state at the current tip
+func NewStoredUTXOView() *UTXOView {
+	view := NewUTXOView()
+	view.fetch = func(txID string, vout int) (models.UTXO, bool) {
+		utxo, err := db.GetUTXO(txID, vout)
+		if err != nil || utxo.IsSpent {
+			return models.UTXO{}, false
+		}
+		return *utxo, true
+	}
+	return view
+}
+
+// Output returns an unspent output, if it exists
+func (v *UTXOView) Output(txID string, vout int) (models.UTXO, bool) {
+	key := outpoint(txID, vout)
+	if v.spent[key] {
+		return models.UTXO{}, false
+	}
+	if output, ok := v.outputs[key]; ok {
+		return output, true
+	}
+	if v.fetch != nil {
+		return v.fetch(txID, vout)
+	}
+	return models.UTXO{}, false
+}
+
+// ApplyBlock spends the block's inputs and adds its outputs
+func (v *UTXOView) ApplyBlock(block models.Block) {
+	for _, tx := range block.Transactions {
+		for _, input := range tx.Vin {
+			key := outpoint(input.TxID, input.Vout)
+			delete(v.outputs, key)
+			v.spent[key] = true
+		}
+		for vout, output := range tx.Vout {
+			key := outpoint(tx.ID, vout)
+			v.outputs[key] = models.UTXO{
+				TxID:       tx.ID,
+				Vout:       vout,
+				WalletID:   output.PubKeyHash,
+				Amount:     output.Value,
+				IsCoinbase: IsCoinbase(tx),
+				BlockIndex: block.Index,
+			}
+			delete(v.spent, key)
+		}
+	}
+}
+
+func outpoint(txID string, vout int) string {
+	return fmt.Sprintf("%s:%d", txID, vout)
+}
+
+// IsCoinbase reports whether a transaction is a mining reward
+func IsCoinbase(tx models.Transaction) bool {
+	return tx.Type == "mining_reward" || tx.SenderID == "coinbase"
+}
+
+// VerifyTransactionSignature verifies the signature on every input of a
+// transaction. spent holds the output each input consumes, in input order;
+// the public key of every input must own that output. Since version 3 each
+// input signs its own InputSignatureHash. Earlier versions signed one
+// SignatureHash for all inputs, and legacy transactions a plain string.
+func VerifyTransactionSignature(tx models.Transaction, spent []models.UTXO) error {
+	if len(tx.Vin) == 0 {
+		// Zakat or genesis transactions might not have inputs
+		return nil
+	}
+	if len(spent) != len(tx.Vin) {
+		return fmt.Errorf("transaction has %d inputs but %d spent outputs were given", len(tx.Vin), len(spent))
+	}
+
+	var sharedData string
+	switch {
+	case tx.Version < txVersionCanonical:
+		sharedData = crypto.CreateTransactionSignatureData(
+			tx.SenderID,
+			tx.ReceiverID,
+			tx.Amount,
+			tx.Timestamp,
+			tx.Note,
+		)
+	case tx.Version < txVersionInputSig:
+		sharedData = SignatureHash(tx)
+	}
+
+	for i, input := range tx.Vin {
+		if crypto.GenerateWalletID(input.PubKey) != spent[i].WalletID {
+			return fmt.Errorf("input %d public key does not own the output it spends", i)
+		}
+		signatureData := sharedData
+		if tx.Version >= txVersionInputSig {
+			signatureData = InputSignatureHash(tx, i, spent[i])
+		}
+		if err := crypto.VerifySignature(signatureData, input.Signature, input.PubKey); err != nil {
+			return fmt.Errorf("invalid signature on input %d", i)
+		}
+	}
+	return nil
+}
+
+// ValidateBlockWithState fully validates a block: its header against the
+// previous block, its merkle root, and every transaction against the UTXO
+// view as of the previous block. The view is not modified.
+func ValidateBlockWithState(block models.Block, prevBlock models.Block, lookup BlockLookup, view *UTXOView) *BlockValidationError {
+	return validateBlock(block, prevBlock, lookup, view, isStoredLegacy(block))
+}
+
+// validateBlock is ValidateBlockWithState with the legacy exemption decided
+// by the caller
+func validateBlock(block models.Block, prevBlock models.Block, lookup BlockLookup, view *UTXOView, legacy bool) *BlockValidationError {
+	if reason := checkBlockHeader(block, prevBlock, lookup, legacy); reason != "" {
+		return &BlockValidationError{BlockIndex: block.Index, BlockHash: block.Hash, Reason: reason}
+	}
+	if reason := checkBlockLimits(block, legacy); reason != "" {
+		return &BlockValidationError{BlockIndex: block.Index, BlockHash: block.Hash, Reason: reason}
+	}
+
+	if txErrors := validateBlockTransactions(block, view, legacy); len(txErrors) > 0 {
+		return &BlockValidationError{
+			BlockIndex:   block.Index,
+			BlockHash:    block.Hash,
+			Reason:       fmt.Sprintf("%d invalid transaction(s)", len(txErrors)),
+			Transactions: txErrors,
+		}
+	}
+
+	return nil
+}
+
+// ValidateBlockTransactions checks every transaction in a block against the
+// UTXO view: IDs, signatures, input ownership and value, fees, and coinbase rules
+func ValidateBlockTransactions(block models.Block, view *UTXOView) []TxValidationError {
+	return validateBlockTransactions(block, view, isStoredLegacy(block))
+}
+
+// validateBlockTransactions is ValidateBlockTransactions with the legacy
+// exemption decided by the caller
+func validateBlockTransactions(block models.Block, view *UTXOView, legacy bool) []TxValidationError {
+	var txErrors []TxValidationError
+	spent := make(map[string]bool)
+	seen := make(map[string]bool)
+	coinbases := 0
+	var fees models.Amount
+
+	for position, tx := range block.Transactions {
+		reason := validateTransaction(block, position, tx, view, spent, fees, legacy)
+		if reason == "" && seen[tx.ID] {
+			reason = "duplicate transaction in block"
+		}
+		if reason == "" && IsCoinbase(tx) {
+			coinbases++
+			if coinbases > 1 {
+				reason = "block has more than one coinbase transaction"
+			}
+		}
+		seen[tx.ID] = true
+		if reason == "" && !IsCoinbase(tx) {
+			total, ok := AddAmounts(fees, tx.Fee)
+			if !ok {
+				reason = "block fees exceed the maximum amount"
+			} else {
+				fees = total
+			}
+		}
+
+		if reason != "" {
+			txErrors = append(txErrors, TxValidationError{Position: position, TxID: tx.ID, Reason: reason})
+		}
+	}
+
+	return txErrors
+}
+
+// validateTransaction returns why a transaction is invalid, or "" if it is valid.
+// Inputs it consumes are recorded in spent. fees is the total collected by the
+// transactions before it, which a coinbase may claim. legacy exempts a stored
+// legacy block from the rules it predates.
+func validateTransaction(block models.Block, position int, tx models.Transaction, view *UTXOView, spent map[string]bool, fees models.Amount, legacy bool) string {
+	if tx.ID != TransactionID(tx) {
+		return "transaction ID does not match its contents"
+	}
+	// Legacy transactions sign a plain string that does not commit to their
+	// inputs, so their signatures could be replayed in new blocks
+	if !legacy && tx.Version < txVersionCanonical {
+		return fmt.Sprintf("transaction version %d is not allowed in a version %d block", tx.Version, block.Version)
+	}
+
+	var outputsTotal models.Amount
+	for vout, output := range tx.Vout {
+		if output.Value <= 0 {
+			return fmt.Sprintf("output %d has a non-positive value", vout)
+		}
+		total, ok := AddAmounts(outputsTotal, output.Value)
+		if !ok {
+			return fmt.Sprintf("output %d takes the output total above the maximum amount", vout)
+		}
+		outputsTotal = total
+	}
+
+	switch {
+	case IsCoinbase(tx):
+		if position != len(block.Transactions)-1 {
+			return "coinbase must be the last transaction in the block"
+		}
+		if len(tx.Vin) > 0 {
+			return "coinbase must not have inputs"
+		}
+		// Reward rules apply to every block received since activation; stored
+		// legacy blocks were mined under whatever MINING_REWARD was configured
+		if !legacy {
+			if outputsTotal != tx.Amount {
+				return fmt.Sprintf("coinbase outputs (%s) do not match its amount (%s)", outputsTotal, tx.Amount)
+			}
+			subsidy := BlockSubsidy(block.Index)
+			reward, ok := AddAmounts(subsidy, fees)
+			if !ok {
+				return fmt.Sprintf("block subsidy %s plus fees %s exceeds the maximum amount", subsidy, fees)
+			}
+			if outputsTotal != reward {
+				return fmt.Sprintf("coinbase pays %s, expected the block subsidy %s plus fees %s", outputsTotal, subsidy, fees)
+			}
+		}
+		return ""
+
+	case tx.IsZakat:
+		if len(tx.Vin) > 0 || len(tx.Vout) > 0 {
+			return "zakat deduction must not spend or create outputs"
+		}
+		if tx.Fee != 0 {
+			return "zakat deduction must not pay a fee"
+		}
+		return ""
+	}
+
+	if len(tx.Vin) == 0 {
+		return "transaction has no inputs"
+	}
+
+	var inputsTotal models.Amount
+	spentUTXOs := make([]models.UTXO, 0, len(tx.Vin))
+	for _, input := range tx.Vin {
+		key := outpoint(input.TxID, input.Vout)
+		if spent[key] {
+			return fmt.Sprintf("input %s is spent twice in this block", key)
+		}
+		utxo, ok := view.Output(input.TxID, input.Vout)
+		if !ok {
+			return fmt.Sprintf("input %s does not exist or is already spent", key)
+		}
+		if utxo.WalletID != tx.SenderID {
+			return fmt.Sprintf("input %s is not owned by the sender", key)
+		}
+		// Maturity applies to every block but stored legacy ones, which predate the rule
+		if !legacy {
+			if maturity := MaturityHeight(utxo); maturity > block.Index {
+				return fmt.Sprintf("input %s is a mining reward that cannot be spent before block %d", key, maturity)
+			}
+		}
+		total, ok := AddAmounts(inputsTotal, utxo.Amount)
+		if !ok {
+			return fmt.Sprintf("input %s takes the input total above the maximum amount", key)
+		}
+		inputsTotal = total
+		spentUTXOs = append(spentUTXOs, utxo)
+	}
+
+	if err := VerifyTransactionSignature(tx, spentUTXOs); err != nil {
+		return err.Error()
+	}
+
+	if outputsTotal > inputsTotal {
+		return fmt.Sprintf("outputs (%s) exceed inputs (%s)", outputsTotal, inputsTotal)
+	}
+	if tx.Version >= txVersionCanonical && tx.Fee != inputsTotal-outputsTotal {
+		return fmt.Sprintf("fee (%s) does not equal inputs minus outputs (%s)", tx.Fee, inputsTotal-outputsTotal)
+	}
+
+	for _, input := range tx.Vin {
+		spent[outpoint(input.TxID, input.Vout)] = true
+	}
+	return ""
+}
+
+// CheckBlockHeader validates a block header against its parent without
+// touching transaction state, e.g. for a block on a side branch
+func CheckBlockHeader(block models.Block, prevBlock models.Block, lookup BlockLookup) *BlockValidationError {
+	if reason := checkBlockHeader(block, prevBlock, lookup, isStoredLegacy(block)); reason != "" {
+		return &BlockValidationError{BlockIndex: block.Index, BlockHash: block.Hash, Reason: reason}
+	}
+	return nil
+}
+
+// ValidateHeader checks a header received without its transactions: it must
+// link to prevBlock, carry a valid timestamp and the expected target, and meet it
+func ValidateHeader(header models.Block, prevBlock models.Block, lookup BlockLookup) error {
+	if reason := checkHeaderChain(header, prevBlock, lookup, isStoredLegacy(header)); reason != "" {
+		return &BlockValidationError{BlockIndex: header.Index, BlockHash: header.Hash, Reason: reason}
+	}
+	return nil
+}
+
+// checkBlockHeader returns why a block does not link to prevBlock, use the
+// expected difficulty or commit to its transactions, or "" if the header is valid
+func checkBlockHeader(block models.Block, prevBlock models.Block, lookup BlockLookup, legacy bool) string {
+	if reason := checkHeaderChain(block, prevBlock, lookup, legacy); reason != "" {
+		return reason
+	}
+	if CalculateMerkleRoot(block.Transactions) != block.MerkleRoot {
+		return "Merkle root mismatch - transactions have been tampered with"
+	}
+	return ""
+}
+
+// checkHeaderChain checks the parts of a header that do not depend on its
+// transactions. Only legacy blocks, which are already stored, may go without
+// a version, difficulty target and timestamp rules.
+func checkHeaderChain(block models.Block, prevBlock models.Block, lookup BlockLookup, legacy bool) string {
+	if block.Index != prevBlock.Index+1 {
+		return fmt.Sprintf("Invalid index: expected %d, got %d", prevBlock.Index+1, block.Index)
+	}
+	if block.PrevHash != prevBlock.Hash {
+		return "Previous hash mismatch"
+	}
+	if CalculateHash(block) != block.Hash {
+		return "Hash mismatch - block has been tampered with"
+	}
+	if legacy {
+		if block.Bits != 0 || block.Difficulty < config.AppConfig.POWDifficulty {
+			return fmt.Sprintf("Legacy difficulty %d is below the required %d", block.Difficulty, config.AppConfig.POWDifficulty)
+		}
+	} else {
+		if block.Version < BlockVersion {
+			return fmt.Sprintf("Header version %d is no longer accepted, expected %d", block.Version, BlockVersion)
+		}
+		expected, err := NextBits(prevBlock, lookup)
+		if err != nil {
+			return err.Error()
+		}
+		if block.Bits != expected {
+			return fmt.Sprintf("Unexpected difficulty: expected bits %08x, got %08x", expected, block.Bits)
+		}
+		// Retargeting trusts timestamps, so they must move forward and stay near real time
+		if reason := checkBlockTime(block, prevBlock, lookup); reason != "" {
+			return reason
+		}
+	}
+	if !MeetsProofOfWork(block) {
+		return "Proof of work validation failed"
+	}
+	return ""
+}
+
+// ValidateChain validates the entire blockchain
+func ValidateChain(blocks []models.Block) bool {
+	valid, _, _ := ValidateChainWithDetails(blocks)
+	return valid
+}
+
+// ValidateChainWithDetails fully validates the stored blockchain, replaying
+// UTXO state from genesis. It returns the position of the first invalid block
+// and why it failed, or -1 and nil if the chain is valid.
+func ValidateChainWithDetails(blocks []models.Block) (bool, int, *BlockValidationError) {
+	if len(blocks) == 0 {
+		return false, -1, &BlockValidationError{BlockIndex: -1, Reason: "Blockchain is empty"}
+	}
+	// A versioned chain must start from its network's genesis; a legacy
+	// genesis predates per-network genesis blocks and is kept as stored
+	if blocks[0].Version >= BlockVersion {
+		params, err := ActiveNetwork()
+		if err != nil {
+			return false, 0, &BlockValidationError{BlockIndex: 0, BlockHash: blocks[0].Hash, Reason: err.Error()}
+		}
+		if blocks[0].Hash != params.GenesisHash || CalculateHash(blocks[0]) != blocks[0].Hash {
+			return false, 0, &BlockValidationError{BlockIndex: 0, BlockHash: blocks[0].Hash, Reason: fmt.Sprintf("Genesis block %s is not the %s genesis %s", blocks[0].Hash, params.Name, params.GenesisHash)}
+		}
+	}
+
+	view := NewUTXOView()
+	view.ApplyBlock(blocks[0])
+	lookup := chainLookup(blocks)
+	activation := legacyPrefix(blocks)
+
+	// Skip genesis block (index 0)
+	for i := 1; i < len(blocks); i++ {
+		if verr := validateBlock(blocks[i], blocks[i-1], lookup, view, i < activation); verr != nil {
+			return false, i, verr
+		}
+		view.ApplyBlock(blocks[i])
+	}
+
+	return true, -1, nil
+}
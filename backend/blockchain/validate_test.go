@@ -0,0 +1,295 @@
+package blockchain
+
+import (
+	"crypto-wallet/config"
+	"crypto-wallet/crypto"
+	"crypto-wallet/db"
+	"crypto-wallet/models"
+	"math"
+	"strings"
+	"testing"
+)
+
+// newTestChain starts an in-memory dev chain and returns its genesis block
+func newTestChain(t *testing.T) models.Block {
+	t.Helper()
+	config.AppConfig = &config.Config{
+		StorageBackend:   "memory",
+		Network:          "dev",
+		POWDifficulty:    2,
+		MiningReward:     50 * models.Coin,
+		CoinbaseMaturity: 2,
+		TargetBlockTime:  60,
+		RetargetInterval: 10,
+	}
+	db.SetStore(db.NewMemoryStore())
+	if err := InitializeGenesisBlock(); err != nil {
+		t.Fatal(err)
+	}
+	genesis, err := db.GetLastBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *genesis
+}
+
+// testCoinbase pays the subsidy at height plus fees to walletID
+func testCoinbase(height int, fees models.Amount, walletID string) models.Transaction {
+	reward := BlockSubsidy(height) + fees
+	tx := models.Transaction{
+		Version:   TransactionVersion,
+		Type:      "mining_reward",
+		SenderID:  "coinbase",
+		Amount:    reward,
+		Timestamp: int64(height),
+		Vout:      []models.TXOutput{{Value: reward, PubKeyHash: walletID}},
+	}
+	tx.ID = TransactionID(tx)
+	return tx
+}
+
+// testBlock mines a versioned block with txs on top of prev
+func testBlock(t *testing.T, prev models.Block, txs ...models.Transaction) models.Block {
+	t.Helper()
+	bits, err := NextBits(prev, LookupStoredBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := models.Block{
+		Version:      BlockVersion,
+		Index:        prev.Index + 1,
+		Timestamp:    prev.Timestamp + 60,
+		PrevHash:     prev.Hash,
+		Transactions: txs,
+		Bits:         bits,
+		MerkleRoot:   CalculateMerkleRoot(txs),
+	}
+	RunProofOfWork(&b)
+	return b
+}
+
+// connect stores a block as the new tip and applies it to view
+func connect(t *testing.T, view *UTXOView, block models.Block) {
+	t.Helper()
+	if err := db.InsertBlock(&block); err != nil {
+		t.Fatal(err)
+	}
+	view.ApplyBlock(block)
+}
+
+// testSpend signs a transfer of a whole output from key's wallet to receiver
+func testSpend(t *testing.T, key string, pubKey string, utxo models.UTXO, receiver string) models.Transaction {
+	t.Helper()
+	tx := models.Transaction{
+		Version:    TransactionVersion,
+		Type:       "transfer",
+		SenderID:   utxo.WalletID,
+		ReceiverID: receiver,
+		Amount:     utxo.Amount,
+		Timestamp:  1,
+		Vin:        []models.TXInput{{TxID: utxo.TxID, Vout: utxo.Vout, PubKey: pubKey}},
+		Vout:       []models.TXOutput{{Value: utxo.Amount, PubKeyHash: receiver}},
+	}
+	signature, err := crypto.SignData(InputSignatureHash(tx, 0, utxo), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Vin[0].Signature = signature
+	tx.ID = TransactionID(tx)
+	return tx
+}
+
+// expectInvalid fails unless verr has a transaction error containing reason
+func expectInvalid(t *testing.T, verr *BlockValidationError, reason string) {
+	t.Helper()
+	if verr == nil {
+		t.Fatalf("block was accepted, want %q", reason)
+	}
+	for _, txErr := range verr.Transactions {
+		if strings.Contains(txErr.Reason, reason) {
+			return
+		}
+	}
+	t.Fatalf("block rejected with %v, want %q", verr, reason)
+}
+
+func TestValidateBlockAcceptsSubsidy(t *testing.T) {
+	genesis := newTestChain(t)
+	block := testBlock(t, genesis, testCoinbase(1, 0, "miner"))
+
+	if verr := ValidateBlockWithState(block, genesis, LookupStoredBlock, NewUTXOView()); verr != nil {
+		t.Fatal(verr)
+	}
+}
+
+func TestValidateBlockRejectsCoinbaseOverpay(t *testing.T) {
+	genesis := newTestChain(t)
+	block := testBlock(t, genesis, testCoinbase(1, 1, "miner"))
+
+	expectInvalid(t, ValidateBlockWithState(block, genesis, LookupStoredBlock, NewUTXOView()), "expected the block subsidy")
+}
+
+func TestValidateBlockRejectsOutputOverflow(t *testing.T) {
+	genesis := newTestChain(t)
+	coinbase := testCoinbase(1, 0, "miner")
+	coinbase.Vout = []models.TXOutput{{Value: math.MaxInt64, PubKeyHash: "miner"}, {Value: 1, PubKeyHash: "miner"}}
+	coinbase.ID = TransactionID(coinbase)
+	block := testBlock(t, genesis, coinbase)
+
+	expectInvalid(t, ValidateBlockWithState(block, genesis, LookupStoredBlock, NewUTXOView()), "output 1 takes the output total above the maximum amount")
+}
+
+func TestValidateBlockRejectsTamperedTransactions(t *testing.T) {
+	genesis := newTestChain(t)
+	block := testBlock(t, genesis, testCoinbase(1, 0, "miner"))
+	block.Transactions[0].Note = "tampered"
+	block.Transactions[0].ID = TransactionID(block.Transactions[0])
+
+	verr := ValidateBlockWithState(block, genesis, LookupStoredBlock, NewUTXOView())
+	if verr == nil || !strings.Contains(verr.Reason, "Merkle root mismatch") {
+		t.Fatalf("tampered block rejected with %v, want a merkle root mismatch", verr)
+	}
+}
+
+func TestValidateBlockRejectsLegacyTransaction(t *testing.T) {
+	genesis := newTestChain(t)
+	legacy := models.Transaction{
+		Type:       "transfer",
+		SenderID:   "a",
+		ReceiverID: "b",
+		Amount:     1,
+		Vin:        []models.TXInput{{TxID: "t", Vout: 0}},
+		Vout:       []models.TXOutput{{Value: 1, PubKeyHash: "b"}},
+	}
+	legacy.ID = TransactionID(legacy)
+	block := testBlock(t, genesis, legacy, testCoinbase(1, 0, "miner"))
+
+	expectInvalid(t, ValidateBlockWithState(block, genesis, LookupStoredBlock, NewUTXOView()), "transaction version 0 is not allowed")
+}
+
+func TestValidateBlockCoinbaseMaturity(t *testing.T) {
+	genesis := newTestChain(t)
+	priv, pub, err := crypto.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := crypto.PublicKeyToString(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := crypto.PrivateKeyToString(priv)
+	wallet := crypto.GenerateWalletID(pubKey)
+
+	view := NewUTXOView()
+	reward := testCoinbase(1, 0, wallet)
+	first := testBlock(t, genesis, reward)
+	connect(t, view, first)
+	utxo, ok := view.Output(reward.ID, 0)
+	if !ok {
+		t.Fatal("coinbase output missing from view")
+	}
+	spend := testSpend(t, key, pubKey, utxo, "bob")
+
+	// Maturity 2: the reward at height 1 is spendable from height 3
+	immature := testBlock(t, first, spend, testCoinbase(2, 0, "miner"))
+	expectInvalid(t, ValidateBlockWithState(immature, first, LookupStoredBlock, view), "cannot be spent before block 3")
+
+	second := testBlock(t, first, testCoinbase(2, 0, "miner"))
+	connect(t, view, second)
+	mature := testBlock(t, second, spend, testCoinbase(3, 0, "miner"))
+	if verr := ValidateBlockWithState(mature, second, LookupStoredBlock, view); verr != nil {
+		t.Fatal(verr)
+	}
+}
+
+func TestValidateChainRejectsForeignGenesis(t *testing.T) {
+	genesis := newTestChain(t)
+	chain := []models.Block{genesis, testBlock(t, genesis, testCoinbase(1, 0, "miner"))}
+	if valid, index, verr := ValidateChainWithDetails(chain); !valid {
+		t.Fatalf("chain rejected at %d: %v", index, verr)
+	}
+
+	// Another network's genesis is well formed but not this chain's
+	foreign, err := GenesisBlock(Networks["test"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain = []models.Block{foreign, testBlock(t, foreign, testCoinbase(1, 0, "miner"))}
+	if valid, index, verr := ValidateChainWithDetails(chain); valid || index != 0 || verr == nil {
+		t.Fatalf("foreign genesis = %v, %d, %v", valid, index, verr)
+	}
+
+	tampered := genesis
+	tampered.Nonce++
+	if valid, index, _ := ValidateChainWithDetails([]models.Block{tampered}); valid || index != 0 {
+		t.Fatalf("tampered genesis = %v, %d", valid, index)
+	}
+}
+
+func TestLegacyBlocksOnlyValidWhenStored(t *testing.T) {
+	newTestChain(t)
+	if err := db.DeleteBlocksFromIndex(0); err != nil {
+		t.Fatal(err)
+	}
+
+	// A legacy chain: no version or bits, a fixed difficulty and a reward
+	// above today's subsidy
+	legacyReward := func(height int) models.Transaction {
+		tx := models.Transaction{Type: "mining_reward", SenderID: "coinbase", Amount: 100 * models.Coin, Timestamp: int64(height),
+			Vout: []models.TXOutput{{Value: 100 * models.Coin, PubKeyHash: "old"}}}
+		tx.ID = TransactionID(tx)
+		return tx
+	}
+	prev := models.Block{Index: 0, Timestamp: 1, PrevHash: "0", Difficulty: 2}
+	prev.Hash = CalculateHash(prev)
+	if err := db.InsertBlock(&prev); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 2; i++ {
+		txs := []models.Transaction{legacyReward(i)}
+		b := models.Block{Index: i, Timestamp: prev.Timestamp + 60, PrevHash: prev.Hash, Transactions: txs, Difficulty: 2, MerkleRoot: CalculateMerkleRoot(txs)}
+		RunProofOfWork(&b)
+		if err := db.InsertBlock(&b); err != nil {
+			t.Fatal(err)
+		}
+		prev = b
+	}
+
+	if height, err := ActivationHeight(); err != nil || height != 3 {
+		t.Fatalf("activation height = %d, %v, want 3", height, err)
+	}
+	blocks, err := db.GetAllBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid, index, verr := ValidateChainWithDetails(blocks); !valid {
+		t.Fatalf("stored legacy chain rejected at %d: %v", index, verr)
+	}
+
+	// The same kind of block received now is held to the current rules
+	txs := []models.Transaction{legacyReward(3)}
+	received := models.Block{Index: 3, Timestamp: prev.Timestamp + 60, PrevHash: prev.Hash, Transactions: txs, Difficulty: 2, MerkleRoot: CalculateMerkleRoot(txs)}
+	RunProofOfWork(&received)
+	if verr := ValidateBlockWithState(received, prev, LookupStoredBlock, NewStoredUTXOView()); verr == nil {
+		t.Fatal("new legacy block was accepted")
+	}
+
+	// The first versioned block activates the rules at its height
+	versioned := testBlock(t, prev, testCoinbase(3, 0, "miner"))
+	if verr := ValidateBlockWithState(versioned, prev, LookupStoredBlock, NewStoredUTXOView()); verr != nil {
+		t.Fatal(verr)
+	}
+	if err := db.InsertBlock(&versioned); err != nil {
+		t.Fatal(err)
+	}
+	if height, err := ActivationHeight(); err != nil || height != 3 {
+		t.Fatalf("activation height = %d, %v, want 3", height, err)
+	}
+	blocks, err = db.GetAllBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid, index, verr := ValidateChainWithDetails(blocks); !valid {
+		t.Fatalf("chain rejected at %d: %v", index, verr)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto-wallet/crypto"
+	"crypto-wallet/db"
+	"crypto-wallet/keymanager"
+	"crypto-wallet/services"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// runCommand executes a maintenance command given on the command line instead
+// of starting the server. It returns false when no command was given.
+//
+//	go run . reindex [-dry-run]
+//	go run . export <file>
+//	go run . import <file>
+//	go run . rotate-master-key
+//	go run . hd-account [-words 12] [-mnemonic "..."] [-passphrase "..."]
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	// hd-account is meant to run offline on the user's own machine
+	if args[0] == "hd-account" {
+		runHDAccount(args[1:])
+		return true
+	}
+
+	if err := db.ConnectDB(); err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+	defer db.DisconnectDB()
+
+	switch args[0] {
+	case "reindex":
+		fs := flag.NewFlagSet("reindex", flag.ExitOnError)
+		dryRun := fs.Bool("dry-run", false, "only report discrepancies, do not modify the database")
+		fs.Parse(args[1:])
+
+		report, err := services.ReindexUTXOs(*dryRun)
+		if err != nil {
+			log.Fatal("Reindex failed:", err)
+		}
+		printJSON(report)
+	case "export":
+		path := commandFile(args)
+		file, err := os.Create(path)
+		if err != nil {
+			log.Fatal("Export failed:", err)
+		}
+		report, err := services.ExportChain(file)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(path)
+			log.Fatal("Export failed:", err)
+		}
+		printJSON(report)
+	case "import":
+		file, err := os.Open(commandFile(args))
+		if err != nil {
+			log.Fatal("Import failed:", err)
+		}
+		defer file.Close()
+		report, err := services.ImportChain(file)
+		if report != nil {
+			printJSON(report)
+		}
+		if err != nil {
+			log.Fatal("Import failed:", err)
+		}
+	case "rotate-master-key":
+		if err := keymanager.Init(); err != nil {
+			log.Fatal("Failed to initialize key manager:", err)
+		}
+		report, err := services.RotateMasterKey()
+		if err != nil {
+			log.Fatal("Rotation failed:", err)
+		}
+		printJSON(report)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		fmt.Fprintln(os.Stderr, "available commands: reindex, export, import, rotate-master-key, hd-account")
+		os.Exit(2)
+	}
+
+	return true
+}
+
+// runHDAccount generates a mnemonic, or takes an existing one, and prints the
+// account xpub that /wallet/hd/create and /wallet/hd/restore expect. The
+// mnemonic never leaves this process.
+func runHDAccount(args []string) {
+	fs := flag.NewFlagSet("hd-account", flag.ExitOnError)
+	words := fs.Int("words", 12, "mnemonic length for a new account: 12 or 24")
+	mnemonic := fs.String("mnemonic", "", "derive from an existing mnemonic instead of generating one")
+	passphrase := fs.String("passphrase", "", "optional BIP39 passphrase")
+	fs.Parse(args)
+
+	if *mnemonic == "" {
+		generated, err := crypto.NewMnemonic(*words)
+		if err != nil {
+			log.Fatal("Failed to generate mnemonic:", err)
+		}
+		*mnemonic = generated
+	}
+	accountKey, err := crypto.DeriveAccountKey(*mnemonic, *passphrase, 0)
+	if err != nil {
+		log.Fatal("Failed to derive account key:", err)
+	}
+
+	printJSON(map[string]string{
+		"mnemonic":     *mnemonic,
+		"account_key":  accountKey.Neuter().String(),
+		"account_path": crypto.HDAccountPath(0),
+	})
+}
+
+// commandFile returns the file argument of an export or import command
+func commandFile(args []string) string {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "usage: %s <file>\n", args[0])
+		os.Exit(2)
+	}
+	return args[1]
+}
+
+func printJSON(v interface{}) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(out))
+}
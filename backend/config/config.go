@@ -1,15 +1,19 @@
 package config
 
 import (
+	"crypto-wallet/models"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
 	Port              string
+	StorageBackend    string // "mongo" (default) or "memory"
+	Network           string // "main" (default), "test" or "dev"; selects the genesis block
 	MongoDBURI        string
 	DBName            string
 	JWTSecret         string
@@ -17,12 +21,35 @@ type Config struct {
 	SMTPPort          int
 	SMTPUser          string
 	SMTPPassword      string
-	POWDifficulty     int
-	MiningReward      float64
+	POWDifficulty     int   // Starting difficulty in leading zero hex digits
+	TargetBlockTime   int64 // Seconds between blocks that retargeting aims for
+	RetargetInterval  int   // Blocks between difficulty adjustments
+	MiningReward      models.Amount // Block subsidy before the first halving
+	HalvingInterval   int           // Blocks between subsidy halvings; 0 disables halving
+	MaxSupply         models.Amount // Most coins the subsidy schedule will ever create; 0 for no cap
+	CoinbaseMaturity  int           // Blocks before a mining reward can be spent; 0 disables the rule
+	MiningWorkers     int   // Proof-of-work goroutines per mining job; 0 uses every CPU core
+	MiningTimeout     int64 // Seconds before a mining job is given up; 0 disables the limit
+	MaxBlockSize      int   // Largest serialized block in bytes
+	MaxBlockTxs       int   // Most transactions in a block, including the coinbase
+	HDGapLimit        int   // Unused HD wallets in a row that end a chain scan
 	ZakatPercentage   float64
 	ZakatWalletID     string
-	AESEncryptionKey  string
+	AESEncryptionKey  string // Seeds the local key manager's first master key
+	KeyManager        string // "local" (default) or an external KMS registered with keymanager.RegisterKMS
+	MasterKeyFile     string // Keyring file of the local key manager; empty keeps it in memory
+	KMSKeyID          string // Master key of an external KMS
+	KeystoreKDF       string // KDF for new key keystores: "argon2id" (default) or "scrypt"
+	KeystoreArgon2Time    int // Argon2id passes
+	KeystoreArgon2Memory  int // Argon2id memory in KiB
+	KeystoreArgon2Threads int // Argon2id parallelism
+	KeystoreScryptN   int // scrypt CPU/memory cost, a power of two
+	KeystoreScryptR   int // scrypt block size
+	KeystoreScryptP   int // scrypt parallelism
 	GoogleClientID    string
+	P2PListenAddr     string   // TCP address for peer connections; empty disables listening
+	P2PPeers          []string // Peers to connect to at startup
+	AdminEmails       []string // Verified users allowed to run node operations under /api/admin
 }
 
 var AppConfig *Config
@@ -33,11 +60,35 @@ func LoadConfig() {
 
 	smtpPort, _ := strconv.Atoi(getEnv("SMTP_PORT", "587"))
 	powDifficulty, _ := strconv.Atoi(getEnv("POW_DIFFICULTY", "4"))
-	miningReward, _ := strconv.ParseFloat(getEnv("MINING_REWARD", "50.0"), 64)
+	targetBlockTime, _ := strconv.ParseInt(getEnv("TARGET_BLOCK_TIME", "60"), 10, 64)
+	retargetInterval, _ := strconv.Atoi(getEnv("RETARGET_INTERVAL", "10"))
+	miningReward, err := models.ParseAmount(getEnv("MINING_REWARD", "50.0"))
+	if err != nil {
+		log.Fatalf("Invalid MINING_REWARD: %v", err)
+	}
+	halvingInterval, _ := strconv.Atoi(getEnv("HALVING_INTERVAL", "210000"))
+	maxSupply, err := models.ParseAmount(getEnv("MAX_SUPPLY", "21000000"))
+	if err != nil {
+		log.Fatalf("Invalid MAX_SUPPLY: %v", err)
+	}
+	coinbaseMaturity, _ := strconv.Atoi(getEnv("COINBASE_MATURITY", "100"))
+	miningWorkers, _ := strconv.Atoi(getEnv("MINING_WORKERS", "0"))
+	miningTimeout, _ := strconv.ParseInt(getEnv("MINING_TIMEOUT", "600"), 10, 64)
+	maxBlockSize, _ := strconv.Atoi(getEnv("MAX_BLOCK_SIZE", "1000000"))
+	maxBlockTxs, _ := strconv.Atoi(getEnv("MAX_BLOCK_TXS", "2000"))
+	hdGapLimit, _ := strconv.Atoi(getEnv("HD_GAP_LIMIT", "20"))
+	keystoreArgon2Time, _ := strconv.Atoi(getEnv("KEYSTORE_ARGON2_TIME", "3"))
+	keystoreArgon2Memory, _ := strconv.Atoi(getEnv("KEYSTORE_ARGON2_MEMORY", "65536"))
+	keystoreArgon2Threads, _ := strconv.Atoi(getEnv("KEYSTORE_ARGON2_THREADS", "4"))
+	keystoreScryptN, _ := strconv.Atoi(getEnv("KEYSTORE_SCRYPT_N", "32768"))
+	keystoreScryptR, _ := strconv.Atoi(getEnv("KEYSTORE_SCRYPT_R", "8"))
+	keystoreScryptP, _ := strconv.Atoi(getEnv("KEYSTORE_SCRYPT_P", "1"))
 	zakatPercentage, _ := strconv.ParseFloat(getEnv("ZAKAT_PERCENTAGE", "2.5"), 64)
 
 	AppConfig = &Config{
 		Port:              getEnv("PORT", "8080"),
+		StorageBackend:    getEnv("STORAGE_BACKEND", "mongo"),
+		Network:           getEnv("NETWORK", "main"),
 		MongoDBURI:        getEnv("MONGODB_URI", ""),
 		DBName:            getEnv("DB_NAME", "crypto_wallet"),
 		JWTSecret:         getEnv("JWT_SECRET", "default-secret-key-change-me"),
@@ -46,14 +97,37 @@ func LoadConfig() {
 		SMTPUser:          getEnv("SMTP_USER", ""),
 		SMTPPassword:      getEnv("SMTP_PASSWORD", ""),
 		POWDifficulty:     powDifficulty,
+		TargetBlockTime:   targetBlockTime,
+		RetargetInterval:  retargetInterval,
 		MiningReward:      miningReward,
+		HalvingInterval:   halvingInterval,
+		MaxSupply:         maxSupply,
+		CoinbaseMaturity:  coinbaseMaturity,
+		MiningWorkers:     miningWorkers,
+		MiningTimeout:     miningTimeout,
+		MaxBlockSize:      maxBlockSize,
+		MaxBlockTxs:       maxBlockTxs,
+		HDGapLimit:        hdGapLimit,
 		ZakatPercentage:   zakatPercentage,
 		ZakatWalletID:     getEnv("ZAKAT_WALLET_ID", "zakat_pool_wallet"),
 		AESEncryptionKey:  getEnv("AES_ENCRYPTION_KEY", "change-this-32-char-key-prod!"),
+		KeyManager:        getEnv("KEY_MANAGER", "local"),
+		MasterKeyFile:     getEnv("MASTER_KEY_FILE", ""),
+		KMSKeyID:          getEnv("KMS_KEY_ID", ""),
+		KeystoreKDF:       getEnv("KEYSTORE_KDF", "argon2id"),
+		KeystoreArgon2Time:    keystoreArgon2Time,
+		KeystoreArgon2Memory:  keystoreArgon2Memory,
+		KeystoreArgon2Threads: keystoreArgon2Threads,
+		KeystoreScryptN:   keystoreScryptN,
+		KeystoreScryptR:   keystoreScryptR,
+		KeystoreScryptP:   keystoreScryptP,
 		GoogleClientID:    getEnv("GOOGLE_CLIENT_ID", ""),
+		P2PListenAddr:     getEnv("P2P_LISTEN_ADDR", ""),
+		P2PPeers:          splitList(getEnv("P2P_PEERS", "")),
+		AdminEmails:       splitList(getEnv("ADMIN_EMAILS", "")),
 	}
 
-	if AppConfig.MongoDBURI == "" {
+	if AppConfig.StorageBackend != "memory" && AppConfig.MongoDBURI == "" {
 		log.Fatal("MONGODB_URI is required in environment variables")
 	}
 
@@ -67,3 +141,14 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// splitList parses a comma-separated list, ignoring empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
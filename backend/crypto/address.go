@@ -0,0 +1,51 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// Addresses are the human-facing form of a wallet ID: a two-byte version,
+// which names the network and address format, and the 32-byte wallet ID,
+// base58check encoded. The checksum catches typos before coins are sent to
+// a wallet nobody owns.
+
+const walletIDLen = 32
+
+// EncodeAddress encodes a hex wallet ID as an address with the given version
+func EncodeAddress(version uint16, walletID string) (string, error) {
+	if !IsLegacyWalletID(walletID) {
+		return "", errors.New("wallet ID must be 64 hex characters")
+	}
+	hash, _ := hex.DecodeString(walletID)
+
+	data := binary.BigEndian.AppendUint16(nil, version)
+	return base58CheckEncode(append(data, hash...)), nil
+}
+
+// DecodeAddress returns the version and hex wallet ID of an address
+func DecodeAddress(address string) (uint16, string, error) {
+	data, err := base58CheckDecode(strings.TrimSpace(address))
+	if errors.Is(err, errBadChecksum) {
+		return 0, "", errors.New("invalid address checksum, check the address for typos")
+	}
+	if err != nil {
+		return 0, "", errors.New("invalid address: " + err.Error())
+	}
+	if len(data) != 2+walletIDLen {
+		return 0, "", errors.New("invalid address length")
+	}
+	return binary.BigEndian.Uint16(data[:2]), hex.EncodeToString(data[2:]), nil
+}
+
+// IsLegacyWalletID reports whether s is a bare 64-character hex wallet ID,
+// the form wallet IDs are stored in
+func IsLegacyWalletID(s string) bool {
+	if len(s) != 2*walletIDLen {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
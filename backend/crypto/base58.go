@@ -0,0 +1,86 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// base58Alphabet is the Bitcoin alphabet, which leaves out 0, O, I and l
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var errBadChecksum = errors.New("checksum mismatch")
+
+// base58Encode encodes data in base58, keeping leading zero bytes as '1'
+func base58Encode(data []byte) string {
+	n := new(big.Int).SetBytes(data)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+// base58Decode decodes a base58 string
+func base58Decode(s string) ([]byte, error) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for i := 0; i < len(s); i++ {
+		digit := bytes.IndexByte([]byte(base58Alphabet), s[i])
+		if digit < 0 {
+			return nil, errors.New("invalid base58 character")
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(digit)))
+	}
+
+	var zeros int
+	for zeros < len(s) && s[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
+
+// base58CheckEncode appends the first four bytes of the double SHA-256 of
+// data and encodes the result in base58
+func base58CheckEncode(data []byte) string {
+	checksum := doubleSHA256(data)
+	return base58Encode(append(append([]byte{}, data...), checksum[:4]...))
+}
+
+// base58CheckDecode decodes a base58check string and verifies its checksum
+func base58CheckDecode(s string) ([]byte, error) {
+	decoded, err := base58Decode(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) < 4 {
+		return nil, errors.New("base58check string too short")
+	}
+	data, checksum := decoded[:len(decoded)-4], decoded[len(decoded)-4:]
+	expected := doubleSHA256(data)
+	if !bytes.Equal(checksum, expected[:4]) {
+		return nil, errBadChecksum
+	}
+	return data, nil
+}
+
+func doubleSHA256(data []byte) [32]byte {
+	first := sha256.Sum256(data)
+	return sha256.Sum256(first[:])
+}
@@ -0,0 +1,98 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestBase58Vectors(t *testing.T) {
+	tests := []struct {
+		hex     string
+		encoded string
+	}{
+		{"", ""},
+		{"68656c6c6f20776f726c64", "StV1DL6CwTryKyV"},
+		{"0000287fb4cd", "11233QC4"},
+		{"000000", "111"},
+	}
+	for _, tt := range tests {
+		data, _ := hex.DecodeString(tt.hex)
+		if got := base58Encode(data); got != tt.encoded {
+			t.Errorf("encode %s = %q, want %q", tt.hex, got, tt.encoded)
+		}
+		decoded, err := base58Decode(tt.encoded)
+		if err != nil || !bytes.Equal(decoded, data) {
+			t.Errorf("decode %q = %x, %v, want %s", tt.encoded, decoded, err, tt.hex)
+		}
+	}
+	if _, err := base58Decode("0OIl"); err == nil {
+		t.Fatal("characters outside the alphabet were accepted")
+	}
+}
+
+func TestBase58CheckRoundTrip(t *testing.T) {
+	// The first Bitcoin address: version 0 and a hash160, so the leading
+	// zero byte must survive as a leading '1'
+	data, _ := hex.DecodeString("0062e907b15cbf27d5425399ebf6f0fb50ebb88f18")
+	encoded := base58CheckEncode(data)
+	if encoded != "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa" {
+		t.Fatalf("encoded = %s", encoded)
+	}
+	decoded, err := base58CheckDecode(encoded)
+	if err != nil || !bytes.Equal(decoded, data) {
+		t.Fatalf("decoded = %x, %v", decoded, err)
+	}
+
+	for _, zeros := range []int{1, 2, 5} {
+		data := append(make([]byte, zeros), 0xff, 0x01)
+		decoded, err := base58CheckDecode(base58CheckEncode(data))
+		if err != nil || !bytes.Equal(decoded, data) {
+			t.Errorf("%d leading zeros: decoded = %x, %v", zeros, decoded, err)
+		}
+	}
+}
+
+func TestBase58CheckRejectsCorruption(t *testing.T) {
+	encoded := []byte(base58CheckEncode([]byte("wallet")))
+	for i := range encoded {
+		corrupted := append([]byte{}, encoded...)
+		if corrupted[i] == '2' {
+			corrupted[i] = '3'
+		} else {
+			corrupted[i] = '2'
+		}
+		if _, err := base58CheckDecode(string(corrupted)); err == nil {
+			t.Errorf("changing character %d of %s was not detected", i, encoded)
+		}
+	}
+	if _, err := base58CheckDecode("111"); err == nil {
+		t.Fatal("string shorter than a checksum was accepted")
+	}
+}
+
+func TestAddressRoundTrip(t *testing.T) {
+	walletID := "00" + hex.EncodeToString(bytes.Repeat([]byte{0xab}, walletIDLen-1))
+	address, err := EncodeAddress(0x5481, walletID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, decoded, err := DecodeAddress(address)
+	if err != nil || version != 0x5481 || decoded != walletID {
+		t.Fatalf("decoded = %04x, %s, %v", version, decoded, err)
+	}
+
+	typo := []byte(address)
+	if typo[10] == 'z' {
+		typo[10] = 'y'
+	} else {
+		typo[10] = 'z'
+	}
+	if _, _, err := DecodeAddress(string(typo)); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("typo = %v, want a checksum error", err)
+	}
+	if _, err := EncodeAddress(0x5481, "abc"); err == nil {
+		t.Fatal("short wallet ID was encoded")
+	}
+}
@@ -1,7 +1,7 @@
 package crypto
 
 import (
-	"crypto"
+	"crypto-wallet/models"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
@@ -84,57 +84,34 @@ func GenerateWalletID(publicKeyStr string) string {
 	return hex.EncodeToString(hash[:])
 }
 
-// SignData signs data with private key and returns base64 encoded signature
+// SignData signs data with a private key of any supported type and returns
+// the base64 encoded signature
 func SignData(data string, privateKeyStr string) (string, error) {
-	privateKey, err := StringToPrivateKey(privateKeyStr)
+	privateKey, err := ParsePrivateKey(privateKeyStr)
 	if err != nil {
 		return "", err
 	}
-
-	// Hash the data
-	hashed := sha256.Sum256([]byte(data))
-
-	// Sign the hash
-	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, hashed[:])
-	if err != nil {
-		return "", err
-	}
-
-	// Return base64 encoded signature
-	return base64.StdEncoding.EncodeToString(signature), nil
+	return privateKey.Sign(data)
 }
 
-// VerifySignature verifies a signature against data and public key
+// VerifySignature verifies a signature against data and a public key of any
+// supported type
 func VerifySignature(data string, signatureStr string, publicKeyStr string) error {
-	publicKey, err := StringToPublicKey(publicKeyStr)
+	publicKey, err := ParsePublicKey(publicKeyStr)
 	if err != nil {
 		return err
 	}
-
-	// Decode the signature from base64
-	signature, err := base64.StdEncoding.DecodeString(signatureStr)
-	if err != nil {
-		return err
-	}
-
-	// Hash the data
-	hashed := sha256.Sum256([]byte(data))
-
-	// Verify the signature
-	err = rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hashed[:], signature)
-	if err != nil {
-		return errors.New("signature verification failed")
-	}
-
-	return nil
+	return publicKey.Verify(data, signatureStr)
 }
 
 // CreateTransactionSignatureData creates the data string to be signed for a transaction
-func CreateTransactionSignatureData(senderID, receiverID string, amount float64, timestamp int64, note string) string {
-	return fmt.Sprintf("%s%s%.8f%d%s", senderID, receiverID, amount, timestamp, note)
+func CreateTransactionSignatureData(senderID, receiverID string, amount models.Amount, timestamp int64, note string) string {
+	return fmt.Sprintf("%s%s%s%d%s", senderID, receiverID, amount, timestamp, note)
 }
 
-// EncryptPrivateKey encrypts private key with AES for secure storage
+// EncryptPrivateKey encrypts private key with AES keyed by SHA-256 of the
+// passphrase. This is the legacy storage format; new keys are stored with
+// EncryptKeystore.
 func EncryptPrivateKey(privateKeyStr string, passphrase string) (string, error) {
 	// Create a key from passphrase
 	key := sha256.Sum256([]byte(passphrase))
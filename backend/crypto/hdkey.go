@@ -0,0 +1,313 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"golang.org/x/crypto/ripemd160"
+)
+
+// HardenedKeyStart is the first hardened child index. Hardened children
+// can only be derived from a private key.
+const HardenedKeyStart uint32 = 0x80000000
+
+// BIP44 path levels used for HD wallets: m/44'/1'/account'/chain/index.
+// Coin type 1 is the value registered for test networks of every coin.
+const (
+	HDPurpose       = 44
+	HDCoinType      = 1
+	HDExternalChain = 0 // receiving wallets
+	HDInternalChain = 1 // change wallets
+)
+
+// Serialized extended key versions, giving the familiar xprv/xpub prefixes
+var (
+	hdPrivateVersion = []byte{0x04, 0x88, 0xad, 0xe4}
+	hdPublicVersion  = []byte{0x04, 0x88, 0xb2, 0x1e}
+)
+
+const hdSerializedLen = 78
+
+var errDeriveInvalidKey = errors.New("derived key is invalid, use the next index")
+
+// HDKey is a BIP32 extended secp256k1 key: a private or public key plus
+// the chain code needed to derive its children
+type HDKey struct {
+	key       []byte // 32-byte private key or 33-byte compressed public key
+	chainCode []byte
+	depth     uint8
+	parentFP  []byte
+	index     uint32
+	private   bool
+}
+
+// NewMasterKey derives the master extended private key from a seed
+func NewMasterKey(seed []byte) (*HDKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, errors.New("seed must be between 16 and 64 bytes")
+	}
+
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+
+	var scalar secp256k1.ModNScalar
+	if overflow := scalar.SetByteSlice(sum[:32]); overflow || scalar.IsZero() {
+		return nil, errors.New("seed produces an invalid master key")
+	}
+	return &HDKey{
+		key:       sum[:32],
+		chainCode: sum[32:],
+		parentFP:  make([]byte, 4),
+		private:   true,
+	}, nil
+}
+
+// IsPrivate reports whether the key can sign and derive hardened children
+func (k *HDKey) IsPrivate() bool {
+	return k.private
+}
+
+// Depth returns the number of derivation steps from the master key
+func (k *HDKey) Depth() uint8 {
+	return k.depth
+}
+
+// publicKeyBytes returns the compressed public key
+func (k *HDKey) publicKeyBytes() []byte {
+	if !k.private {
+		return k.key
+	}
+	return secp256k1.PrivKeyFromBytes(k.key).PubKey().SerializeCompressed()
+}
+
+// Child derives the child key at index. Indexes from HardenedKeyStart up
+// are hardened.
+func (k *HDKey) Child(index uint32) (*HDKey, error) {
+	hardened := index >= HardenedKeyStart
+	if hardened && !k.private {
+		return nil, errors.New("cannot derive a hardened child from a public key")
+	}
+
+	data := make([]byte, 0, 37)
+	if hardened {
+		data = append(data, 0x00)
+		data = append(data, k.key...)
+	} else {
+		data = append(data, k.publicKeyBytes()...)
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	var tweak secp256k1.ModNScalar
+	if overflow := tweak.SetByteSlice(sum[:32]); overflow {
+		return nil, errDeriveInvalidKey
+	}
+
+	child := &HDKey{
+		chainCode: sum[32:],
+		depth:     k.depth + 1,
+		parentFP:  hash160(k.publicKeyBytes())[:4],
+		index:     index,
+		private:   k.private,
+	}
+
+	if k.private {
+		// child = parse256(IL) + kpar (mod n)
+		var parent secp256k1.ModNScalar
+		parent.SetByteSlice(k.key)
+		parent.Add(&tweak)
+		if parent.IsZero() {
+			return nil, errDeriveInvalidKey
+		}
+		key := parent.Bytes()
+		child.key = key[:]
+		return child, nil
+	}
+
+	// child = point(parse256(IL)) + Kpar
+	parentKey, err := secp256k1.ParsePubKey(k.key)
+	if err != nil {
+		return nil, err
+	}
+	var tweakPoint, parentPoint, result secp256k1.JacobianPoint
+	secp256k1.ScalarBaseMultNonConst(&tweak, &tweakPoint)
+	parentKey.AsJacobian(&parentPoint)
+	secp256k1.AddNonConst(&tweakPoint, &parentPoint, &result)
+	if result.Z.IsZero() {
+		return nil, errDeriveInvalidKey
+	}
+	result.ToAffine()
+	child.key = secp256k1.NewPublicKey(&result.X, &result.Y).SerializeCompressed()
+	return child, nil
+}
+
+// DerivePath derives a descendant from a path such as "m/44'/1'/0'/0/5".
+// A trailing ' or h marks a hardened index. The path is relative to k, so
+// "m" refers to k itself.
+func (k *HDKey) DerivePath(path string) (*HDKey, error) {
+	parts := strings.Split(strings.TrimSpace(path), "/")
+	if parts[0] != "m" {
+		return nil, fmt.Errorf("derivation path %q must start with m", path)
+	}
+
+	key := k
+	for _, part := range parts[1:] {
+		hardened := strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h")
+		part = strings.TrimRight(part, "'h")
+		index, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || uint32(index) >= HardenedKeyStart {
+			return nil, fmt.Errorf("invalid index %q in derivation path", part)
+		}
+		if hardened {
+			index += uint64(HardenedKeyStart)
+		}
+		if key, err = key.Child(uint32(index)); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// Neuter returns the extended public key, which derives the same
+// non-hardened public children but cannot sign
+func (k *HDKey) Neuter() *HDKey {
+	if !k.private {
+		return k
+	}
+	return &HDKey{
+		key:       k.publicKeyBytes(),
+		chainCode: k.chainCode,
+		depth:     k.depth,
+		parentFP:  k.parentFP,
+		index:     k.index,
+	}
+}
+
+// PrivateKey returns the signing key, in the same form as a generated
+// secp256k1 wallet key
+func (k *HDKey) PrivateKey() (PrivateKey, error) {
+	if !k.private {
+		return nil, errors.New("extended key is public")
+	}
+	return secp256k1PrivateKey{secp256k1.PrivKeyFromBytes(k.key)}, nil
+}
+
+// PublicKey returns the verifying key
+func (k *HDKey) PublicKey() PublicKey {
+	publicKey, _ := secp256k1.ParsePubKey(k.publicKeyBytes())
+	return secp256k1PublicKey{publicKey}
+}
+
+// WalletID returns the wallet ID of the key's public key
+func (k *HDKey) WalletID() string {
+	return GenerateWalletID(k.PublicKey().String())
+}
+
+// String serializes the key in the BIP32 xprv/xpub format
+func (k *HDKey) String() string {
+	data := make([]byte, 0, hdSerializedLen)
+	if k.private {
+		data = append(data, hdPrivateVersion...)
+	} else {
+		data = append(data, hdPublicVersion...)
+	}
+	data = append(data, k.depth)
+	data = append(data, k.parentFP...)
+	data = binary.BigEndian.AppendUint32(data, k.index)
+	data = append(data, k.chainCode...)
+	if k.private {
+		data = append(data, 0x00)
+	}
+	data = append(data, k.key...)
+	return base58CheckEncode(data)
+}
+
+// ParseHDKey parses an xprv or xpub string
+func ParseHDKey(s string) (*HDKey, error) {
+	data, err := base58CheckDecode(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid extended key: %w", err)
+	}
+	if len(data) != hdSerializedLen {
+		return nil, errors.New("invalid extended key length")
+	}
+
+	version, keyData := data[:4], data[45:]
+	k := &HDKey{
+		depth:     data[4],
+		parentFP:  data[5:9],
+		index:     binary.BigEndian.Uint32(data[9:13]),
+		chainCode: data[13:45],
+	}
+	switch {
+	case bytes.Equal(version, hdPrivateVersion):
+		var scalar secp256k1.ModNScalar
+		if keyData[0] != 0x00 || scalar.SetByteSlice(keyData[1:]) || scalar.IsZero() {
+			return nil, errors.New("invalid extended private key")
+		}
+		k.key = keyData[1:]
+		k.private = true
+	case bytes.Equal(version, hdPublicVersion):
+		if _, err := secp256k1.ParsePubKey(keyData); err != nil {
+			return nil, errors.New("invalid extended public key")
+		}
+		k.key = keyData
+	default:
+		return nil, errors.New("unknown extended key version")
+	}
+	return k, nil
+}
+
+// HDAccountPath returns the BIP44 path of an account
+func HDAccountPath(account uint32) string {
+	return fmt.Sprintf("m/%d'/%d'/%d'", HDPurpose, HDCoinType, account)
+}
+
+// HDWalletPath returns the BIP44 path of a wallet within an account
+func HDWalletPath(account, chain, index uint32) string {
+	return fmt.Sprintf("%s/%d/%d", HDAccountPath(account), chain, index)
+}
+
+// DeriveAccountKey derives an account's extended private key from a BIP39
+// mnemonic and passphrase
+func DeriveAccountKey(mnemonic, passphrase string, account uint32) (*HDKey, error) {
+	seed, err := MnemonicToSeed(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	return master.DerivePath(HDAccountPath(account))
+}
+
+// DeriveWallet derives the key of a wallet from an account key. Given the
+// account xpub it yields the public key only, which is all the server needs
+// to find the wallet on chain.
+func DeriveWallet(accountKey *HDKey, chain, index uint32) (*HDKey, error) {
+	chainKey, err := accountKey.Child(chain)
+	if err != nil {
+		return nil, err
+	}
+	return chainKey.Child(index)
+}
+
+func hash160(data []byte) []byte {
+	sha := sha256.Sum256(data)
+	h := ripemd160.New()
+	h.Write(sha[:])
+	return h.Sum(nil)
+}
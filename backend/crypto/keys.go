@@ -0,0 +1,285 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+// KeyType names a signature algorithm a wallet key can use
+type KeyType string
+
+const (
+	KeyTypeRSA       KeyType = "rsa"       // RSA-2048, PKCS #1 v1.5 signatures over SHA-256
+	KeyTypeSecp256k1 KeyType = "secp256k1" // ECDSA on secp256k1 over SHA-256, DER signatures
+	KeyTypeEd25519   KeyType = "ed25519"   // Ed25519 over the raw message
+)
+
+// Key string formats. RSA keys stay PEM encoded so existing wallets keep
+// their keys and wallet IDs; other keys are "<type>:<hex>", e.g.
+// "secp256k1:02a1..." for a compressed public key or "ed25519:<seed>" for a
+// private key. Signatures are base64 for every key type.
+
+// PrivateKey signs data for one key type
+type PrivateKey interface {
+	Type() KeyType
+	Public() PublicKey
+	Sign(data string) (string, error)
+	String() string
+}
+
+// PublicKey verifies signatures for one key type
+type PublicKey interface {
+	Type() KeyType
+	Verify(data string, signature string) error
+	String() string
+}
+
+// ParseKeyType parses a key type name. An empty name selects RSA.
+func ParseKeyType(name string) (KeyType, error) {
+	switch t := KeyType(strings.ToLower(name)); t {
+	case "":
+		return KeyTypeRSA, nil
+	case KeyTypeRSA, KeyTypeSecp256k1, KeyTypeEd25519:
+		return t, nil
+	}
+	return "", fmt.Errorf("unsupported key type %q (use rsa, secp256k1 or ed25519)", name)
+}
+
+// SignatureScheme describes how signatures are made with a key type
+func SignatureScheme(t KeyType) string {
+	switch t {
+	case KeyTypeSecp256k1:
+		return "ecdsa-secp256k1-sha256-der"
+	case KeyTypeEd25519:
+		return "ed25519"
+	}
+	return "rsa-pkcs1v15-sha256"
+}
+
+// GenerateKey creates a new private key of the given type
+func GenerateKey(t KeyType) (PrivateKey, error) {
+	switch t {
+	case KeyTypeRSA:
+		privateKey, _, err := GenerateKeyPair()
+		if err != nil {
+			return nil, err
+		}
+		return rsaPrivateKey{privateKey}, nil
+	case KeyTypeSecp256k1:
+		privateKey, err := secp256k1.GeneratePrivateKey()
+		if err != nil {
+			return nil, err
+		}
+		return secp256k1PrivateKey{privateKey}, nil
+	case KeyTypeEd25519:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return ed25519PrivateKey{privateKey}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", t)
+}
+
+// ParsePrivateKey decodes a private key string of any supported type
+func ParsePrivateKey(privateKeyStr string) (PrivateKey, error) {
+	t, data, err := splitTaggedKey(privateKeyStr)
+	if err != nil {
+		return nil, err
+	}
+
+	switch t {
+	case KeyTypeRSA:
+		privateKey, err := StringToPrivateKey(privateKeyStr)
+		if err != nil {
+			return nil, err
+		}
+		return rsaPrivateKey{privateKey}, nil
+	case KeyTypeSecp256k1:
+		if len(data) != secp256k1.PrivKeyBytesLen {
+			return nil, errors.New("secp256k1 private key must be 32 bytes")
+		}
+		return secp256k1PrivateKey{secp256k1.PrivKeyFromBytes(data)}, nil
+	case KeyTypeEd25519:
+		if len(data) != ed25519.SeedSize {
+			return nil, errors.New("ed25519 private key must be a 32-byte seed")
+		}
+		return ed25519PrivateKey{ed25519.NewKeyFromSeed(data)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", t)
+}
+
+// ParsePublicKey decodes a public key string of any supported type
+func ParsePublicKey(publicKeyStr string) (PublicKey, error) {
+	t, data, err := splitTaggedKey(publicKeyStr)
+	if err != nil {
+		return nil, err
+	}
+
+	switch t {
+	case KeyTypeRSA:
+		publicKey, err := StringToPublicKey(publicKeyStr)
+		if err != nil {
+			return nil, err
+		}
+		return rsaPublicKey{publicKey}, nil
+	case KeyTypeSecp256k1:
+		publicKey, err := secp256k1.ParsePubKey(data)
+		if err != nil {
+			return nil, err
+		}
+		return secp256k1PublicKey{publicKey}, nil
+	case KeyTypeEd25519:
+		if len(data) != ed25519.PublicKeySize {
+			return nil, errors.New("ed25519 public key must be 32 bytes")
+		}
+		return ed25519PublicKey{ed25519.PublicKey(data)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", t)
+}
+
+// KeyTypeOf returns the type of a public or private key string without
+// fully parsing it
+func KeyTypeOf(keyStr string) KeyType {
+	t, _, err := splitTaggedKey(keyStr)
+	if err != nil {
+		return ""
+	}
+	return t
+}
+
+// splitTaggedKey returns a key's type and, for tagged keys, its decoded bytes
+func splitTaggedKey(keyStr string) (KeyType, []byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(keyStr), "-----BEGIN") {
+		return KeyTypeRSA, nil, nil
+	}
+	tag, encoded, ok := strings.Cut(keyStr, ":")
+	if !ok {
+		return "", nil, errors.New("key has no type tag")
+	}
+	t := KeyType(tag)
+	if t != KeyTypeSecp256k1 && t != KeyTypeEd25519 {
+		return "", nil, fmt.Errorf("unsupported key type %q", tag)
+	}
+	data, err := hex.DecodeString(encoded)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid %s key encoding", t)
+	}
+	return t, data, nil
+}
+
+func taggedKey(t KeyType, data []byte) string {
+	return string(t) + ":" + hex.EncodeToString(data)
+}
+
+type rsaPrivateKey struct{ key *rsa.PrivateKey }
+
+func (k rsaPrivateKey) Type() KeyType     { return KeyTypeRSA }
+func (k rsaPrivateKey) Public() PublicKey { return rsaPublicKey{&k.key.PublicKey} }
+func (k rsaPrivateKey) String() string    { return PrivateKeyToString(k.key) }
+
+func (k rsaPrivateKey) Sign(data string) (string, error) {
+	hashed := sha256.Sum256([]byte(data))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, k.key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+type rsaPublicKey struct{ key *rsa.PublicKey }
+
+func (k rsaPublicKey) Type() KeyType { return KeyTypeRSA }
+
+func (k rsaPublicKey) String() string {
+	s, _ := PublicKeyToString(k.key)
+	return s
+}
+
+func (k rsaPublicKey) Verify(data string, signatureStr string) error {
+	signature, err := base64.StdEncoding.DecodeString(signatureStr)
+	if err != nil {
+		return err
+	}
+	hashed := sha256.Sum256([]byte(data))
+	if err := rsa.VerifyPKCS1v15(k.key, crypto.SHA256, hashed[:], signature); err != nil {
+		return errors.New("signature verification failed")
+	}
+	return nil
+}
+
+type secp256k1PrivateKey struct{ key *secp256k1.PrivateKey }
+
+func (k secp256k1PrivateKey) Type() KeyType     { return KeyTypeSecp256k1 }
+func (k secp256k1PrivateKey) Public() PublicKey { return secp256k1PublicKey{k.key.PubKey()} }
+func (k secp256k1PrivateKey) String() string    { return taggedKey(KeyTypeSecp256k1, k.key.Serialize()) }
+
+func (k secp256k1PrivateKey) Sign(data string) (string, error) {
+	hashed := sha256.Sum256([]byte(data))
+	signature := ecdsa.Sign(k.key, hashed[:])
+	return base64.StdEncoding.EncodeToString(signature.Serialize()), nil
+}
+
+type secp256k1PublicKey struct{ key *secp256k1.PublicKey }
+
+func (k secp256k1PublicKey) Type() KeyType { return KeyTypeSecp256k1 }
+func (k secp256k1PublicKey) String() string {
+	return taggedKey(KeyTypeSecp256k1, k.key.SerializeCompressed())
+}
+
+func (k secp256k1PublicKey) Verify(data string, signatureStr string) error {
+	der, err := base64.StdEncoding.DecodeString(signatureStr)
+	if err != nil {
+		return err
+	}
+	signature, err := ecdsa.ParseDERSignature(der)
+	if err != nil {
+		return err
+	}
+	hashed := sha256.Sum256([]byte(data))
+	if !signature.Verify(hashed[:], k.key) {
+		return errors.New("signature verification failed")
+	}
+	return nil
+}
+
+type ed25519PrivateKey struct{ key ed25519.PrivateKey }
+
+func (k ed25519PrivateKey) Type() KeyType { return KeyTypeEd25519 }
+
+func (k ed25519PrivateKey) Public() PublicKey {
+	return ed25519PublicKey{k.key.Public().(ed25519.PublicKey)}
+}
+
+func (k ed25519PrivateKey) String() string { return taggedKey(KeyTypeEd25519, k.key.Seed()) }
+
+func (k ed25519PrivateKey) Sign(data string) (string, error) {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(k.key, []byte(data))), nil
+}
+
+type ed25519PublicKey struct{ key ed25519.PublicKey }
+
+func (k ed25519PublicKey) Type() KeyType  { return KeyTypeEd25519 }
+func (k ed25519PublicKey) String() string { return taggedKey(KeyTypeEd25519, k.key) }
+
+func (k ed25519PublicKey) Verify(data string, signatureStr string) error {
+	signature, err := base64.StdEncoding.DecodeString(signatureStr)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(k.key, []byte(data), signature) {
+		return errors.New("signature verification failed")
+	}
+	return nil
+}
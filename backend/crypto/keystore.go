@@ -0,0 +1,286 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KeystoreVersion is the version of the keystore format written by
+// EncryptKeystore. Private keys stored by older versions are bare base64
+// AES-GCM blobs keyed with SHA-256 of the user's email (see
+// EncryptPrivateKey) and count as version 0.
+const KeystoreVersion = 1
+
+// MinPassphraseLength is the shortest passphrase a keystore accepts
+const MinPassphraseLength = 8
+
+// Key derivation functions a keystore can use
+const (
+	KDFArgon2id = "argon2id"
+	KDFScrypt   = "scrypt"
+)
+
+const (
+	keystoreCipher  = "aes-256-gcm"
+	keystoreKeyLen  = 32
+	keystoreSaltLen = 16
+)
+
+// Limits on the cost of a keystore's KDF. Imported keystores choose their
+// own parameters, so these keep one decryption near the cost of the
+// defaults: at most 256 MiB of memory and 4 passes or lanes.
+const (
+	maxKDFMemory  = 256 << 20 // bytes
+	maxKDFTime    = 4
+	maxKDFThreads = 4
+	maxScryptP    = 4
+)
+
+// KDFParams are the key derivation settings stored with a keystore. Only
+// the cost fields of its KDF are set.
+type KDFParams struct {
+	Salt    string `json:"salt"`
+	KeyLen  int    `json:"keylen"`
+	Time    uint32 `json:"time,omitempty"`    // argon2id passes
+	Memory  uint32 `json:"memory,omitempty"`  // argon2id memory in KiB
+	Threads uint8  `json:"threads,omitempty"` // argon2id lanes
+	N       int    `json:"n,omitempty"`       // scrypt CPU/memory cost, a power of two
+	R       int    `json:"r,omitempty"`       // scrypt block size
+	P       int    `json:"p,omitempty"`       // scrypt parallelism
+}
+
+// KeystoreCrypto holds the encrypted key and how to decrypt it
+type KeystoreCrypto struct {
+	Cipher     string    `json:"cipher"`
+	CipherText string    `json:"ciphertext"`
+	Nonce      string    `json:"nonce"`
+	KDF        string    `json:"kdf"`
+	KDFParams  KDFParams `json:"kdfparams"`
+}
+
+// Keystore is a private key encrypted under a passphrase, serialized as
+// versioned JSON that can be exported and imported again. The header
+// fields are authenticated along with the key, so editing them makes
+// decryption fail.
+type Keystore struct {
+	Version  int            `json:"version"`
+	ID       string         `json:"id"`
+	KeyType  KeyType        `json:"key_type"`
+	WalletID string         `json:"wallet_id"`
+	Crypto   KeystoreCrypto `json:"crypto"`
+}
+
+// KDFConfig chooses the KDF and its cost for new keystores
+type KDFConfig struct {
+	KDF     string
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	N       int
+	R       int
+	P       int
+}
+
+// DefaultKDFConfig follows the RFC 9106 second recommended Argon2id option
+// (3 passes over 64 MiB); the scrypt settings are used when KDF is scrypt
+var DefaultKDFConfig = KDFConfig{
+	KDF:     KDFArgon2id,
+	Time:    3,
+	Memory:  64 * 1024,
+	Threads: 4,
+	N:       1 << 15,
+	R:       8,
+	P:       1,
+}
+
+// EncryptKeystore encrypts a private key under a passphrase with a fresh
+// salt and nonce
+func EncryptKeystore(privateKeyStr, passphrase string, cfg KDFConfig) (*Keystore, error) {
+	if len(passphrase) < MinPassphraseLength {
+		return nil, fmt.Errorf("passphrase must be at least %d characters", MinPassphraseLength)
+	}
+	privateKey, err := ParsePrivateKey(privateKeyStr)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, keystoreSaltLen)
+	id := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	params := KDFParams{Salt: hex.EncodeToString(salt), KeyLen: keystoreKeyLen}
+	switch cfg.KDF {
+	case KDFArgon2id:
+		params.Time, params.Memory, params.Threads = cfg.Time, cfg.Memory, cfg.Threads
+	case KDFScrypt:
+		params.N, params.R, params.P = cfg.N, cfg.R, cfg.P
+	default:
+		return nil, fmt.Errorf("unsupported KDF %q", cfg.KDF)
+	}
+
+	ks := &Keystore{
+		Version:  KeystoreVersion,
+		ID:       hex.EncodeToString(id),
+		KeyType:  privateKey.Type(),
+		WalletID: GenerateWalletID(privateKey.Public().String()),
+		Crypto: KeystoreCrypto{
+			Cipher:    keystoreCipher,
+			KDF:       cfg.KDF,
+			KDFParams: params,
+		},
+	}
+
+	gcm, err := ks.cipher(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	ks.Crypto.Nonce = hex.EncodeToString(nonce)
+	ks.Crypto.CipherText = hex.EncodeToString(gcm.Seal(nil, nonce, []byte(privateKeyStr), ks.additionalData()))
+
+	return ks, nil
+}
+
+// Decrypt returns the private key, failing if the passphrase is wrong or
+// the keystore was altered
+func (ks *Keystore) Decrypt(passphrase string) (string, error) {
+	gcm, err := ks.cipher(passphrase)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := hex.DecodeString(ks.Crypto.Nonce)
+	if err != nil || len(nonce) != gcm.NonceSize() {
+		return "", errors.New("invalid keystore nonce")
+	}
+	ciphertext, err := hex.DecodeString(ks.Crypto.CipherText)
+	if err != nil {
+		return "", errors.New("invalid keystore ciphertext")
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, ks.additionalData())
+	if err != nil {
+		return "", errors.New("incorrect passphrase or corrupted keystore")
+	}
+	return string(plaintext), nil
+}
+
+// NeedsUpgrade reports whether the keystore was written by an older version
+// or with a different KDF, or a lower cost, than cfg asks for
+func (ks *Keystore) NeedsUpgrade(cfg KDFConfig) bool {
+	params := ks.Crypto.KDFParams
+	if ks.Version < KeystoreVersion || ks.Crypto.KDF != cfg.KDF {
+		return true
+	}
+	if cfg.KDF == KDFScrypt {
+		return params.N < cfg.N || params.R < cfg.R || params.P < cfg.P
+	}
+	return params.Time < cfg.Time || params.Memory < cfg.Memory || params.Threads < cfg.Threads
+}
+
+// String serializes the keystore as JSON
+func (ks *Keystore) String() string {
+	data, _ := json.Marshal(ks)
+	return string(data)
+}
+
+// ParseKeystore parses and checks a keystore's JSON
+func ParseKeystore(data string) (*Keystore, error) {
+	var ks Keystore
+	if err := json.Unmarshal([]byte(data), &ks); err != nil {
+		return nil, fmt.Errorf("invalid keystore: %w", err)
+	}
+	if ks.Version < 1 || ks.Version > KeystoreVersion {
+		return nil, fmt.Errorf("unsupported keystore version %d", ks.Version)
+	}
+	if ks.Crypto.Cipher != keystoreCipher {
+		return nil, fmt.Errorf("unsupported keystore cipher %q", ks.Crypto.Cipher)
+	}
+	if err := checkKDFParams(ks.Crypto.KDF, ks.Crypto.KDFParams); err != nil {
+		return nil, err
+	}
+	return &ks, nil
+}
+
+// IsLegacyEncryptedKey reports whether a stored private key predates the
+// keystore format
+func IsLegacyEncryptedKey(encrypted string) bool {
+	return !strings.HasPrefix(strings.TrimSpace(encrypted), "{")
+}
+
+// cipher derives the encryption key from the passphrase
+func (ks *Keystore) cipher(passphrase string) (cipher.AEAD, error) {
+	params := ks.Crypto.KDFParams
+	if err := checkKDFParams(ks.Crypto.KDF, params); err != nil {
+		return nil, err
+	}
+	salt, _ := hex.DecodeString(params.Salt)
+
+	var key []byte
+	switch ks.Crypto.KDF {
+	case KDFArgon2id:
+		key = argon2.IDKey([]byte(passphrase), salt, params.Time, params.Memory, params.Threads, uint32(params.KeyLen))
+	case KDFScrypt:
+		var err error
+		key, err = scrypt.Key([]byte(passphrase), salt, params.N, params.R, params.P, params.KeyLen)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// additionalData binds the header to the ciphertext
+func (ks *Keystore) additionalData() []byte {
+	return []byte(fmt.Sprintf("%d:%s:%s:%s", ks.Version, ks.ID, ks.KeyType, ks.WalletID))
+}
+
+// checkKDFParams rejects parameters that are unusable or would let an
+// imported keystore exhaust the server's memory
+func checkKDFParams(kdf string, params KDFParams) error {
+	salt, err := hex.DecodeString(params.Salt)
+	if err != nil || len(salt) < 8 {
+		return errors.New("keystore salt must be at least 8 bytes")
+	}
+	if params.KeyLen != keystoreKeyLen {
+		return fmt.Errorf("keystore key length must be %d", keystoreKeyLen)
+	}
+
+	switch kdf {
+	case KDFArgon2id:
+		if params.Time < 1 || params.Time > maxKDFTime || params.Threads < 1 || params.Threads > maxKDFThreads ||
+			params.Memory < 8*uint32(params.Threads) || params.Memory > maxKDFMemory/1024 {
+			return errors.New("argon2id parameters out of range")
+		}
+	case KDFScrypt:
+		// scrypt uses 128*N*r bytes of memory
+		if params.N < 2 || params.N&(params.N-1) != 0 || params.R < 1 || params.P < 1 || params.P > maxScryptP ||
+			params.N > maxKDFMemory/128 || params.R > maxKDFMemory/128/params.N {
+			return errors.New("scrypt parameters out of range")
+		}
+	default:
+		return fmt.Errorf("unsupported KDF %q", kdf)
+	}
+	return nil
+}
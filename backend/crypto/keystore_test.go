@@ -0,0 +1,102 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+)
+
+// Cheap KDF settings so the tests stay fast; the format is the same
+var testKDFConfigs = []KDFConfig{
+	{KDF: KDFArgon2id, Time: 1, Memory: 1024, Threads: 1},
+	{KDF: KDFScrypt, N: 1 << 10, R: 8, P: 1},
+}
+
+func testPrivateKey(t *testing.T) string {
+	t.Helper()
+	priv, _, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return PrivateKeyToString(priv)
+}
+
+func TestKeystoreRoundTrip(t *testing.T) {
+	key := testPrivateKey(t)
+	for _, cfg := range testKDFConfigs {
+		ks, err := EncryptKeystore(key, "correct horse", cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", cfg.KDF, err)
+		}
+
+		// Export and import through the JSON form
+		parsed, err := ParseKeystore(ks.String())
+		if err != nil {
+			t.Fatalf("%s: %v", cfg.KDF, err)
+		}
+		if got, err := parsed.Decrypt("correct horse"); err != nil || got != key {
+			t.Fatalf("%s: decrypted %q, %v", cfg.KDF, got, err)
+		}
+		if _, err := parsed.Decrypt("wrong horse"); err == nil {
+			t.Fatalf("%s: wrong passphrase decrypted the keystore", cfg.KDF)
+		}
+
+		// The header is authenticated with the key
+		parsed.WalletID = strings.Repeat("0", 64)
+		if _, err := parsed.Decrypt("correct horse"); err == nil {
+			t.Fatalf("%s: edited header decrypted", cfg.KDF)
+		}
+	}
+
+	if _, err := EncryptKeystore(key, "short", testKDFConfigs[0]); err == nil {
+		t.Fatal("short passphrase accepted")
+	}
+}
+
+func TestParseKeystoreRejectsCostlyParams(t *testing.T) {
+	ks, err := EncryptKeystore(testPrivateKey(t), "correct horse", testKDFConfigs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		kdf    string
+		params func(*KDFParams)
+	}{
+		{"argon2id memory", KDFArgon2id, func(p *KDFParams) { p.Memory = 256*1024 + 1 }},
+		{"argon2id passes", KDFArgon2id, func(p *KDFParams) { p.Time = 5 }},
+		{"argon2id threads", KDFArgon2id, func(p *KDFParams) { p.Threads = 5 }},
+		{"argon2id no passes", KDFArgon2id, func(p *KDFParams) { p.Time = 0 }},
+		{"scrypt memory", KDFScrypt, func(p *KDFParams) { p.N, p.R, p.P = 1<<18, 16, 1 }},
+		{"scrypt huge N", KDFScrypt, func(p *KDFParams) { p.N, p.R, p.P = 1<<40, 1, 1 }},
+		{"scrypt parallelism", KDFScrypt, func(p *KDFParams) { p.N, p.R, p.P = 1<<10, 8, 5 }},
+		{"scrypt N not a power of two", KDFScrypt, func(p *KDFParams) { p.N, p.R, p.P = 1000, 8, 1 }},
+		{"short salt", KDFArgon2id, func(p *KDFParams) { p.Salt = "00" }},
+		{"key length", KDFArgon2id, func(p *KDFParams) { p.KeyLen = 16 }},
+	}
+	for _, tt := range tests {
+		imported := *ks
+		imported.Crypto.KDF = tt.kdf
+		imported.Crypto.KDFParams = KDFParams{Salt: ks.Crypto.KDFParams.Salt, KeyLen: keystoreKeyLen, Time: 1, Memory: 1024, Threads: 1}
+		if tt.kdf == KDFScrypt {
+			imported.Crypto.KDFParams = KDFParams{Salt: ks.Crypto.KDFParams.Salt, KeyLen: keystoreKeyLen, N: 1 << 10, R: 8, P: 1}
+		}
+		tt.params(&imported.Crypto.KDFParams)
+
+		if _, err := ParseKeystore(imported.String()); err == nil {
+			t.Errorf("%s: keystore was accepted", tt.name)
+		}
+	}
+
+	// The largest accepted settings are still parsed
+	limit := *ks
+	limit.Crypto.KDFParams.Time, limit.Crypto.KDFParams.Memory, limit.Crypto.KDFParams.Threads = 4, 256*1024, 4
+	if _, err := ParseKeystore(limit.String()); err != nil {
+		t.Fatalf("keystore at the limits: %v", err)
+	}
+	limit.Crypto.KDF = KDFScrypt
+	limit.Crypto.KDFParams = KDFParams{Salt: ks.Crypto.KDFParams.Salt, KeyLen: keystoreKeyLen, N: 1 << 18, R: 8, P: 4}
+	if _, err := ParseKeystore(limit.String()); err != nil {
+		t.Fatalf("scrypt keystore at the limits: %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package crypto
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/tyler-smith/go-bip39"
+)
+
+// NewMnemonic generates a BIP39 mnemonic of 12, 15, 18, 21 or 24 English
+// words. Every 3 words carry 32 bits of entropy.
+func NewMnemonic(words int) (string, error) {
+	if words < 12 || words > 24 || words%3 != 0 {
+		return "", errors.New("mnemonic must have 12, 15, 18, 21 or 24 words")
+	}
+	entropy, err := bip39.NewEntropy(words / 3 * 32)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// NormalizeMnemonic lowercases a mnemonic and collapses its whitespace
+func NormalizeMnemonic(mnemonic string) string {
+	return strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+}
+
+// ValidateMnemonic checks that every word is in the wordlist and that the
+// checksum matches
+func ValidateMnemonic(mnemonic string) error {
+	if !bip39.IsMnemonicValid(NormalizeMnemonic(mnemonic)) {
+		return errors.New("invalid mnemonic: unknown word or bad checksum")
+	}
+	return nil
+}
+
+// MnemonicToSeed turns a mnemonic and optional passphrase into the 64-byte
+// BIP39 seed. A different passphrase gives a different, equally valid seed.
+func MnemonicToSeed(mnemonic, passphrase string) ([]byte, error) {
+	if err := ValidateMnemonic(mnemonic); err != nil {
+		return nil, err
+	}
+	return bip39.NewSeed(NormalizeMnemonic(mnemonic), passphrase), nil
+}
@@ -0,0 +1,76 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/tyler-smith/go-bip39"
+)
+
+// BIP39 English test vectors, with the seed under the passphrase "TREZOR"
+var bip39Vectors = []struct {
+	entropy  string
+	mnemonic string
+	seed     string
+}{
+	{
+		"00000000000000000000000000000000",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+	},
+	{
+		"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+		"legal winner thank year wave sausage worth useful legal winner thank yellow",
+		"2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607",
+	},
+	{
+		"80808080808080808080808080808080",
+		"letter advice cage absurd amount doctor acoustic avoid letter advice cage above",
+		"d71de856f81a8acc65e6fc851a38d4d7ec216fd0796d0a6827a3ad6ed5511a30fa280f12eb2e47ed2ac03b5c462a0358d18d69fe4f985ec81778c1b370b652a8",
+	},
+	{
+		"ffffffffffffffffffffffffffffffff",
+		"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo wrong",
+		"ac27495480225222079d7be181583751e86f571027b0497b5b5d11218e0a8a13332572917f0f8e5a589620c6f15b11c61dee327651a14c34e18231052e48c069",
+	},
+}
+
+func TestMnemonicBIP39Vectors(t *testing.T) {
+	for _, vector := range bip39Vectors {
+		entropy, err := hex.DecodeString(vector.entropy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mnemonic, err := bip39.NewMnemonic(entropy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mnemonic != vector.mnemonic {
+			t.Errorf("entropy %s: mnemonic = %q, want %q", vector.entropy, mnemonic, vector.mnemonic)
+		}
+
+		// Case and spacing do not change the seed
+		for _, m := range []string{vector.mnemonic, "  " + strings.ToUpper(vector.mnemonic) + "\n"} {
+			seed, err := MnemonicToSeed(m, "TREZOR")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(seed); got != vector.seed {
+				t.Errorf("%q: seed = %s, want %s", m, got, vector.seed)
+			}
+		}
+	}
+}
+
+func TestMnemonicRejectsBadChecksum(t *testing.T) {
+	if err := ValidateMnemonic("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon"); err == nil {
+		t.Fatal("mnemonic with a bad checksum was accepted")
+	}
+	if _, err := MnemonicToSeed("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon notaword", ""); err == nil {
+		t.Fatal("mnemonic with an unknown word was accepted")
+	}
+	if _, err := NewMnemonic(13); err == nil {
+		t.Fatal("13-word mnemonic was generated")
+	}
+}
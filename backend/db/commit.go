@@ -0,0 +1,192 @@
+package db
+
+import (
+	"crypto-wallet/models"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UTXORef identifies a transaction output consumed by a block
+type UTXORef struct {
+	TxID      string `json:"tx_id" bson:"tx_id"`
+	Vout      int    `json:"vout" bson:"vout"`
+	SpentInTx string `json:"spent_in_tx" bson:"spent_in_tx"`
+}
+
+// BlockCommit is the unit of work applied when a block is connected to the
+// chain. Every Store applies it all-or-nothing: the block insert, UTXO spends
+// and creations, pending-pool updates, logs, zakat records and wallet balance
+// caches either all land or none of them do.
+type BlockCommit struct {
+	Block           models.Block
+	SpentInputs     []UTXORef
+	CreatedUTXOs    []models.UTXO
+	TransactionLogs []models.TransactionLog
+	ZakatRecords    []models.ZakatRecord
+}
+
+// NewBlockCommit derives the UTXO spends and creations for a block. Logs and
+// zakat records are attached by the caller since they need user lookups.
+func NewBlockCommit(block models.Block) *BlockCommit {
+	commit := &BlockCommit{Block: block}
+
+	for _, tx := range block.Transactions {
+		// Same rule as blockchain.IsCoinbase
+		isCoinbase := tx.Type == "mining_reward" || tx.SenderID == "coinbase"
+
+		for _, input := range tx.Vin {
+			commit.SpentInputs = append(commit.SpentInputs, UTXORef{
+				TxID:      input.TxID,
+				Vout:      input.Vout,
+				SpentInTx: tx.ID,
+			})
+		}
+
+		for vout, output := range tx.Vout {
+			commit.CreatedUTXOs = append(commit.CreatedUTXOs, models.UTXO{
+				TxID:       tx.ID,
+				Vout:       vout,
+				WalletID:   output.PubKeyHash,
+				Amount:     output.Value,
+				IsSpent:    false,
+				IsCoinbase: isCoinbase,
+				BlockIndex: block.Index,
+			})
+		}
+	}
+
+	return commit
+}
+
+// TransactionIDs returns the IDs of every transaction in the block
+func (c *BlockCommit) TransactionIDs() []string {
+	ids := make([]string, 0, len(c.Block.Transactions))
+	for _, tx := range c.Block.Transactions {
+		ids = append(ids, tx.ID)
+	}
+	return ids
+}
+
+// AffectedWallets returns the wallets whose cached balance may change
+func (c *BlockCommit) AffectedWallets() []string {
+	seen := make(map[string]bool)
+	var wallets []string
+	add := func(walletID string) {
+		if walletID == "" || seen[walletID] {
+			return
+		}
+		seen[walletID] = true
+		wallets = append(wallets, walletID)
+	}
+
+	for _, tx := range c.Block.Transactions {
+		add(tx.SenderID)
+		for _, output := range tx.Vout {
+			add(output.PubKeyHash)
+		}
+	}
+	for _, utxo := range c.CreatedUTXOs {
+		add(utxo.WalletID)
+	}
+	return wallets
+}
+
+// prepareUndo assigns IDs and timestamps to everything the commit will insert
+// and starts the block's undo journal. Stores append the pre-spend state of
+// each consumed UTXO and the mined pool entries before persisting it.
+func (c *BlockCommit) prepareUndo() *models.BlockUndo {
+	now := time.Now()
+	undo := &models.BlockUndo{
+		BlockHash:  c.Block.Hash,
+		BlockIndex: c.Block.Index,
+		CreatedAt:  now,
+	}
+
+	for i := range c.CreatedUTXOs {
+		if c.CreatedUTXOs[i].ID == "" {
+			c.CreatedUTXOs[i].ID = primitive.NewObjectID().Hex()
+		}
+		c.CreatedUTXOs[i].CreatedAt = now
+	}
+	undo.CreatedUTXOs = append(undo.CreatedUTXOs, c.CreatedUTXOs...)
+
+	for i := range c.TransactionLogs {
+		if c.TransactionLogs[i].ID == "" {
+			c.TransactionLogs[i].ID = primitive.NewObjectID().Hex()
+		}
+		if c.TransactionLogs[i].Timestamp.IsZero() {
+			c.TransactionLogs[i].Timestamp = now
+		}
+		undo.TransactionLogIDs = append(undo.TransactionLogIDs, c.TransactionLogs[i].ID)
+	}
+
+	for i := range c.ZakatRecords {
+		if c.ZakatRecords[i].ID == "" {
+			c.ZakatRecords[i].ID = primitive.NewObjectID().Hex()
+		}
+		if c.ZakatRecords[i].Timestamp.IsZero() {
+			c.ZakatRecords[i].Timestamp = now
+		}
+	}
+	undo.ZakatRecords = append(undo.ZakatRecords, c.ZakatRecords...)
+
+	return undo
+}
+
+// legacyUndo reconstructs an undo journal for blocks committed before journals
+// were written. Spent outputs are looked up in the store and marked unspent.
+func (c *BlockCommit) legacyUndo(lookup func(txID string, vout int) (*models.UTXO, error)) *models.BlockUndo {
+	undo := &models.BlockUndo{
+		BlockHash:    c.Block.Hash,
+		BlockIndex:   c.Block.Index,
+		CreatedUTXOs: c.CreatedUTXOs,
+	}
+
+	for _, ref := range c.SpentInputs {
+		utxo, err := lookup(ref.TxID, ref.Vout)
+		if err != nil {
+			continue
+		}
+		utxo.IsSpent = false
+		utxo.SpentInTx = ""
+		undo.SpentUTXOs = append(undo.SpentUTXOs, *utxo)
+	}
+
+	return undo
+}
+
+// errInputUnavailable is returned when a block spends a missing or spent UTXO
+func errInputUnavailable(ref UTXORef) error {
+	return fmt.Errorf("input %s:%d spent in %s is missing or already spent", ref.TxID, ref.Vout, ref.SpentInTx)
+}
+
+// LedgerState is the chain-derived data regenerated by replaying every block.
+// Wallets missing from Balances are reset to zero.
+type LedgerState struct {
+	UTXOs           []models.UTXO
+	TransactionLogs []models.TransactionLog
+	Balances        map[string]models.Amount
+}
+
+// CommitBlock atomically connects a block and all of its state changes
+func CommitBlock(commit *BlockCommit) error {
+	return store.CommitBlock(commit)
+}
+
+// RollbackBlock atomically disconnects a previously committed tip block,
+// restoring spent UTXOs and returning its transfers to the pending pool
+func RollbackBlock(commit *BlockCommit) error {
+	return store.RollbackBlock(commit)
+}
+
+// GetBlockUndo returns the undo journal stored for a block
+func GetBlockUndo(blockHash string) (*models.BlockUndo, error) {
+	return store.GetBlockUndo(blockHash)
+}
+
+// ReplaceLedgerState atomically replaces UTXOs, transaction logs and balances
+func ReplaceLedgerState(state *LedgerState) error {
+	return store.ReplaceLedgerState(state)
+}
@@ -1,498 +1,249 @@
 package db
 
 import (
-	"context"
 	"crypto-wallet/config"
 	"crypto-wallet/models"
 	"errors"
 	"log"
-	"regexp"
-	"time"
-
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var Client *mongo.Client
-var Database *mongo.Database
-
-// Collections
-var (
-	BlocksCollection              *mongo.Collection
-	UsersCollection               *mongo.Collection
-	WalletsCollection             *mongo.Collection
-	UTXOsCollection               *mongo.Collection
-	PendingTransactionsCollection *mongo.Collection
-	TransactionLogsCollection     *mongo.Collection
-	SystemLogsCollection          *mongo.Collection
-	ZakatRecordsCollection        *mongo.Collection
-)
+// store is the active persistence backend used by the package-level functions
+var store Store
 
-// ConnectDB establishes connection to MongoDB
-func ConnectDB() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// SetStore replaces the active store (used for tests and offline development)
+func SetStore(s Store) {
+	store = s
+}
 
-	clientOptions := options.Client().ApplyURI(config.AppConfig.MongoDBURI)
-	client, err := mongo.Connect(ctx, clientOptions)
-	if err != nil {
-		return err
+// GetStore returns the active store
+func GetStore() Store {
+	return store
+}
+
+// ConnectDB initializes the storage backend selected by STORAGE_BACKEND
+func ConnectDB() error {
+	if config.AppConfig.StorageBackend == "memory" {
+		SetStore(NewMemoryStore())
+		log.Println("✅ Using in-memory storage backend")
+		return nil
 	}
 
-	// Ping the database
-	err = client.Ping(ctx, nil)
+	mongoStore, err := NewMongoStore(config.AppConfig.MongoDBURI, config.AppConfig.DBName)
 	if err != nil {
 		return err
 	}
-
-	Client = client
-	Database = client.Database(config.AppConfig.DBName)
-
-	// Initialize collections
-	BlocksCollection = Database.Collection("blocks")
-	UsersCollection = Database.Collection("users")
-	WalletsCollection = Database.Collection("wallets")
-	UTXOsCollection = Database.Collection("utxos")
-	PendingTransactionsCollection = Database.Collection("pending_transactions")
-	TransactionLogsCollection = Database.Collection("transaction_logs")
-	SystemLogsCollection = Database.Collection("system_logs")
-	ZakatRecordsCollection = Database.Collection("zakat_records")
-
-	// Create indexes
-	createIndexes()
+	SetStore(mongoStore)
 
 	log.Println("✅ Connected to MongoDB successfully")
 	return nil
 }
 
-// createIndexes creates necessary indexes for efficient queries
-func createIndexes() {
-	ctx := context.Background()
-
-	// Users indexes
-	UsersCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys:    bson.D{{Key: "email", Value: 1}},
-		Options: options.Index().SetUnique(true),
-	})
-	UsersCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys:    bson.D{{Key: "wallet_id", Value: 1}},
-		Options: options.Index().SetUnique(true),
-	})
-
-	// Wallets indexes
-	WalletsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "user_id", Value: 1}},
-	})
-
-	// UTXOs indexes
-	UTXOsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "wallet_id", Value: 1}, {Key: "is_spent", Value: 1}},
-	})
-	UTXOsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "tx_id", Value: 1}, {Key: "vout", Value: 1}},
-	})
-
-	// Blocks index
-	BlocksCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys:    bson.D{{Key: "index", Value: -1}},
-		Options: options.Index().SetUnique(true),
-	})
-
-	// Transaction logs index
-	TransactionLogsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "wallet_id", Value: 1}, {Key: "timestamp", Value: -1}},
-	})
-
-	// System logs index
-	SystemLogsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "timestamp", Value: -1}},
-	})
-
-	log.Println("✅ Database indexes created")
-}
-
 // DisconnectDB closes the database connection
 func DisconnectDB() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	return Client.Disconnect(ctx)
+	return store.Close()
 }
 
 // User operations
 func CreateUser(user *models.User) error {
-	user.CreatedAt = time.Now()
-	user.UpdatedAt = time.Now()
-	_, err := UsersCollection.InsertOne(context.Background(), user)
-	return err
+	return store.CreateUser(user)
 }
 
 func GetUserByEmail(email string) (*models.User, error) {
-	var user models.User
-	// Case-insensitive email search using regex
-	// Escape special regex characters in email
-	escapedEmail := regexp.QuoteMeta(email)
-	filter := bson.M{"email": bson.M{"$regex": "^" + escapedEmail + "$", "$options": "i"}}
-	err := UsersCollection.FindOne(context.Background(), filter).Decode(&user)
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
+	return store.GetUserByEmail(email)
 }
 
 func GetUserByWalletID(walletID string) (*models.User, error) {
-	var user models.User
-	err := UsersCollection.FindOne(context.Background(), bson.M{"wallet_id": walletID}).Decode(&user)
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
+	return store.GetUserByWalletID(walletID)
 }
 
-func UpdateUser(email string, update bson.M) error {
-	update["updated_at"] = time.Now()
-	_, err := UsersCollection.UpdateOne(
-		context.Background(),
-		bson.M{"email": email},
-		bson.M{"$set": update},
-	)
-	return err
+func UpdateUser(email string, update map[string]interface{}) error {
+	return store.UpdateUser(email, update)
 }
 
 func GetAllUsers() ([]models.User, error) {
-	var users []models.User
-	cursor, err := UsersCollection.Find(context.Background(), bson.M{})
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(context.Background())
-	
-	if err = cursor.All(context.Background(), &users); err != nil {
-		return nil, err
-	}
-	return users, nil
+	return store.GetAllUsers()
 }
 
 // Wallet operations
 func CreateWallet(wallet *models.Wallet) error {
-	wallet.CreatedAt = time.Now()
-	wallet.UpdatedAt = time.Now()
-	_, err := WalletsCollection.InsertOne(context.Background(), wallet)
-	return err
+	return store.CreateWallet(wallet)
 }
 
 func GetWallet(walletID string) (*models.Wallet, error) {
-	var wallet models.Wallet
-	err := WalletsCollection.FindOne(context.Background(), bson.M{"_id": walletID}).Decode(&wallet)
-	if err != nil {
-		return nil, err
-	}
-	return &wallet, nil
+	return store.GetWallet(walletID)
 }
 
-func UpdateWalletBalance(walletID string, balance float64) error {
-	_, err := WalletsCollection.UpdateOne(
-		context.Background(),
-		bson.M{"_id": walletID},
-		bson.M{"$set": bson.M{"balance": balance, "updated_at": time.Now()}},
-	)
-	return err
+func UpdateWalletBalance(walletID string, balance models.Amount) error {
+	return store.UpdateWalletBalance(walletID, balance)
 }
 
 func UpdateWalletZakatDate(walletID string) error {
-	_, err := WalletsCollection.UpdateOne(
-		context.Background(),
-		bson.M{"_id": walletID},
-		bson.M{"$set": bson.M{"last_zakat_date": time.Now(), "updated_at": time.Now()}},
-	)
-	return err
+	return store.UpdateWalletZakatDate(walletID)
 }
 
 // Block operations
 func InsertBlock(block *models.Block) error {
-	_, err := BlocksCollection.InsertOne(context.Background(), block)
-	return err
+	return store.InsertBlock(block)
 }
 
 func GetLastBlock() (*models.Block, error) {
-	var block models.Block
-	opts := options.FindOne().SetSort(bson.D{{Key: "index", Value: -1}})
-	err := BlocksCollection.FindOne(context.Background(), bson.D{}, opts).Decode(&block)
-	if err != nil {
-		return nil, err
-	}
-	return &block, nil
+	return store.GetLastBlock()
 }
 
 func GetAllBlocks() ([]models.Block, error) {
-	var blocks []models.Block
-	opts := options.Find().SetSort(bson.D{{Key: "index", Value: 1}})
-	cursor, err := BlocksCollection.Find(context.Background(), bson.D{}, opts)
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(context.Background())
-	
-	if err = cursor.All(context.Background(), &blocks); err != nil {
-		return nil, err
-	}
-	return blocks, nil
+	return store.GetAllBlocks()
 }
 
 func GetBlockByIndex(index int) (*models.Block, error) {
-	var block models.Block
-	err := BlocksCollection.FindOne(context.Background(), bson.M{"index": index}).Decode(&block)
-	if err != nil {
-		return nil, err
-	}
-	return &block, nil
+	return store.GetBlockByIndex(index)
+}
+
+func GetBlockByHash(hash string) (*models.Block, error) {
+	return store.GetBlockByHash(hash)
+}
+
+func CountBlocks() (int64, error) {
+	return store.CountBlocks()
+}
+
+// Side branch operations
+func SaveSideBlock(side *models.SideBlock) error {
+	return store.SaveSideBlock(side)
+}
+
+func GetSideBlock(hash string) (*models.SideBlock, error) {
+	return store.GetSideBlock(hash)
+}
+
+func GetSideBlocks() ([]models.SideBlock, error) {
+	return store.GetSideBlocks()
+}
+
+func DeleteSideBlock(hash string) error {
+	return store.DeleteSideBlock(hash)
 }
 
 // UTXO operations
 func CreateUTXO(utxo *models.UTXO) error {
-	utxo.CreatedAt = time.Now()
-	_, err := UTXOsCollection.InsertOne(context.Background(), utxo)
-	return err
+	return store.CreateUTXO(utxo)
 }
 
 func GetUnspentUTXOs(walletID string) ([]models.UTXO, error) {
-	var utxos []models.UTXO
-	cursor, err := UTXOsCollection.Find(
-		context.Background(),
-		bson.M{"wallet_id": walletID, "is_spent": false},
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(context.Background())
-	
-	if err = cursor.All(context.Background(), &utxos); err != nil {
-		return nil, err
-	}
-	return utxos, nil
+	return store.GetUnspentUTXOs(walletID)
 }
 
 func MarkUTXOAsSpent(txID string, vout int, spentInTx string) error {
-	_, err := UTXOsCollection.UpdateOne(
-		context.Background(),
-		bson.M{"tx_id": txID, "vout": vout},
-		bson.M{"$set": bson.M{"is_spent": true, "spent_in_tx": spentInTx}},
-	)
-	return err
+	return store.MarkUTXOAsSpent(txID, vout, spentInTx)
 }
 
 func GetUTXO(txID string, vout int) (*models.UTXO, error) {
-	var utxo models.UTXO
-	err := UTXOsCollection.FindOne(
-		context.Background(),
-		bson.M{"tx_id": txID, "vout": vout},
-	).Decode(&utxo)
-	if err != nil {
-		return nil, err
-	}
-	return &utxo, nil
+	return store.GetUTXO(txID, vout)
 }
 
-// LockUTXO locks a UTXO for a pending transaction
+// LockUTXO locks a UTXO for a pending transaction. Locking is atomic: it
+// fails with ErrUTXOUnavailable unless the output is unspent and unlocked or
+// already locked by the same transaction.
 func LockUTXO(txID string, vout int, pendingTxID string) error {
-	_, err := UTXOsCollection.UpdateOne(
-		context.Background(),
-		bson.M{"tx_id": txID, "vout": vout, "is_spent": false, "is_locked": false},
-		bson.M{"$set": bson.M{"is_locked": true, "locked_by": pendingTxID}},
-	)
-	return err
+	return store.LockUTXO(txID, vout, pendingTxID)
 }
 
 // UnlockUTXO unlocks a UTXO (removes lock from pending transaction)
 func UnlockUTXO(txID string, vout int) error {
-	_, err := UTXOsCollection.UpdateOne(
-		context.Background(),
-		bson.M{"tx_id": txID, "vout": vout},
-		bson.M{"$set": bson.M{"is_locked": false}, "$unset": bson.M{"locked_by": ""}},
-	)
-	return err
+	return store.UnlockUTXO(txID, vout)
 }
 
 // UnlockUTXOsByPendingTx unlocks all UTXOs locked by a specific pending transaction
 func UnlockUTXOsByPendingTx(pendingTxID string) error {
-	_, err := UTXOsCollection.UpdateMany(
-		context.Background(),
-		bson.M{"locked_by": pendingTxID},
-		bson.M{"$set": bson.M{"is_locked": false}, "$unset": bson.M{"locked_by": ""}},
-	)
-	return err
+	return store.UnlockUTXOsByPendingTx(pendingTxID)
 }
 
 // Pending Transaction operations
 func AddPendingTransaction(ptx *models.PendingTransaction) error {
-	ptx.CreatedAt = time.Now()
-	ptx.Status = "pending"
-	_, err := PendingTransactionsCollection.InsertOne(context.Background(), ptx)
-	return err
+	return store.AddPendingTransaction(ptx)
 }
 
 func GetPendingTransactions() ([]models.PendingTransaction, error) {
-	var txs []models.PendingTransaction
-	cursor, err := PendingTransactionsCollection.Find(
-		context.Background(),
-		bson.M{"status": "pending"},
-		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}),
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(context.Background())
-	
-	if err = cursor.All(context.Background(), &txs); err != nil {
-		return nil, err
-	}
-	return txs, nil
+	return store.GetPendingTransactions()
 }
 
 func UpdatePendingTransactionStatus(txID string, status string) error {
-	_, err := PendingTransactionsCollection.UpdateOne(
-		context.Background(),
-		bson.M{"_id": txID},
-		bson.M{"$set": bson.M{"status": status}},
-	)
-	return err
+	return store.UpdatePendingTransactionStatus(txID, status)
 }
 
 func DeletePendingTransaction(txID string) error {
-	_, err := PendingTransactionsCollection.DeleteOne(
-		context.Background(),
-		bson.M{"_id": txID},
-	)
-	return err
+	return store.DeletePendingTransaction(txID)
 }
 
 // Transaction Log operations
 func CreateTransactionLog(log *models.TransactionLog) error {
-	log.Timestamp = time.Now()
-	_, err := TransactionLogsCollection.InsertOne(context.Background(), log)
-	return err
+	return store.CreateTransactionLog(log)
 }
 
 func GetTransactionLogsByWallet(walletID string, limit int) ([]models.TransactionLog, error) {
-	var logs []models.TransactionLog
-	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}).SetLimit(int64(limit))
-	cursor, err := TransactionLogsCollection.Find(
-		context.Background(),
-		bson.M{"wallet_id": walletID},
-		opts,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(context.Background())
-	
-	if err = cursor.All(context.Background(), &logs); err != nil {
-		return nil, err
-	}
-	return logs, nil
+	return store.GetTransactionLogsByWallet(walletID, limit)
 }
 
 // System Log operations
 func CreateSystemLog(log *models.SystemLog) error {
-	log.Timestamp = time.Now()
-	_, err := SystemLogsCollection.InsertOne(context.Background(), log)
-	return err
+	return store.CreateSystemLog(log)
 }
 
 func GetSystemLogs(limit int) ([]models.SystemLog, error) {
-	var logs []models.SystemLog
-	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}).SetLimit(int64(limit))
-	cursor, err := SystemLogsCollection.Find(context.Background(), bson.D{}, opts)
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(context.Background())
-	
-	if err = cursor.All(context.Background(), &logs); err != nil {
-		return nil, err
-	}
-	return logs, nil
+	return store.GetSystemLogs(limit)
 }
 
 // Zakat Record operations
 func CreateZakatRecord(record *models.ZakatRecord) error {
-	record.Timestamp = time.Now()
-	_, err := ZakatRecordsCollection.InsertOne(context.Background(), record)
-	return err
+	return store.CreateZakatRecord(record)
 }
 
 func GetZakatRecordsByWallet(walletID string) ([]models.ZakatRecord, error) {
-	var records []models.ZakatRecord
-	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}})
-	cursor, err := ZakatRecordsCollection.Find(
-		context.Background(),
-		bson.M{"wallet_id": walletID},
-		opts,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(context.Background())
-	
-	if err = cursor.All(context.Background(), &records); err != nil {
-		return nil, err
-	}
-	return records, nil
+	return store.GetZakatRecordsByWallet(walletID)
 }
 
 func GetZakatRecordsByMonth(month, year int) ([]models.ZakatRecord, error) {
-	var records []models.ZakatRecord
-	cursor, err := ZakatRecordsCollection.Find(
-		context.Background(),
-		bson.M{"month": month, "year": year},
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(context.Background())
-	
-	if err = cursor.All(context.Background(), &records); err != nil {
-		return nil, err
-	}
-	return records, nil
+	return store.GetZakatRecordsByMonth(month, year)
 }
 
 // Helper function to check if wallet exists
 func WalletExists(walletID string) bool {
-	count, err := WalletsCollection.CountDocuments(
-		context.Background(),
-		bson.M{"_id": walletID},
-	)
-	if err != nil {
-		return false
-	}
-	return count > 0
+	return store.WalletExists(walletID)
+}
+
+// GetAllWallets retrieves every wallet
+func GetAllWallets() ([]models.Wallet, error) {
+	return store.GetAllWallets()
+}
+
+// GetAllUTXOs retrieves every UTXO, spent or not
+func GetAllUTXOs() ([]models.UTXO, error) {
+	return store.GetAllUTXOs()
 }
 
-// InitializeGenesisBlock creates the first block if blockchain is empty
-func InitializeGenesisBlock() error {
-	count, err := BlocksCollection.CountDocuments(context.Background(), bson.D{})
+// InitializeGenesisBlock stores the network's genesis block if the blockchain
+// is empty. A chain that already starts from a different genesis (e.g. one
+// created before genesis blocks were fixed per network) is left as it is.
+func InitializeGenesisBlock(genesis models.Block) error {
+	count, err := store.CountBlocks()
 	if err != nil {
 		return err
 	}
 
 	if count == 0 {
-		genesisBlock := models.Block{
-			Index:        0,
-			Timestamp:    time.Now().Unix(),
-			Transactions: []models.Transaction{},
-			PrevHash:     "0",
-			Hash:         "genesis",
-			Nonce:        0,
-			Difficulty:   0,
-			MerkleRoot:   "genesis",
-		}
-		
-		err = InsertBlock(&genesisBlock)
+		err = InsertBlock(&genesis)
 		if err != nil {
 			return err
 		}
-		log.Println("✅ Genesis block created")
+		log.Printf("✅ Genesis block created (%s)", genesis.Hash)
+		return nil
+	}
+
+	stored, err := store.GetBlockByIndex(0)
+	if err != nil {
+		return err
+	}
+	if stored.Hash != genesis.Hash {
+		log.Printf("⚠️ Stored genesis block %s is not the network genesis %s; peers on the network will reject this chain", stored.Hash, genesis.Hash)
 	}
 	return nil
 }
@@ -507,46 +258,20 @@ func ValidateWalletExists(walletID string) error {
 
 // DeleteBlocksFromIndex deletes all blocks from the specified index onwards
 func DeleteBlocksFromIndex(startIndex int) error {
-	_, err := BlocksCollection.DeleteMany(
-		context.Background(),
-		bson.M{"index": bson.M{"$gte": startIndex}},
-	)
-	return err
+	return store.DeleteBlocksFromIndex(startIndex)
 }
 
 // DeleteUTXOsByTransactionID deletes all UTXOs created by a specific transaction
 func DeleteUTXOsByTransactionID(txID string) error {
-	_, err := UTXOsCollection.DeleteMany(
-		context.Background(),
-		bson.M{"tx_id": txID},
-	)
-	return err
+	return store.DeleteUTXOsByTransactionID(txID)
 }
 
 // GetUTXOsByWalletID retrieves all UTXOs for a specific wallet
 func GetUTXOsByWalletID(walletID string) ([]models.UTXO, error) {
-	var utxos []models.UTXO
-	cursor, err := UTXOsCollection.Find(
-		context.Background(),
-		bson.M{"wallet_id": walletID, "is_spent": false},
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(context.Background())
-	
-	if err = cursor.All(context.Background(), &utxos); err != nil {
-		return nil, err
-	}
-	return utxos, nil
+	return store.GetUnspentUTXOs(walletID)
 }
 
 // UpdateUserBalance updates a user's balance by their user ID
-func UpdateUserBalance(userID string, balance float64) error {
-	_, err := UsersCollection.UpdateOne(
-		context.Background(),
-		bson.M{"_id": userID},
-		bson.M{"$set": bson.M{"balance": balance, "updated_at": time.Now()}},
-	)
-	return err
+func UpdateUserBalance(userID string, balance models.Amount) error {
+	return store.UpdateUserBalance(userID, balance)
 }
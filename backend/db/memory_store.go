@@ -0,0 +1,982 @@
+package db
+
+import (
+	"crypto-wallet/models"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// errDuplicateKey mirrors the unique indexes enforced by MongoStore
+var errDuplicateKey = errors.New("duplicate key error")
+
+// MemoryStore is a fully in-process implementation of Store. It keeps the
+// same ordering, filtering and uniqueness rules as MongoStore so it can be
+// used for unit tests, demos and offline development.
+type MemoryStore struct {
+	mu sync.RWMutex
+
+	users               []models.User
+	wallets             map[string]models.Wallet
+	blocks              []models.Block
+	utxos               []models.UTXO
+	pendingTransactions []models.PendingTransaction
+	transactionLogs     []models.TransactionLog
+	systemLogs          []models.SystemLog
+	zakatRecords        []models.ZakatRecord
+	blockUndo           map[string]models.BlockUndo
+	sideBlocks          map[string]models.SideBlock
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		wallets:    make(map[string]models.Wallet),
+		blockUndo:  make(map[string]models.BlockUndo),
+		sideBlocks: make(map[string]models.SideBlock),
+	}
+}
+
+// Close is a no-op for the in-memory store
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// clone deep-copies a document through BSON so callers never share slices
+// or maps with the store, exactly as if it had been read from MongoDB
+func clone[T any](v T) T {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out T
+	if err := bson.Unmarshal(data, &out); err != nil {
+		return v
+	}
+	return out
+}
+
+func newID() string {
+	return primitive.NewObjectID().Hex()
+}
+
+func utxoKey(txID string, vout int) string {
+	return fmt.Sprintf("%s:%d", txID, vout)
+}
+
+// User operations
+func (s *MemoryStore) CreateUser(user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Email == user.Email || u.WalletID == user.WalletID {
+			return errDuplicateKey
+		}
+	}
+
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = time.Now()
+	if user.ID == "" {
+		user.ID = newID()
+	}
+	s.users = append(s.users, clone(*user))
+	return nil
+}
+
+func (s *MemoryStore) GetUserByEmail(email string) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Case-insensitive match, like the regex query in MongoStore
+	for _, u := range s.users {
+		if strings.EqualFold(u.Email, email) {
+			user := clone(u)
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryStore) GetUserByWalletID(walletID string) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, u := range s.users {
+		if u.WalletID == walletID {
+			user := clone(u)
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// UpdateUser applies a $set-style update keyed by BSON field names
+func (s *MemoryStore) UpdateUser(email string, update map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	update["updated_at"] = time.Now()
+	for i, u := range s.users {
+		if u.Email != email {
+			continue
+		}
+
+		data, err := bson.Marshal(u)
+		if err != nil {
+			return err
+		}
+		var doc bson.M
+		if err := bson.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+		for key, value := range update {
+			doc[key] = value
+		}
+		data, err = bson.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		var updated models.User
+		if err := bson.Unmarshal(data, &updated); err != nil {
+			return err
+		}
+		s.users[i] = updated
+		return nil
+	}
+	return nil
+}
+
+// UpdateUserBalance only touches updated_at; users carry no balance field
+func (s *MemoryStore) UpdateUserBalance(userID string, balance models.Amount) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.users {
+		if s.users[i].ID == userID {
+			s.users[i].UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetAllUsers() ([]models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var users []models.User
+	for _, u := range s.users {
+		users = append(users, clone(u))
+	}
+	return users, nil
+}
+
+// Wallet operations
+func (s *MemoryStore) CreateWallet(wallet *models.Wallet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.wallets[wallet.WalletID]; exists {
+		return errDuplicateKey
+	}
+
+	wallet.CreatedAt = time.Now()
+	wallet.UpdatedAt = time.Now()
+	s.wallets[wallet.WalletID] = clone(*wallet)
+	return nil
+}
+
+func (s *MemoryStore) GetWallet(walletID string) (*models.Wallet, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	w, ok := s.wallets[walletID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	wallet := clone(w)
+	return &wallet, nil
+}
+
+func (s *MemoryStore) UpdateWalletBalance(walletID string, balance models.Amount) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if w, ok := s.wallets[walletID]; ok {
+		w.Balance = balance
+		w.UpdatedAt = time.Now()
+		s.wallets[walletID] = w
+	}
+	return nil
+}
+
+func (s *MemoryStore) UpdateWalletZakatDate(walletID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if w, ok := s.wallets[walletID]; ok {
+		w.LastZakatDate = time.Now()
+		w.UpdatedAt = time.Now()
+		s.wallets[walletID] = w
+	}
+	return nil
+}
+
+func (s *MemoryStore) WalletExists(walletID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.wallets[walletID]
+	return ok
+}
+
+func (s *MemoryStore) GetAllWallets() ([]models.Wallet, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var wallets []models.Wallet
+	for _, w := range s.wallets {
+		wallets = append(wallets, w)
+	}
+	sort.Slice(wallets, func(i, j int) bool {
+		return wallets[i].WalletID < wallets[j].WalletID
+	})
+	return wallets, nil
+}
+
+// Block operations
+func (s *MemoryStore) InsertBlock(block *models.Block) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, b := range s.blocks {
+		if b.Index == block.Index {
+			return errDuplicateKey
+		}
+	}
+
+	s.blocks = append(s.blocks, clone(*block))
+	sort.SliceStable(s.blocks, func(i, j int) bool {
+		return s.blocks[i].Index < s.blocks[j].Index
+	})
+	return nil
+}
+
+func (s *MemoryStore) GetLastBlock() (*models.Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.blocks) == 0 {
+		return nil, ErrNotFound
+	}
+	block := clone(s.blocks[len(s.blocks)-1])
+	return &block, nil
+}
+
+func (s *MemoryStore) GetAllBlocks() ([]models.Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var blocks []models.Block
+	for _, b := range s.blocks {
+		blocks = append(blocks, clone(b))
+	}
+	return blocks, nil
+}
+
+func (s *MemoryStore) GetBlockByIndex(index int) (*models.Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, b := range s.blocks {
+		if b.Index == index {
+			block := clone(b)
+			return &block, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryStore) GetBlockByHash(hash string) (*models.Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, b := range s.blocks {
+		if b.Hash == hash {
+			block := clone(b)
+			return &block, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryStore) CountBlocks() (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return int64(len(s.blocks)), nil
+}
+
+// DeleteBlocksFromIndex deletes all blocks from the specified index onwards
+func (s *MemoryStore) DeleteBlocksFromIndex(startIndex int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.blocks[:0]
+	for _, b := range s.blocks {
+		if b.Index < startIndex {
+			kept = append(kept, b)
+		}
+	}
+	s.blocks = kept
+	return nil
+}
+
+// Side branch operations
+func (s *MemoryStore) SaveSideBlock(side *models.SideBlock) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	side.Hash = side.Block.Hash
+	if side.ReceivedAt.IsZero() {
+		side.ReceivedAt = time.Now()
+	}
+	s.sideBlocks[side.Hash] = clone(*side)
+	return nil
+}
+
+func (s *MemoryStore) GetSideBlock(hash string) (*models.SideBlock, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sb, ok := s.sideBlocks[hash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	side := clone(sb)
+	return &side, nil
+}
+
+func (s *MemoryStore) GetSideBlocks() ([]models.SideBlock, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sides []models.SideBlock
+	for _, sb := range s.sideBlocks {
+		sides = append(sides, clone(sb))
+	}
+	sort.SliceStable(sides, func(i, j int) bool {
+		if sides[i].Block.Index != sides[j].Block.Index {
+			return sides[i].Block.Index < sides[j].Block.Index
+		}
+		return sides[i].Hash < sides[j].Hash
+	})
+	return sides, nil
+}
+
+func (s *MemoryStore) DeleteSideBlock(hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sideBlocks, hash)
+	return nil
+}
+
+// UTXO operations
+func (s *MemoryStore) CreateUTXO(utxo *models.UTXO) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	utxo.CreatedAt = time.Now()
+	if utxo.ID == "" {
+		utxo.ID = newID()
+	}
+	s.utxos = append(s.utxos, clone(*utxo))
+	return nil
+}
+
+func (s *MemoryStore) GetUnspentUTXOs(walletID string) ([]models.UTXO, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var utxos []models.UTXO
+	for _, u := range s.utxos {
+		if u.WalletID == walletID && !u.IsSpent {
+			utxos = append(utxos, u)
+		}
+	}
+	return utxos, nil
+}
+
+func (s *MemoryStore) GetUTXO(txID string, vout int) (*models.UTXO, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, u := range s.utxos {
+		if u.TxID == txID && u.Vout == vout {
+			utxo := u
+			return &utxo, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryStore) MarkUTXOAsSpent(txID string, vout int, spentInTx string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.utxos {
+		if s.utxos[i].TxID == txID && s.utxos[i].Vout == vout {
+			s.utxos[i].IsSpent = true
+			s.utxos[i].SpentInTx = spentInTx
+			return nil
+		}
+	}
+	return nil
+}
+
+// LockUTXO locks a UTXO for a pending transaction
+func (s *MemoryStore) LockUTXO(txID string, vout int, pendingTxID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.utxos {
+		u := &s.utxos[i]
+		if u.TxID == txID && u.Vout == vout && !u.IsSpent && (!u.IsLocked || u.LockedBy == pendingTxID) {
+			u.IsLocked = true
+			u.LockedBy = pendingTxID
+			return nil
+		}
+	}
+	return ErrUTXOUnavailable
+}
+
+// UnlockUTXO unlocks a UTXO (removes lock from pending transaction)
+func (s *MemoryStore) UnlockUTXO(txID string, vout int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.utxos {
+		if s.utxos[i].TxID == txID && s.utxos[i].Vout == vout {
+			s.utxos[i].IsLocked = false
+			s.utxos[i].LockedBy = ""
+			return nil
+		}
+	}
+	return nil
+}
+
+// UnlockUTXOsByPendingTx unlocks all UTXOs locked by a specific pending transaction
+func (s *MemoryStore) UnlockUTXOsByPendingTx(pendingTxID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.utxos {
+		if s.utxos[i].LockedBy == pendingTxID {
+			s.utxos[i].IsLocked = false
+			s.utxos[i].LockedBy = ""
+		}
+	}
+	return nil
+}
+
+// DeleteUTXOsByTransactionID deletes all UTXOs created by a specific transaction
+func (s *MemoryStore) DeleteUTXOsByTransactionID(txID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.utxos[:0]
+	for _, u := range s.utxos {
+		if u.TxID != txID {
+			kept = append(kept, u)
+		}
+	}
+	s.utxos = kept
+	return nil
+}
+
+func (s *MemoryStore) GetAllUTXOs() ([]models.UTXO, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	utxos := append([]models.UTXO(nil), s.utxos...)
+	sort.SliceStable(utxos, func(i, j int) bool {
+		return utxos[i].BlockIndex < utxos[j].BlockIndex
+	})
+	return utxos, nil
+}
+
+// Pending Transaction operations
+func (s *MemoryStore) AddPendingTransaction(ptx *models.PendingTransaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range s.pendingTransactions {
+		if p.ID == ptx.ID {
+			return errDuplicateKey
+		}
+	}
+
+	ptx.CreatedAt = time.Now()
+	ptx.Status = "pending"
+	s.pendingTransactions = append(s.pendingTransactions, clone(*ptx))
+	return nil
+}
+
+// GetPendingTransactions returns pending entries in insertion (created_at) order
+func (s *MemoryStore) GetPendingTransactions() ([]models.PendingTransaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var txs []models.PendingTransaction
+	for _, p := range s.pendingTransactions {
+		if p.Status == "pending" {
+			txs = append(txs, clone(p))
+		}
+	}
+	return txs, nil
+}
+
+func (s *MemoryStore) UpdatePendingTransactionStatus(txID string, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.pendingTransactions {
+		if s.pendingTransactions[i].ID == txID {
+			s.pendingTransactions[i].Status = status
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeletePendingTransaction(txID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, p := range s.pendingTransactions {
+		if p.ID == txID {
+			s.pendingTransactions = append(s.pendingTransactions[:i], s.pendingTransactions[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// Transaction Log operations
+func (s *MemoryStore) CreateTransactionLog(log *models.TransactionLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	log.Timestamp = time.Now()
+	if log.ID == "" {
+		log.ID = newID()
+	}
+	s.transactionLogs = append(s.transactionLogs, *log)
+	return nil
+}
+
+// GetTransactionLogsByWallet returns the newest logs first, up to limit
+func (s *MemoryStore) GetTransactionLogsByWallet(walletID string, limit int) ([]models.TransactionLog, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var logs []models.TransactionLog
+	for i := len(s.transactionLogs) - 1; i >= 0; i-- {
+		if limit > 0 && len(logs) >= limit {
+			break
+		}
+		if s.transactionLogs[i].WalletID == walletID {
+			logs = append(logs, s.transactionLogs[i])
+		}
+	}
+	return logs, nil
+}
+
+// System Log operations
+func (s *MemoryStore) CreateSystemLog(log *models.SystemLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	log.Timestamp = time.Now()
+	if log.ID == "" {
+		log.ID = newID()
+	}
+	s.systemLogs = append(s.systemLogs, clone(*log))
+	return nil
+}
+
+// GetSystemLogs returns the newest logs first, up to limit
+func (s *MemoryStore) GetSystemLogs(limit int) ([]models.SystemLog, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var logs []models.SystemLog
+	for i := len(s.systemLogs) - 1; i >= 0; i-- {
+		if limit > 0 && len(logs) >= limit {
+			break
+		}
+		logs = append(logs, clone(s.systemLogs[i]))
+	}
+	return logs, nil
+}
+
+// Zakat Record operations
+func (s *MemoryStore) CreateZakatRecord(record *models.ZakatRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record.Timestamp = time.Now()
+	if record.ID == "" {
+		record.ID = newID()
+	}
+	s.zakatRecords = append(s.zakatRecords, *record)
+	return nil
+}
+
+// GetZakatRecordsByWallet returns the newest records first
+func (s *MemoryStore) GetZakatRecordsByWallet(walletID string) ([]models.ZakatRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var records []models.ZakatRecord
+	for i := len(s.zakatRecords) - 1; i >= 0; i-- {
+		if s.zakatRecords[i].WalletID == walletID {
+			records = append(records, s.zakatRecords[i])
+		}
+	}
+	return records, nil
+}
+
+func (s *MemoryStore) GetZakatRecordsByMonth(month, year int) ([]models.ZakatRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var records []models.ZakatRecord
+	for _, r := range s.zakatRecords {
+		if r.Month == month && r.Year == year {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+// memorySnapshot captures the mutable collections touched by a block commit
+type memorySnapshot struct {
+	wallets             map[string]models.Wallet
+	blocks              []models.Block
+	utxos               []models.UTXO
+	pendingTransactions []models.PendingTransaction
+	transactionLogs     []models.TransactionLog
+	zakatRecords        []models.ZakatRecord
+	blockUndo           map[string]models.BlockUndo
+}
+
+func (s *MemoryStore) snapshot() memorySnapshot {
+	wallets := make(map[string]models.Wallet, len(s.wallets))
+	for id, w := range s.wallets {
+		wallets[id] = w
+	}
+	blockUndo := make(map[string]models.BlockUndo, len(s.blockUndo))
+	for hash, u := range s.blockUndo {
+		blockUndo[hash] = u
+	}
+	return memorySnapshot{
+		wallets:             wallets,
+		blocks:              append([]models.Block(nil), s.blocks...),
+		utxos:               append([]models.UTXO(nil), s.utxos...),
+		pendingTransactions: append([]models.PendingTransaction(nil), s.pendingTransactions...),
+		transactionLogs:     append([]models.TransactionLog(nil), s.transactionLogs...),
+		zakatRecords:        append([]models.ZakatRecord(nil), s.zakatRecords...),
+		blockUndo:           blockUndo,
+	}
+}
+
+func (s *MemoryStore) restore(snap memorySnapshot) {
+	s.wallets = snap.wallets
+	s.blocks = snap.blocks
+	s.utxos = snap.utxos
+	s.pendingTransactions = snap.pendingTransactions
+	s.transactionLogs = snap.transactionLogs
+	s.zakatRecords = snap.zakatRecords
+	s.blockUndo = snap.blockUndo
+}
+
+// atomically runs fn under the write lock and restores the previous state if it fails
+func (s *MemoryStore) atomically(fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap := s.snapshot()
+	if err := fn(); err != nil {
+		s.restore(snap)
+		return err
+	}
+	return nil
+}
+
+// CommitBlock applies a block commit and writes its undo journal all-or-nothing
+func (s *MemoryStore) CommitBlock(commit *BlockCommit) error {
+	return s.atomically(func() error {
+		for _, b := range s.blocks {
+			if b.Index == commit.Block.Index {
+				return errDuplicateKey
+			}
+		}
+		s.blocks = append(s.blocks, clone(commit.Block))
+		sort.SliceStable(s.blocks, func(i, j int) bool {
+			return s.blocks[i].Index < s.blocks[j].Index
+		})
+
+		undo := commit.prepareUndo()
+		affected := commit.AffectedWallets()
+		txIDs := make(map[string]bool)
+		for _, id := range commit.TransactionIDs() {
+			txIDs[id] = true
+		}
+
+		// Spend inputs; each UTXO may only be spent once
+		for _, ref := range commit.SpentInputs {
+			found := false
+			for i := range s.utxos {
+				u := &s.utxos[i]
+				if u.TxID == ref.TxID && u.Vout == ref.Vout && !u.IsSpent {
+					undo.SpentUTXOs = append(undo.SpentUTXOs, *u)
+					u.IsSpent = true
+					u.SpentInTx = ref.SpentInTx
+					u.IsLocked = false
+					u.LockedBy = ""
+					affected = append(affected, u.WalletID)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return errInputUnavailable(ref)
+			}
+		}
+
+		// Release any remaining locks held by the mined transactions
+		for i := range s.utxos {
+			if txIDs[s.utxos[i].LockedBy] {
+				s.utxos[i].IsLocked = false
+				s.utxos[i].LockedBy = ""
+			}
+		}
+
+		s.utxos = append(s.utxos, commit.CreatedUTXOs...)
+
+		for i := range s.pendingTransactions {
+			if txIDs[s.pendingTransactions[i].ID] {
+				if s.pendingTransactions[i].Status == "pending" {
+					undo.PendingTxIDs = append(undo.PendingTxIDs, s.pendingTransactions[i].ID)
+				}
+				s.pendingTransactions[i].Status = "mined"
+			}
+		}
+
+		s.transactionLogs = append(s.transactionLogs, commit.TransactionLogs...)
+		s.zakatRecords = append(s.zakatRecords, commit.ZakatRecords...)
+		s.blockUndo[undo.BlockHash] = clone(*undo)
+
+		s.refreshBalances(affected)
+		return nil
+	})
+}
+
+// RollbackBlock disconnects the tip block all-or-nothing using its undo journal
+func (s *MemoryStore) RollbackBlock(commit *BlockCommit) error {
+	return s.atomically(func() error {
+		removed := false
+		keptBlocks := s.blocks[:0:0]
+		for _, b := range s.blocks {
+			if b.Index == commit.Block.Index && b.Hash == commit.Block.Hash {
+				removed = true
+				continue
+			}
+			keptBlocks = append(keptBlocks, b)
+		}
+		if !removed {
+			return ErrNotFound
+		}
+		s.blocks = keptBlocks
+
+		undo, ok := s.blockUndo[commit.Block.Hash]
+		if !ok {
+			undo = *commit.legacyUndo(func(txID string, vout int) (*models.UTXO, error) {
+				for _, u := range s.utxos {
+					if u.TxID == txID && u.Vout == vout {
+						utxo := u
+						return &utxo, nil
+					}
+				}
+				return nil, ErrNotFound
+			})
+		}
+
+		affected := commit.AffectedWallets()
+		txIDs := make(map[string]bool)
+		for _, id := range commit.TransactionIDs() {
+			txIDs[id] = true
+		}
+
+		// Remove the outputs this block created and those it consumed, then
+		// put the consumed ones back exactly as they were
+		touched := make(map[string]bool)
+		for _, u := range undo.CreatedUTXOs {
+			touched[utxoKey(u.TxID, u.Vout)] = true
+			affected = append(affected, u.WalletID)
+		}
+		for _, u := range undo.SpentUTXOs {
+			touched[utxoKey(u.TxID, u.Vout)] = true
+			affected = append(affected, u.WalletID)
+		}
+		keptUTXOs := s.utxos[:0:0]
+		for _, u := range s.utxos {
+			if !touched[utxoKey(u.TxID, u.Vout)] {
+				keptUTXOs = append(keptUTXOs, u)
+			}
+		}
+		s.utxos = append(keptUTXOs, undo.SpentUTXOs...)
+
+		// Return transfers to the pending pool and make sure their inputs are locked
+		restored := make(map[string]bool)
+		for _, id := range undo.PendingTxIDs {
+			restored[id] = true
+		}
+		for _, tx := range commit.Block.Transactions {
+			if len(tx.Vin) > 0 {
+				restored[tx.ID] = true
+			}
+		}
+		for _, tx := range commit.Block.Transactions {
+			if !restored[tx.ID] {
+				continue
+			}
+
+			found := false
+			for i := range s.pendingTransactions {
+				if s.pendingTransactions[i].ID == tx.ID {
+					s.pendingTransactions[i].Status = "pending"
+					s.pendingTransactions[i].Transaction = clone(tx)
+					found = true
+					break
+				}
+			}
+			if !found {
+				s.pendingTransactions = append(s.pendingTransactions, models.PendingTransaction{
+					ID:          tx.ID,
+					Transaction: clone(tx),
+					CreatedAt:   time.Now(),
+					Status:      "pending",
+				})
+			}
+
+			for _, input := range tx.Vin {
+				for i := range s.utxos {
+					u := &s.utxos[i]
+					if u.TxID == input.TxID && u.Vout == input.Vout && !u.IsSpent && !u.IsLocked {
+						u.IsLocked = true
+						u.LockedBy = tx.ID
+					}
+				}
+			}
+		}
+
+		logIDs := make(map[string]bool)
+		for _, id := range undo.TransactionLogIDs {
+			logIDs[id] = true
+		}
+		keptLogs := s.transactionLogs[:0:0]
+		for _, l := range s.transactionLogs {
+			if !logIDs[l.ID] && !txIDs[l.TxID] {
+				keptLogs = append(keptLogs, l)
+			}
+		}
+		s.transactionLogs = keptLogs
+
+		zakatIDs := make(map[string]bool)
+		for _, r := range undo.ZakatRecords {
+			zakatIDs[r.ID] = true
+		}
+		keptRecords := s.zakatRecords[:0:0]
+		for _, r := range s.zakatRecords {
+			if !zakatIDs[r.ID] && !txIDs[r.TxID] {
+				keptRecords = append(keptRecords, r)
+			}
+		}
+		s.zakatRecords = keptRecords
+
+		delete(s.blockUndo, commit.Block.Hash)
+
+		s.refreshBalances(affected)
+		return nil
+	})
+}
+
+// GetBlockUndo returns the undo journal stored for a block
+func (s *MemoryStore) GetBlockUndo(blockHash string) (*models.BlockUndo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.blockUndo[blockHash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	undo := clone(u)
+	return &undo, nil
+}
+
+// ReplaceLedgerState swaps UTXOs, transaction logs and balances all-or-nothing
+func (s *MemoryStore) ReplaceLedgerState(state *LedgerState) error {
+	return s.atomically(func() error {
+		s.utxos = nil
+		for _, utxo := range state.UTXOs {
+			if utxo.ID == "" {
+				utxo.ID = newID()
+			}
+			s.utxos = append(s.utxos, utxo)
+		}
+
+		s.transactionLogs = nil
+		for _, txLog := range state.TransactionLogs {
+			if txLog.ID == "" {
+				txLog.ID = newID()
+			}
+			s.transactionLogs = append(s.transactionLogs, txLog)
+		}
+
+		for walletID, w := range s.wallets {
+			w.Balance = state.Balances[walletID]
+			w.UpdatedAt = time.Now()
+			s.wallets[walletID] = w
+		}
+		return nil
+	})
+}
+
+// refreshBalances recomputes the cached balance of each wallet from its UTXOs
+func (s *MemoryStore) refreshBalances(walletIDs []string) {
+	for _, walletID := range walletIDs {
+		w, ok := s.wallets[walletID]
+		if !ok {
+			continue
+		}
+
+		var balance models.Amount
+		for _, u := range s.utxos {
+			if u.WalletID == walletID && !u.IsSpent {
+				balance += u.Amount
+			}
+		}
+		w.Balance = balance
+		w.UpdatedAt = time.Now()
+		s.wallets[walletID] = w
+	}
+}
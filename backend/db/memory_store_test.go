@@ -0,0 +1,47 @@
+package db
+
+import (
+	"crypto-wallet/models"
+	"errors"
+	"testing"
+)
+
+func TestMemoryStoreLockUTXO(t *testing.T) {
+	s := NewMemoryStore()
+	for _, utxo := range []models.UTXO{
+		{TxID: "a", Vout: 0, WalletID: "w", Amount: 1},
+		{TxID: "b", Vout: 0, WalletID: "w", Amount: 1, IsSpent: true},
+	} {
+		if err := s.CreateUTXO(&utxo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.LockUTXO("a", 0, "tx1"); err != nil {
+		t.Fatal(err)
+	}
+	// Locking again for the same transaction is allowed
+	if err := s.LockUTXO("a", 0, "tx1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.LockUTXO("a", 0, "tx2"); !errors.Is(err, ErrUTXOUnavailable) {
+		t.Fatalf("locked by another transaction: %v", err)
+	}
+	if err := s.LockUTXO("b", 0, "tx2"); !errors.Is(err, ErrUTXOUnavailable) {
+		t.Fatalf("spent output: %v", err)
+	}
+	if err := s.LockUTXO("c", 0, "tx2"); !errors.Is(err, ErrUTXOUnavailable) {
+		t.Fatalf("missing output: %v", err)
+	}
+
+	utxo, err := s.GetUTXO("a", 0)
+	if err != nil || utxo.LockedBy != "tx1" {
+		t.Fatalf("locked output = %+v, %v", utxo, err)
+	}
+	if err := s.UnlockUTXOsByPendingTx("tx1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.LockUTXO("a", 0, "tx2"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"context"
+	"crypto-wallet/models"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// amountMigrationID marks the one-time float → fixed-point amount migration
+const amountMigrationID = "fixed_point_amounts"
+
+// migrateAmounts rewrites amounts stored as floating-point coins into integer
+// smallest units. Documents are decoded through the models (which convert
+// legacy doubles) and only the amount-bearing fields are written back, so the
+// migration is idempotent and safe to interrupt.
+func (s *MongoStore) migrateAmounts() error {
+	ctx := context.Background()
+	migrations := s.Database.Collection("migrations")
+
+	if err := migrations.FindOne(ctx, bson.M{"_id": amountMigrationID}).Err(); err == nil {
+		return nil
+	} else if err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	total := 0
+	steps := []func() (int, error){
+		func() (int, error) {
+			return migrateAmountFields[models.Block](ctx, s.BlocksCollection, "transactions.amount", "transactions.vout.value")
+		},
+		func() (int, error) {
+			return migrateAmountFields[models.PendingTransaction](ctx, s.PendingTransactionsCollection, "transaction.amount", "transaction.vout.value")
+		},
+		func() (int, error) {
+			return migrateAmountFields[models.UTXO](ctx, s.UTXOsCollection, "amount")
+		},
+		func() (int, error) {
+			return migrateAmountFields[models.Wallet](ctx, s.WalletsCollection, "balance")
+		},
+		func() (int, error) {
+			return migrateAmountFields[models.TransactionLog](ctx, s.TransactionLogsCollection, "amount")
+		},
+		func() (int, error) {
+			return migrateAmountFields[models.ZakatRecord](ctx, s.ZakatRecordsCollection, "amount", "balance_at_deduction")
+		},
+		func() (int, error) {
+			return migrateAmountFields[models.BlockUndo](ctx, s.BlockUndoCollection,
+				"spent_utxos.amount", "created_utxos.amount", "zakat_records.amount", "zakat_records.balance_at_deduction")
+		},
+		func() (int, error) {
+			return migrateUserBalances(ctx, s.UsersCollection)
+		},
+	}
+
+	for _, step := range steps {
+		n, err := step()
+		if err != nil {
+			return err
+		}
+		total += n
+	}
+
+	_, err := migrations.InsertOne(ctx, bson.M{
+		"_id":        amountMigrationID,
+		"documents":  total,
+		"applied_at": time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if total > 0 {
+		log.Printf("🔢 Migrated %d documents to fixed-point amounts", total)
+	}
+	return nil
+}
+
+// migrateAmountFields re-encodes every document that still holds a double at
+// one of the given paths, setting the top-level fields those paths live under
+func migrateAmountFields[T any](ctx context.Context, collection *mongo.Collection, paths ...string) (int, error) {
+	var legacy []bson.M
+	fields := make(map[string]bool)
+	for _, path := range paths {
+		legacy = append(legacy, bson.M{path: bson.M{"$type": "double"}})
+		fields[strings.SplitN(path, ".", 2)[0]] = true
+	}
+
+	cursor, err := collection.Find(ctx, bson.M{"$or": legacy})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		id := cursor.Current.Lookup("_id")
+
+		var doc T
+		if err := cursor.Decode(&doc); err != nil {
+			return migrated, err
+		}
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			return migrated, err
+		}
+		var encoded bson.M
+		if err := bson.Unmarshal(data, &encoded); err != nil {
+			return migrated, err
+		}
+
+		set := bson.M{}
+		for field := range fields {
+			set[field] = encoded[field]
+		}
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set}); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+
+	return migrated, cursor.Err()
+}
+
+// migrateUserBalances converts the balance field UpdateUserBalance keeps on user documents
+func migrateUserBalances(ctx context.Context, collection *mongo.Collection) (int, error) {
+	cursor, err := collection.Find(ctx, bson.M{"balance": bson.M{"$type": "double"}})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		var balance models.Amount
+		if err := cursor.Current.Lookup("balance").Unmarshal(&balance); err != nil {
+			return migrated, err
+		}
+		_, err := collection.UpdateOne(ctx,
+			bson.M{"_id": cursor.Current.Lookup("_id")},
+			bson.M{"$set": bson.M{"balance": balance}},
+		)
+		if err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+
+	return migrated, cursor.Err()
+}
@@ -0,0 +1,942 @@
+package db
+
+import (
+	"context"
+	"crypto-wallet/models"
+	"log"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore is the MongoDB implementation of Store
+type MongoStore struct {
+	Client   *mongo.Client
+	Database *mongo.Database
+
+	BlocksCollection              *mongo.Collection
+	UsersCollection               *mongo.Collection
+	WalletsCollection             *mongo.Collection
+	UTXOsCollection               *mongo.Collection
+	PendingTransactionsCollection *mongo.Collection
+	TransactionLogsCollection     *mongo.Collection
+	SystemLogsCollection          *mongo.Collection
+	ZakatRecordsCollection        *mongo.Collection
+	BlockUndoCollection           *mongo.Collection
+	SideBlocksCollection          *mongo.Collection
+}
+
+// NewMongoStore connects to MongoDB and prepares the collections and indexes
+func NewMongoStore(uri, dbName string) (*MongoStore, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clientOptions := options.Client().ApplyURI(uri)
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ping the database
+	err = client.Ping(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	database := client.Database(dbName)
+	s := &MongoStore{
+		Client:                        client,
+		Database:                      database,
+		BlocksCollection:              database.Collection("blocks"),
+		UsersCollection:               database.Collection("users"),
+		WalletsCollection:             database.Collection("wallets"),
+		UTXOsCollection:               database.Collection("utxos"),
+		PendingTransactionsCollection: database.Collection("pending_transactions"),
+		TransactionLogsCollection:     database.Collection("transaction_logs"),
+		SystemLogsCollection:          database.Collection("system_logs"),
+		ZakatRecordsCollection:        database.Collection("zakat_records"),
+		BlockUndoCollection:           database.Collection("block_undo"),
+		SideBlocksCollection:          database.Collection("side_blocks"),
+	}
+
+	// Create indexes
+	s.createIndexes()
+
+	// Convert amounts written before they were fixed-point (runs once)
+	if err := s.migrateAmounts(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// createIndexes creates necessary indexes for efficient queries
+func (s *MongoStore) createIndexes() {
+	ctx := context.Background()
+
+	// Users indexes
+	s.UsersCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	s.UsersCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "wallet_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+
+	// Wallets indexes
+	s.WalletsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}},
+	})
+
+	// UTXOs indexes
+	s.UTXOsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "wallet_id", Value: 1}, {Key: "is_spent", Value: 1}},
+	})
+	s.UTXOsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "tx_id", Value: 1}, {Key: "vout", Value: 1}},
+	})
+
+	// Blocks index
+	s.BlocksCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "index", Value: -1}},
+		Options: options.Index().SetUnique(true),
+	})
+
+	// Block undo journal index
+	s.BlockUndoCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "block_index", Value: -1}},
+	})
+	s.BlocksCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "hash", Value: 1}},
+	})
+	s.SideBlocksCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "block.prev_hash", Value: 1}},
+	})
+
+	// Transaction logs index
+	s.TransactionLogsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "wallet_id", Value: 1}, {Key: "timestamp", Value: -1}},
+	})
+
+	// System logs index
+	s.SystemLogsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "timestamp", Value: -1}},
+	})
+
+	log.Println("✅ Database indexes created")
+}
+
+// Close disconnects the MongoDB client
+func (s *MongoStore) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return s.Client.Disconnect(ctx)
+}
+
+// insertedID returns the hex form of a generated ObjectID, or "" otherwise
+func insertedID(res *mongo.InsertOneResult) string {
+	if oid, ok := res.InsertedID.(primitive.ObjectID); ok {
+		return oid.Hex()
+	}
+	return ""
+}
+
+// User operations
+func (s *MongoStore) CreateUser(user *models.User) error {
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = time.Now()
+	res, err := s.UsersCollection.InsertOne(context.Background(), user)
+	if err != nil {
+		return err
+	}
+	if user.ID == "" {
+		user.ID = insertedID(res)
+	}
+	return nil
+}
+
+func (s *MongoStore) GetUserByEmail(email string) (*models.User, error) {
+	var user models.User
+	// Case-insensitive email search using regex
+	// Escape special regex characters in email
+	escapedEmail := regexp.QuoteMeta(email)
+	filter := bson.M{"email": bson.M{"$regex": "^" + escapedEmail + "$", "$options": "i"}}
+	err := s.UsersCollection.FindOne(context.Background(), filter).Decode(&user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *MongoStore) GetUserByWalletID(walletID string) (*models.User, error) {
+	var user models.User
+	err := s.UsersCollection.FindOne(context.Background(), bson.M{"wallet_id": walletID}).Decode(&user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *MongoStore) UpdateUser(email string, update map[string]interface{}) error {
+	update["updated_at"] = time.Now()
+	_, err := s.UsersCollection.UpdateOne(
+		context.Background(),
+		bson.M{"email": email},
+		bson.M{"$set": update},
+	)
+	return err
+}
+
+// UpdateUserBalance updates a user's balance by their user ID
+func (s *MongoStore) UpdateUserBalance(userID string, balance models.Amount) error {
+	_, err := s.UsersCollection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"balance": balance, "updated_at": time.Now()}},
+	)
+	return err
+}
+
+func (s *MongoStore) GetAllUsers() ([]models.User, error) {
+	var users []models.User
+	cursor, err := s.UsersCollection.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	if err = cursor.All(context.Background(), &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Wallet operations
+func (s *MongoStore) CreateWallet(wallet *models.Wallet) error {
+	wallet.CreatedAt = time.Now()
+	wallet.UpdatedAt = time.Now()
+	_, err := s.WalletsCollection.InsertOne(context.Background(), wallet)
+	return err
+}
+
+func (s *MongoStore) GetWallet(walletID string) (*models.Wallet, error) {
+	var wallet models.Wallet
+	err := s.WalletsCollection.FindOne(context.Background(), bson.M{"_id": walletID}).Decode(&wallet)
+	if err != nil {
+		return nil, err
+	}
+	return &wallet, nil
+}
+
+func (s *MongoStore) UpdateWalletBalance(walletID string, balance models.Amount) error {
+	_, err := s.WalletsCollection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": walletID},
+		bson.M{"$set": bson.M{"balance": balance, "updated_at": time.Now()}},
+	)
+	return err
+}
+
+func (s *MongoStore) UpdateWalletZakatDate(walletID string) error {
+	_, err := s.WalletsCollection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": walletID},
+		bson.M{"$set": bson.M{"last_zakat_date": time.Now(), "updated_at": time.Now()}},
+	)
+	return err
+}
+
+func (s *MongoStore) WalletExists(walletID string) bool {
+	count, err := s.WalletsCollection.CountDocuments(
+		context.Background(),
+		bson.M{"_id": walletID},
+	)
+	if err != nil {
+		return false
+	}
+	return count > 0
+}
+
+func (s *MongoStore) GetAllWallets() ([]models.Wallet, error) {
+	var wallets []models.Wallet
+	cursor, err := s.WalletsCollection.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	if err = cursor.All(context.Background(), &wallets); err != nil {
+		return nil, err
+	}
+	return wallets, nil
+}
+
+// Block operations
+func (s *MongoStore) InsertBlock(block *models.Block) error {
+	_, err := s.BlocksCollection.InsertOne(context.Background(), block)
+	return err
+}
+
+func (s *MongoStore) GetLastBlock() (*models.Block, error) {
+	var block models.Block
+	opts := options.FindOne().SetSort(bson.D{{Key: "index", Value: -1}})
+	err := s.BlocksCollection.FindOne(context.Background(), bson.D{}, opts).Decode(&block)
+	if err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+func (s *MongoStore) GetAllBlocks() ([]models.Block, error) {
+	var blocks []models.Block
+	opts := options.Find().SetSort(bson.D{{Key: "index", Value: 1}})
+	cursor, err := s.BlocksCollection.Find(context.Background(), bson.D{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	if err = cursor.All(context.Background(), &blocks); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+func (s *MongoStore) GetBlockByIndex(index int) (*models.Block, error) {
+	var block models.Block
+	err := s.BlocksCollection.FindOne(context.Background(), bson.M{"index": index}).Decode(&block)
+	if err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+func (s *MongoStore) GetBlockByHash(hash string) (*models.Block, error) {
+	var block models.Block
+	err := s.BlocksCollection.FindOne(context.Background(), bson.M{"hash": hash}).Decode(&block)
+	if err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+func (s *MongoStore) CountBlocks() (int64, error) {
+	return s.BlocksCollection.CountDocuments(context.Background(), bson.D{})
+}
+
+// DeleteBlocksFromIndex deletes all blocks from the specified index onwards
+func (s *MongoStore) DeleteBlocksFromIndex(startIndex int) error {
+	_, err := s.BlocksCollection.DeleteMany(
+		context.Background(),
+		bson.M{"index": bson.M{"$gte": startIndex}},
+	)
+	return err
+}
+
+// Side branch operations
+func (s *MongoStore) SaveSideBlock(side *models.SideBlock) error {
+	side.Hash = side.Block.Hash
+	if side.ReceivedAt.IsZero() {
+		side.ReceivedAt = time.Now()
+	}
+	_, err := s.SideBlocksCollection.ReplaceOne(
+		context.Background(),
+		bson.M{"_id": side.Hash},
+		side,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+func (s *MongoStore) GetSideBlock(hash string) (*models.SideBlock, error) {
+	var side models.SideBlock
+	err := s.SideBlocksCollection.FindOne(context.Background(), bson.M{"_id": hash}).Decode(&side)
+	if err != nil {
+		return nil, err
+	}
+	return &side, nil
+}
+
+func (s *MongoStore) GetSideBlocks() ([]models.SideBlock, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "block.index", Value: 1}})
+	cursor, err := s.SideBlocksCollection.Find(context.Background(), bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var sides []models.SideBlock
+	if err = cursor.All(context.Background(), &sides); err != nil {
+		return nil, err
+	}
+	return sides, nil
+}
+
+func (s *MongoStore) DeleteSideBlock(hash string) error {
+	_, err := s.SideBlocksCollection.DeleteOne(context.Background(), bson.M{"_id": hash})
+	return err
+}
+
+// UTXO operations
+func (s *MongoStore) CreateUTXO(utxo *models.UTXO) error {
+	utxo.CreatedAt = time.Now()
+	_, err := s.UTXOsCollection.InsertOne(context.Background(), utxo)
+	return err
+}
+
+func (s *MongoStore) GetUnspentUTXOs(walletID string) ([]models.UTXO, error) {
+	var utxos []models.UTXO
+	cursor, err := s.UTXOsCollection.Find(
+		context.Background(),
+		bson.M{"wallet_id": walletID, "is_spent": false},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	if err = cursor.All(context.Background(), &utxos); err != nil {
+		return nil, err
+	}
+	return utxos, nil
+}
+
+func (s *MongoStore) GetUTXO(txID string, vout int) (*models.UTXO, error) {
+	var utxo models.UTXO
+	err := s.UTXOsCollection.FindOne(
+		context.Background(),
+		bson.M{"tx_id": txID, "vout": vout},
+	).Decode(&utxo)
+	if err != nil {
+		return nil, err
+	}
+	return &utxo, nil
+}
+
+func (s *MongoStore) MarkUTXOAsSpent(txID string, vout int, spentInTx string) error {
+	_, err := s.UTXOsCollection.UpdateOne(
+		context.Background(),
+		bson.M{"tx_id": txID, "vout": vout},
+		bson.M{"$set": bson.M{"is_spent": true, "spent_in_tx": spentInTx}},
+	)
+	return err
+}
+
+// LockUTXO locks a UTXO for a pending transaction
+func (s *MongoStore) LockUTXO(txID string, vout int, pendingTxID string) error {
+	result, err := s.UTXOsCollection.UpdateOne(
+		context.Background(),
+		bson.M{
+			"tx_id":    txID,
+			"vout":     vout,
+			"is_spent": false,
+			"$or":      bson.A{bson.M{"is_locked": false}, bson.M{"locked_by": pendingTxID}},
+		},
+		bson.M{"$set": bson.M{"is_locked": true, "locked_by": pendingTxID}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUTXOUnavailable
+	}
+	return nil
+}
+
+// UnlockUTXO unlocks a UTXO (removes lock from pending transaction)
+func (s *MongoStore) UnlockUTXO(txID string, vout int) error {
+	_, err := s.UTXOsCollection.UpdateOne(
+		context.Background(),
+		bson.M{"tx_id": txID, "vout": vout},
+		bson.M{"$set": bson.M{"is_locked": false}, "$unset": bson.M{"locked_by": ""}},
+	)
+	return err
+}
+
+// UnlockUTXOsByPendingTx unlocks all UTXOs locked by a specific pending transaction
+func (s *MongoStore) UnlockUTXOsByPendingTx(pendingTxID string) error {
+	_, err := s.UTXOsCollection.UpdateMany(
+		context.Background(),
+		bson.M{"locked_by": pendingTxID},
+		bson.M{"$set": bson.M{"is_locked": false}, "$unset": bson.M{"locked_by": ""}},
+	)
+	return err
+}
+
+// DeleteUTXOsByTransactionID deletes all UTXOs created by a specific transaction
+func (s *MongoStore) DeleteUTXOsByTransactionID(txID string) error {
+	_, err := s.UTXOsCollection.DeleteMany(
+		context.Background(),
+		bson.M{"tx_id": txID},
+	)
+	return err
+}
+
+func (s *MongoStore) GetAllUTXOs() ([]models.UTXO, error) {
+	var utxos []models.UTXO
+	opts := options.Find().SetSort(bson.D{{Key: "block_index", Value: 1}})
+	cursor, err := s.UTXOsCollection.Find(context.Background(), bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	if err = cursor.All(context.Background(), &utxos); err != nil {
+		return nil, err
+	}
+	return utxos, nil
+}
+
+// Pending Transaction operations
+func (s *MongoStore) AddPendingTransaction(ptx *models.PendingTransaction) error {
+	ptx.CreatedAt = time.Now()
+	ptx.Status = "pending"
+	_, err := s.PendingTransactionsCollection.InsertOne(context.Background(), ptx)
+	return err
+}
+
+func (s *MongoStore) GetPendingTransactions() ([]models.PendingTransaction, error) {
+	var txs []models.PendingTransaction
+	cursor, err := s.PendingTransactionsCollection.Find(
+		context.Background(),
+		bson.M{"status": "pending"},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	if err = cursor.All(context.Background(), &txs); err != nil {
+		return nil, err
+	}
+	return txs, nil
+}
+
+func (s *MongoStore) UpdatePendingTransactionStatus(txID string, status string) error {
+	_, err := s.PendingTransactionsCollection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": txID},
+		bson.M{"$set": bson.M{"status": status}},
+	)
+	return err
+}
+
+func (s *MongoStore) DeletePendingTransaction(txID string) error {
+	_, err := s.PendingTransactionsCollection.DeleteOne(
+		context.Background(),
+		bson.M{"_id": txID},
+	)
+	return err
+}
+
+// Transaction Log operations
+func (s *MongoStore) CreateTransactionLog(log *models.TransactionLog) error {
+	log.Timestamp = time.Now()
+	_, err := s.TransactionLogsCollection.InsertOne(context.Background(), log)
+	return err
+}
+
+func (s *MongoStore) GetTransactionLogsByWallet(walletID string, limit int) ([]models.TransactionLog, error) {
+	var logs []models.TransactionLog
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := s.TransactionLogsCollection.Find(
+		context.Background(),
+		bson.M{"wallet_id": walletID},
+		opts,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	if err = cursor.All(context.Background(), &logs); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// System Log operations
+func (s *MongoStore) CreateSystemLog(log *models.SystemLog) error {
+	log.Timestamp = time.Now()
+	_, err := s.SystemLogsCollection.InsertOne(context.Background(), log)
+	return err
+}
+
+func (s *MongoStore) GetSystemLogs(limit int) ([]models.SystemLog, error) {
+	var logs []models.SystemLog
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := s.SystemLogsCollection.Find(context.Background(), bson.D{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	if err = cursor.All(context.Background(), &logs); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// Zakat Record operations
+func (s *MongoStore) CreateZakatRecord(record *models.ZakatRecord) error {
+	record.Timestamp = time.Now()
+	_, err := s.ZakatRecordsCollection.InsertOne(context.Background(), record)
+	return err
+}
+
+func (s *MongoStore) GetZakatRecordsByWallet(walletID string) ([]models.ZakatRecord, error) {
+	var records []models.ZakatRecord
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}})
+	cursor, err := s.ZakatRecordsCollection.Find(
+		context.Background(),
+		bson.M{"wallet_id": walletID},
+		opts,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	if err = cursor.All(context.Background(), &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (s *MongoStore) GetZakatRecordsByMonth(month, year int) ([]models.ZakatRecord, error) {
+	var records []models.ZakatRecord
+	cursor, err := s.ZakatRecordsCollection.Find(
+		context.Background(),
+		bson.M{"month": month, "year": year},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	if err = cursor.All(context.Background(), &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// CommitBlock applies a block commit inside a multi-document transaction and
+// writes the block's undo journal in the same transaction.
+// MongoDB transactions require a replica set or sharded cluster (Atlas
+// clusters always are); a standalone server will reject the commit.
+func (s *MongoStore) CommitBlock(commit *BlockCommit) error {
+	return s.withTransaction(func(ctx mongo.SessionContext) error {
+		if _, err := s.BlocksCollection.InsertOne(ctx, commit.Block); err != nil {
+			return err
+		}
+
+		undo := commit.prepareUndo()
+		affected := commit.AffectedWallets()
+
+		// Spend inputs; the filter guarantees each UTXO is spent exactly once.
+		// FindOneAndUpdate returns the document as it was before the spend.
+		for _, ref := range commit.SpentInputs {
+			var spent models.UTXO
+			err := s.UTXOsCollection.FindOneAndUpdate(
+				ctx,
+				bson.M{"tx_id": ref.TxID, "vout": ref.Vout, "is_spent": false},
+				bson.M{
+					"$set":   bson.M{"is_spent": true, "spent_in_tx": ref.SpentInTx, "is_locked": false},
+					"$unset": bson.M{"locked_by": ""},
+				},
+			).Decode(&spent)
+			if err == mongo.ErrNoDocuments {
+				return errInputUnavailable(ref)
+			}
+			if err != nil {
+				return err
+			}
+			undo.SpentUTXOs = append(undo.SpentUTXOs, spent)
+			affected = append(affected, spent.WalletID)
+		}
+
+		txIDs := commit.TransactionIDs()
+
+		// Release any remaining locks held by the mined transactions
+		if _, err := s.UTXOsCollection.UpdateMany(
+			ctx,
+			bson.M{"locked_by": bson.M{"$in": txIDs}},
+			bson.M{"$set": bson.M{"is_locked": false}, "$unset": bson.M{"locked_by": ""}},
+		); err != nil {
+			return err
+		}
+
+		if len(commit.CreatedUTXOs) > 0 {
+			docs := make([]interface{}, 0, len(commit.CreatedUTXOs))
+			for _, utxo := range commit.CreatedUTXOs {
+				docs = append(docs, utxo)
+			}
+			if _, err := s.UTXOsCollection.InsertMany(ctx, docs); err != nil {
+				return err
+			}
+		}
+
+		// Remember which pool entries this block mined so a rollback can restore them
+		cursor, err := s.PendingTransactionsCollection.Find(ctx, bson.M{"_id": bson.M{"$in": txIDs}, "status": "pending"})
+		if err != nil {
+			return err
+		}
+		var mined []models.PendingTransaction
+		if err := cursor.All(ctx, &mined); err != nil {
+			return err
+		}
+		for _, ptx := range mined {
+			undo.PendingTxIDs = append(undo.PendingTxIDs, ptx.ID)
+		}
+
+		if _, err := s.PendingTransactionsCollection.UpdateMany(
+			ctx,
+			bson.M{"_id": bson.M{"$in": txIDs}},
+			bson.M{"$set": bson.M{"status": "mined"}},
+		); err != nil {
+			return err
+		}
+
+		for _, txLog := range commit.TransactionLogs {
+			if _, err := s.TransactionLogsCollection.InsertOne(ctx, txLog); err != nil {
+				return err
+			}
+		}
+
+		for _, record := range commit.ZakatRecords {
+			if _, err := s.ZakatRecordsCollection.InsertOne(ctx, record); err != nil {
+				return err
+			}
+		}
+
+		if _, err := s.BlockUndoCollection.InsertOne(ctx, undo); err != nil {
+			return err
+		}
+
+		return s.refreshBalances(ctx, affected)
+	})
+}
+
+// RollbackBlock disconnects the tip block inside a transaction, replaying its
+// undo journal so spent UTXOs, pool entries, logs and zakat records are
+// restored exactly. Blocks committed before undo journals existed fall back
+// to reversing the block's own transactions.
+func (s *MongoStore) RollbackBlock(commit *BlockCommit) error {
+	return s.withTransaction(func(ctx mongo.SessionContext) error {
+		res, err := s.BlocksCollection.DeleteOne(ctx, bson.M{"index": commit.Block.Index, "hash": commit.Block.Hash})
+		if err != nil {
+			return err
+		}
+		if res.DeletedCount == 0 {
+			return ErrNotFound
+		}
+
+		var undo models.BlockUndo
+		err = s.BlockUndoCollection.FindOne(ctx, bson.M{"_id": commit.Block.Hash}).Decode(&undo)
+		if err == mongo.ErrNoDocuments {
+			undo = *commit.legacyUndo(func(txID string, vout int) (*models.UTXO, error) {
+				var utxo models.UTXO
+				err := s.UTXOsCollection.FindOne(ctx, bson.M{"tx_id": txID, "vout": vout}).Decode(&utxo)
+				return &utxo, err
+			})
+		} else if err != nil {
+			return err
+		}
+
+		affected := commit.AffectedWallets()
+		txIDs := commit.TransactionIDs()
+
+		// Remove the outputs this block created
+		for _, utxo := range undo.CreatedUTXOs {
+			if _, err := s.UTXOsCollection.DeleteMany(ctx, bson.M{"tx_id": utxo.TxID, "vout": utxo.Vout}); err != nil {
+				return err
+			}
+			affected = append(affected, utxo.WalletID)
+		}
+
+		// Restore the outputs this block consumed exactly as they were
+		for _, utxo := range undo.SpentUTXOs {
+			if _, err := s.UTXOsCollection.DeleteMany(ctx, bson.M{"tx_id": utxo.TxID, "vout": utxo.Vout}); err != nil {
+				return err
+			}
+			if _, err := s.UTXOsCollection.InsertOne(ctx, utxo); err != nil {
+				return err
+			}
+			affected = append(affected, utxo.WalletID)
+		}
+
+		// Return transfers to the pending pool and make sure their inputs are locked
+		for _, tx := range commit.Block.Transactions {
+			if len(tx.Vin) == 0 {
+				continue
+			}
+			if _, err := s.PendingTransactionsCollection.UpdateOne(
+				ctx,
+				bson.M{"_id": tx.ID},
+				bson.M{
+					"$set":         bson.M{"status": "pending", "transaction": tx},
+					"$setOnInsert": bson.M{"created_at": time.Now()},
+				},
+				options.Update().SetUpsert(true),
+			); err != nil {
+				return err
+			}
+			for _, input := range tx.Vin {
+				if _, err := s.UTXOsCollection.UpdateOne(
+					ctx,
+					bson.M{"tx_id": input.TxID, "vout": input.Vout, "is_spent": false, "is_locked": false},
+					bson.M{"$set": bson.M{"is_locked": true, "locked_by": tx.ID}},
+				); err != nil {
+					return err
+				}
+			}
+		}
+		if len(undo.PendingTxIDs) > 0 {
+			if _, err := s.PendingTransactionsCollection.UpdateMany(
+				ctx,
+				bson.M{"_id": bson.M{"$in": undo.PendingTxIDs}},
+				bson.M{"$set": bson.M{"status": "pending"}},
+			); err != nil {
+				return err
+			}
+		}
+
+		if _, err := s.TransactionLogsCollection.DeleteMany(ctx, bson.M{"$or": bson.A{
+			bson.M{"_id": bson.M{"$in": undo.TransactionLogIDs}},
+			bson.M{"tx_id": bson.M{"$in": txIDs}},
+		}}); err != nil {
+			return err
+		}
+
+		var zakatIDs []string
+		for _, record := range undo.ZakatRecords {
+			zakatIDs = append(zakatIDs, record.ID)
+		}
+		if _, err := s.ZakatRecordsCollection.DeleteMany(ctx, bson.M{"$or": bson.A{
+			bson.M{"_id": bson.M{"$in": zakatIDs}},
+			bson.M{"tx_id": bson.M{"$in": txIDs}},
+		}}); err != nil {
+			return err
+		}
+
+		if _, err := s.BlockUndoCollection.DeleteOne(ctx, bson.M{"_id": commit.Block.Hash}); err != nil {
+			return err
+		}
+
+		return s.refreshBalances(ctx, affected)
+	})
+}
+
+// GetBlockUndo returns the undo journal stored for a block
+func (s *MongoStore) GetBlockUndo(blockHash string) (*models.BlockUndo, error) {
+	var undo models.BlockUndo
+	err := s.BlockUndoCollection.FindOne(context.Background(), bson.M{"_id": blockHash}).Decode(&undo)
+	if err != nil {
+		return nil, err
+	}
+	return &undo, nil
+}
+
+// ReplaceLedgerState swaps UTXOs, transaction logs and balances in one transaction
+func (s *MongoStore) ReplaceLedgerState(state *LedgerState) error {
+	return s.withTransaction(func(ctx mongo.SessionContext) error {
+		if _, err := s.UTXOsCollection.DeleteMany(ctx, bson.M{}); err != nil {
+			return err
+		}
+		if len(state.UTXOs) > 0 {
+			docs := make([]interface{}, 0, len(state.UTXOs))
+			for _, utxo := range state.UTXOs {
+				docs = append(docs, utxo)
+			}
+			if _, err := s.UTXOsCollection.InsertMany(ctx, docs); err != nil {
+				return err
+			}
+		}
+
+		if _, err := s.TransactionLogsCollection.DeleteMany(ctx, bson.M{}); err != nil {
+			return err
+		}
+		if len(state.TransactionLogs) > 0 {
+			docs := make([]interface{}, 0, len(state.TransactionLogs))
+			for _, txLog := range state.TransactionLogs {
+				docs = append(docs, txLog)
+			}
+			if _, err := s.TransactionLogsCollection.InsertMany(ctx, docs); err != nil {
+				return err
+			}
+		}
+
+		if _, err := s.WalletsCollection.UpdateMany(
+			ctx,
+			bson.M{},
+			bson.M{"$set": bson.M{"balance": models.Amount(0), "updated_at": time.Now()}},
+		); err != nil {
+			return err
+		}
+		for walletID, balance := range state.Balances {
+			if _, err := s.WalletsCollection.UpdateOne(
+				ctx,
+				bson.M{"_id": walletID},
+				bson.M{"$set": bson.M{"balance": balance}},
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// withTransaction runs fn inside a MongoDB session transaction
+func (s *MongoStore) withTransaction(fn func(ctx mongo.SessionContext) error) error {
+	ctx := context.Background()
+	session, err := s.Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	return err
+}
+
+// refreshBalances recomputes the cached balance of each wallet from its UTXOs
+func (s *MongoStore) refreshBalances(ctx context.Context, walletIDs []string) error {
+	seen := make(map[string]bool)
+	for _, walletID := range walletIDs {
+		if walletID == "" || seen[walletID] {
+			continue
+		}
+		seen[walletID] = true
+
+		cursor, err := s.UTXOsCollection.Find(ctx, bson.M{"wallet_id": walletID, "is_spent": false})
+		if err != nil {
+			return err
+		}
+		var utxos []models.UTXO
+		if err := cursor.All(ctx, &utxos); err != nil {
+			return err
+		}
+
+		var balance models.Amount
+		for _, utxo := range utxos {
+			balance += utxo.Amount
+		}
+
+		if _, err := s.WalletsCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": walletID},
+			bson.M{"$set": bson.M{"balance": balance, "updated_at": time.Now()}},
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"crypto-wallet/models"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrNotFound is returned by every Store when a lookup matches no document.
+// It is the same value the Mongo driver returns so existing checks keep working.
+var ErrNotFound = mongo.ErrNoDocuments
+
+// ErrUTXOUnavailable is returned by LockUTXO when the output is missing,
+// spent or already locked by another pending transaction
+var ErrUTXOUnavailable = errors.New("output is spent or locked by another transaction")
+
+// Store is the persistence layer behind the db package. The package-level
+// functions (GetUserByEmail, InsertBlock, ...) delegate to the active Store,
+// which is MongoDB in production and an in-memory map for tests, demos and
+// offline development.
+type Store interface {
+	// User operations
+	CreateUser(user *models.User) error
+	GetUserByEmail(email string) (*models.User, error)
+	GetUserByWalletID(walletID string) (*models.User, error)
+	UpdateUser(email string, update map[string]interface{}) error
+	UpdateUserBalance(userID string, balance models.Amount) error
+	GetAllUsers() ([]models.User, error)
+
+	// Wallet operations
+	CreateWallet(wallet *models.Wallet) error
+	GetWallet(walletID string) (*models.Wallet, error)
+	UpdateWalletBalance(walletID string, balance models.Amount) error
+	UpdateWalletZakatDate(walletID string) error
+	WalletExists(walletID string) bool
+	GetAllWallets() ([]models.Wallet, error)
+
+	// Block operations
+	InsertBlock(block *models.Block) error
+	GetLastBlock() (*models.Block, error)
+	GetAllBlocks() ([]models.Block, error)
+	GetBlockByIndex(index int) (*models.Block, error)
+	GetBlockByHash(hash string) (*models.Block, error)
+	CountBlocks() (int64, error)
+	DeleteBlocksFromIndex(startIndex int) error
+
+	// Side branch operations (blocks off the active chain, keyed by hash)
+	SaveSideBlock(side *models.SideBlock) error
+	GetSideBlock(hash string) (*models.SideBlock, error)
+	GetSideBlocks() ([]models.SideBlock, error)
+	DeleteSideBlock(hash string) error
+
+	// UTXO operations
+	CreateUTXO(utxo *models.UTXO) error
+	GetUnspentUTXOs(walletID string) ([]models.UTXO, error)
+	GetUTXO(txID string, vout int) (*models.UTXO, error)
+	MarkUTXOAsSpent(txID string, vout int, spentInTx string) error
+	LockUTXO(txID string, vout int, pendingTxID string) error
+	UnlockUTXO(txID string, vout int) error
+	UnlockUTXOsByPendingTx(pendingTxID string) error
+	DeleteUTXOsByTransactionID(txID string) error
+	GetAllUTXOs() ([]models.UTXO, error)
+
+	// Pending transaction operations
+	AddPendingTransaction(ptx *models.PendingTransaction) error
+	GetPendingTransactions() ([]models.PendingTransaction, error)
+	UpdatePendingTransactionStatus(txID string, status string) error
+	DeletePendingTransaction(txID string) error
+
+	// Log operations
+	CreateTransactionLog(log *models.TransactionLog) error
+	GetTransactionLogsByWallet(walletID string, limit int) ([]models.TransactionLog, error)
+	CreateSystemLog(log *models.SystemLog) error
+	GetSystemLogs(limit int) ([]models.SystemLog, error)
+
+	// Zakat record operations
+	CreateZakatRecord(record *models.ZakatRecord) error
+	GetZakatRecordsByWallet(walletID string) ([]models.ZakatRecord, error)
+	GetZakatRecordsByMonth(month, year int) ([]models.ZakatRecord, error)
+
+	// Block commit operations (all-or-nothing)
+	CommitBlock(commit *BlockCommit) error
+	RollbackBlock(commit *BlockCommit) error
+	GetBlockUndo(blockHash string) (*models.BlockUndo, error)
+
+	// ReplaceLedgerState atomically swaps the chain-derived data (UTXOs,
+	// transaction logs and cached wallet balances) for a rebuilt copy
+	ReplaceLedgerState(state *LedgerState) error
+
+	// Close releases any resources held by the store
+	Close() error
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"crypto-wallet/config"
+	"crypto-wallet/middleware"
+	"crypto-wallet/services"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReindexUTXOs rebuilds the UTXO set, balances and transaction logs from the chain (admin)
+// Pass ?dry_run=true to only report discrepancies without modifying anything
+func ReindexUTXOs(c *gin.Context) {
+	_, _, userID, exists := middleware.GetUserContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	dryRun := c.Query("dry_run") == "true"
+
+	report, err := services.ReindexUTXOs(dryRun)
+	if err != nil {
+		services.LogSystemEvent("utxo_reindex_failed", userID, map[string]interface{}{
+			"error": err.Error(),
+		}, "error")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reindex UTXOs", "details": err.Error()})
+		return
+	}
+
+	message := "UTXO set rebuilt from the blockchain"
+	if dryRun {
+		message = "Dry run complete, no changes were made"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": message,
+		"report":  report,
+	})
+}
+
+// ExportChain streams the active chain as a chain archive file (admin)
+func ExportChain(c *gin.Context) {
+	_, _, userID, exists := middleware.GetUserContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	filename := fmt.Sprintf("chain-%s-%s.cwc", config.AppConfig.Network, time.Now().UTC().Format("20060102-150405"))
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	// The response has started, so a failure can only be logged; the
+	// missing checksum makes the partial file unimportable
+	report, err := services.ExportChain(c.Writer)
+	if err != nil {
+		services.LogSystemEvent("chain_export_failed", userID, map[string]interface{}{
+			"error": err.Error(),
+		}, "error")
+		return
+	}
+
+	services.LogSystemEvent("chain_exported", userID, map[string]interface{}{
+		"blocks":   report.Blocks,
+		"tip_hash": report.TipHash,
+	}, "info")
+}
+
+// ImportChain loads a chain archive sent as the request body into an empty chain (admin)
+func ImportChain(c *gin.Context) {
+	_, _, userID, exists := middleware.GetUserContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	report, err := services.ImportChain(c.Request.Body)
+	if err != nil {
+		services.LogSystemEvent("chain_import_failed", userID, map[string]interface{}{
+			"error": err.Error(),
+		}, "error")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "report": report})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Chain imported",
+		"report":  report,
+	})
+}
+
+// RotateMasterKey makes a new key manager master key active and rewraps
+// every custodial data key under it (admin)
+func RotateMasterKey(c *gin.Context) {
+	_, _, userID, exists := middleware.GetUserContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	report, err := services.RotateMasterKey()
+	if err != nil {
+		services.LogSystemEvent("master_key_rotation_failed", userID, map[string]interface{}{
+			"error": err.Error(),
+		}, "error")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate master key", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Master key rotated",
+		"report":  report,
+	})
+}
@@ -1,13 +1,15 @@
 package handlers
 
 import (
+	"context"
 	"crypto-wallet/blockchain"
 	"crypto-wallet/config"
 	"crypto-wallet/db"
 	"crypto-wallet/middleware"
-	"crypto-wallet/models"
 	"crypto-wallet/services"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
@@ -64,123 +66,194 @@ func MineBlock(c *gin.Context) {
 		return
 	}
 
-	// Get pending transactions
-	pendingTxs, err := db.GetPendingTransactions()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get pending transactions"})
+	job, err := services.SubmitMiningJob(userID, walletID)
+	switch {
+	case errors.Is(err, services.ErrNoPendingTransactions):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No pending transactions to mine"})
+		return
+	case errors.Is(err, services.ErrMiningQueueFull):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start mining", "details": err.Error()})
 		return
 	}
 
-	// Extract and validate transactions
-	var transactions []models.Transaction
-	for _, ptx := range pendingTxs {
-		// Validate transaction
-		if err := services.ProcessTransaction(ptx.Transaction); err != nil {
-			services.LogSystemEvent("transaction_validation_failed", userID, map[string]interface{}{
-				"tx_id": ptx.Transaction.ID,
-				"error": err.Error(),
-			}, "error")
-			// Unlock UTXOs that were locked for this failed transaction
-			db.UnlockUTXOsByPendingTx(ptx.Transaction.ID)
-			// Mark as failed and continue
-			db.UpdatePendingTransactionStatus(ptx.ID, "failed")
-			continue
-		}
-		transactions = append(transactions, ptx.Transaction)
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":    "Mining job queued",
+		"job":        job,
+		"status_url": "/api/mining/jobs/" + job.ID,
+		"events_url": "/api/mining/jobs/" + job.ID + "/events",
+	})
+}
+
+// MineBlockNow mines pending transactions into a new block before
+// responding, for deployments where a background job cannot outlive the request
+func MineBlockNow(c *gin.Context) {
+	_, walletID, userID, exists := middleware.GetUserContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
 	}
 
-	// Require at least one valid pending transaction before mining
-	if len(transactions) == 0 {
+	block, result, err := services.MineBlock(c.Request.Context(), userID, walletID)
+	switch {
+	case errors.Is(err, services.ErrNoPendingTransactions):
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No pending transactions to mine"})
 		return
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled):
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "Mining did not finish in time, try again"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mine block", "details": err.Error()})
+		return
 	}
 
-	// Create coinbase transaction (mining reward)
-	coinbaseTx := models.Transaction{
-		ID:         fmt.Sprintf("coinbase_%d_%d", time.Now().Unix(), len(transactions)),
-		SenderID:   "coinbase",
-		ReceiverID: userID,
-		Amount:     config.AppConfig.MiningReward,
-		Timestamp:  time.Now().Unix(),
-		Type:       "mining_reward",
-		Vin:        []models.TXInput{},
-		Vout: []models.TXOutput{
-			{
-				Value:      config.AppConfig.MiningReward,
-				PubKeyHash: walletID,
-				IsSpent:    false,
-			},
-		},
-	}
-	transactions = append(transactions, coinbaseTx)
+	c.JSON(http.StatusOK, gin.H{
+		"message":            "Block mined successfully",
+		"block":              block,
+		"transactions_count": len(block.Transactions),
+		"chain":              result,
+	})
+}
 
-	// Get last block
-	lastBlock, err := db.GetLastBlock()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get last block"})
+// GetMiningJob returns the status of one of the caller's mining jobs
+func GetMiningJob(c *gin.Context) {
+	job, ok := ownMiningJob(c)
+	if !ok {
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{"job": job})
+}
 
-	// Calculate merkle root
-	merkleRoot := blockchain.CalculateMerkleRoot(transactions)
-
-	// Create new block
-	newBlock := models.Block{
-		Index:        lastBlock.Index + 1,
-		Timestamp:    time.Now().Unix(),
-		Transactions: transactions,
-		PrevHash:     lastBlock.Hash,
-		Difficulty:   config.AppConfig.POWDifficulty,
-		MerkleRoot:   merkleRoot,
-		MinedBy:      walletID,
+// MiningJobEvents streams a mining job's progress as server-sent events: a
+// "progress" event every second while it runs and a final "done" event
+func MiningJobEvents(c *gin.Context) {
+	job, ok := ownMiningJob(c)
+	if !ok {
+		return
 	}
 
-	// Run Proof of Work
-	blockchain.RunProofOfWork(&newBlock)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 
-	// Insert block into database
-	err = db.InsertBlock(&newBlock)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert block"})
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Stream(func(w io.Writer) bool {
+		if job.Finished() {
+			c.SSEvent("done", job)
+			return false
+		}
+		c.SSEvent("progress", job)
+
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-ticker.C:
+		}
+		latest, err := services.GetMiningJob(job.ID)
+		if err != nil {
+			return false
+		}
+		job = latest
+		return true
+	})
+}
+
+// CancelMiningJob stops one of the caller's queued or running mining jobs
+func CancelMiningJob(c *gin.Context) {
+	if _, ok := ownMiningJob(c); !ok {
 		return
 	}
 
-	// Process all transactions in the block
-	for _, tx := range transactions {
-		// Unlock UTXOs that were locked for this transaction (they'll be marked as spent instead)
-		db.UnlockUTXOsByPendingTx(tx.ID)
+	job, err := services.CancelMiningJob(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Mining job cancelled", "job": job})
+}
 
-		// Mark inputs as spent
-		for _, input := range tx.Vin {
-			db.MarkUTXOAsSpent(input.TxID, input.Vout, tx.ID)
-		}
+// GetBlockTemplate returns a block template for hashing outside the server,
+// with a coinbase paying the reward to the caller's wallet
+func GetBlockTemplate(c *gin.Context) {
+	_, walletID, userID, exists := middleware.GetUserContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
 
-		// Create new UTXOs from outputs
-		blockchain.CreateUTXOsFromTransaction(tx, newBlock.Index)
+	template, err := services.GetBlockTemplate(userID, walletID)
+	if errors.Is(err, services.ErrNoPendingTransactions) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No pending transactions to mine"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build block template", "details": err.Error()})
+		return
+	}
 
-		// Create transaction logs
-		services.CreateTransactionLogs(tx, newBlock.Hash, newBlock.Index, "success")
+	c.JSON(http.StatusOK, template)
+}
 
-		// Update pending transaction status
-		db.UpdatePendingTransactionStatus(tx.ID, "mined")
+// SubmitBlock completes a block template with a nonce found by an external miner
+func SubmitBlock(c *gin.Context) {
+	_, _, userID, exists := middleware.GetUserContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
 
-		// Recalculate balances
-		blockchain.RecalculateBalance(tx.SenderID)
-		if tx.ReceiverID != "zakat_pool" {
-			blockchain.RecalculateBalance(tx.ReceiverID)
-		}
+	var req struct {
+		TemplateID string `json:"template_id" binding:"required"`
+		Nonce      *int64 `json:"nonce" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if *req.Nonce < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "nonce must not be negative"})
+		return
 	}
 
-	// Log mining event
-	services.LogMining(walletID, newBlock.Index, newBlock.Hash, len(transactions))
+	block, result, err := services.SubmitBlockSolution(userID, req.TemplateID, *req.Nonce)
+	switch {
+	case errors.Is(err, services.ErrTemplateNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrInvalidProofOfWork):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusConflict, gin.H{"error": "Block rejected", "details": err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":           "Block mined successfully",
-		"block":             newBlock,
-		"transactions_count": len(transactions),
+		"message": "Block accepted",
+		"block":   block,
+		"chain":   result,
 	})
 }
 
+// ownMiningJob loads the job named in the URL, answering 404 if it does not
+// belong to the caller
+func ownMiningJob(c *gin.Context) (*services.MiningJob, bool) {
+	_, _, userID, exists := middleware.GetUserContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, false
+	}
+
+	job, err := services.GetMiningJob(c.Param("id"))
+	if err != nil || job.UserID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mining job not found"})
+		return nil, false
+	}
+	return job, true
+}
+
 // ValidateBlockchain validates the entire blockchain
 func ValidateBlockchain(c *gin.Context) {
 	blocks, err := db.GetAllBlocks()
@@ -189,7 +262,17 @@ func ValidateBlockchain(c *gin.Context) {
 		return
 	}
 
-	isValid := blockchain.ValidateChain(blocks)
+	isValid, problematicIndex, verr := blockchain.ValidateChainWithDetails(blocks)
+	if !isValid {
+		c.JSON(http.StatusOK, gin.H{
+			"valid":             false,
+			"blocks":            len(blocks),
+			"problematic_index": problematicIndex,
+			"reason":            verr.Reason,
+			"details":           verr,
+		})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"valid":  isValid,
@@ -199,110 +282,42 @@ func ValidateBlockchain(c *gin.Context) {
 
 // ValidateAndRevertBlockchain validates the blockchain and reverts problematic blocks if found
 func ValidateAndRevertBlockchain(c *gin.Context) {
-	blocks, err := db.GetAllBlocks()
+	report, err := services.RevertInvalidBlocks()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get blockchain"})
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to revert blockchain",
+			"details": err.Error(),
+		})
 		return
 	}
 
-	isValid, problematicIndex, reason := blockchain.ValidateChainWithDetails(blocks)
-
-	if isValid {
+	if report.Invalid == nil {
 		c.JSON(http.StatusOK, gin.H{
 			"valid":   true,
 			"message": "Blockchain is valid",
-			"blocks":  len(blocks),
+			"blocks":  report.Blocks,
 		})
 		return
 	}
 
-	// Blockchain is invalid - revert from the problematic block onwards
-	revertedCount := len(blocks) - problematicIndex
-	var revertedTransactions []models.Transaction
-
-	// Collect all transactions from blocks being reverted
-	for i := problematicIndex; i < len(blocks); i++ {
-		revertedTransactions = append(revertedTransactions, blocks[i].Transactions...)
-	}
-
-	// Delete blocks from problematic index onwards
-	err = db.DeleteBlocksFromIndex(problematicIndex)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to revert blockchain",
-			"details": err.Error(),
-		})
+	// An empty chain has nothing to revert
+	if report.ProblematicIndex < 0 {
+		c.JSON(http.StatusOK, gin.H{"valid": false, "reverted": false, "reason": report.Invalid.Reason})
 		return
 	}
 
-	// Unlock UTXOs and restore balances
-	for _, tx := range revertedTransactions {
-		// Skip genesis/mining rewards - they have empty Vin (inputs)
-		if len(tx.Vin) > 0 {
-			// Re-add UTXOs for inputs that were spent in this transaction
-			for _, input := range tx.Vin {
-				// Recreate the UTXO that was consumed
-				utxo := &models.UTXO{
-					TxID:     input.TxID,
-					Vout:     input.Vout,
-					WalletID: tx.SenderID, // The sender owned this UTXO
-					IsSpent:  false,
-					IsLocked: false,
-				}
-				// Note: We can't recover the exact amount from just the input
-				// The UTXO system will be recalculated from the remaining valid blocks
-				db.CreateUTXO(utxo)
-			}
-		}
-
-		// Remove UTXOs created by outputs in this transaction
-		db.DeleteUTXOsByTransactionID(tx.ID)
-
-		// Update balances for affected wallets
-		if tx.SenderID != "" {
-			services.RecalculateUserBalance(tx.SenderID)
-		}
-		if tx.ReceiverID != "" {
-			services.RecalculateUserBalance(tx.ReceiverID)
-		}
-	}
-
-	// Move transactions back to pending (except genesis/mining rewards)
-	for _, tx := range revertedTransactions {
-		if tx.SenderID != "" && len(tx.Vin) > 0 {
-			// Only add back non-mining transactions
-			pendingTx := &models.PendingTransaction{
-				ID:          tx.ID,
-				Transaction: tx,
-				CreatedAt:   time.Now(),
-				Status:      "pending",
-			}
-			db.AddPendingTransaction(pendingTx)
-		}
-	}
-
-	// Log the revert action
-	db.CreateSystemLog(&models.SystemLog{
-		Event:    "blockchain_revert",
-		Details: map[string]interface{}{
-			"reverted_blocks":       revertedCount,
-			"problematic_index":     problematicIndex,
-			"reason":                reason,
-			"reverted_transactions": len(revertedTransactions),
-		},
-		Severity: "warning",
-	})
-
 	c.JSON(http.StatusOK, gin.H{
 		"valid":                 false,
 		"reverted":              true,
-		"problematic_index":     problematicIndex,
-		"reason":                reason,
-		"reverted_blocks":       revertedCount,
-		"reverted_transactions": len(revertedTransactions),
-		"restored_to_pending":   len(revertedTransactions),
-		"remaining_blocks":      problematicIndex,
-		"message":               fmt.Sprintf("Blockchain was invalid. Reverted %d blocks and restored transactions to pending pool.", revertedCount),
+		"problematic_index":     report.ProblematicIndex,
+		"reason":                report.Invalid.Error(),
+		"details":               report.Invalid,
+		"reverted_blocks":       report.RevertedBlocks,
+		"reverted_transactions": report.RevertedTransactions,
+		"restored_to_pending":   report.RestoredToPending,
+		"journaled_blocks":      report.JournaledBlocks,
+		"remaining_blocks":      report.ProblematicIndex,
+		"message":               fmt.Sprintf("Blockchain was invalid. Reverted %d blocks and restored transactions to pending pool.", report.RevertedBlocks),
 	})
 }
 
@@ -327,6 +342,9 @@ func GetBlockchainStats(c *gin.Context) {
 
 	users, _ := db.GetAllUsers()
 	pendingTxs, _ := db.GetPendingTransactions()
+	tip := blocks[len(blocks)-1]
+	nextBits, _ := blockchain.NextBits(tip, blockchain.LookupStoredBlock)
+	sideBlocks, _ := db.GetSideBlocks()
 
 	c.JSON(http.StatusOK, gin.H{
 		"total_blocks":              len(blocks),
@@ -335,6 +353,23 @@ func GetBlockchainStats(c *gin.Context) {
 		"pending_transactions":      len(pendingTxs),
 		"total_users":               len(users),
 		"pow_difficulty":            config.AppConfig.POWDifficulty,
+		"current_bits":              fmt.Sprintf("%08x", tip.Bits),
+		"next_bits":                 fmt.Sprintf("%08x", nextBits),
+		"target_block_time":         config.AppConfig.TargetBlockTime,
+		"retarget_interval":         config.AppConfig.RetargetInterval,
+		"chain_work":                blockchain.ChainWork(blocks).Text(16),
+		"side_blocks":               len(sideBlocks),
 		"latest_block_hash":         blocks[len(blocks)-1].Hash,
 	})
 }
+
+// GetSupply reports the coin supply and the subsidy schedule
+func GetSupply(c *gin.Context) {
+	report, err := services.GetSupply()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute supply"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"crypto-wallet/middleware"
+	"crypto-wallet/node"
+	"crypto-wallet/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetNodeInfo returns this node's P2P identity and connected peers
+func GetNodeInfo(c *gin.Context) {
+	c.JSON(http.StatusOK, node.Info())
+}
+
+// AddPeer connects this node to another node (admin)
+func AddPeer(c *gin.Context) {
+	_, _, userID, exists := middleware.GetUserContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		Address string `json:"address" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := node.Connect(req.Address); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to connect to peer", "details": err.Error()})
+		return
+	}
+
+	services.LogSystemEvent("peer_added", userID, map[string]interface{}{
+		"address": req.Address,
+	}, "info")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Connecting to peer", "address": req.Address})
+}
@@ -4,6 +4,7 @@ import (
 	"crypto-wallet/blockchain"
 	"crypto-wallet/db"
 	"crypto-wallet/middleware"
+	"crypto-wallet/models"
 	"crypto-wallet/services"
 	"fmt"
 	"net/http"
@@ -32,8 +33,8 @@ func GetMonthlyReport(c *gin.Context) {
 	currentMonth := now.Month()
 	currentYear := now.Year()
 
-	var totalSent float64
-	var totalReceived float64
+	var totalSent models.Amount
+	var totalReceived models.Amount
 	var monthlyTxCount int
 
 	for _, log := range logs {
@@ -49,7 +50,7 @@ func GetMonthlyReport(c *gin.Context) {
 
 	// Get Zakat records for current month
 	zakatRecords, _ := db.GetZakatRecordsByMonth(int(currentMonth), currentYear)
-	var totalZakat float64
+	var totalZakat models.Amount
 	for _, record := range zakatRecords {
 		if record.WalletID == walletID {
 			totalZakat += record.Amount
@@ -86,8 +87,8 @@ func GetZakatReport(c *gin.Context) {
 		return
 	}
 
-	var totalZakat float64
-	var yearlyZakat = make(map[int]float64)
+	var totalZakat models.Amount
+	var yearlyZakat = make(map[int]models.Amount)
 
 	for _, record := range records {
 		totalZakat += record.Amount
@@ -111,8 +112,8 @@ func GetSystemStats(c *gin.Context) {
 	systemLogs, _ := db.GetSystemLogs(100)
 
 	var totalTransactions int
-	var totalVolume float64
-	var totalZakat float64
+	var totalVolume models.Amount
+	var totalZakat models.Amount
 
 	for _, block := range blocks {
 		totalTransactions += len(block.Transactions)
@@ -197,8 +198,8 @@ func GetTransactionStats(c *gin.Context) {
 		return
 	}
 
-	var totalSent float64
-	var totalReceived float64
+	var totalSent models.Amount
+	var totalReceived models.Amount
 	var sentCount int
 	var receivedCount int
 
@@ -215,7 +216,7 @@ func GetTransactionStats(c *gin.Context) {
 	balance, _ := blockchain.GetBalance(walletID)
 	zakatRecords, _ := db.GetZakatRecordsByWallet(walletID)
 	
-	var totalZakat float64
+	var totalZakat models.Amount
 	for _, record := range zakatRecords {
 		totalZakat += record.Amount
 	}
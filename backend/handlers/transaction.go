@@ -1,10 +1,14 @@
 package handlers
 
 import (
+	"crypto-wallet/blockchain"
 	"crypto-wallet/db"
+	"crypto-wallet/keymanager"
 	"crypto-wallet/middleware"
 	"crypto-wallet/models"
 	"crypto-wallet/services"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -25,6 +29,14 @@ func SendMoney(c *gin.Context) {
 		return
 	}
 
+	// The receiver may be given as an address or a legacy hex wallet ID
+	receiverWalletID, err := blockchain.ParseWalletID(req.ReceiverWalletID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.ReceiverWalletID = receiverWalletID
+
 	ipAddress := middleware.GetClientIP(c)
 
 	// Prevent sending to self
@@ -33,18 +45,32 @@ func SendMoney(c *gin.Context) {
 		return
 	}
 
+	user, err := db.GetUserByWalletID(senderWalletID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Sign with the key sent along, or the key manager's for custodial wallets
+	signer, err := keymanager.SignerFor(user, req.PrivateKey)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Create transaction
 	transaction, err := services.CreateTransaction(
 		senderWalletID,
 		req.ReceiverWalletID,
 		req.Amount,
+		req.Fee,
 		req.Note,
-		req.PrivateKey,
+		signer,
 	)
 	if err != nil {
 		services.LogSystemEventWithIP("transaction_failed", userID, ipAddress, map[string]interface{}{
 			"receiver": req.ReceiverWalletID,
-			"amount":   req.Amount,
+			"amount":   req.Amount.String(),
 			"error":    err.Error(),
 		}, "error")
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -54,19 +80,121 @@ func SendMoney(c *gin.Context) {
 	services.LogSystemEventWithIP("transaction_pending", userID, ipAddress, map[string]interface{}{
 		"tx_id":    transaction.ID,
 		"receiver": req.ReceiverWalletID,
-		"amount":   req.Amount,
+		"amount":   req.Amount.String(),
 	}, "info")
 
 	c.JSON(http.StatusCreated, gin.H{
 		"message":      "Transaction created and added to pending pool",
 		"tx_id":        transaction.ID,
 		"amount":       req.Amount,
+		"fee":          req.Fee,
 		"receiver":     req.ReceiverWalletID,
 		"status":       "pending",
 		"note":         "Transaction will be processed when the next block is mined",
 	})
 }
 
+// BuildTransaction returns an unsigned transfer and the signature hash of
+// each input, so the sender can sign it without sending the server a key
+func BuildTransaction(c *gin.Context) {
+	_, senderWalletID, userID, exists := middleware.GetUserContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req models.BuildTransactionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	receiverWalletID, err := blockchain.ParseWalletID(req.ReceiverWalletID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.ReceiverWalletID = receiverWalletID
+
+	// Spend from one of the user's HD wallets if asked
+	if req.FromWalletID != "" {
+		fromWalletID, err := blockchain.ParseWalletID(req.FromWalletID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if fromWalletID != senderWalletID && !services.OwnsWallet(userID, fromWalletID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Wallet does not belong to you"})
+			return
+		}
+		senderWalletID = fromWalletID
+	}
+
+	// Prevent sending to self
+	if req.ReceiverWalletID == senderWalletID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot send money to yourself"})
+		return
+	}
+
+	unsigned, err := services.PrepareTransaction(senderWalletID, req.ReceiverWalletID, req.Amount, req.Fee, req.Note)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, unsigned)
+}
+
+// SubmitTransaction verifies a transfer signed by the sender and adds it to
+// the pending pool
+func SubmitTransaction(c *gin.Context) {
+	_, senderWalletID, userID, exists := middleware.GetUserContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req models.SubmitTransactionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ipAddress := middleware.GetClientIP(c)
+	tx := req.Transaction
+
+	if err := services.SubmitTransaction(userID, senderWalletID, tx); err != nil {
+		services.LogSystemEventWithIP("transaction_failed", userID, ipAddress, map[string]interface{}{
+			"tx_id":    tx.ID,
+			"receiver": tx.ReceiverID,
+			"amount":   tx.Amount.String(),
+			"error":    err.Error(),
+		}, "error")
+		status := http.StatusBadRequest
+		if errors.Is(err, services.ErrKnownTransaction) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	services.LogSystemEventWithIP("transaction_pending", userID, ipAddress, map[string]interface{}{
+		"tx_id":    tx.ID,
+		"receiver": tx.ReceiverID,
+		"amount":   tx.Amount.String(),
+	}, "info")
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "Transaction verified and added to pending pool",
+		"tx_id":    tx.ID,
+		"amount":   tx.Amount,
+		"fee":      tx.Fee,
+		"receiver": tx.ReceiverID,
+		"status":   "pending",
+		"note":     "Transaction will be processed when the next block is mined",
+	})
+}
+
 // GetTransactionHistory returns transaction history for authenticated user
 func GetTransactionHistory(c *gin.Context) {
 	_, walletID, _, exists := middleware.GetUserContext(c)
@@ -104,6 +232,8 @@ func GetPendingTransactions(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get pending transactions"})
 		return
 	}
+	// Listed in the order miners pick them
+	services.SortByFeeRate(pendingTxs)
 
 	c.JSON(http.StatusOK, gin.H{
 		"pending_transactions": pendingTxs,
@@ -151,6 +281,55 @@ func GetTransactionByID(c *gin.Context) {
 	c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
 }
 
+// GetTransactionProof returns the block header and merkle branch proving a
+// transaction was mined, so clients can verify inclusion without the full chain
+func GetTransactionProof(c *gin.Context) {
+	txID := c.Param("txId")
+
+	blocks, err := db.GetAllBlocks()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search transaction"})
+		return
+	}
+
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			if tx.ID != txID {
+				continue
+			}
+
+			proof, err := blockchain.BuildMerkleProof(block.Transactions, txID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build merkle proof", "details": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"tx_id": txID,
+				"block_header": gin.H{
+					"version":     block.Version,
+					"index":       block.Index,
+					"timestamp":   block.Timestamp,
+					"prev_hash":   block.PrevHash,
+					"merkle_root": block.MerkleRoot,
+					"bits":        block.Bits,
+					"difficulty":  block.Difficulty,
+					"nonce":       block.Nonce,
+					"mined_by":    block.MinedBy,
+					"hash":        block.Hash,
+				},
+				"serialized_header": hex.EncodeToString(blockchain.SerializeBlockHeader(block)),
+				"proof":             proof,
+				"confirmations":     blocks[len(blocks)-1].Index - block.Index + 1,
+				"valid":             blockchain.VerifyMerkleProof(*proof) && proof.MerkleRoot == block.MerkleRoot,
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found in any block"})
+}
+
 // GetMyPendingTransactions returns pending transactions for authenticated user
 func GetMyPendingTransactions(c *gin.Context) {
 	_, walletID, _, exists := middleware.GetUserContext(c)
@@ -193,7 +372,7 @@ func GetZakatHistory(c *gin.Context) {
 		return
 	}
 
-	var totalZakat float64
+	var totalZakat models.Amount
 	for _, record := range records {
 		totalZakat += record.Amount
 	}
@@ -2,11 +2,14 @@ package handlers
 
 import (
 	"crypto-wallet/auth"
+	"crypto-wallet/blockchain"
 	"crypto-wallet/crypto"
 	"crypto-wallet/db"
 	"crypto-wallet/middleware"
 	"crypto-wallet/models"
 	"crypto-wallet/services"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 
@@ -31,26 +34,28 @@ func Signup(c *gin.Context) {
 		return
 	}
 
-	// Generate RSA key pair
-	privateKey, publicKey, err := crypto.GenerateKeyPair()
+	keyType, err := crypto.ParseKeyType(req.KeyType)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate keys"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Convert keys to strings
-	privateKeyStr := crypto.PrivateKeyToString(privateKey)
-	publicKeyStr, err := crypto.PublicKeyToString(publicKey)
+	// Generate the wallet key pair
+	privateKey, err := crypto.GenerateKey(keyType)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert public key"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate keys"})
 		return
 	}
 
+	// Convert keys to strings
+	privateKeyStr := privateKey.String()
+	publicKeyStr := privateKey.Public().String()
+
 	// Generate wallet ID from public key
 	walletID := crypto.GenerateWalletID(publicKeyStr)
 
-	// Encrypt private key with user's email as passphrase (in production, use a better method)
-	encryptedPrivateKey, err := crypto.EncryptPrivateKey(privateKeyStr, req.Email)
+	// Encrypt private key into a keystore under the user's passphrase
+	encryptedPrivateKey, err := services.SealPrivateKey(privateKeyStr, req.Passphrase)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt private key"})
 		return
@@ -102,7 +107,9 @@ func Signup(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{
 		"message":     "User created successfully. Please verify your email with the OTP sent.",
 		"wallet_id":   walletID,
+		"address":     blockchain.WalletAddress(walletID),
 		"email":       req.Email,
+		"key_type":    keyType,
 		"private_key": privateKeyStr, // Return unencrypted private key for user to save
 	})
 }
@@ -175,7 +182,7 @@ func VerifyOTP(c *gin.Context) {
 	services.LogOTPVerified(user.ID, user.Email, ipAddress)
 	services.LogLogin(user.ID, user.Email, ipAddress)
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"message":   "Login successful",
 		"token":     token,
 		"user": gin.H{
@@ -183,9 +190,32 @@ func VerifyOTP(c *gin.Context) {
 			"full_name":  user.FullName,
 			"email":      user.Email,
 			"wallet_id":  user.WalletID,
+			"address":    blockchain.WalletAddress(user.WalletID),
 			"public_key": user.PublicKey,
 		},
-	})
+	}
+	upgradeKeystore(response, user, req.Passphrase)
+
+	c.JSON(http.StatusOK, response)
+}
+
+// upgradeKeystore re-encrypts a legacy or outdated stored private key when
+// the user logs in with a passphrase and reports the outcome in the login
+// response. A bad passphrase does not fail the login.
+func upgradeKeystore(response gin.H, user *models.User, passphrase string) {
+	if passphrase == "" {
+		response["keystore_migration_required"] = services.NeedsKeystoreUpgrade(user)
+		return
+	}
+
+	upgraded, err := services.UpgradeKeystore(user, passphrase)
+	if err != nil {
+		response["keystore_migration_required"] = true
+		response["keystore_error"] = err.Error()
+		return
+	}
+	response["keystore_upgraded"] = upgraded
+	response["keystore_migration_required"] = false
 }
 
 // ResendOTP resends OTP to user's email
@@ -236,24 +266,30 @@ func GoogleLogin(c *gin.Context) {
 	user, err := db.GetUserByEmail(email)
 	
 	if err != nil {
-		// User doesn't exist, create new user with Google OAuth
-		privateKey, publicKey, err := crypto.GenerateKeyPair()
+		// User doesn't exist, create new user with Google OAuth. Its key is
+		// sealed under a passphrase like any signup's.
+		if len(req.Passphrase) < crypto.MinPassphraseLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("a passphrase of at least %d characters is required to create a wallet", crypto.MinPassphraseLength)})
+			return
+		}
+		keyType, err := crypto.ParseKeyType(req.KeyType)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate keys"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		privateKeyStr := crypto.PrivateKeyToString(privateKey)
-		publicKeyStr, err := crypto.PublicKeyToString(publicKey)
+		privateKey, err := crypto.GenerateKey(keyType)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert public key"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate keys"})
 			return
 		}
 
+		privateKeyStr := privateKey.String()
+		publicKeyStr := privateKey.Public().String()
+
 		walletID := crypto.GenerateWalletID(publicKeyStr)
 
-		// For Google users, encrypt private key with Google ID
-		encryptedPrivateKey, err := crypto.EncryptPrivateKey(privateKeyStr, googleID)
+		encryptedPrivateKey, err := services.SealPrivateKey(privateKeyStr, req.Passphrase)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt private key"})
 			return
@@ -318,7 +354,7 @@ func GoogleLogin(c *gin.Context) {
 
 	services.LogLogin(user.ID, user.Email, ipAddress)
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"message": "Login successful",
 		"token":   token,
 		"user": gin.H{
@@ -326,9 +362,13 @@ func GoogleLogin(c *gin.Context) {
 			"full_name":  user.FullName,
 			"email":      user.Email,
 			"wallet_id":  user.WalletID,
+			"address":    blockchain.WalletAddress(user.WalletID),
 			"public_key": user.PublicKey,
 		},
-	})
+	}
+	upgradeKeystore(response, user, req.Passphrase)
+
+	c.JSON(http.StatusOK, response)
 }
 
 // GetProfile returns the authenticated user's profile
@@ -352,7 +392,10 @@ func GetProfile(c *gin.Context) {
 			"email":             user.Email,
 			"cnic":              user.CNIC,
 			"wallet_id":         user.WalletID,
+			"address":           blockchain.WalletAddress(user.WalletID),
 			"public_key":        user.PublicKey,
+			"key_type":          crypto.KeyTypeOf(user.PublicKey),
+			"custodial":         user.Custody != nil,
 			"is_email_verified": user.IsEmailVerified,
 			"beneficiaries":     user.Beneficiaries,
 			"created_at":        user.CreatedAt,
@@ -431,6 +474,14 @@ func AddBeneficiary(c *gin.Context) {
 		return
 	}
 
+	// Beneficiaries are stored by wallet ID, whichever form was given
+	walletID, err := blockchain.ParseWalletID(req.WalletID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.WalletID = walletID
+
 	// Validate wallet exists
 	if !db.WalletExists(req.WalletID) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid wallet ID"})
@@ -479,7 +530,11 @@ func RemoveBeneficiary(c *gin.Context) {
 		return
 	}
 
-	walletID := c.Param("walletId")
+	walletID, err := blockchain.ParseWalletID(c.Param("walletId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// Get user
 	user, err := db.GetUserByEmail(email)
@@ -520,32 +575,186 @@ func RemoveBeneficiary(c *gin.Context) {
 	})
 }
 
-// GetPrivateKey returns the encrypted private key (user must provide email as passphrase to decrypt)
+// GetPrivateKey exports the private key of a wallet not in custody, given
+// the user's passphrase
 func GetPrivateKey(c *gin.Context) {
-	email, _, _, exists := middleware.GetUserContext(c)
+	email, _, userID, exists := middleware.GetUserContext(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
+	var req models.PrivateKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	user, err := db.GetUserByEmail(email)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
-	// Decrypt private key using user's email as passphrase
-	decryptedPrivateKey, err := crypto.DecryptPrivateKey(user.EncryptedPrivateKey, email)
+	privateKey, err := services.ExportPrivateKey(user, req.Passphrase)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt private key"})
+		if errors.Is(err, services.ErrLegacyKeystore) || errors.Is(err, services.ErrCustodialExport) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		services.LogSystemEventWithIP("private_key_unlock_failed", userID, middleware.GetClientIP(c), nil, "warning")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"private_key": decryptedPrivateKey,
+		"private_key": privateKey,
 	})
 }
 
+// ExportKeystore returns the user's encrypted keystore for backup
+func ExportKeystore(c *gin.Context) {
+	email, _, _, exists := middleware.GetUserContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	user, err := db.GetUserByEmail(email)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	keystore, err := services.ExportKeystore(user)
+	if err != nil {
+		if errors.Is(err, services.ErrLegacyKeystore) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read keystore"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"keystore": keystore})
+}
+
+// ImportKeystore replaces the stored private key with an exported keystore
+// of the user's wallet
+func ImportKeystore(c *gin.Context) {
+	email, _, _, exists := middleware.GetUserContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req models.ImportKeystoreRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := db.GetUserByEmail(email)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := services.ImportKeystore(user, string(req.Keystore), req.Passphrase); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Keystore imported successfully"})
+}
+
+// ChangePassphrase re-encrypts the stored private key under a new passphrase
+func ChangePassphrase(c *gin.Context) {
+	email, _, _, exists := middleware.GetUserContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req models.ChangePassphraseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := db.GetUserByEmail(email)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := services.ChangePassphrase(user, req.CurrentPassphrase, req.NewPassphrase); err != nil {
+		if errors.Is(err, services.ErrLegacyKeystore) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Passphrase changed successfully"})
+}
+
+// EnableCustody lets the server hold the user's private key so transfers
+// can be sent without it
+func EnableCustody(c *gin.Context) {
+	email, _, _, exists := middleware.GetUserContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req models.EnableCustodyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := db.GetUserByEmail(email)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := services.EnableCustody(user, req.Passphrase); err != nil {
+		if errors.Is(err, services.ErrLegacyKeystore) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Wallet key is now held by the server", "custodial": true})
+}
+
+// DisableCustody deletes the server's copy of the user's private key
+func DisableCustody(c *gin.Context) {
+	email, _, _, exists := middleware.GetUserContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	user, err := db.GetUserByEmail(email)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := services.DisableCustody(user); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Server copy of the wallet key deleted", "custodial": false})
+}
+
 // SearchUserByEmail searches for a user by email (public endpoint for sending money)
 func SearchUserByEmail(c *gin.Context) {
 	email := c.Query("email")
@@ -569,6 +778,7 @@ func SearchUserByEmail(c *gin.Context) {
 	// Return only public information
 	c.JSON(http.StatusOK, gin.H{
 		"wallet_id": user.WalletID,
+		"address":    blockchain.WalletAddress(user.WalletID),
 		"full_name":  user.FullName,
 		"email":      user.Email,
 	})
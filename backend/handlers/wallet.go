@@ -2,8 +2,13 @@ package handlers
 
 import (
 	"crypto-wallet/blockchain"
+	"crypto-wallet/config"
+	"crypto-wallet/crypto"
 	"crypto-wallet/db"
 	"crypto-wallet/middleware"
+	"crypto-wallet/models"
+	"crypto-wallet/services"
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -11,7 +16,11 @@ import (
 
 // GetWalletBalance returns the balance for a wallet
 func GetWalletBalance(c *gin.Context) {
-	walletID := c.Param("walletId")
+	walletID, err := blockchain.ParseWalletID(c.Param("walletId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// Validate wallet exists
 	if !db.WalletExists(walletID) {
@@ -31,6 +40,7 @@ func GetWalletBalance(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"wallet_id": walletID,
+		"address":   blockchain.WalletAddress(walletID),
 		"balance":   balance,
 	})
 }
@@ -43,24 +53,34 @@ func GetMyBalance(c *gin.Context) {
 		return
 	}
 
-	balance, err := blockchain.GetBalance(walletID)
+	// Mining rewards that have not matured cannot be spent yet
+	spendable, immature, err := blockchain.GetSpendableBalance(walletID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get balance"})
 		return
 	}
+	balance := spendable + immature
 
 	// Update cached balance
 	db.UpdateWalletBalance(walletID, balance)
 
 	c.JSON(http.StatusOK, gin.H{
-		"wallet_id": walletID,
-		"balance":   balance,
+		"wallet_id":         walletID,
+		"address":           blockchain.WalletAddress(walletID),
+		"balance":           balance,
+		"spendable_balance": spendable,
+		"immature_balance":  immature,
+		"coinbase_maturity": config.AppConfig.CoinbaseMaturity,
 	})
 }
 
 // GetWalletInfo returns detailed wallet information
 func GetWalletInfo(c *gin.Context) {
-	walletID := c.Param("walletId")
+	walletID, err := blockchain.ParseWalletID(c.Param("walletId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// Validate wallet exists
 	wallet, err := db.GetWallet(walletID)
@@ -84,8 +104,10 @@ func GetWalletInfo(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"wallet_id":       walletID,
+		"address":         blockchain.WalletAddress(walletID),
 		"user_name":       user.FullName,
 		"public_key":      wallet.PublicKey,
+		"key_type":        crypto.KeyTypeOf(wallet.PublicKey),
 		"balance":         balance,
 		"utxo_count":      len(utxos),
 		"last_zakat_date": wallet.LastZakatDate,
@@ -118,9 +140,11 @@ func GetMyWalletInfo(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"wallet_id":       walletID,
+		"address":         blockchain.WalletAddress(walletID),
 		"user_name":       user.FullName,
 		"email":           user.Email,
 		"public_key":      wallet.PublicKey,
+		"key_type":        crypto.KeyTypeOf(wallet.PublicKey),
 		"balance":         balance,
 		"utxo_count":      len(utxos),
 		"last_zakat_date": wallet.LastZakatDate,
@@ -143,18 +167,42 @@ func GetMyUTXOs(c *gin.Context) {
 		return
 	}
 
-	var totalBalance float64
+	height, err := blockchain.NextBlockHeight()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get UTXOs"})
+		return
+	}
+
+	// Mining rewards that have not matured are listed separately
+	immatureUTXOs := []gin.H{}
+	var totalBalance, spendable, immature models.Amount
 	for _, utxo := range utxos {
-		if !utxo.IsSpent {
-			totalBalance += utxo.Amount
+		if utxo.IsSpent {
+			continue
+		}
+		totalBalance += utxo.Amount
+		if maturity := blockchain.MaturityHeight(utxo); maturity > height {
+			immature += utxo.Amount
+			immatureUTXOs = append(immatureUTXOs, gin.H{
+				"tx_id":          utxo.TxID,
+				"vout":           utxo.Vout,
+				"amount":         utxo.Amount,
+				"block_index":    utxo.BlockIndex,
+				"spendable_from": maturity,
+			})
+		} else {
+			spendable += utxo.Amount
 		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"wallet_id":     walletID,
-		"utxos":         utxos,
-		"utxo_count":    len(utxos),
-		"total_balance": totalBalance,
+		"wallet_id":         walletID,
+		"utxos":             utxos,
+		"utxo_count":        len(utxos),
+		"total_balance":     totalBalance,
+		"spendable_balance": spendable,
+		"immature_balance":  immature,
+		"immature_utxos":    immatureUTXOs,
 	})
 }
 
@@ -182,6 +230,7 @@ func GetBeneficiaries(c *gin.Context) {
 
 		beneficiaryDetails = append(beneficiaryDetails, gin.H{
 			"wallet_id": walletID,
+			"address":   blockchain.WalletAddress(walletID),
 			"full_name": beneficiary.FullName,
 			"email":     beneficiary.Email,
 		})
@@ -193,9 +242,17 @@ func GetBeneficiaries(c *gin.Context) {
 	})
 }
 
-// ValidateWalletID checks if a wallet ID exists
+// ValidateWalletID checks that a wallet address or legacy wallet ID is well
+// formed and that the wallet exists
 func ValidateWalletID(c *gin.Context) {
-	walletID := c.Param("walletId")
+	walletID, err := blockchain.ParseWalletID(c.Param("walletId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"valid":   false,
+			"message": err.Error(),
+		})
+		return
+	}
 
 	exists := db.WalletExists(walletID)
 	if !exists {
@@ -210,7 +267,9 @@ func ValidateWalletID(c *gin.Context) {
 	user, err := db.GetUserByWalletID(walletID)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
-			"valid": true,
+			"valid":     true,
+			"wallet_id": walletID,
+			"address":   blockchain.WalletAddress(walletID),
 		})
 		return
 	}
@@ -218,6 +277,140 @@ func ValidateWalletID(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"valid":     true,
 		"wallet_id": walletID,
+		"address":   blockchain.WalletAddress(walletID),
 		"user_name": user.FullName,
 	})
 }
+
+// CreateHDWallet registers the user's HD account from its extended public
+// key. The mnemonic and the keys that spend stay with the user: the account
+// xpub is derived client-side and is all the server ever sees.
+func CreateHDWallet(c *gin.Context) {
+	email, _, userID, exists := middleware.GetUserContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req models.CreateHDWalletRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	accountKey, err := parseAccountXpub(req.AccountKey)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := db.GetUserByEmail(email)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.HDAccountKey != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "HD wallet already exists; restore an account key to replace it"})
+		return
+	}
+
+	scan, err := services.RegisterHDAccount(user, accountKey)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	services.LogSystemEventWithIP("hd_wallet_created", userID, middleware.GetClientIP(c), nil, "info")
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":      "HD wallet created",
+		"account_key":  scan.AccountKey,
+		"account_path": crypto.HDAccountPath(0),
+		"next_receive": scan.NextReceive,
+	})
+}
+
+// RestoreHDWallet watches a user's HD wallets from an account extended
+// public key, scanning the chain for the wallets derived from it
+func RestoreHDWallet(c *gin.Context) {
+	email, _, userID, exists := middleware.GetUserContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req models.RestoreHDWalletRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	accountKey, err := parseAccountXpub(req.AccountKey)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := db.GetUserByEmail(email)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	scan, err := services.RegisterHDAccount(user, accountKey)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	services.LogSystemEventWithIP("hd_wallet_restored", userID, middleware.GetClientIP(c), map[string]interface{}{
+		"used_wallets": len(scan.Wallets),
+	}, "info")
+
+	c.JSON(http.StatusOK, scan)
+}
+
+// parseAccountXpub parses an account extended public key. Extended private
+// keys are refused so they are never sent to the server.
+func parseAccountXpub(s string) (*crypto.HDKey, error) {
+	accountKey, err := crypto.ParseHDKey(s)
+	if err != nil {
+		return nil, err
+	}
+	if accountKey.IsPrivate() {
+		return nil, errors.New("account_key must be an xpub; keep extended private keys on your device")
+	}
+	return accountKey, nil
+}
+
+// GetHDWallets scans the chain for the user's HD wallets, returning their
+// balances and registering the next receiving wallet
+func GetHDWallets(c *gin.Context) {
+	email, _, _, exists := middleware.GetUserContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	user, err := db.GetUserByEmail(email)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.HDAccountKey == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No HD wallet; create or restore one first"})
+		return
+	}
+
+	accountKey, err := crypto.ParseHDKey(user.HDAccountKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Stored HD account key is invalid"})
+		return
+	}
+
+	scan, err := services.RegisterHDAccount(user, accountKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, scan)
+}
@@ -0,0 +1,124 @@
+package keymanager
+
+import (
+	"crypto-wallet/models"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+// dataKeyLen is the size of a per-user AES-256 data key
+const dataKeyLen = 32
+
+// Seal encrypts a custodial private key under a fresh data key and wraps
+// the data key under the active master key. The wallet ID is authenticated
+// with the key so a record cannot be moved to another wallet.
+func Seal(privateKeyStr, walletID string) (*models.CustodyKey, error) {
+	m, err := active()
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, dataKeyLen)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	ciphertext := gcm.Seal(nil, nonce, []byte(privateKeyStr), []byte(walletID))
+
+	keyID, wrapped, err := m.WrapKey(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.CustodyKey{
+		MasterKeyID:    keyID,
+		WrappedDataKey: base64.StdEncoding.EncodeToString(wrapped),
+		Nonce:          base64.StdEncoding.EncodeToString(nonce),
+		CipherText:     base64.StdEncoding.EncodeToString(ciphertext),
+		CreatedAt:      time.Now(),
+	}, nil
+}
+
+// open decrypts a custodial private key
+func open(custody *models.CustodyKey, walletID string) (string, error) {
+	m, err := active()
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := unwrapDataKey(m, custody)
+	if err != nil {
+		return "", err
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(custody.Nonce)
+	if err != nil {
+		return "", errors.New("invalid custody nonce")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(custody.CipherText)
+	if err != nil {
+		return "", errors.New("invalid custody ciphertext")
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return "", errors.New("invalid custody nonce")
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(walletID))
+	if err != nil {
+		return "", errors.New("custodial key does not belong to this wallet")
+	}
+	return string(plaintext), nil
+}
+
+// Rewrap wraps a custodial key's data key under the active master key. The
+// encrypted private key is left as it is, so the wallet keeps its key. It
+// reports false if the data key was already under the active master key.
+func Rewrap(custody *models.CustodyKey) (bool, error) {
+	m, err := active()
+	if err != nil {
+		return false, err
+	}
+	if custody.MasterKeyID == m.ActiveKeyID() {
+		return false, nil
+	}
+
+	dataKey, err := unwrapDataKey(m, custody)
+	if err != nil {
+		return false, err
+	}
+	keyID, wrapped, err := m.WrapKey(dataKey)
+	if err != nil {
+		return false, err
+	}
+
+	custody.MasterKeyID = keyID
+	custody.WrappedDataKey = base64.StdEncoding.EncodeToString(wrapped)
+	custody.RotatedAt = time.Now()
+	return true, nil
+}
+
+func unwrapDataKey(m KeyManager, custody *models.CustodyKey) ([]byte, error) {
+	wrapped, err := base64.StdEncoding.DecodeString(custody.WrappedDataKey)
+	if err != nil {
+		return nil, errors.New("invalid wrapped data key")
+	}
+	dataKey, err := m.UnwrapKey(custody.MasterKeyID, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if len(dataKey) != dataKeyLen {
+		return nil, errors.New("invalid data key length")
+	}
+	return dataKey, nil
+}
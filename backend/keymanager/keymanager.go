@@ -0,0 +1,86 @@
+package keymanager
+
+import (
+	"crypto-wallet/config"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// KeyManager wraps per-user data keys under a master key that never leaves
+// it. LocalKeyManager keeps master keys in a file; an external KMS plugs in
+// through KMSClient.
+type KeyManager interface {
+	// ActiveKeyID names the master key new data keys are wrapped under
+	ActiveKeyID() string
+	// WrapKey encrypts a data key under the active master key
+	WrapKey(dataKey []byte) (keyID string, wrapped []byte, err error)
+	// UnwrapKey decrypts a data key wrapped under the named master key,
+	// which need not be the active one
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+	// RotateMasterKey makes a new master key active. Earlier keys keep
+	// unwrapping until every data key has been rewrapped.
+	RotateMasterKey() (string, error)
+}
+
+var manager KeyManager
+
+// SetKeyManager replaces the active key manager
+func SetKeyManager(m KeyManager) {
+	manager = m
+}
+
+// Init selects the key manager named by KEY_MANAGER: "local" (default) or
+// an external KMS added with RegisterKMS
+func Init() error {
+	switch name := config.AppConfig.KeyManager; name {
+	case "", "local":
+		local, err := NewLocalKeyManager(config.AppConfig.MasterKeyFile, config.AppConfig.AESEncryptionKey)
+		if err != nil {
+			return err
+		}
+		SetKeyManager(local)
+		log.Printf("✅ Using local key manager (master key %s)", local.ActiveKeyID())
+	default:
+		open, ok := kmsProviders[name]
+		if !ok {
+			return fmt.Errorf("unknown key manager %q", name)
+		}
+		client, err := open()
+		if err != nil {
+			return fmt.Errorf("failed to open %s KMS: %w", name, err)
+		}
+		if config.AppConfig.KMSKeyID == "" {
+			return errors.New("KMS_KEY_ID is required for an external key manager")
+		}
+		SetKeyManager(NewKMSKeyManager(client, config.AppConfig.KMSKeyID))
+		log.Printf("✅ Using %s key manager (master key %s)", name, config.AppConfig.KMSKeyID)
+	}
+	return nil
+}
+
+// active returns the key manager, or an error if Init has not been called
+func active() (KeyManager, error) {
+	if manager == nil {
+		return nil, errors.New("key manager is not initialized")
+	}
+	return manager, nil
+}
+
+// ActiveKeyID names the master key new data keys are wrapped under
+func ActiveKeyID() string {
+	if manager == nil {
+		return ""
+	}
+	return manager.ActiveKeyID()
+}
+
+// RotateMasterKey makes a new master key active. Existing data keys have
+// to be rewrapped afterwards with Rewrap.
+func RotateMasterKey() (string, error) {
+	m, err := active()
+	if err != nil {
+		return "", err
+	}
+	return m.RotateMasterKey()
+}
@@ -0,0 +1,177 @@
+package keymanager
+
+import (
+	"bytes"
+	"crypto-wallet/crypto"
+	"crypto-wallet/models"
+	"encoding/base64"
+	"path/filepath"
+	"testing"
+)
+
+// newTestKeyManager installs a local key manager with a keyring file in a
+// temporary directory, so it can be rotated
+func newTestKeyManager(t *testing.T) (*LocalKeyManager, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	m, err := NewLocalKeyManager(path, "test secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetKeyManager(m)
+	return m, path
+}
+
+// testKey returns a private key and the wallet it belongs to
+func testKey(t *testing.T) (string, string) {
+	t.Helper()
+	priv, pub, err := crypto.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := crypto.PublicKeyToString(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crypto.PrivateKeyToString(priv), crypto.GenerateWalletID(pubKey)
+}
+
+// flip returns a base64 value with one bit of its decoded bytes changed
+func flip(t *testing.T, encoded string) string {
+	t.Helper()
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 1
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func TestLocalKeyManagerWrapUnwrap(t *testing.T) {
+	m, _ := newTestKeyManager(t)
+	dataKey := bytes.Repeat([]byte{7}, dataKeyLen)
+
+	keyID, wrapped, err := m.WrapKey(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID != envKeyID {
+		t.Fatalf("wrapped under %q, want %q", keyID, envKeyID)
+	}
+	if got, err := m.UnwrapKey(keyID, wrapped); err != nil || !bytes.Equal(got, dataKey) {
+		t.Fatalf("unwrapped %x, %v", got, err)
+	}
+
+	tampered := append([]byte{}, wrapped...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := m.UnwrapKey(keyID, tampered); err == nil {
+		t.Fatal("tampered wrapped key was unwrapped")
+	}
+	if _, err := m.UnwrapKey("missing", wrapped); err == nil {
+		t.Fatal("unwrapped under an unknown master key")
+	}
+
+	// The key ID is authenticated, so a wrapped key relabelled with another
+	// key ID fails even when that ID holds the same key
+	rotated, err := m.RotateMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.keys[rotated] = m.keys[envKeyID]
+	if _, err := m.UnwrapKey(rotated, wrapped); err == nil {
+		t.Fatal("wrapped key was unwrapped under another key ID")
+	}
+}
+
+func TestLocalKeyManagerRotationNeedsKeyring(t *testing.T) {
+	m, err := NewLocalKeyManager("", "test secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.RotateMasterKey(); err == nil {
+		t.Fatal("rotated a keyring without a file")
+	}
+	if _, err := NewLocalKeyManager("", ""); err == nil {
+		t.Fatal("created a key manager without a secret")
+	}
+}
+
+func TestSealOpensAfterRotation(t *testing.T) {
+	m, path := newTestKeyManager(t)
+	privateKey, walletID := testKey(t)
+
+	custody, err := Seal(privateKey, walletID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := *custody
+
+	if _, err := RotateMasterKey(); err != nil {
+		t.Fatal(err)
+	}
+	if custody.MasterKeyID == m.ActiveKeyID() {
+		t.Fatal("rotation kept the same master key")
+	}
+	// An envelope under the old master key still opens before rewrapping
+	if got, err := open(custody, walletID); err != nil || got != privateKey {
+		t.Fatalf("old envelope opened to %q, %v", got, err)
+	}
+
+	rewrapped, err := Rewrap(custody)
+	if err != nil || !rewrapped || custody.MasterKeyID != m.ActiveKeyID() {
+		t.Fatalf("rewrap = %v, %v, key %s", rewrapped, err, custody.MasterKeyID)
+	}
+	if custody.CipherText != old.CipherText {
+		t.Fatal("rewrap re-encrypted the private key")
+	}
+	if rewrapped, err := Rewrap(custody); err != nil || rewrapped {
+		t.Fatalf("second rewrap = %v, %v", rewrapped, err)
+	}
+
+	// The rotated keyring reloads with both master keys
+	reloaded, err := NewLocalKeyManager(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetKeyManager(reloaded)
+	for _, envelope := range []models.CustodyKey{old, *custody} {
+		if got, err := open(&envelope, walletID); err != nil || got != privateKey {
+			t.Fatalf("envelope under %s opened to %q, %v", envelope.MasterKeyID, got, err)
+		}
+	}
+}
+
+func TestSealRejectsTampering(t *testing.T) {
+	newTestKeyManager(t)
+	privateKey, walletID := testKey(t)
+	custody, err := Seal(privateKey, walletID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciphertext := *custody
+	ciphertext.CipherText = flip(t, custody.CipherText)
+	wrapped := *custody
+	wrapped.WrappedDataKey = flip(t, custody.WrappedDataKey)
+	if _, err := open(&ciphertext, walletID); err == nil {
+		t.Fatal("tampered ciphertext opened")
+	}
+	if _, err := open(&wrapped, walletID); err == nil {
+		t.Fatal("tampered wrapped data key opened")
+	}
+
+	// The wallet ID is the additional data, so the envelope cannot be
+	// moved to another wallet
+	_, otherWallet := testKey(t)
+	if _, err := open(custody, otherWallet); err == nil {
+		t.Fatal("envelope opened for another wallet")
+	}
+	if _, err := CustodySigner(&models.User{WalletID: otherWallet, Custody: custody}); err == nil {
+		t.Fatal("custody signer for another wallet")
+	}
+
+	signer, err := CustodySigner(&models.User{WalletID: walletID, Custody: custody})
+	if err != nil || signer.WalletID() != walletID {
+		t.Fatalf("custody signer = %v, %v", signer, err)
+	}
+}
@@ -0,0 +1,76 @@
+package keymanager
+
+import (
+	"errors"
+	"sync"
+)
+
+// KMSClient is the part of an external key management service the key
+// manager uses. Master keys stay inside the KMS; only data keys are sent to
+// it for wrapping.
+type KMSClient interface {
+	// Encrypt wraps plaintext under the named KMS key
+	Encrypt(keyID string, plaintext []byte) ([]byte, error)
+	// Decrypt unwraps ciphertext produced by Encrypt with the same key
+	Decrypt(keyID string, ciphertext []byte) ([]byte, error)
+	// CreateKey creates a new KMS key and returns its ID
+	CreateKey() (string, error)
+}
+
+var kmsProviders = map[string]func() (KMSClient, error){}
+
+// RegisterKMS makes an external KMS selectable with KEY_MANAGER=name. open
+// is called once at startup and reads whatever credentials the KMS needs.
+func RegisterKMS(name string, open func() (KMSClient, error)) {
+	kmsProviders[name] = open
+}
+
+// KMSKeyManager wraps data keys with an external KMS
+type KMSKeyManager struct {
+	mu     sync.RWMutex
+	client KMSClient
+	keyID  string
+}
+
+// NewKMSKeyManager wraps new data keys under the KMS key keyID
+func NewKMSKeyManager(client KMSClient, keyID string) *KMSKeyManager {
+	return &KMSKeyManager{client: client, keyID: keyID}
+}
+
+// ActiveKeyID names the KMS key new data keys are wrapped under
+func (m *KMSKeyManager) ActiveKeyID() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.keyID
+}
+
+// WrapKey sends a data key to the KMS to be wrapped under the active key
+func (m *KMSKeyManager) WrapKey(dataKey []byte) (string, []byte, error) {
+	keyID := m.ActiveKeyID()
+	wrapped, err := m.client.Encrypt(keyID, dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+	return keyID, wrapped, nil
+}
+
+// UnwrapKey asks the KMS to unwrap a data key
+func (m *KMSKeyManager) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	if keyID == "" {
+		return nil, errors.New("wrapped key has no master key ID")
+	}
+	return m.client.Decrypt(keyID, wrapped)
+}
+
+// RotateMasterKey creates a KMS key and makes it active. The new ID only
+// lasts until restart, so KMS_KEY_ID should be updated to it as well.
+func (m *KMSKeyManager) RotateMasterKey() (string, error) {
+	keyID, err := m.client.CreateKey()
+	if err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	m.keyID = keyID
+	m.mu.Unlock()
+	return keyID, nil
+}
@@ -0,0 +1,211 @@
+package keymanager
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// envKeyID names the master key derived from AES_ENCRYPTION_KEY
+const envKeyID = "env"
+
+// masterKeyLen is the size of an AES-256 master key
+const masterKeyLen = 32
+
+// keyringFile is the on-disk form of a local keyring
+type keyringFile struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"` // Key ID to base64 AES-256 key
+}
+
+// LocalKeyManager keeps master keys in a JSON keyring file that only the
+// server should be able to read. A new keyring starts with the key derived
+// from AES_ENCRYPTION_KEY. Without a file that is the only master key and
+// it cannot be rotated.
+type LocalKeyManager struct {
+	mu     sync.RWMutex
+	path   string
+	active string
+	keys   map[string][]byte
+}
+
+// NewLocalKeyManager loads the keyring at path, creating it if it does not
+// exist. An empty path keeps the keyring in memory.
+func NewLocalKeyManager(path, secret string) (*LocalKeyManager, error) {
+	m := &LocalKeyManager{path: path, keys: make(map[string][]byte)}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			return m, m.load(data)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	if secret == "" {
+		return nil, errors.New("AES_ENCRYPTION_KEY is required for the local key manager")
+	}
+	key, err := hkdf.Key(sha256.New, []byte(secret), nil, "crypto-wallet master key", masterKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	m.keys[envKeyID] = key
+	m.active = envKeyID
+
+	if path != "" {
+		if err := m.save(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// load parses a keyring file
+func (m *LocalKeyManager) load(data []byte) error {
+	var file keyringFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("invalid keyring %s: %w", m.path, err)
+	}
+	for id, encoded := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != masterKeyLen {
+			return fmt.Errorf("invalid master key %q in %s", id, m.path)
+		}
+		m.keys[id] = key
+	}
+	if _, ok := m.keys[file.Active]; !ok {
+		return fmt.Errorf("active master key %q is missing from %s", file.Active, m.path)
+	}
+	m.active = file.Active
+	return nil
+}
+
+// save writes the keyring to a temporary file and renames it into place so
+// a crash never leaves a partial keyring
+func (m *LocalKeyManager) save() error {
+	file := keyringFile{Active: m.active, Keys: make(map[string]string)}
+	for id, key := range m.keys {
+		file.Keys[id] = base64.StdEncoding.EncodeToString(key)
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), ".keyring-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), m.path)
+}
+
+// ActiveKeyID names the master key new data keys are wrapped under
+func (m *LocalKeyManager) ActiveKeyID() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.active
+}
+
+// WrapKey encrypts a data key with AES-256-GCM under the active master key
+func (m *LocalKeyManager) WrapKey(dataKey []byte) (string, []byte, error) {
+	m.mu.RLock()
+	keyID, key := m.active, m.keys[m.active]
+	m.mu.RUnlock()
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return keyID, gcm.Seal(nonce, nonce, dataKey, []byte(keyID)), nil
+}
+
+// UnwrapKey decrypts a data key wrapped under any master key in the keyring
+func (m *LocalKeyManager) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	m.mu.RLock()
+	key, ok := m.keys[keyID]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("master key %q not found", keyID)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, errors.New("wrapped key too short")
+	}
+	nonce, ciphertext := wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():]
+	dataKey, err := gcm.Open(nil, nonce, ciphertext, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with master key %q", keyID)
+	}
+	return dataKey, nil
+}
+
+// RotateMasterKey adds a random master key to the keyring and makes it
+// active. Earlier keys stay in the file so existing data keys still unwrap.
+func (m *LocalKeyManager) RotateMasterKey() (string, error) {
+	if m.path == "" {
+		return "", errors.New("master key rotation requires MASTER_KEY_FILE")
+	}
+
+	key := make([]byte, masterKeyLen)
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	keyID := fmt.Sprintf("mk-%s-%s", time.Now().UTC().Format("20060102"), hex.EncodeToString(suffix))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	previous := m.active
+	m.keys[keyID] = key
+	m.active = keyID
+	if err := m.save(); err != nil {
+		delete(m.keys, keyID)
+		m.active = previous
+		return "", err
+	}
+	return keyID, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,68 @@
+package keymanager
+
+import (
+	"crypto-wallet/crypto"
+	"crypto-wallet/models"
+	"errors"
+)
+
+// Signer signs for one wallet without exposing its private key. This
+// package is the only place a Signer comes from, so handlers never handle
+// custodial keys themselves.
+type Signer interface {
+	// WalletID returns the wallet the signer spends from
+	WalletID() string
+	// PublicKey returns the wallet's public key
+	PublicKey() string
+	// Sign signs data such as an input's signature hash
+	Sign(data string) (string, error)
+}
+
+type keySigner struct {
+	key crypto.PrivateKey
+}
+
+func (s keySigner) WalletID() string                 { return crypto.GenerateWalletID(s.PublicKey()) }
+func (s keySigner) PublicKey() string                { return s.key.Public().String() }
+func (s keySigner) Sign(data string) (string, error) { return s.key.Sign(data) }
+
+// KeySigner signs with a private key the wallet owner sent with a request
+func KeySigner(privateKeyStr string) (Signer, error) {
+	key, err := crypto.ParsePrivateKey(privateKeyStr)
+	if err != nil {
+		return nil, err
+	}
+	return keySigner{key}, nil
+}
+
+// CustodySigner signs for the primary wallet of a custodial user with the
+// key held by the key manager
+func CustodySigner(user *models.User) (Signer, error) {
+	if user.Custody == nil {
+		return nil, errors.New("wallet is not in custody")
+	}
+	privateKeyStr, err := open(user.Custody, user.WalletID)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := KeySigner(privateKeyStr)
+	if err != nil {
+		return nil, err
+	}
+	if signer.WalletID() != user.WalletID {
+		return nil, errors.New("custodial key does not belong to this wallet")
+	}
+	return signer, nil
+}
+
+// SignerFor returns a signer for a user's primary wallet: the private key
+// sent with the request if there is one, otherwise the custodial key
+func SignerFor(user *models.User, privateKeyStr string) (Signer, error) {
+	if privateKeyStr != "" {
+		return KeySigner(privateKeyStr)
+	}
+	if user.Custody == nil {
+		return nil, errors.New("private_key is required for wallets not in custody")
+	}
+	return CustodySigner(user)
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"crypto-wallet/blockchain"
 	"crypto-wallet/config"
 	"crypto-wallet/db"
 	"crypto-wallet/handlers"
+	"crypto-wallet/keymanager"
 	"crypto-wallet/middleware"
+	"crypto-wallet/node"
 	"crypto-wallet/services"
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/gin-gonic/gin"
 )
@@ -17,6 +21,11 @@ func main() {
 	log.Println("🚀 Starting Crypto Wallet Backend...")
 	config.LoadConfig()
 
+	// Run a maintenance command (e.g. "reindex") instead of the server if one was given
+	if runCommand(os.Args[1:]) {
+		return
+	}
+
 	// Connect to database
 	if err := db.ConnectDB(); err != nil {
 		log.Fatal("Failed to connect to database:", err)
@@ -24,13 +33,25 @@ func main() {
 	defer db.DisconnectDB()
 
 	// Initialize genesis block if needed
-	if err := db.InitializeGenesisBlock(); err != nil {
+	if err := blockchain.InitializeGenesisBlock(); err != nil {
 		log.Fatal("Failed to initialize genesis block:", err)
 	}
 
+	// Load the master keys that protect custodial wallet keys
+	if err := keymanager.Init(); err != nil {
+		log.Fatal("Failed to initialize key manager:", err)
+	}
+
 	// Start Zakat scheduler in background
 	go services.StartZakatScheduler()
 
+	// Join the peer-to-peer network if configured
+	if config.AppConfig.P2PListenAddr != "" || len(config.AppConfig.P2PPeers) > 0 {
+		if err := node.Start(config.AppConfig.P2PListenAddr, config.AppConfig.P2PPeers); err != nil {
+			log.Fatal("Failed to start P2P node:", err)
+		}
+	}
+
 	// Setup Gin router
 	gin.SetMode(gin.ReleaseMode) // Change to gin.DebugMode for development
 	r := gin.Default()
@@ -44,6 +65,8 @@ func main() {
 			"status":  "healthy",
 			"service": "Crypto Wallet API",
 			"version": "1.0.0",
+			"network": config.AppConfig.Network,
+			"sync":    node.Status(),
 		})
 	})
 
@@ -69,6 +92,7 @@ func main() {
 			blockchain.GET("/validate", handlers.ValidateBlockchain)
 			blockchain.POST("/validate-and-revert", handlers.ValidateAndRevertBlockchain)
 			blockchain.GET("/stats", handlers.GetBlockchainStats)
+			blockchain.GET("/supply", handlers.GetSupply)
 		}
 
 		// Public wallet validation
@@ -81,7 +105,11 @@ func main() {
 
 		// Public transaction lookup
 		public.GET("/transaction/:txId", handlers.GetTransactionByID)
+		public.GET("/transaction/:txId/proof", handlers.GetTransactionProof)
 		public.GET("/transactions/pending", handlers.GetPendingTransactions)
+
+		// Peer-to-peer node status
+		public.GET("/node/info", handlers.GetNodeInfo)
 	}
 
 	// Protected routes (authentication required)
@@ -93,7 +121,12 @@ func main() {
 		{
 			user.GET("/profile", handlers.GetProfile)
 			user.PUT("/profile", handlers.UpdateProfile)
-			user.GET("/private-key", handlers.GetPrivateKey)
+			user.POST("/private-key", handlers.GetPrivateKey)
+			user.GET("/keystore", handlers.ExportKeystore)
+			user.POST("/keystore/import", handlers.ImportKeystore)
+			user.PUT("/passphrase", handlers.ChangePassphrase)
+			user.POST("/custody", handlers.EnableCustody)
+			user.DELETE("/custody", handlers.DisableCustody)
 		}
 
 		// Wallet routes
@@ -105,12 +138,17 @@ func main() {
 			wallet.GET("/beneficiaries", handlers.GetBeneficiaries)
 			wallet.POST("/beneficiary", handlers.AddBeneficiary)
 			wallet.DELETE("/beneficiary/:walletId", handlers.RemoveBeneficiary)
+			wallet.GET("/hd", handlers.GetHDWallets)
+			wallet.POST("/hd/create", handlers.CreateHDWallet)
+			wallet.POST("/hd/restore", handlers.RestoreHDWallet)
 		}
 
 		// Transaction routes
 		transaction := protected.Group("/transaction")
 		{
 			transaction.POST("/send", handlers.SendMoney)
+			transaction.POST("/build", handlers.BuildTransaction)
+			transaction.POST("/submit", handlers.SubmitTransaction)
 			transaction.GET("/history", handlers.GetTransactionHistory)
 			transaction.GET("/my-pending", handlers.GetMyPendingTransactions)
 			transaction.GET("/zakat-history", handlers.GetZakatHistory)
@@ -120,6 +158,11 @@ func main() {
 		mining := protected.Group("/mining")
 		{
 			mining.POST("/mine", handlers.MineBlock)
+			mining.GET("/jobs/:id", handlers.GetMiningJob)
+			mining.GET("/jobs/:id/events", handlers.MiningJobEvents)
+			mining.DELETE("/jobs/:id", handlers.CancelMiningJob)
+			mining.GET("/template", handlers.GetBlockTemplate)
+			mining.POST("/submit", handlers.SubmitBlock)
 		}
 
 		// Reports routes
@@ -136,6 +179,11 @@ func main() {
 			admin.GET("/system-stats", handlers.GetSystemStats)
 			admin.GET("/system-logs", handlers.GetSystemLogs)
 			admin.POST("/trigger-zakat", handlers.TriggerZakatDeduction)
+			admin.POST("/reindex", middleware.AdminMiddleware(), handlers.ReindexUTXOs)
+			admin.POST("/keys/rotate", middleware.AdminMiddleware(), handlers.RotateMasterKey)
+			admin.POST("/peers", middleware.AdminMiddleware(), handlers.AddPeer)
+			admin.GET("/chain/export", middleware.AdminMiddleware(), handlers.ExportChain)
+			admin.POST("/chain/import", middleware.AdminMiddleware(), handlers.ImportChain)
 		}
 	}
 
@@ -144,7 +192,7 @@ func main() {
 	log.Printf("✅ Server running on http://localhost:%s", port)
 	log.Printf("📚 API Documentation available at http://localhost:%s/api", port)
 	log.Println("🔐 Authentication: JWT-based with OTP verification")
-	log.Println("⛏️  Mining: Use POST /api/mining/mine (requires authentication)")
+	log.Println("⛏️  Mining: Use POST /api/mining/mine, then follow GET /api/mining/jobs/:id (requires authentication)")
 	log.Println("🕌 Zakat: Automatically deducted monthly at 2.5%")
 	
 	if err := r.Run(fmt.Sprintf(":%s", port)); err != nil {
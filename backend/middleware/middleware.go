@@ -2,6 +2,8 @@ package middleware
 
 import (
 	"crypto-wallet/auth"
+	"crypto-wallet/config"
+	"crypto-wallet/db"
 	"net/http"
 	"strings"
 
@@ -42,6 +44,37 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// AdminMiddleware only lets through users listed in ADMIN_EMAILS. The email
+// must still be the user's current, verified one, so changing a profile's
+// email to an admin's does not grant access. It runs after AuthMiddleware.
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		email, _, userID, exists := GetUserContext(c)
+		if !exists || !isAdminEmail(email) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			return
+		}
+
+		user, err := db.GetUserByEmail(email)
+		if err != nil || user.ID != userID || !user.IsEmailVerified {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// isAdminEmail reports whether email is in the configured admin list
+func isAdminEmail(email string) bool {
+	for _, admin := range config.AppConfig.AdminEmails {
+		if strings.EqualFold(admin, email) {
+			return true
+		}
+	}
+	return false
+}
+
 // CORSMiddleware handles CORS
 func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
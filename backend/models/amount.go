@@ -0,0 +1,157 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// Amount is a ledger value in fixed-point smallest units (1 coin = 10^8 units).
+// It is string-encoded in JSON ("12.50000000") and stored as an int64 in BSON,
+// so balances, change outputs and zakat never accumulate rounding error.
+type Amount int64
+
+const (
+	// AmountDecimals is the number of decimal places an Amount carries
+	AmountDecimals = 8
+
+	// Coin is one whole coin expressed in smallest units
+	Coin Amount = 100000000
+)
+
+// ParseAmount parses a decimal string such as "12", "12.5" or "0.00000001"
+func ParseAmount(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errors.New("empty amount")
+	}
+
+	negative := false
+	if s[0] == '-' || s[0] == '+' {
+		negative = s[0] == '-'
+		s = s[1:]
+	}
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if len(frac) > AmountDecimals {
+		return 0, fmt.Errorf("amount %q has more than %d decimal places", s, AmountDecimals)
+	}
+	frac += strings.Repeat("0", AmountDecimals-len(frac))
+	if whole == "" {
+		whole = "0"
+	}
+
+	w, err := strconv.ParseUint(whole, 10, 63)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	f, err := strconv.ParseUint(frac, 10, 63)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if w > uint64(math.MaxInt64/int64(Coin)) {
+		return 0, fmt.Errorf("amount %q is out of range", s)
+	}
+
+	a := Amount(w)*Coin + Amount(f)
+	if a < 0 {
+		return 0, fmt.Errorf("amount %q is out of range", s)
+	}
+	if negative {
+		a = -a
+	}
+	return a, nil
+}
+
+// AmountFromFloat converts a legacy floating-point value, rounding to the nearest unit
+func AmountFromFloat(f float64) Amount {
+	return Amount(math.Round(f * float64(Coin)))
+}
+
+// Float64 returns the amount in coins as a float (for display only)
+func (a Amount) Float64() float64 {
+	return float64(a) / float64(Coin)
+}
+
+// String formats the amount with exactly AmountDecimals decimal places
+func (a Amount) String() string {
+	sign := ""
+	u := uint64(a)
+	if a < 0 {
+		sign = "-"
+		u = uint64(-a)
+	}
+	return fmt.Sprintf("%s%d.%08d", sign, u/uint64(Coin), u%uint64(Coin))
+}
+
+// MulFraction returns a*num/den rounded down, without intermediate overflow
+func (a Amount) MulFraction(num, den int64) Amount {
+	q, r := int64(a)/den, int64(a)%den
+	return Amount(q*num + r*num/den)
+}
+
+// MarshalJSON encodes the amount as a decimal string
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON accepts a decimal string or, for older clients, a JSON number
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		// Not a string: parse the number literal without going through float64
+		s = string(data)
+	}
+
+	parsed, err := ParseAmount(s)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// MarshalBSONValue stores the amount as an int64 of smallest units
+func (a Amount) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bson.MarshalValue(int64(a))
+}
+
+// UnmarshalBSONValue reads int64/int32 units, and converts legacy doubles
+// (whole coins) written before amounts were fixed-point
+func (a *Amount) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	value := bsoncore.Value{Type: t, Data: data}
+
+	switch t {
+	case bsontype.Int64:
+		*a = Amount(value.Int64())
+	case bsontype.Int32:
+		*a = Amount(value.Int32())
+	case bsontype.Double:
+		*a = AmountFromFloat(value.Double())
+	case bsontype.String:
+		parsed, err := ParseAmount(value.StringValue())
+		if err != nil {
+			return err
+		}
+		*a = parsed
+	case bsontype.Null, bsontype.Undefined:
+		*a = 0
+	default:
+		return fmt.Errorf("cannot decode BSON %s into Amount", t)
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // TXInput: References a previous output
 type TXInput struct {
@@ -12,7 +15,7 @@ type TXInput struct {
 
 // TXOutput: The value locked to a receiver
 type TXOutput struct {
-	Value      float64 `json:"value" bson:"value"`
+	Value      Amount  `json:"value" bson:"value"`
 	PubKeyHash string  `json:"pub_key_hash" bson:"pub_key_hash"` // Receiver's Wallet ID
 	IsSpent    bool    `json:"is_spent" bson:"is_spent"`
 	SpentInTx  string  `json:"spent_in_tx,omitempty" bson:"spent_in_tx,omitempty"`
@@ -20,25 +23,29 @@ type TXOutput struct {
 
 type Transaction struct {
 	ID         string     `json:"id" bson:"id"`
+	Version    int        `json:"version" bson:"version"` // Serialization version, 0 for legacy transactions
 	Vin        []TXInput  `json:"vin" bson:"vin"`
 	Vout       []TXOutput `json:"vout" bson:"vout"`
 	Timestamp  int64      `json:"timestamp" bson:"timestamp"`
 	SenderID   string     `json:"sender_id" bson:"sender_id"`
 	ReceiverID string     `json:"receiver_id" bson:"receiver_id"`
-	Amount     float64    `json:"amount" bson:"amount"`
+	Amount     Amount     `json:"amount" bson:"amount"`
+	Fee        Amount     `json:"fee" bson:"fee"` // Inputs minus outputs, collected by the miner
 	Note       string     `json:"note,omitempty" bson:"note,omitempty"`
 	IsZakat    bool       `json:"is_zakat" bson:"is_zakat"`
 	Type       string     `json:"type" bson:"type"` // "transfer", "zakat_deduction", "mining_reward"
 }
 
 type Block struct {
+	Version      int           `json:"version" bson:"version"` // Header serialization version, 0 for legacy blocks
 	Index        int           `json:"index" bson:"index"`
 	Timestamp    int64         `json:"timestamp" bson:"timestamp"`
 	Transactions []Transaction `json:"transactions" bson:"transactions"`
 	PrevHash     string        `json:"prev_hash" bson:"prev_hash"`
 	Hash         string        `json:"hash" bson:"hash"`
 	Nonce        int           `json:"nonce" bson:"nonce"`
-	Difficulty   int           `json:"difficulty" bson:"difficulty"`
+	Difficulty   int           `json:"difficulty" bson:"difficulty"` // Leading zero hex digits (legacy blocks)
+	Bits         uint32        `json:"bits" bson:"bits"`             // Compact proof-of-work target
 	MerkleRoot   string        `json:"merkle_root" bson:"merkle_root"`
 	MinedBy      string        `json:"mined_by,omitempty" bson:"mined_by,omitempty"`
 }
@@ -58,20 +65,34 @@ type User struct {
 	GoogleID          string    `json:"google_id,omitempty" bson:"google_id,omitempty"` // Google OAuth ID
 	AuthProvider      string    `json:"auth_provider" bson:"auth_provider"` // "email" or "google"
 	Beneficiaries     []string  `json:"beneficiaries" bson:"beneficiaries"` // List of wallet IDs
+	HDAccountKey      string    `json:"hd_account_key,omitempty" bson:"hd_account_key,omitempty"` // BIP32 account xpub of the user's HD wallets
+	Custody           *CustodyKey `json:"-" bson:"custody,omitempty"` // Set when the server holds the key for signing
 	CreatedAt         time.Time `json:"created_at" bson:"created_at"`
 	UpdatedAt         time.Time `json:"updated_at" bson:"updated_at"`
 	LastLogin         time.Time `json:"last_login" bson:"last_login"`
 }
 
+// CustodyKey is a custodial user's private key under envelope encryption:
+// encrypted with a per-user data key, which is wrapped by a master key
+type CustodyKey struct {
+	MasterKeyID    string    `json:"master_key_id" bson:"master_key_id"`
+	WrappedDataKey string    `json:"wrapped_data_key" bson:"wrapped_data_key"` // base64
+	Nonce          string    `json:"nonce" bson:"nonce"`
+	CipherText     string    `json:"ciphertext" bson:"ciphertext"`
+	CreatedAt      time.Time `json:"created_at" bson:"created_at"`
+	RotatedAt      time.Time `json:"rotated_at,omitempty" bson:"rotated_at,omitempty"` // Last time the data key was rewrapped
+}
+
 // Wallet represents wallet information
 type Wallet struct {
-	WalletID      string    `json:"wallet_id" bson:"_id"`
-	UserID        string    `json:"user_id" bson:"user_id"`
-	PublicKey     string    `json:"public_key" bson:"public_key"`
-	Balance       float64   `json:"balance" bson:"balance"` // Cached balance
-	LastZakatDate time.Time `json:"last_zakat_date" bson:"last_zakat_date"`
-	CreatedAt     time.Time `json:"created_at" bson:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at" bson:"updated_at"`
+	WalletID       string    `json:"wallet_id" bson:"_id"`
+	UserID         string    `json:"user_id" bson:"user_id"`
+	PublicKey      string    `json:"public_key" bson:"public_key"`
+	Balance        Amount    `json:"balance" bson:"balance"` // Cached balance
+	LastZakatDate  time.Time `json:"last_zakat_date" bson:"last_zakat_date"`
+	DerivationPath string    `json:"derivation_path,omitempty" bson:"derivation_path,omitempty"` // Set for wallets derived from an HD account
+	CreatedAt      time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" bson:"updated_at"`
 }
 
 // UTXO represents an unspent transaction output
@@ -80,9 +101,10 @@ type UTXO struct {
 	TxID       string    `json:"tx_id" bson:"tx_id"`
 	Vout       int       `json:"vout" bson:"vout"`
 	WalletID   string    `json:"wallet_id" bson:"wallet_id"`
-	Amount     float64   `json:"amount" bson:"amount"`
+	Amount     Amount    `json:"amount" bson:"amount"`
 	IsSpent    bool      `json:"is_spent" bson:"is_spent"`
 	SpentInTx  string    `json:"spent_in_tx,omitempty" bson:"spent_in_tx,omitempty"`
+	IsCoinbase bool      `json:"is_coinbase" bson:"is_coinbase"` // Mining reward output, subject to coinbase maturity
 	IsLocked   bool      `json:"is_locked" bson:"is_locked"` // Locked for pending transactions
 	LockedBy   string    `json:"locked_by,omitempty" bson:"locked_by,omitempty"` // Pending transaction ID
 	BlockIndex int       `json:"block_index" bson:"block_index"`
@@ -104,7 +126,7 @@ type TransactionLog struct {
 	WalletID    string    `json:"wallet_id" bson:"wallet_id"`
 	TxID        string    `json:"tx_id" bson:"tx_id"`
 	Action      string    `json:"action" bson:"action"` // "sent", "received", "mined", "zakat_deducted"
-	Amount      float64   `json:"amount" bson:"amount"`
+	Amount      Amount    `json:"amount" bson:"amount"`
 	Counterparty string   `json:"counterparty,omitempty" bson:"counterparty,omitempty"`
 	BlockHash   string    `json:"block_hash,omitempty" bson:"block_hash,omitempty"`
 	BlockIndex  int       `json:"block_index,omitempty" bson:"block_index,omitempty"`
@@ -129,8 +151,8 @@ type ZakatRecord struct {
 	ID          string    `json:"id" bson:"_id,omitempty"`
 	UserID      string    `json:"user_id" bson:"user_id"`
 	WalletID    string    `json:"wallet_id" bson:"wallet_id"`
-	Amount      float64   `json:"amount" bson:"amount"`
-	Balance     float64   `json:"balance_at_deduction" bson:"balance_at_deduction"`
+	Amount      Amount    `json:"amount" bson:"amount"`
+	Balance     Amount    `json:"balance_at_deduction" bson:"balance_at_deduction"`
 	TxID        string    `json:"tx_id" bson:"tx_id"`
 	BlockHash   string    `json:"block_hash" bson:"block_hash"`
 	BlockIndex  int       `json:"block_index" bson:"block_index"`
@@ -139,19 +161,71 @@ type ZakatRecord struct {
 	Year        int       `json:"year" bson:"year"`
 }
 
+// BlockUndo is the undo journal written alongside every committed block. It
+// records exactly what the block changed so it can be disconnected later.
+type BlockUndo struct {
+	BlockHash         string        `json:"block_hash" bson:"_id"`
+	BlockIndex        int           `json:"block_index" bson:"block_index"`
+	SpentUTXOs        []UTXO        `json:"spent_utxos" bson:"spent_utxos"`     // State of each consumed UTXO before the block
+	CreatedUTXOs      []UTXO        `json:"created_utxos" bson:"created_utxos"` // Outputs the block added
+	PendingTxIDs      []string      `json:"pending_tx_ids" bson:"pending_tx_ids"` // Pool entries moved to "mined"
+	TransactionLogIDs []string      `json:"transaction_log_ids" bson:"transaction_log_ids"`
+	ZakatRecords      []ZakatRecord `json:"zakat_records" bson:"zakat_records"`
+	CreatedAt         time.Time     `json:"created_at" bson:"created_at"`
+}
+
+// SideBlock is a block that is known but not on the active chain. It is kept
+// so a competing branch can become active once it has more cumulative work.
+type SideBlock struct {
+	Hash         string        `json:"hash" bson:"_id"`
+	Block        Block         `json:"block" bson:"block"`
+	ChainWork    string        `json:"chain_work" bson:"chain_work"` // Cumulative work up to this block (hex)
+	ZakatRecords []ZakatRecord `json:"zakat_records,omitempty" bson:"zakat_records,omitempty"`
+	ReceivedAt   time.Time     `json:"received_at" bson:"received_at"`
+}
+
 // SendMoneyRequest represents the request body for sending money
 type SendMoneyRequest struct {
 	ReceiverWalletID string  `json:"receiver_wallet_id" binding:"required"`
-	Amount           float64 `json:"amount" binding:"required,gt=0"`
+	Amount           Amount  `json:"amount" binding:"required,gt=0"`
+	Fee              Amount  `json:"fee"` // Optional miner fee on top of the amount
 	Note             string  `json:"note"`
-	PrivateKey       string  `json:"private_key" binding:"required"` // User sends decrypted private key temporarily
+	PrivateKey       string  `json:"private_key"` // User sends decrypted private key temporarily; omitted for custodial wallets
+}
+
+// BuildTransactionRequest asks for an unsigned transfer to sign client-side
+type BuildTransactionRequest struct {
+	ReceiverWalletID string `json:"receiver_wallet_id" binding:"required"`
+	Amount           Amount `json:"amount" binding:"required,gt=0"`
+	Fee              Amount `json:"fee"`
+	Note             string `json:"note"`
+	FromWalletID     string `json:"from_wallet_id"` // Optional HD wallet to spend from; defaults to the primary wallet
+}
+
+// SubmitTransactionRequest carries a transfer signed client-side
+type SubmitTransactionRequest struct {
+	Transaction Transaction `json:"transaction" binding:"required"`
+}
+
+// CreateHDWalletRequest registers a new HD account from its extended
+// public key, derived from a mnemonic on the user's device
+type CreateHDWalletRequest struct {
+	AccountKey string `json:"account_key" binding:"required"` // xpub at m/44'/1'/0'
+}
+
+// RestoreHDWalletRequest watches an existing HD account from its extended
+// public key
+type RestoreHDWalletRequest struct {
+	AccountKey string `json:"account_key" binding:"required"` // xpub at m/44'/1'/0'
 }
 
 // SignupRequest represents user signup request
 type SignupRequest struct {
-	FullName string `json:"full_name" binding:"required"`
-	Email    string `json:"email" binding:"required,email"`
-	CNIC     string `json:"cnic" binding:"required"`
+	FullName   string `json:"full_name" binding:"required"`
+	Email      string `json:"email" binding:"required,email"`
+	CNIC       string `json:"cnic" binding:"required"`
+	KeyType    string `json:"key_type"` // "rsa" (default), "secp256k1" or "ed25519"
+	Passphrase string `json:"passphrase" binding:"required,min=8"` // Encrypts the stored private key
 }
 
 // LoginRequest represents login request
@@ -161,13 +235,16 @@ type LoginRequest struct {
 
 // VerifyOTPRequest represents OTP verification
 type VerifyOTPRequest struct {
-	Email string `json:"email" binding:"required,email"`
-	OTP   string `json:"otp" binding:"required"`
+	Email      string `json:"email" binding:"required,email"`
+	OTP        string `json:"otp" binding:"required"`
+	Passphrase string `json:"passphrase"` // Optional; re-encrypts a legacy or outdated stored key
 }
 
 // GoogleLoginRequest represents Google OAuth login
 type GoogleLoginRequest struct {
-	Token string `json:"token" binding:"required"` // Google ID token
+	Token      string `json:"token" binding:"required"` // Google ID token
+	KeyType    string `json:"key_type"`                 // Key type for a new wallet, as in SignupRequest
+	Passphrase string `json:"passphrase"`               // Required for a new wallet's key; upgrades an existing one as in VerifyOTPRequest
 }
 
 // UpdateProfileRequest represents profile update
@@ -176,6 +253,28 @@ type UpdateProfileRequest struct {
 	Email    string `json:"email,email"`
 }
 
+// PrivateKeyRequest unlocks the stored private key
+type PrivateKeyRequest struct {
+	Passphrase string `json:"passphrase" binding:"required"`
+}
+
+// ImportKeystoreRequest replaces the stored private key with an exported keystore
+type ImportKeystoreRequest struct {
+	Keystore   json.RawMessage `json:"keystore" binding:"required"`
+	Passphrase string          `json:"passphrase" binding:"required"`
+}
+
+// EnableCustodyRequest hands the stored private key to the server's key manager
+type EnableCustodyRequest struct {
+	Passphrase string `json:"passphrase" binding:"required"`
+}
+
+// ChangePassphraseRequest re-encrypts the stored private key
+type ChangePassphraseRequest struct {
+	CurrentPassphrase string `json:"current_passphrase"` // Not needed for keys without a passphrase yet
+	NewPassphrase     string `json:"new_passphrase" binding:"required,min=8"`
+}
+
 // AddBeneficiaryRequest adds a beneficiary wallet
 type AddBeneficiaryRequest struct {
 	WalletID string `json:"wallet_id" binding:"required"`
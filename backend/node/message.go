@@ -0,0 +1,73 @@
+package node
+
+import (
+	"encoding/json"
+)
+
+// ProtocolVersion is the peer protocol version this node speaks. Version 2
+// replaced block-by-block catch-up with header-first sync.
+const ProtocolVersion = 2
+
+// Message types exchanged between peers
+const (
+	MsgVersion    = "version"    // First message on a connection, describes the sender
+	MsgVerAck     = "verack"     // Accepts the peer's version; the handshake is complete once both are sent
+	MsgTx         = "tx"         // A pending transaction
+	MsgBlock      = "block"      // A mined block
+	MsgGetHeaders = "getheaders" // Asks for active-chain headers after the first known locator hash
+	MsgHeaders    = "headers"    // Serialized block headers, in chain order
+	MsgGetData    = "getdata"    // Asks for full blocks by hash
+	MsgNotFound   = "notfound"   // Requested blocks the sender does not have
+	MsgPing       = "ping"
+	MsgPong       = "pong"
+)
+
+// Message is the envelope for everything sent over a peer connection. Each
+// message is one line of JSON.
+type Message struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// VersionPayload describes a node during the handshake
+type VersionPayload struct {
+	ProtocolVersion int    `json:"protocol_version"`
+	NodeID          string `json:"node_id"`
+	Network         string `json:"network"`
+	GenesisHash     string `json:"genesis_hash"`
+	BestHeight      int    `json:"best_height"`
+	BestHash        string `json:"best_hash"`
+	ListenAddr      string `json:"listen_addr,omitempty"`
+	UserAgent       string `json:"user_agent"`
+	Timestamp       int64  `json:"timestamp"`
+}
+
+// GetHeadersPayload lists block hashes from the requester's tip back to
+// genesis, spaced further apart the deeper they are
+type GetHeadersPayload struct {
+	Locator []string `json:"locator"`
+}
+
+// HeadersPayload carries hex-encoded canonical block headers
+type HeadersPayload struct {
+	Headers []string `json:"headers"`
+}
+
+// InventoryPayload lists block hashes, for getdata and notfound
+type InventoryPayload struct {
+	Hashes []string `json:"hashes"`
+}
+
+// newMessage builds a message with a JSON-encoded payload
+func newMessage(msgType string, payload interface{}) (Message, error) {
+	msg := Message{Type: msgType}
+	if payload == nil {
+		return msg, nil
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return msg, err
+	}
+	msg.Payload = data
+	return msg, nil
+}
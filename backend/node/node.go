@@ -0,0 +1,554 @@
+package node
+
+import (
+	"crypto-wallet/blockchain"
+	"crypto-wallet/config"
+	"crypto-wallet/db"
+	"crypto-wallet/models"
+	"crypto-wallet/services"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// UserAgent identifies this software to peers
+	UserAgent = "crypto-wallet/1.0"
+
+	maxPeers             = 32
+	dialTimeout          = 10 * time.Second
+	reconnectInterval    = 30 * time.Second
+	seenInventoryEntries = 20000
+)
+
+var (
+	mu         sync.RWMutex
+	peers      = make(map[*Peer]bool)
+	nodeID     string
+	listenAddr string
+	started    bool
+
+	// seen remembers recently relayed blocks and transactions so gossip does not loop
+	seen = newInventory(seenInventoryEntries)
+)
+
+// NodeInfo describes this node and its peers
+type NodeInfo struct {
+	NodeID          string     `json:"node_id"`
+	ListenAddr      string     `json:"listen_addr"`
+	ProtocolVersion int        `json:"protocol_version"`
+	UserAgent       string     `json:"user_agent"`
+	Peers           []PeerInfo `json:"peers"`
+}
+
+// Start listens for peers on addr (if not empty), connects to the given
+// peers, and begins relaying new transactions and blocks
+func Start(addr string, seeds []string) error {
+	mu.Lock()
+	if started {
+		mu.Unlock()
+		return errors.New("node already started")
+	}
+	started = true
+	nodeID = randomNodeID()
+	listenAddr = addr
+	mu.Unlock()
+
+	services.OnTransactionAccepted(func(tx models.Transaction) {
+		seen.add(tx.ID)
+		broadcast(MsgTx, tx)
+	})
+	services.OnBlockAccepted(func(block models.Block, result *services.AcceptResult) {
+		seen.add(block.Hash)
+		// Peers fetch blocks downloaded during a sync themselves
+		if !syncer.syncing() {
+			broadcast(MsgBlock, block)
+		}
+	})
+
+	if addr != "" {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		log.Printf("🌐 P2P node %s listening on %s", nodeID, listener.Addr())
+		go acceptLoop(listener)
+	}
+
+	for _, seed := range seeds {
+		go maintainPeer(seed)
+	}
+	go syncLoop()
+	return nil
+}
+
+// Connect dials a peer and starts the handshake
+func Connect(addr string) error {
+	if connectedTo(addr) {
+		return errors.New("already connected to " + addr)
+	}
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return err
+	}
+	p := newPeer(conn, false)
+	p.addr = addr
+	go runPeer(p)
+	return nil
+}
+
+// Info returns this node's identity and its current peers
+func Info() NodeInfo {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	info := NodeInfo{
+		NodeID:          nodeID,
+		ListenAddr:      listenAddr,
+		ProtocolVersion: ProtocolVersion,
+		UserAgent:       UserAgent,
+		Peers:           []PeerInfo{},
+	}
+	for p := range peers {
+		info.Peers = append(info.Peers, p.Info())
+	}
+	return info
+}
+
+// BroadcastTransaction relays a pending transaction to every peer
+func BroadcastTransaction(tx models.Transaction) {
+	seen.add(tx.ID)
+	broadcast(MsgTx, tx)
+}
+
+// BroadcastBlock relays a block to every peer
+func BroadcastBlock(block models.Block) {
+	seen.add(block.Hash)
+	broadcast(MsgBlock, block)
+}
+
+func broadcast(msgType string, payload interface{}) {
+	mu.RLock()
+	targets := make([]*Peer, 0, len(peers))
+	for p := range peers {
+		targets = append(targets, p)
+	}
+	mu.RUnlock()
+
+	for _, p := range targets {
+		if p.Handshaked() {
+			p.Send(msgType, payload)
+		}
+	}
+}
+
+func acceptLoop(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("❌ P2P listener stopped: %v", err)
+			return
+		}
+
+		mu.RLock()
+		full := len(peers) >= maxPeers
+		mu.RUnlock()
+		if full {
+			conn.Close()
+			continue
+		}
+		go runPeer(newPeer(conn, true))
+	}
+}
+
+// maintainPeer keeps an outbound connection to addr, redialling when it drops
+func maintainPeer(addr string) {
+	for {
+		if !connectedTo(addr) {
+			if err := Connect(addr); err != nil {
+				log.Printf("⚠️ Could not connect to peer %s: %v", addr, err)
+			}
+		}
+		time.Sleep(reconnectInterval)
+	}
+}
+
+func connectedTo(addr string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	for p := range peers {
+		if !p.inbound && p.addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// runPeer handles a connection from handshake until it closes
+func runPeer(p *Peer) {
+	mu.Lock()
+	peers[p] = true
+	mu.Unlock()
+
+	defer func() {
+		p.Close()
+		mu.Lock()
+		delete(peers, p)
+		mu.Unlock()
+	}()
+
+	go p.writeLoop()
+
+	version, err := localVersion()
+	if err != nil {
+		log.Printf("❌ Cannot build version message: %v", err)
+		return
+	}
+	p.Send(MsgVersion, version)
+
+	err = p.readLoop(handleMessage)
+	if p.Handshaked() {
+		log.Printf("🔌 Peer %s disconnected: %v", p.addr, err)
+		services.LogSystemEvent("peer_disconnected", "", map[string]interface{}{
+			"address": p.addr,
+			"reason":  err.Error(),
+		}, "info")
+	} else {
+		log.Printf("⚠️ Peer %s dropped during handshake: %v", p.addr, err)
+	}
+}
+
+func localVersion() (*VersionPayload, error) {
+	genesis, err := db.GetBlockByIndex(0)
+	if err != nil {
+		return nil, err
+	}
+	tip, err := db.GetLastBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	return &VersionPayload{
+		ProtocolVersion: ProtocolVersion,
+		NodeID:          nodeID,
+		Network:         config.AppConfig.Network,
+		GenesisHash:     genesis.Hash,
+		BestHeight:      tip.Index,
+		BestHash:        tip.Hash,
+		ListenAddr:      listenAddr,
+		UserAgent:       UserAgent,
+		Timestamp:       time.Now().Unix(),
+	}, nil
+}
+
+// handleMessage processes one message from a peer. Returning an error disconnects the peer.
+func handleMessage(p *Peer, msg Message) error {
+	switch msg.Type {
+	case MsgVersion:
+		return handleVersion(p, msg.Payload)
+	case MsgVerAck:
+		p.mu.Lock()
+		duplicate := p.gotVerAck
+		p.gotVerAck = true
+		p.mu.Unlock()
+		if duplicate {
+			return errors.New("duplicate verack message")
+		}
+		if p.Handshaked() {
+			onHandshake(p)
+		}
+		return nil
+	}
+
+	if !p.Handshaked() {
+		return fmt.Errorf("%q received before handshake", msg.Type)
+	}
+
+	switch msg.Type {
+	case MsgPing:
+		p.Send(MsgPong, nil)
+	case MsgPong:
+	case MsgTx:
+		var tx models.Transaction
+		if err := json.Unmarshal(msg.Payload, &tx); err != nil {
+			return fmt.Errorf("malformed transaction: %w", err)
+		}
+		handleTransaction(p, tx)
+	case MsgBlock:
+		var block models.Block
+		if err := json.Unmarshal(msg.Payload, &block); err != nil {
+			return fmt.Errorf("malformed block: %w", err)
+		}
+		handleBlock(p, block)
+	case MsgGetHeaders:
+		var req GetHeadersPayload
+		if err := json.Unmarshal(msg.Payload, &req); err != nil {
+			return fmt.Errorf("malformed getheaders: %w", err)
+		}
+		handleGetHeaders(p, req)
+	case MsgHeaders:
+		var resp HeadersPayload
+		if err := json.Unmarshal(msg.Payload, &resp); err != nil {
+			return fmt.Errorf("malformed headers: %w", err)
+		}
+		return handleHeaders(p, resp)
+	case MsgGetData:
+		var req InventoryPayload
+		if err := json.Unmarshal(msg.Payload, &req); err != nil {
+			return fmt.Errorf("malformed getdata: %w", err)
+		}
+		handleGetData(p, req)
+	case MsgNotFound:
+		var resp InventoryPayload
+		if err := json.Unmarshal(msg.Payload, &resp); err != nil {
+			return fmt.Errorf("malformed notfound: %w", err)
+		}
+		syncer.deliverNotFound(p, resp.Hashes)
+	default:
+		// Unknown messages are ignored so newer peers can extend the protocol
+		log.Printf("⚠️ Ignoring unknown message %q from %s", msg.Type, p.addr)
+	}
+	return nil
+}
+
+func handleVersion(p *Peer, payload json.RawMessage) error {
+	var version VersionPayload
+	if err := json.Unmarshal(payload, &version); err != nil {
+		return fmt.Errorf("malformed version: %w", err)
+	}
+
+	p.mu.Lock()
+	duplicate := p.version != nil
+	p.mu.Unlock()
+	if duplicate {
+		return errors.New("duplicate version message")
+	}
+
+	if version.ProtocolVersion < ProtocolVersion {
+		return fmt.Errorf("unsupported protocol version %d", version.ProtocolVersion)
+	}
+	if version.Network != config.AppConfig.Network {
+		return fmt.Errorf("peer is on network %q", version.Network)
+	}
+	if version.NodeID == nodeID {
+		return errors.New("connected to self")
+	}
+	genesis, err := db.GetBlockByIndex(0)
+	if err != nil {
+		return err
+	}
+	if version.GenesisHash != genesis.Hash {
+		return fmt.Errorf("peer is on a different network (genesis %s)", version.GenesisHash)
+	}
+
+	mu.RLock()
+	for other := range peers {
+		if other != p && other.Info().NodeID == version.NodeID {
+			mu.RUnlock()
+			return errors.New("already connected to node " + version.NodeID)
+		}
+	}
+	mu.RUnlock()
+
+	p.mu.Lock()
+	p.version = &version
+	p.sentVerAck = true
+	p.mu.Unlock()
+	p.Send(MsgVerAck, nil)
+
+	if p.Handshaked() {
+		onHandshake(p)
+	}
+	return nil
+}
+
+func onHandshake(p *Peer) {
+	info := p.Info()
+	log.Printf("🤝 Connected to peer %s (node %s, height %d)", p.addr, info.NodeID, info.BestHeight)
+	services.LogSystemEvent("peer_connected", "", map[string]interface{}{
+		"address":     p.addr,
+		"node_id":     info.NodeID,
+		"inbound":     info.Inbound,
+		"best_height": info.BestHeight,
+	}, "info")
+
+	if tip, err := db.GetLastBlock(); err == nil && info.BestHeight > tip.Index {
+		triggerSync()
+	}
+}
+
+func handleTransaction(p *Peer, tx models.Transaction) {
+	if !seen.add(tx.ID) {
+		return
+	}
+	if err := services.AcceptTransaction(tx); err != nil && !errors.Is(err, services.ErrKnownTransaction) {
+		log.Printf("⚠️ Rejected transaction %s from %s: %v", tx.ID, p.addr, err)
+	}
+}
+
+func handleBlock(p *Peer, block models.Block) {
+	p.noteHeight(block.Index)
+	if syncer.deliverBlock(p, block) {
+		return
+	}
+	if !seen.add(block.Hash) {
+		return
+	}
+
+	// Blocks building on the active chain get the header checks up front
+	if parent, err := db.GetBlockByHash(block.PrevHash); err == nil {
+		if !blockchain.ValidateBlock(block, *parent, blockchain.LookupStoredBlock) {
+			log.Printf("⚠️ Rejected invalid block %d (%s) from %s", block.Index, block.Hash, p.addr)
+			return
+		}
+	}
+
+	result, err := services.AcceptBlock(block, nil)
+	if errors.Is(err, services.ErrOrphanBlock) {
+		// We are missing its ancestors; the sync will fetch them
+		seen.remove(block.Hash)
+		triggerSync()
+		return
+	}
+	if err != nil {
+		log.Printf("⚠️ Rejected block %d (%s) from %s: %v", block.Index, block.Hash, p.addr, err)
+		return
+	}
+	if result.Status != "duplicate" {
+		log.Printf("📦 Block %d from %s: %s", block.Index, p.addr, result.Status)
+	}
+}
+
+// handleGetHeaders sends the headers on our active chain after the first
+// locator hash we know
+func handleGetHeaders(p *Peer, req GetHeadersPayload) {
+	blocks, err := db.GetAllBlocks()
+	if err != nil {
+		return
+	}
+
+	start := 1
+	for _, hash := range req.Locator {
+		if block, err := db.GetBlockByHash(hash); err == nil {
+			start = block.Index + 1
+			break
+		}
+	}
+
+	resp := HeadersPayload{Headers: []string{}}
+	for _, block := range blocks {
+		if block.Index < start {
+			continue
+		}
+		if len(resp.Headers) == maxHeadersPerMessage {
+			break
+		}
+		resp.Headers = append(resp.Headers, hex.EncodeToString(blockchain.SerializeBlockHeader(block)))
+	}
+	p.Send(MsgHeaders, resp)
+}
+
+func handleHeaders(p *Peer, resp HeadersPayload) error {
+	if len(resp.Headers) > maxHeadersPerMessage {
+		return fmt.Errorf("too many headers (%d)", len(resp.Headers))
+	}
+
+	headers := make([]models.Block, 0, len(resp.Headers))
+	for _, encoded := range resp.Headers {
+		data, err := hex.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("malformed header: %w", err)
+		}
+		header, err := blockchain.DeserializeBlockHeader(data)
+		if err != nil {
+			return err
+		}
+		headers = append(headers, header)
+	}
+	if len(headers) > 0 {
+		p.noteHeight(headers[len(headers)-1].Index)
+	}
+
+	syncer.deliverHeaders(p, headers)
+	return nil
+}
+
+// handleGetData sends the requested blocks, from the active chain or a side branch
+func handleGetData(p *Peer, req InventoryPayload) {
+	var missing []string
+	for _, hash := range req.Hashes {
+		if block, err := db.GetBlockByHash(hash); err == nil {
+			p.Send(MsgBlock, block)
+		} else if side, err := db.GetSideBlock(hash); err == nil {
+			p.Send(MsgBlock, side.Block)
+		} else {
+			missing = append(missing, hash)
+		}
+	}
+	if len(missing) > 0 {
+		p.Send(MsgNotFound, InventoryPayload{Hashes: missing})
+	}
+}
+
+// blockLocator lists hashes from the tip back to genesis: the last ten
+// blocks, then doubling the step each time
+func blockLocator(blocks []models.Block) []string {
+	var locator []string
+	step := 1
+	for i := len(blocks) - 1; i > 0; i -= step {
+		locator = append(locator, blocks[i].Hash)
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+	return append(locator, blocks[0].Hash)
+}
+
+func randomNodeID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// inventory is a bounded set of recently seen hashes
+type inventory struct {
+	mu    sync.Mutex
+	max   int
+	items map[string]bool
+	order []string
+}
+
+func newInventory(max int) *inventory {
+	return &inventory{max: max, items: make(map[string]bool)}
+}
+
+// add records a hash and reports whether it was new
+func (inv *inventory) add(hash string) bool {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	if inv.items[hash] {
+		return false
+	}
+	inv.items[hash] = true
+	inv.order = append(inv.order, hash)
+	if len(inv.order) > inv.max {
+		delete(inv.items, inv.order[0])
+		inv.order = inv.order[1:]
+	}
+	return true
+}
+
+func (inv *inventory) remove(hash string) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	delete(inv.items, hash)
+}
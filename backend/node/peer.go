@@ -0,0 +1,196 @@
+package node
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// maxMessageSize bounds a single message line
+	maxMessageSize = 32 << 20
+
+	handshakeTimeout = 30 * time.Second
+	pingInterval     = 2 * time.Minute
+	idleTimeout      = 5 * time.Minute
+	writeTimeout     = 30 * time.Second
+	sendQueueSize    = 256
+)
+
+// Peer is a connection to another node
+type Peer struct {
+	conn        net.Conn
+	addr        string
+	inbound     bool
+	connectedAt time.Time
+
+	send      chan Message
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu         sync.Mutex
+	version    *VersionPayload
+	sentVerAck bool
+	gotVerAck  bool
+}
+
+// PeerInfo is the public view of a peer
+type PeerInfo struct {
+	Address         string    `json:"address"`
+	Inbound         bool      `json:"inbound"`
+	NodeID          string    `json:"node_id"`
+	ProtocolVersion int       `json:"protocol_version"`
+	UserAgent       string    `json:"user_agent"`
+	BestHeight      int       `json:"best_height"`
+	Handshaked      bool      `json:"handshaked"`
+	ConnectedAt     time.Time `json:"connected_at"`
+}
+
+func newPeer(conn net.Conn, inbound bool) *Peer {
+	return &Peer{
+		conn:        conn,
+		addr:        conn.RemoteAddr().String(),
+		inbound:     inbound,
+		connectedAt: time.Now(),
+		send:        make(chan Message, sendQueueSize),
+		done:        make(chan struct{}),
+	}
+}
+
+// Send queues a message for the peer. A peer that cannot keep up is disconnected.
+func (p *Peer) Send(msgType string, payload interface{}) error {
+	msg, err := newMessage(msgType, payload)
+	if err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(writeTimeout)
+	defer timer.Stop()
+	select {
+	case p.send <- msg:
+		return nil
+	case <-p.done:
+		return errors.New("peer disconnected")
+	case <-timer.C:
+		p.Close()
+		return errors.New("peer send queue is full")
+	}
+}
+
+// Close disconnects the peer
+func (p *Peer) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.conn.Close()
+	})
+}
+
+// closed reports whether the peer has been disconnected
+func (p *Peer) closed() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Handshaked reports whether both sides have exchanged version and verack
+func (p *Peer) Handshaked() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.version != nil && p.sentVerAck && p.gotVerAck
+}
+
+// Info returns the public view of the peer
+func (p *Peer) Info() PeerInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info := PeerInfo{
+		Address:     p.addr,
+		Inbound:     p.inbound,
+		Handshaked:  p.version != nil && p.sentVerAck && p.gotVerAck,
+		ConnectedAt: p.connectedAt,
+	}
+	if p.version != nil {
+		info.NodeID = p.version.NodeID
+		info.ProtocolVersion = p.version.ProtocolVersion
+		info.UserAgent = p.version.UserAgent
+		info.BestHeight = p.version.BestHeight
+	}
+	return info
+}
+
+// bestHeight is the highest block the peer is known to have
+func (p *Peer) bestHeight() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.version == nil {
+		return -1
+	}
+	return p.version.BestHeight
+}
+
+// noteHeight records that the peer has a block at height
+func (p *Peer) noteHeight(height int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.version != nil && height > p.version.BestHeight {
+		p.version.BestHeight = height
+	}
+}
+
+// writeLoop sends queued messages and keeps the connection alive with pings
+func (p *Peer) writeLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		var msg Message
+		select {
+		case msg = <-p.send:
+		case <-ticker.C:
+			msg = Message{Type: MsgPing}
+		case <-p.done:
+			return
+		}
+
+		data, err := json.Marshal(msg)
+		if err != nil {
+			continue
+		}
+		p.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := p.conn.Write(append(data, '\n')); err != nil {
+			p.Close()
+			return
+		}
+	}
+}
+
+// readLoop reads messages until the connection fails and hands them to handle
+func (p *Peer) readLoop(handle func(*Peer, Message) error) error {
+	scanner := bufio.NewScanner(p.conn)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageSize)
+
+	p.conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	for scanner.Scan() {
+		var msg Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return errors.New("malformed message: " + err.Error())
+		}
+		if err := handle(p, msg); err != nil {
+			return err
+		}
+		if p.Handshaked() {
+			p.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("connection closed")
+}
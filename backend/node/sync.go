@@ -0,0 +1,491 @@
+package node
+
+import (
+	"crypto-wallet/blockchain"
+	"crypto-wallet/db"
+	"crypto-wallet/models"
+	"crypto-wallet/services"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	maxHeadersPerMessage = 2000
+	blocksPerRequest     = 16   // Bodies asked for in one getdata
+	maxBlocksPerPeer     = 64   // Bodies in flight from one peer
+	downloadWindow       = 1024 // How far past the next block to connect bodies may be fetched
+	headersTimeout       = 30 * time.Second
+	blockRequestTimeout  = 60 * time.Second
+	syncCheckInterval    = 10 * time.Second
+)
+
+// SyncStatus reports the progress of chain synchronisation
+type SyncStatus struct {
+	State          string    `json:"state"` // "disabled", "idle", "headers", "blocks", "synced"
+	SyncPeer       string    `json:"sync_peer,omitempty"`
+	Height         int       `json:"height"`        // Tip of the active chain
+	HeaderHeight   int       `json:"header_height"` // Highest validated header
+	TargetHeight   int       `json:"target_height"` // Best height announced by peers
+	StartHeight    int       `json:"start_height"`  // Tip when the current sync began
+	Progress       float64   `json:"progress"`      // Percent of blocks up to HeaderHeight connected
+	BlocksInFlight int       `json:"blocks_in_flight"`
+	Peers          int       `json:"peers"`
+	LastError      string    `json:"last_error,omitempty"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+type headersResponse struct {
+	peer    *Peer
+	headers []models.Block
+}
+
+type blockDelivery struct {
+	peer  *Peer
+	block models.Block
+}
+
+type notFoundResponse struct {
+	peer   *Peer
+	hashes []string
+}
+
+// syncManager downloads headers from the best peer, checks their proof of
+// work, then fetches the block bodies from all peers in parallel and
+// connects them in order
+type syncManager struct {
+	mu         sync.Mutex
+	status     SyncStatus
+	headerPeer *Peer           // Peer a getheaders request is outstanding with
+	wanted     map[string]bool // Block bodies being downloaded
+
+	trigger   chan struct{}
+	headersCh chan headersResponse
+	blocksCh  chan blockDelivery
+	notFound  chan notFoundResponse
+}
+
+var syncer = &syncManager{
+	status:    SyncStatus{State: "disabled"},
+	wanted:    make(map[string]bool),
+	trigger:   make(chan struct{}, 1),
+	headersCh: make(chan headersResponse, 1),
+	blocksCh:  make(chan blockDelivery, maxBlocksPerPeer),
+	notFound:  make(chan notFoundResponse, 8),
+}
+
+// Status returns the current sync progress
+func Status() SyncStatus {
+	syncer.mu.Lock()
+	status := syncer.status
+	syncer.mu.Unlock()
+
+	if tip, err := db.GetLastBlock(); err == nil {
+		status.Height = tip.Index
+	}
+	if status.State != "disabled" {
+		status.Peers = len(handshakedPeers())
+		for _, p := range handshakedPeers() {
+			if h := p.bestHeight(); h > status.TargetHeight {
+				status.TargetHeight = h
+			}
+		}
+	}
+	if status.HeaderHeight < status.Height {
+		status.HeaderHeight = status.Height
+	}
+	status.Progress = 100
+	if span := status.HeaderHeight - status.StartHeight; span > 0 && status.Height < status.HeaderHeight {
+		status.Progress = float64(status.Height-status.StartHeight) * 100 / float64(span)
+	}
+	return status
+}
+
+// syncing reports whether blocks are being downloaded, during which they are not relayed
+func (s *syncManager) syncing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status.State == "blocks"
+}
+
+func (s *syncManager) update(fn func(status *SyncStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.status)
+	s.status.UpdatedAt = time.Now()
+}
+
+// triggerSync asks the sync loop to check peers now
+func triggerSync() {
+	select {
+	case syncer.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// deliverHeaders passes a headers message to the sync loop if it asked this peer
+func (s *syncManager) deliverHeaders(p *Peer, headers []models.Block) {
+	s.mu.Lock()
+	expected := s.headerPeer == p
+	s.mu.Unlock()
+	if !expected {
+		return
+	}
+	select {
+	case s.headersCh <- headersResponse{peer: p, headers: headers}:
+	case <-time.After(headersTimeout):
+	}
+}
+
+// deliverBlock passes a requested block body to the sync loop. It returns
+// false for blocks the sync loop is not downloading.
+func (s *syncManager) deliverBlock(p *Peer, block models.Block) bool {
+	s.mu.Lock()
+	wanted := s.wanted[block.Hash]
+	s.mu.Unlock()
+	if !wanted {
+		return false
+	}
+	select {
+	case s.blocksCh <- blockDelivery{peer: p, block: block}:
+	case <-time.After(blockRequestTimeout):
+	}
+	return true
+}
+
+func (s *syncManager) deliverNotFound(p *Peer, hashes []string) {
+	select {
+	case s.notFound <- notFoundResponse{peer: p, hashes: hashes}:
+	default:
+	}
+}
+
+// syncLoop syncs with the best peer whenever one is ahead of us
+func syncLoop() {
+	syncer.update(func(status *SyncStatus) { status.State = "idle" })
+
+	ticker := time.NewTicker(syncCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-syncer.trigger:
+		case <-ticker.C:
+		}
+
+		tip, err := db.GetLastBlock()
+		if err != nil {
+			continue
+		}
+		peer := bestPeer()
+		if peer == nil || peer.bestHeight() <= tip.Index {
+			syncer.update(func(status *SyncStatus) {
+				if peer != nil {
+					status.State = "synced"
+				} else {
+					status.State = "idle"
+				}
+				status.SyncPeer = ""
+			})
+			continue
+		}
+
+		if err := syncFrom(peer); err != nil {
+			log.Printf("⚠️ Sync with %s stopped: %v", peer.addr, err)
+			syncer.update(func(status *SyncStatus) { status.LastError = err.Error() })
+		} else {
+			syncer.update(func(status *SyncStatus) { status.LastError = "" })
+		}
+	}
+}
+
+// syncFrom downloads the peer's headers past our chain and, if they carry
+// more work than our active chain, the blocks behind them
+func syncFrom(peer *Peer) error {
+	active, err := db.GetAllBlocks()
+	if err != nil {
+		return err
+	}
+	if len(active) == 0 {
+		return errors.New("blockchain is empty")
+	}
+
+	start := active[len(active)-1].Index
+	syncer.update(func(status *SyncStatus) {
+		status.State = "headers"
+		status.SyncPeer = peer.addr
+		status.StartHeight = start
+		status.HeaderHeight = start
+	})
+	log.Printf("🔄 Syncing headers from %s (our height %d, theirs %d)", peer.addr, start, peer.bestHeight())
+
+	headers, forkPos, err := downloadHeaders(peer, active)
+	if err != nil {
+		return err
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+
+	work := blockchain.ChainWork(active[:forkPos+1])
+	work.Add(work, blockchain.ChainWork(headers))
+	if work.Cmp(blockchain.ChainWork(active)) <= 0 {
+		log.Printf("ℹ️ Headers from %s do not have more work than our chain", peer.addr)
+		return nil
+	}
+
+	log.Printf("🔄 Downloading %d block(s) from height %d", len(headers), headers[0].Index)
+	syncer.update(func(status *SyncStatus) { status.State = "blocks" })
+	if err := downloadBlocks(headers); err != nil {
+		return err
+	}
+
+	tip, _ := db.GetLastBlock()
+	log.Printf("✅ Sync complete at height %d", tip.Index)
+	syncer.update(func(status *SyncStatus) { status.State = "synced" })
+	return nil
+}
+
+// downloadHeaders asks peer for headers until it has no more, checking that
+// each links to the previous one and meets the expected target. It returns
+// the headers and the position in active of the block they build on.
+func downloadHeaders(peer *Peer, active []models.Block) ([]models.Block, int, error) {
+	positions := make(map[string]int, len(active))
+	for i, block := range active {
+		positions[block.Hash] = i
+	}
+
+	var headers []models.Block
+	forkPos := -1
+
+	for {
+		locator := blockLocator(active)
+		if len(headers) > 0 {
+			locator = append([]string{headers[len(headers)-1].Hash}, locator...)
+		}
+
+		syncer.mu.Lock()
+		syncer.headerPeer = peer
+		syncer.mu.Unlock()
+
+		if err := peer.Send(MsgGetHeaders, GetHeadersPayload{Locator: locator}); err != nil {
+			return nil, 0, err
+		}
+
+		var batch []models.Block
+		select {
+		case resp := <-syncer.headersCh:
+			batch = resp.headers
+		case <-peer.done:
+			return nil, 0, errors.New("peer disconnected")
+		case <-time.After(headersTimeout):
+			peer.Close()
+			return nil, 0, errors.New("timed out waiting for headers")
+		}
+
+		syncer.mu.Lock()
+		syncer.headerPeer = nil
+		syncer.mu.Unlock()
+
+		for _, header := range batch {
+			var prev models.Block
+			if len(headers) == 0 {
+				pos, ok := positions[header.PrevHash]
+				if !ok {
+					peer.Close()
+					return nil, 0, fmt.Errorf("header %d does not connect to our chain", header.Index)
+				}
+				forkPos = pos
+				prev = active[pos]
+			} else {
+				prev = headers[len(headers)-1]
+			}
+
+			lookup := blockchain.BranchLookup(active[:forkPos+1], headers)
+			if err := blockchain.ValidateHeader(header, prev, lookup); err != nil {
+				peer.Close()
+				return nil, 0, fmt.Errorf("invalid header: %w", err)
+			}
+			headers = append(headers, header)
+		}
+
+		if len(headers) > 0 {
+			height := headers[len(headers)-1].Index
+			syncer.update(func(status *SyncStatus) { status.HeaderHeight = height })
+		}
+		if len(batch) < maxHeadersPerMessage {
+			return headers, forkPos, nil
+		}
+	}
+}
+
+// blockRequest is a block body requested from a peer
+type blockRequest struct {
+	peer *Peer
+	sent time.Time
+}
+
+// downloadBlocks fetches the bodies for headers from every peer that has
+// them, several batches at a time, and connects them in chain order. Bodies
+// from a peer that disconnects or stalls are requested again elsewhere.
+func downloadBlocks(headers []models.Block) error {
+	byHash := make(map[string]models.Block, len(headers))
+	syncer.mu.Lock()
+	for _, header := range headers {
+		byHash[header.Hash] = header
+		syncer.wanted[header.Hash] = true
+	}
+	syncer.mu.Unlock()
+
+	defer func() {
+		syncer.mu.Lock()
+		syncer.wanted = make(map[string]bool)
+		syncer.mu.Unlock()
+		syncer.update(func(status *SyncStatus) { status.BlocksInFlight = 0 })
+	}()
+
+	bodies := make(map[string]models.Block)
+	inFlight := make(map[string]blockRequest)
+	perPeer := make(map[*Peer]int)
+	missing := make(map[string]map[*Peer]bool) // Peers that answered notfound
+	next := 0
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for next < len(headers) {
+		// Hand out requests for the window ahead of the next block to connect
+		peers := handshakedPeers()
+		if len(peers) == 0 {
+			return errors.New("no peers to download blocks from")
+		}
+		end := next + downloadWindow
+		if end > len(headers) {
+			end = len(headers)
+		}
+		for _, p := range peers {
+			for perPeer[p] < maxBlocksPerPeer {
+				var batch []string
+				for _, header := range headers[next:end] {
+					hash := header.Hash
+					if _, ok := bodies[hash]; ok {
+						continue
+					}
+					if _, ok := inFlight[hash]; ok || missing[hash][p] || p.bestHeight() < header.Index {
+						continue
+					}
+					batch = append(batch, hash)
+					if len(batch) == blocksPerRequest {
+						break
+					}
+				}
+				if len(batch) == 0 {
+					break
+				}
+				if err := p.Send(MsgGetData, InventoryPayload{Hashes: batch}); err != nil {
+					break
+				}
+				for _, hash := range batch {
+					inFlight[hash] = blockRequest{peer: p, sent: time.Now()}
+				}
+				perPeer[p] += len(batch)
+			}
+		}
+		syncer.update(func(status *SyncStatus) { status.BlocksInFlight = len(inFlight) })
+
+		select {
+		case d := <-syncer.blocksCh:
+			header, ok := byHash[d.block.Hash]
+			if !ok {
+				continue
+			}
+			if req, ok := inFlight[d.block.Hash]; ok {
+				delete(inFlight, d.block.Hash)
+				perPeer[req.peer]--
+			}
+			if !bodyMatchesHeader(d.block, header) {
+				log.Printf("⚠️ Block %d from %s does not match its header", header.Index, d.peer.addr)
+				d.peer.Close()
+				continue
+			}
+			bodies[d.block.Hash] = d.block
+
+		case nf := <-syncer.notFound:
+			for _, hash := range nf.hashes {
+				if req, ok := inFlight[hash]; ok && req.peer == nf.peer {
+					delete(inFlight, hash)
+					perPeer[nf.peer]--
+				}
+				if missing[hash] == nil {
+					missing[hash] = make(map[*Peer]bool)
+				}
+				missing[hash][nf.peer] = true
+			}
+
+		case <-ticker.C:
+			for hash, req := range inFlight {
+				if req.peer.closed() || time.Since(req.sent) > blockRequestTimeout {
+					delete(inFlight, hash)
+					perPeer[req.peer]--
+				}
+			}
+		}
+
+		// Connect every body we have in chain order
+		for next < len(headers) {
+			block, ok := bodies[headers[next].Hash]
+			if !ok {
+				break
+			}
+			delete(bodies, block.Hash)
+			if _, err := services.AcceptBlock(block, nil); err != nil {
+				return fmt.Errorf("block %d rejected: %w", block.Index, err)
+			}
+			next++
+		}
+
+		// Give up on a block no connected peer has
+		if next < len(headers) {
+			hash := headers[next].Hash
+			if _, ok := inFlight[hash]; !ok && len(missing[hash]) >= len(peers) {
+				return fmt.Errorf("no peer has block %d", headers[next].Index)
+			}
+		}
+	}
+	return nil
+}
+
+// bodyMatchesHeader checks that a downloaded block is the one the header committed to
+func bodyMatchesHeader(block, header models.Block) bool {
+	return block.Index == header.Index &&
+		block.PrevHash == header.PrevHash &&
+		block.MerkleRoot == header.MerkleRoot &&
+		blockchain.CalculateHash(block) == header.Hash &&
+		blockchain.CalculateMerkleRoot(block.Transactions) == header.MerkleRoot
+}
+
+// bestPeer returns the handshaked peer announcing the highest chain
+func bestPeer() *Peer {
+	var best *Peer
+	for _, p := range handshakedPeers() {
+		if best == nil || p.bestHeight() > best.bestHeight() {
+			best = p
+		}
+	}
+	return best
+}
+
+func handshakedPeers() []*Peer {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	var list []*Peer
+	for p := range peers {
+		if p.Handshaked() {
+			list = append(list, p)
+		}
+	}
+	return list
+}
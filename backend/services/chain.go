@@ -0,0 +1,470 @@
+package services
+
+import (
+	"crypto-wallet/blockchain"
+	"crypto-wallet/db"
+	"crypto-wallet/models"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+)
+
+// AcceptResult describes what happened to a block passed to AcceptBlock
+type AcceptResult struct {
+	Status       string `json:"status"` // "connected", "side_chain", "reorganized", "duplicate", "reverted"
+	TipHash      string `json:"tip_hash"`
+	TipIndex     int    `json:"tip_index"`
+	ChainWork    string `json:"chain_work"` // Cumulative work of the active chain (hex)
+	Disconnected int    `json:"disconnected,omitempty"`
+	Connected    int    `json:"connected,omitempty"`
+}
+
+// ErrOrphanBlock is returned for a block whose parent is not known
+var ErrOrphanBlock = errors.New("block's parent is not known")
+
+// chainMu serialises changes to the active chain
+var chainMu sync.Mutex
+
+// blockListeners are notified of every block added to the block tree
+var (
+	blockListenersMu sync.RWMutex
+	blockListeners   []func(block models.Block, result *AcceptResult)
+)
+
+// OnBlockAccepted registers fn to be called after a new block is connected,
+// stored on a side branch or causes a reorganization
+func OnBlockAccepted(fn func(block models.Block, result *AcceptResult)) {
+	blockListenersMu.Lock()
+	defer blockListenersMu.Unlock()
+	blockListeners = append(blockListeners, fn)
+}
+
+// AcceptBlock adds a block to the block tree. A block extending the tip is
+// fully validated and connected. A block on another branch is stored as a
+// side block, and if its branch has more cumulative work than the active
+// chain the node reorganizes onto it.
+func AcceptBlock(block models.Block, zakatRecords []models.ZakatRecord) (*AcceptResult, error) {
+	result, err := acceptBlock(block, zakatRecords)
+	if err != nil || result.Status == "duplicate" {
+		return result, err
+	}
+
+	notifyBlockListeners(block, result)
+	return result, nil
+}
+
+// notifyBlockListeners reports a change to the block tree. Listeners run
+// outside the chain lock so they may read the chain.
+func notifyBlockListeners(block models.Block, result *AcceptResult) {
+	blockListenersMu.RLock()
+	listeners := blockListeners
+	blockListenersMu.RUnlock()
+	for _, fn := range listeners {
+		fn(block, result)
+	}
+}
+
+func acceptBlock(block models.Block, zakatRecords []models.ZakatRecord) (*AcceptResult, error) {
+	chainMu.Lock()
+	defer chainMu.Unlock()
+
+	if _, err := db.GetBlockByHash(block.Hash); err == nil {
+		return tipResult("duplicate")
+	}
+	if _, err := db.GetSideBlock(block.Hash); err == nil {
+		return tipResult("duplicate")
+	}
+
+	// Checks that do not depend on the block's position in the tree
+	if blockchain.CalculateHash(block) != block.Hash {
+		return nil, &blockchain.BlockValidationError{BlockIndex: block.Index, BlockHash: block.Hash, Reason: "Hash mismatch - block has been tampered with"}
+	}
+	if !blockchain.MeetsProofOfWork(block) {
+		return nil, &blockchain.BlockValidationError{BlockIndex: block.Index, BlockHash: block.Hash, Reason: "Proof of work validation failed"}
+	}
+	if blockchain.CalculateMerkleRoot(block.Transactions) != block.MerkleRoot {
+		return nil, &blockchain.BlockValidationError{BlockIndex: block.Index, BlockHash: block.Hash, Reason: "Merkle root mismatch - transactions have been tampered with"}
+	}
+
+	tip, err := db.GetLastBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// Common case: the block extends the active chain
+	if block.PrevHash == tip.Hash {
+		if verr := blockchain.ValidateBlockWithState(block, *tip, blockchain.LookupStoredBlock, blockchain.NewStoredUTXOView()); verr != nil {
+			return nil, verr
+		}
+		if err := ConnectBlock(block, zakatRecords); err != nil {
+			return nil, err
+		}
+		return tipResult("connected")
+	}
+
+	active, err := db.GetAllBlocks()
+	if err != nil {
+		return nil, err
+	}
+	branch, forkPos, err := findBranch(block.PrevHash, active)
+	if err != nil {
+		return nil, err
+	}
+
+	parent := active[forkPos]
+	if len(branch) > 0 {
+		parent = branch[len(branch)-1].Block
+	}
+	branchBlocks := make([]models.Block, 0, len(branch)+1)
+	for _, side := range branch {
+		branchBlocks = append(branchBlocks, side.Block)
+	}
+	branchBlocks = append(branchBlocks, block)
+
+	// Transactions on a side branch are validated when it becomes active
+	if verr := blockchain.CheckBlockHeader(block, parent, blockchain.BranchLookup(active[:forkPos+1], branchBlocks)); verr != nil {
+		return nil, verr
+	}
+
+	work := blockchain.ChainWork(active[:forkPos+1])
+	work.Add(work, blockchain.ChainWork(branchBlocks))
+
+	side := models.SideBlock{
+		Block:        block,
+		ChainWork:    work.Text(16),
+		ZakatRecords: zakatRecords,
+	}
+	if err := db.SaveSideBlock(&side); err != nil {
+		return nil, err
+	}
+
+	if work.Cmp(blockchain.ChainWork(active)) <= 0 {
+		log.Printf("🌿 Side block %d stored (%s)", block.Index, block.Hash)
+		LogSystemEvent("side_block_received", "", map[string]interface{}{
+			"block_index": block.Index,
+			"block_hash":  block.Hash,
+			"fork_index":  active[forkPos].Index,
+			"chain_work":  side.ChainWork,
+		}, "info")
+		return tipResult("side_chain")
+	}
+
+	return reorganize(active, forkPos, append(branch, side))
+}
+
+// findBranch walks back from a block's parent through side blocks until it
+// reaches the active chain. It returns the side blocks from the fork point
+// upward and the position of the fork block in active.
+func findBranch(prevHash string, active []models.Block) ([]models.SideBlock, int, error) {
+	positions := make(map[string]int, len(active))
+	for i, block := range active {
+		positions[block.Hash] = i
+	}
+
+	var branch []models.SideBlock
+	hash := prevHash
+	for {
+		if pos, ok := positions[hash]; ok {
+			for i, j := 0, len(branch)-1; i < j; i, j = i+1, j-1 {
+				branch[i], branch[j] = branch[j], branch[i]
+			}
+			return branch, pos, nil
+		}
+		side, err := db.GetSideBlock(hash)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: %s", ErrOrphanBlock, hash)
+		}
+		branch = append(branch, *side)
+		hash = side.Block.PrevHash
+	}
+}
+
+// reorganize switches the active chain to branch, which forks off after
+// active[forkPos]. The disconnected blocks are kept as side blocks. If a
+// branch block turns out to be invalid, it and its descendants are dropped
+// and the original chain is restored.
+func reorganize(active []models.Block, forkPos int, branch []models.SideBlock) (*AcceptResult, error) {
+	oldTip := active[len(active)-1]
+	disconnected := active[forkPos+1:]
+
+	log.Printf("🔀 Reorganizing: fork at block %d, %d block(s) out, %d block(s) in", active[forkPos].Index, len(disconnected), len(branch))
+
+	// Keep the old branch so it can become active again
+	oldBranch := make([]models.SideBlock, 0, len(disconnected))
+	work := blockchain.ChainWork(active[:forkPos+1])
+	for _, block := range disconnected {
+		work.Add(work, blockchain.BlockWork(block))
+		side := models.SideBlock{Block: block, ChainWork: work.Text(16)}
+		if undo, err := db.GetBlockUndo(block.Hash); err == nil {
+			side.ZakatRecords = undo.ZakatRecords
+		}
+		if err := db.SaveSideBlock(&side); err != nil {
+			return nil, err
+		}
+		oldBranch = append(oldBranch, side)
+	}
+
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		if err := DisconnectBlock(disconnected[i]); err != nil {
+			restoreBranch(active[forkPos].Index, oldBranch)
+			return nil, fmt.Errorf("failed to disconnect block %d: %w", disconnected[i].Index, err)
+		}
+	}
+
+	for i, side := range branch {
+		prev := active[forkPos]
+		if i > 0 {
+			prev = branch[i-1].Block
+		}
+
+		var failure error
+		if verr := blockchain.ValidateBlockWithState(side.Block, prev, blockchain.LookupStoredBlock, blockchain.NewStoredUTXOView()); verr != nil {
+			failure = verr
+		} else if err := ConnectBlock(side.Block, side.ZakatRecords); err != nil {
+			failure = err
+		}
+
+		if failure != nil {
+			dropSideBranch(side.Hash)
+			restoreBranch(active[forkPos].Index, oldBranch)
+			LogSystemEvent("chain_reorganization_failed", "", map[string]interface{}{
+				"fork_index":  active[forkPos].Index,
+				"block_index": side.Block.Index,
+				"block_hash":  side.Hash,
+				"error":       failure.Error(),
+			}, "error")
+			return nil, failure
+		}
+	}
+
+	for _, side := range branch {
+		db.DeleteSideBlock(side.Hash)
+	}
+
+	dropped, err := RevalidateMempool()
+	if err != nil {
+		log.Printf("⚠️ Failed to revalidate pending transactions after reorganization: %v", err)
+	}
+
+	newTip := branch[len(branch)-1].Block
+	LogSystemEvent("chain_reorganization", "", map[string]interface{}{
+		"fork_index":          active[forkPos].Index,
+		"old_tip_hash":        oldTip.Hash,
+		"old_tip_index":       oldTip.Index,
+		"new_tip_hash":        newTip.Hash,
+		"new_tip_index":       newTip.Index,
+		"disconnected_blocks": len(disconnected),
+		"connected_blocks":    len(branch),
+		"dropped_pending_txs": dropped,
+	}, "warning")
+
+	result, err := tipResult("reorganized")
+	if err != nil {
+		return nil, err
+	}
+	result.Disconnected = len(disconnected)
+	result.Connected = len(branch)
+	return result, nil
+}
+
+// restoreBranch rolls the active chain back to the fork block and reconnects
+// the given blocks. It is used to undo a failed reorganization.
+func restoreBranch(forkIndex int, branch []models.SideBlock) {
+	for {
+		tip, err := db.GetLastBlock()
+		if err != nil || tip.Index <= forkIndex {
+			break
+		}
+		if err := DisconnectBlock(*tip); err != nil {
+			log.Printf("❌ Failed to disconnect block %d while restoring chain: %v", tip.Index, err)
+			return
+		}
+	}
+
+	for _, side := range branch {
+		if err := ConnectBlock(side.Block, side.ZakatRecords); err != nil {
+			log.Printf("❌ Failed to reconnect block %d while restoring chain: %v", side.Block.Index, err)
+			LogSystemEvent("chain_restore_failed", "", map[string]interface{}{
+				"block_index": side.Block.Index,
+				"block_hash":  side.Hash,
+				"error":       err.Error(),
+			}, "critical")
+			return
+		}
+		db.DeleteSideBlock(side.Hash)
+	}
+}
+
+// dropSideBranch removes an invalid side block and every side block built on it
+func dropSideBranch(hash string) {
+	invalid := map[string]bool{hash: true}
+	db.DeleteSideBlock(hash)
+
+	sides, err := db.GetSideBlocks()
+	if err != nil {
+		return
+	}
+	// Side blocks are ordered by height, so parents are seen before children
+	for _, side := range sides {
+		if invalid[side.Block.PrevHash] {
+			invalid[side.Hash] = true
+			db.DeleteSideBlock(side.Hash)
+		}
+	}
+}
+
+// ChainRevert describes a rollback of the active chain past its first
+// invalid block
+type ChainRevert struct {
+	Blocks               int                              `json:"blocks"`
+	Invalid              *blockchain.BlockValidationError `json:"details,omitempty"` // nil if the chain is valid
+	ProblematicIndex     int                              `json:"problematic_index"`
+	RevertedBlocks       int                              `json:"reverted_blocks"`
+	RevertedTransactions int                              `json:"reverted_transactions"`
+	RestoredToPending    int                              `json:"restored_to_pending"`
+	JournaledBlocks      int                              `json:"journaled_blocks"`
+}
+
+// RevertInvalidBlocks validates the active chain and disconnects every block
+// from the tip down to the first invalid one. The blocks above the invalid
+// one are kept as side blocks, like those of a reorganization, so they can
+// be reconnected if a valid copy of it arrives. The invalid block itself is
+// dropped, since it would shadow that copy. Block listeners are told about
+// the new tip, so mining moves off the reverted blocks.
+func RevertInvalidBlocks() (*ChainRevert, error) {
+	report, result, err := revertInvalidBlocks()
+	if err != nil || result == nil {
+		return report, err
+	}
+
+	tip, err := db.GetBlockByHash(result.TipHash)
+	if err != nil {
+		return report, nil
+	}
+	notifyBlockListeners(*tip, result)
+	return report, nil
+}
+
+// revertInvalidBlocks does the work of RevertInvalidBlocks under the chain
+// lock. The result describes the new tip, or is nil if nothing was reverted.
+func revertInvalidBlocks() (*ChainRevert, *AcceptResult, error) {
+	chainMu.Lock()
+	defer chainMu.Unlock()
+
+	active, err := db.GetAllBlocks()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, problematicIndex, verr := blockchain.ValidateChainWithDetails(active)
+	report := &ChainRevert{Blocks: len(active), Invalid: verr, ProblematicIndex: problematicIndex}
+	// A valid or empty chain has nothing to revert
+	if verr == nil || problematicIndex < 0 {
+		return report, nil, nil
+	}
+	// Reverting cannot replace a foreign genesis; the chain must be rebuilt
+	if problematicIndex == 0 {
+		return nil, nil, fmt.Errorf("genesis block is invalid and cannot be reverted: %w", verr)
+	}
+
+	disconnected := active[problematicIndex:]
+	work := blockchain.ChainWork(active[:problematicIndex])
+	for i, block := range disconnected {
+		work.Add(work, blockchain.BlockWork(block))
+		// Blocks with an undo journal are restored exactly; older ones are reversed from their transactions
+		undo, err := db.GetBlockUndo(block.Hash)
+		journaled := err == nil
+		if journaled {
+			report.JournaledBlocks++
+		}
+		if i == 0 {
+			continue
+		}
+		side := models.SideBlock{Block: block, ChainWork: work.Text(16)}
+		if journaled {
+			side.ZakatRecords = undo.ZakatRecords
+		}
+		if err := db.SaveSideBlock(&side); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		block := disconnected[i]
+		if err := DisconnectBlock(block); err != nil {
+			return nil, nil, fmt.Errorf("failed to disconnect block %d: %w", block.Index, err)
+		}
+		report.RevertedBlocks++
+		report.RevertedTransactions += len(block.Transactions)
+		for _, tx := range block.Transactions {
+			if len(tx.Vin) > 0 {
+				report.RestoredToPending++
+			}
+		}
+	}
+
+	LogSystemEvent("blockchain_revert", "", map[string]interface{}{
+		"reverted_blocks":       report.RevertedBlocks,
+		"problematic_index":     problematicIndex,
+		"reason":                verr.Error(),
+		"reverted_transactions": report.RevertedTransactions,
+		"journaled_blocks":      report.JournaledBlocks,
+	}, "warning")
+
+	// The revert has committed even if the new tip cannot be reported
+	result, _ := tipResult("reverted")
+	return report, result, nil
+}
+
+// tipResult reports the current tip and the cumulative work of the active chain
+func tipResult(status string) (*AcceptResult, error) {
+	tip, err := db.GetLastBlock()
+	if err != nil {
+		return nil, err
+	}
+	work, err := activeChainWork(*tip)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AcceptResult{
+		Status:    status,
+		TipHash:   tip.Hash,
+		TipIndex:  tip.Index,
+		ChainWork: work.Text(16),
+	}, nil
+}
+
+// activeWork caches the cumulative work of the active chain at a tip, so
+// connecting a block does not need to reload the whole chain
+var activeWork struct {
+	sync.Mutex
+	tipHash string
+	work    *big.Int
+}
+
+// activeChainWork returns the cumulative work of the active chain ending at tip
+func activeChainWork(tip models.Block) (*big.Int, error) {
+	activeWork.Lock()
+	defer activeWork.Unlock()
+
+	switch {
+	case activeWork.work != nil && activeWork.tipHash == tip.Hash:
+	case activeWork.work != nil && activeWork.tipHash == tip.PrevHash:
+		activeWork.work = new(big.Int).Add(activeWork.work, blockchain.BlockWork(tip))
+	default:
+		blocks, err := db.GetAllBlocks()
+		if err != nil {
+			return nil, err
+		}
+		if len(blocks) == 0 {
+			return nil, errors.New("blockchain is empty")
+		}
+		activeWork.work = blockchain.ChainWork(blocks)
+		tip = blocks[len(blocks)-1]
+	}
+	activeWork.tipHash = tip.Hash
+	return new(big.Int).Set(activeWork.work), nil
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"crypto-wallet/db"
+	"crypto-wallet/models"
+	"testing"
+)
+
+func TestConnectAndDisconnectBlock(t *testing.T) {
+	alice := newTestChain(t)
+	reward := matureReward(t, alice)
+	tx := alice.spend(t, reward, "bob", 10*models.Coin, models.Coin)
+	block := mineBlock(t, "miner", tx)
+
+	if spent, err := db.GetUTXO(reward.TxID, reward.Vout); err != nil || !spent.IsSpent {
+		t.Fatalf("spent reward = %+v, %v", spent, err)
+	}
+	if _, err := db.GetUTXO(tx.ID, 0); err != nil {
+		t.Fatalf("transfer output missing: %v", err)
+	}
+	expectConsistent(t)
+
+	if err := DisconnectBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	tip, err := db.GetLastBlock()
+	if err != nil || tip.Hash != block.PrevHash {
+		t.Fatalf("tip after rollback = %+v, %v", tip, err)
+	}
+	if restored, err := db.GetUTXO(reward.TxID, reward.Vout); err != nil || restored.IsSpent || !restored.IsLocked {
+		t.Fatalf("restored reward = %+v, %v", restored, err)
+	}
+	if _, err := db.GetUTXO(tx.ID, 0); err == nil {
+		t.Fatal("transfer output survived the rollback")
+	}
+	pending, err := db.GetPendingTransactions()
+	if err != nil || len(pending) != 1 || pending[0].ID != tx.ID {
+		t.Fatalf("pending after rollback = %+v, %v", pending, err)
+	}
+	expectConsistent(t)
+}
+
+func TestRevertInvalidBlocks(t *testing.T) {
+	newTestChain(t)
+	for i := 0; i < 4; i++ {
+		mineBlock(t, "miner")
+	}
+	blocks, err := db.GetAllBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tamper with block 2's transactions, leaving its header unchanged
+	tampered := blocks[2]
+	tampered.Transactions = append([]models.Transaction(nil), tampered.Transactions...)
+	tampered.Transactions[0].Note = "tampered"
+	if err := db.DeleteBlocksFromIndex(2); err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range append([]models.Block{tampered}, blocks[3:]...) {
+		if err := db.InsertBlock(&block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Miners and templates follow the tip through the block listeners
+	var reverted []*AcceptResult
+	OnBlockAccepted(func(block models.Block, result *AcceptResult) {
+		if result.Status == "reverted" {
+			reverted = append(reverted, result)
+		}
+	})
+
+	report, err := RevertInvalidBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Invalid == nil || report.ProblematicIndex != 2 || report.RevertedBlocks != 3 {
+		t.Fatalf("revert = %+v", report)
+	}
+	tip, err := db.GetLastBlock()
+	if err != nil || tip.Index != 1 {
+		t.Fatalf("tip after revert = %+v, %v", tip, err)
+	}
+	if len(reverted) != 1 || reverted[0].TipHash != tip.Hash {
+		t.Fatalf("listeners saw %+v, want one revert to %s", reverted, tip.Hash)
+	}
+	// The invalid block is dropped, its descendants kept for a valid copy
+	sides, err := db.GetSideBlocks()
+	if err != nil || len(sides) != 2 {
+		t.Fatalf("side blocks = %d, %v, want 2", len(sides), err)
+	}
+
+	if report, err := RevertInvalidBlocks(); err != nil || report.Invalid != nil || report.RevertedBlocks != 0 {
+		t.Fatalf("second revert = %+v, %v", report, err)
+	}
+	if len(reverted) != 1 {
+		t.Fatalf("listeners saw %d reverts, want 1", len(reverted))
+	}
+	mineBlock(t, "miner")
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"crypto-wallet/db"
+	"crypto-wallet/keymanager"
+	"crypto-wallet/models"
+	"errors"
+)
+
+// KeyRotationReport summarizes a master key rotation
+type KeyRotationReport struct {
+	MasterKeyID string   `json:"master_key_id"`
+	Rewrapped   int      `json:"rewrapped"`
+	Current     int      `json:"current"` // Already under the active master key
+	Failed      []string `json:"failed,omitempty"`
+}
+
+// EnableCustody unlocks a user's private key with their passphrase and hands
+// it to the key manager, so transfers can be signed without sending the key
+func EnableCustody(user *models.User, passphrase string) error {
+	if user.Custody != nil {
+		return errors.New("wallet is already in custody")
+	}
+	privateKey, err := unlockPrivateKey(user, passphrase)
+	if err != nil {
+		return err
+	}
+
+	custody, err := keymanager.Seal(privateKey, user.WalletID)
+	if err != nil {
+		return err
+	}
+	if err := db.UpdateUser(user.Email, map[string]interface{}{"custody": custody}); err != nil {
+		return err
+	}
+	user.Custody = custody
+
+	LogSystemEvent("custody_enabled", user.ID, map[string]interface{}{
+		"master_key_id": custody.MasterKeyID,
+	}, "info")
+	return nil
+}
+
+// DisableCustody deletes the key manager's copy of a user's private key.
+// The passphrase keystore is unaffected.
+func DisableCustody(user *models.User) error {
+	if user.Custody == nil {
+		return errors.New("wallet is not in custody")
+	}
+	if err := db.UpdateUser(user.Email, map[string]interface{}{"custody": nil}); err != nil {
+		return err
+	}
+	user.Custody = nil
+
+	LogSystemEvent("custody_disabled", user.ID, nil, "info")
+	return nil
+}
+
+// RotateMasterKey makes a new master key active and rewraps every
+// custodial data key under it
+func RotateMasterKey() (*KeyRotationReport, error) {
+	keyID, err := keymanager.RotateMasterKey()
+	if err != nil {
+		return nil, err
+	}
+	LogSystemEvent("master_key_rotated", "", map[string]interface{}{"master_key_id": keyID}, "info")
+	return RewrapCustodyKeys()
+}
+
+// RewrapCustodyKeys wraps the data key of every custodial user under the
+// active master key. Private keys and wallets do not change. It can be run
+// again to finish a rotation that was interrupted.
+func RewrapCustodyKeys() (*KeyRotationReport, error) {
+	users, err := db.GetAllUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &KeyRotationReport{MasterKeyID: keymanager.ActiveKeyID()}
+	for _, user := range users {
+		if user.Custody == nil {
+			continue
+		}
+		rewrapped, err := keymanager.Rewrap(user.Custody)
+		if err == nil && rewrapped {
+			err = db.UpdateUser(user.Email, map[string]interface{}{"custody": user.Custody})
+		}
+		switch {
+		case err != nil:
+			report.Failed = append(report.Failed, user.ID)
+			LogSystemEvent("custody_rewrap_failed", user.ID, map[string]interface{}{"error": err.Error()}, "error")
+		case rewrapped:
+			report.Rewrapped++
+		default:
+			report.Current++
+		}
+	}
+
+	LogSystemEvent("custody_keys_rewrapped", "", map[string]interface{}{
+		"rewrapped": report.Rewrapped,
+		"failed":    len(report.Failed),
+	}, "info")
+	return report, nil
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"crypto-wallet/config"
+	"crypto-wallet/crypto"
+	"crypto-wallet/db"
+	"crypto-wallet/keymanager"
+	"path/filepath"
+	"testing"
+)
+
+func TestCustodyRefusesExportAndSurvivesRotation(t *testing.T) {
+	alice := newTestChain(t)
+	config.AppConfig.KeystoreKDF = crypto.KDFArgon2id
+	config.AppConfig.KeystoreArgon2Time = 1
+	config.AppConfig.KeystoreArgon2Memory = 1024
+	config.AppConfig.KeystoreArgon2Threads = 1
+	m, err := keymanager.NewLocalKeyManager(filepath.Join(t.TempDir(), "keyring.json"), "test secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keymanager.SetKeyManager(m)
+
+	user, err := db.GetUserByEmail("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storePrivateKey(user, alice.key, "alice passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableCustody(user, "alice passphrase"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The key of a wallet in custody is only used through the key manager
+	if _, err := ExportPrivateKey(user, "alice passphrase"); err != ErrCustodialExport {
+		t.Fatalf("export in custody: %v", err)
+	}
+
+	report, err := RotateMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Rewrapped != 1 || len(report.Failed) != 0 || report.MasterKeyID != m.ActiveKeyID() {
+		t.Fatalf("rotation = %+v", report)
+	}
+	user, err = db.GetUserByEmail("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Custody == nil || user.Custody.MasterKeyID != m.ActiveKeyID() {
+		t.Fatalf("custody after rotation = %+v", user.Custody)
+	}
+	signer, err := keymanager.CustodySigner(user)
+	if err != nil || signer.WalletID() != alice.id {
+		t.Fatalf("custody signer = %v, %v", signer, err)
+	}
+
+	if err := DisableCustody(user); err != nil {
+		t.Fatal(err)
+	}
+	if key, err := ExportPrivateKey(user, "alice passphrase"); err != nil || key != alice.key {
+		t.Fatalf("export after custody = %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"crypto-wallet/blockchain"
+	"crypto-wallet/config"
+	"crypto-wallet/crypto"
+	"crypto-wallet/db"
+	"crypto-wallet/models"
+	"errors"
+	"fmt"
+)
+
+// hdAccount is the BIP44 account every user's HD wallets are derived from
+const hdAccount = 0
+
+// HDWallet is a receiving wallet derived from a user's HD account
+type HDWallet struct {
+	WalletID       string        `json:"wallet_id"`
+	Address        string        `json:"address"`
+	PublicKey      string        `json:"public_key"`
+	DerivationPath string        `json:"derivation_path"`
+	Index          uint32        `json:"index"`
+	Used           bool          `json:"used"`
+	Balance        models.Amount `json:"balance"`
+}
+
+// HDScan lists the wallets of an HD account found on chain and the next
+// unused wallet to receive with
+type HDScan struct {
+	AccountKey  string     `json:"account_key"`
+	GapLimit    int        `json:"gap_limit"`
+	Wallets     []HDWallet `json:"wallets"`
+	NextReceive HDWallet   `json:"next_receive"`
+}
+
+// usedWalletIDs returns every wallet that has sent or received coins on the
+// active chain or in the pending pool
+func usedWalletIDs() (map[string]bool, error) {
+	used := make(map[string]bool)
+	mark := func(tx models.Transaction) {
+		used[tx.SenderID] = true
+		for _, output := range tx.Vout {
+			used[output.PubKeyHash] = true
+		}
+	}
+
+	blocks, err := db.GetAllBlocks()
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			mark(tx)
+		}
+	}
+
+	pending, err := db.GetPendingTransactions()
+	if err != nil {
+		return nil, err
+	}
+	for _, ptx := range pending {
+		mark(ptx.Transaction)
+	}
+	return used, nil
+}
+
+// ScanHDAccount derives the receiving wallets of an account key in index
+// order until gapLimit wallets in a row have never been used, which is how
+// BIP44 wallets find their funds when restored from a seed
+func ScanHDAccount(accountKey *crypto.HDKey, gapLimit int) (*HDScan, error) {
+	if gapLimit < 1 {
+		gapLimit = 1
+	}
+
+	used, err := usedWalletIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	scan := &HDScan{
+		AccountKey: accountKey.Neuter().String(),
+		GapLimit:   gapLimit,
+		Wallets:    []HDWallet{},
+	}
+	foundNext := false
+	for index, gap := uint32(0), 0; gap < gapLimit; index++ {
+		key, err := crypto.DeriveWallet(accountKey, crypto.HDExternalChain, index)
+		if err != nil {
+			// BIP32 skips the rare index that yields no valid key
+			continue
+		}
+
+		wallet := HDWallet{
+			WalletID:       key.WalletID(),
+			Address:        blockchain.WalletAddress(key.WalletID()),
+			PublicKey:      key.PublicKey().String(),
+			DerivationPath: crypto.HDWalletPath(hdAccount, crypto.HDExternalChain, index),
+			Index:          index,
+		}
+		if !used[wallet.WalletID] {
+			if !foundNext {
+				scan.NextReceive = wallet
+				foundNext = true
+			}
+			gap++
+			continue
+		}
+
+		wallet.Used = true
+		if wallet.Balance, err = blockchain.GetBalance(wallet.WalletID); err != nil {
+			return nil, err
+		}
+		scan.Wallets = append(scan.Wallets, wallet)
+		gap = 0
+	}
+
+	return scan, nil
+}
+
+// RegisterHDAccount scans the chain for the wallets of a user's HD account,
+// registers the used ones and the next receiving wallet so they can receive
+// and spend, and stores the account's extended public key on the user
+func RegisterHDAccount(user *models.User, accountKey *crypto.HDKey) (*HDScan, error) {
+	if accountKey.Depth() != 3 {
+		return nil, fmt.Errorf("account key must be derived at %s", crypto.HDAccountPath(hdAccount))
+	}
+
+	scan, err := ScanHDAccount(accountKey, config.AppConfig.HDGapLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, wallet := range append(scan.Wallets, scan.NextReceive) {
+		if err := registerHDWallet(user.ID, wallet); err != nil {
+			return nil, err
+		}
+	}
+
+	if user.HDAccountKey != scan.AccountKey {
+		if err := db.UpdateUser(user.Email, map[string]interface{}{"hd_account_key": scan.AccountKey}); err != nil {
+			return nil, err
+		}
+		user.HDAccountKey = scan.AccountKey
+	}
+
+	LogSystemEvent("hd_wallets_scanned", user.ID, map[string]interface{}{
+		"used_wallets": len(scan.Wallets),
+		"next_receive": scan.NextReceive.WalletID,
+	}, "info")
+
+	return scan, nil
+}
+
+// registerHDWallet adds a derived wallet to the wallets collection unless
+// it is already there
+func registerHDWallet(userID string, wallet HDWallet) error {
+	existing, err := db.GetWallet(wallet.WalletID)
+	if err == nil {
+		if existing.UserID != userID {
+			return fmt.Errorf("wallet %s belongs to another account", wallet.WalletID)
+		}
+		return nil
+	}
+	if !errors.Is(err, db.ErrNotFound) {
+		return err
+	}
+
+	return db.CreateWallet(&models.Wallet{
+		WalletID:       wallet.WalletID,
+		UserID:         userID,
+		PublicKey:      wallet.PublicKey,
+		DerivationPath: wallet.DerivationPath,
+	})
+}
+
+// OwnsWallet reports whether a wallet, primary or HD, belongs to a user
+func OwnsWallet(userID, walletID string) bool {
+	wallet, err := db.GetWallet(walletID)
+	return err == nil && wallet.UserID == userID
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"crypto-wallet/blockchain"
+	"crypto-wallet/config"
+	"crypto-wallet/crypto"
+	"crypto-wallet/db"
+	"crypto-wallet/models"
+	"testing"
+)
+
+// testWallet is a user and wallet with a signing key
+type testWallet struct {
+	id     string
+	key    string
+	pubKey string
+}
+
+// newTestChain starts an in-memory dev chain with a wallet for alice
+func newTestChain(t *testing.T) testWallet {
+	t.Helper()
+	config.AppConfig = &config.Config{
+		StorageBackend:   "memory",
+		Network:          "dev",
+		POWDifficulty:    2,
+		MiningReward:     50 * models.Coin,
+		CoinbaseMaturity: 2,
+		TargetBlockTime:  60,
+		RetargetInterval: 10,
+	}
+	db.SetStore(db.NewMemoryStore())
+	if err := blockchain.InitializeGenesisBlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	priv, pub, err := crypto.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := crypto.PublicKeyToString(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := testWallet{id: crypto.GenerateWalletID(pubKey), key: crypto.PrivateKeyToString(priv), pubKey: pubKey}
+	if err := db.CreateUser(&models.User{ID: "alice", Email: "alice@example.com", WalletID: alice.id, PublicKey: pubKey}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateWallet(&models.Wallet{WalletID: alice.id, UserID: "alice", PublicKey: pubKey}); err != nil {
+		t.Fatal(err)
+	}
+	return alice
+}
+
+// mineBlock connects a block with txs whose coinbase pays walletID
+func mineBlock(t *testing.T, walletID string, txs ...models.Transaction) models.Block {
+	t.Helper()
+	parent, err := db.GetLastBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := buildBlock(t, *parent, walletID, txs...)
+
+	result, err := AcceptBlock(block, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != "connected" {
+		t.Fatalf("block %d was %s, want connected", block.Index, result.Status)
+	}
+	return block
+}
+
+// buildBlock mines a block on top of parent with txs and a coinbase paying
+// walletID, without adding it to the block tree
+func buildBlock(t *testing.T, parent models.Block, walletID string, txs ...models.Transaction) models.Block {
+	t.Helper()
+	var fees models.Amount
+	for _, tx := range txs {
+		fees += tx.Fee
+	}
+	reward := blockchain.BlockSubsidy(parent.Index+1) + fees
+	coinbase := models.Transaction{
+		Version:    blockchain.TransactionVersion,
+		Type:       "mining_reward",
+		SenderID:   "coinbase",
+		ReceiverID: walletID,
+		Amount:     reward,
+		Timestamp:  int64(parent.Index + 1),
+		Vout:       []models.TXOutput{{Value: reward, PubKeyHash: walletID}},
+	}
+	coinbase.ID = blockchain.TransactionID(coinbase)
+	txs = append(txs, coinbase)
+
+	bits, err := blockchain.NextBits(parent, blockchain.LookupStoredBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := models.Block{
+		Version:      blockchain.BlockVersion,
+		Index:        parent.Index + 1,
+		Timestamp:    parent.Timestamp + 60,
+		PrevHash:     parent.Hash,
+		Transactions: txs,
+		Bits:         bits,
+		MerkleRoot:   blockchain.CalculateMerkleRoot(txs),
+	}
+	blockchain.RunProofOfWork(&block)
+	return block
+}
+
+// spend signs a transfer of amount from the output to receiver, paying fee
+// and returning the change
+func (w testWallet) spend(t *testing.T, utxo models.UTXO, receiver string, amount, fee models.Amount) models.Transaction {
+	t.Helper()
+	tx := models.Transaction{
+		Version:    blockchain.TransactionVersion,
+		Type:       "transfer",
+		SenderID:   w.id,
+		ReceiverID: receiver,
+		Amount:     amount,
+		Fee:        fee,
+		Timestamp:  1,
+		Vin:        []models.TXInput{{TxID: utxo.TxID, Vout: utxo.Vout, PubKey: w.pubKey}},
+		Vout:       []models.TXOutput{{Value: amount, PubKeyHash: receiver}},
+	}
+	if change := utxo.Amount - amount - fee; change > 0 {
+		tx.Vout = append(tx.Vout, models.TXOutput{Value: change, PubKeyHash: w.id})
+	}
+	signature, err := crypto.SignData(blockchain.InputSignatureHash(tx, 0, utxo), w.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Vin[0].Signature = signature
+	tx.ID = blockchain.TransactionID(tx)
+	return tx
+}
+
+// matureReward mines a reward to w and enough blocks on top to spend it
+func matureReward(t *testing.T, w testWallet) models.UTXO {
+	t.Helper()
+	block := mineBlock(t, w.id)
+	for i := 1; i < config.AppConfig.CoinbaseMaturity; i++ {
+		mineBlock(t, "miner")
+	}
+	utxo, err := db.GetUTXO(block.Transactions[0].ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *utxo
+}
+
+// expectConsistent fails if a dry-run reindex finds the stored state differs
+// from a replay of the chain
+func expectConsistent(t *testing.T) {
+	t.Helper()
+	report, err := ReindexUTXOs(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Discrepancies) > 0 {
+		t.Fatalf("reindex found discrepancies: %+v", report.Discrepancies)
+	}
+}
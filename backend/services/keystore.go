@@ -0,0 +1,223 @@
+package services
+
+import (
+	"crypto-wallet/config"
+	"crypto-wallet/crypto"
+	"crypto-wallet/db"
+	"crypto-wallet/models"
+	"errors"
+	"fmt"
+)
+
+// ErrLegacyKeystore is returned for keys still stored in the format that
+// predates passphrase keystores
+var ErrLegacyKeystore = errors.New("private key has no passphrase yet, log in with a passphrase to set one")
+
+// ErrCustodialExport is returned when the private key of a wallet in custody
+// is asked for
+var ErrCustodialExport = errors.New("private key of a wallet in custody cannot be exported, disable custody first")
+
+// keystoreKDF returns the key derivation settings for new keystores
+func keystoreKDF() crypto.KDFConfig {
+	cfg := crypto.DefaultKDFConfig
+	if config.AppConfig == nil {
+		return cfg
+	}
+	if config.AppConfig.KeystoreKDF != "" {
+		cfg.KDF = config.AppConfig.KeystoreKDF
+	}
+	if config.AppConfig.KeystoreArgon2Time > 0 {
+		cfg.Time = uint32(config.AppConfig.KeystoreArgon2Time)
+	}
+	if config.AppConfig.KeystoreArgon2Memory > 0 {
+		cfg.Memory = uint32(config.AppConfig.KeystoreArgon2Memory)
+	}
+	if config.AppConfig.KeystoreArgon2Threads > 0 {
+		cfg.Threads = uint8(config.AppConfig.KeystoreArgon2Threads)
+	}
+	if config.AppConfig.KeystoreScryptN > 0 {
+		cfg.N = config.AppConfig.KeystoreScryptN
+	}
+	if config.AppConfig.KeystoreScryptR > 0 {
+		cfg.R = config.AppConfig.KeystoreScryptR
+	}
+	if config.AppConfig.KeystoreScryptP > 0 {
+		cfg.P = config.AppConfig.KeystoreScryptP
+	}
+	return cfg
+}
+
+// SealPrivateKey encrypts a private key under a passphrase into the
+// keystore JSON stored on the user record
+func SealPrivateKey(privateKeyStr, passphrase string) (string, error) {
+	ks, err := crypto.EncryptKeystore(privateKeyStr, passphrase, keystoreKDF())
+	if err != nil {
+		return "", err
+	}
+	return ks.String(), nil
+}
+
+// legacyPrivateKey decrypts a key stored before keystores existed, which
+// was encrypted with the user's email, or Google ID for accounts created
+// through Google
+func legacyPrivateKey(user *models.User) (string, error) {
+	for _, passphrase := range []string{user.Email, user.GoogleID} {
+		if passphrase == "" {
+			continue
+		}
+		if privateKey, err := crypto.DecryptPrivateKey(user.EncryptedPrivateKey, passphrase); err == nil {
+			return privateKey, nil
+		}
+	}
+	return "", errors.New("failed to decrypt legacy private key")
+}
+
+// decryptPrivateKey decrypts a user's keystore with their passphrase; the
+// keystore is returned too so callers can check its KDF. Legacy keys are
+// refused: anyone could choose their passphrase, so only UpgradeKeystore
+// gives them one.
+func decryptPrivateKey(user *models.User, passphrase string) (string, *crypto.Keystore, error) {
+	if crypto.IsLegacyEncryptedKey(user.EncryptedPrivateKey) {
+		return "", nil, ErrLegacyKeystore
+	}
+	ks, err := crypto.ParseKeystore(user.EncryptedPrivateKey)
+	if err != nil {
+		return "", nil, err
+	}
+	privateKey, err := ks.Decrypt(passphrase)
+	return privateKey, ks, err
+}
+
+// storePrivateKey seals a private key under a passphrase and saves it on
+// the user
+func storePrivateKey(user *models.User, privateKey, passphrase string) error {
+	sealed, err := SealPrivateKey(privateKey, passphrase)
+	if err != nil {
+		return err
+	}
+	if err := db.UpdateUser(user.Email, map[string]interface{}{"encrypted_private_key": sealed}); err != nil {
+		return err
+	}
+	user.EncryptedPrivateKey = sealed
+	return nil
+}
+
+// unlockPrivateKey returns a user's private key, re-encrypting it under the
+// same passphrase if its keystore is weaker than the configured KDF
+func unlockPrivateKey(user *models.User, passphrase string) (string, error) {
+	privateKey, ks, err := decryptPrivateKey(user, passphrase)
+	if err != nil {
+		return "", err
+	}
+	if ks.NeedsUpgrade(keystoreKDF()) {
+		if err := storePrivateKey(user, privateKey, passphrase); err != nil {
+			return "", err
+		}
+		LogSystemEvent("keystore_upgraded", user.ID, map[string]interface{}{"kdf": keystoreKDF().KDF}, "info")
+	}
+	return privateKey, nil
+}
+
+// ExportPrivateKey returns a user's private key so it can be used in
+// another wallet. The key of a wallet in custody is only used through the key
+// manager and is not exported until custody is disabled.
+func ExportPrivateKey(user *models.User, passphrase string) (string, error) {
+	if user.Custody != nil {
+		return "", ErrCustodialExport
+	}
+	return unlockPrivateKey(user, passphrase)
+}
+
+// UpgradeKeystore re-encrypts a user's private key under the passphrase if
+// it is still in the legacy format or its keystore uses weaker KDF settings
+// than configured, and reports whether it did. Up-to-date keystores are
+// left alone without checking the passphrase. A legacy key takes the
+// passphrase as its first one, so this must only run once the user has
+// logged in with an OTP or Google.
+func UpgradeKeystore(user *models.User, passphrase string) (bool, error) {
+	if !NeedsKeystoreUpgrade(user) {
+		return false, nil
+	}
+	if !crypto.IsLegacyEncryptedKey(user.EncryptedPrivateKey) {
+		if _, err := unlockPrivateKey(user, passphrase); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	privateKey, err := legacyPrivateKey(user)
+	if err != nil {
+		return false, err
+	}
+	if err := storePrivateKey(user, privateKey, passphrase); err != nil {
+		return false, err
+	}
+	LogSystemEvent("keystore_upgraded", user.ID, map[string]interface{}{"kdf": keystoreKDF().KDF}, "info")
+	return true, nil
+}
+
+// NeedsKeystoreUpgrade reports whether a user's private key should be
+// re-encrypted on their next login with a passphrase
+func NeedsKeystoreUpgrade(user *models.User) bool {
+	if crypto.IsLegacyEncryptedKey(user.EncryptedPrivateKey) {
+		return true
+	}
+	ks, err := crypto.ParseKeystore(user.EncryptedPrivateKey)
+	return err == nil && ks.NeedsUpgrade(keystoreKDF())
+}
+
+// ExportKeystore returns a user's keystore for backup. Legacy keys have to
+// be migrated first so the export is protected by a passphrase.
+func ExportKeystore(user *models.User) (*crypto.Keystore, error) {
+	if crypto.IsLegacyEncryptedKey(user.EncryptedPrivateKey) {
+		return nil, ErrLegacyKeystore
+	}
+	return crypto.ParseKeystore(user.EncryptedPrivateKey)
+}
+
+// ImportKeystore replaces a user's stored key with a keystore exported
+// earlier. The keystore must hold the key of the user's wallet; it is
+// re-encrypted with the configured KDF under the same passphrase.
+func ImportKeystore(user *models.User, keystoreJSON, passphrase string) error {
+	ks, err := crypto.ParseKeystore(keystoreJSON)
+	if err != nil {
+		return err
+	}
+	privateKeyStr, err := ks.Decrypt(passphrase)
+	if err != nil {
+		return err
+	}
+	privateKey, err := crypto.ParsePrivateKey(privateKeyStr)
+	if err != nil {
+		return err
+	}
+	if crypto.GenerateWalletID(privateKey.Public().String()) != user.WalletID {
+		return fmt.Errorf("keystore is for wallet %s, not %s", ks.WalletID, user.WalletID)
+	}
+
+	if err := storePrivateKey(user, privateKeyStr, passphrase); err != nil {
+		return err
+	}
+	LogSystemEvent("keystore_imported", user.ID, map[string]interface{}{"keystore_id": ks.ID}, "info")
+	return nil
+}
+
+// ChangePassphrase re-encrypts a user's private key under a new
+// passphrase. Legacy keys have no current passphrase to check, so they
+// return ErrLegacyKeystore until a login upgrades them.
+func ChangePassphrase(user *models.User, currentPassphrase, newPassphrase string) error {
+	if len(newPassphrase) < crypto.MinPassphraseLength {
+		return fmt.Errorf("passphrase must be at least %d characters", crypto.MinPassphraseLength)
+	}
+
+	privateKey, _, err := decryptPrivateKey(user, currentPassphrase)
+	if err != nil {
+		return err
+	}
+
+	if err := storePrivateKey(user, privateKey, newPassphrase); err != nil {
+		return err
+	}
+	LogSystemEvent("passphrase_changed", user.ID, nil, "info")
+	return nil
+}
@@ -38,7 +38,7 @@ func LogSystemEventWithIP(event string, userID string, ipAddress string, details
 }
 
 // LogTransactionEvent logs a transaction event
-func LogTransactionEvent(userID, walletID, txID, action string, amount float64, counterparty, blockHash string, blockIndex int, status, note string) {
+func LogTransactionEvent(userID, walletID, txID, action string, amount models.Amount, counterparty, blockHash string, blockIndex int, status, note string) {
 	txLog := models.TransactionLog{
 		UserID:       userID,
 		WalletID:     walletID,
@@ -112,10 +112,10 @@ func LogMining(minerWalletID string, blockIndex int, blockHash string, txCount i
 }
 
 // LogZakatDeduction logs a zakat deduction event
-func LogZakatDeduction(userID, walletID string, amount, balance float64) {
+func LogZakatDeduction(userID, walletID string, amount, balance models.Amount) {
 	LogSystemEvent("zakat_deduction", userID, map[string]interface{}{
 		"wallet_id": walletID,
-		"amount":    amount,
-		"balance":   balance,
+		"amount":    amount.String(),
+		"balance":   balance.String(),
 	}, "info")
 }
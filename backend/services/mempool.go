@@ -0,0 +1,245 @@
+package services
+
+import (
+	"crypto-wallet/blockchain"
+	"crypto-wallet/crypto"
+	"crypto-wallet/db"
+	"crypto-wallet/models"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrKnownTransaction is returned for a transaction that is already pending
+var ErrKnownTransaction = errors.New("transaction is already pending")
+
+// txListeners are notified of every transaction added to the pending pool
+var (
+	txListenersMu sync.RWMutex
+	txListeners   []func(tx models.Transaction)
+)
+
+// OnTransactionAccepted registers fn to be called after a transaction enters the pending pool
+func OnTransactionAccepted(fn func(tx models.Transaction)) {
+	txListenersMu.Lock()
+	defer txListenersMu.Unlock()
+	txListeners = append(txListeners, fn)
+}
+
+func notifyTransactionAccepted(tx models.Transaction) {
+	txListenersMu.RLock()
+	listeners := txListeners
+	txListenersMu.RUnlock()
+	for _, fn := range listeners {
+		fn(tx)
+	}
+}
+
+// AcceptTransaction validates a signed transfer received from elsewhere (e.g.
+// a peer) and adds it to the pending pool, locking the UTXOs it spends. Only
+// the current transaction version, whose inputs are signed individually, is
+// accepted.
+func AcceptTransaction(tx models.Transaction) error {
+	if blockchain.IsCoinbase(tx) || tx.IsZakat {
+		return errors.New("only transfers can be submitted to the pending pool")
+	}
+	if tx.Version != blockchain.TransactionVersion {
+		return fmt.Errorf("transaction version %d is not accepted, expected %d", tx.Version, blockchain.TransactionVersion)
+	}
+	if len(tx.Vin) == 0 {
+		return errors.New("transaction has no inputs")
+	}
+
+	pending, err := db.GetPendingTransactions()
+	if err != nil {
+		return err
+	}
+	for _, ptx := range pending {
+		if ptx.ID == tx.ID {
+			return ErrKnownTransaction
+		}
+	}
+
+	// Check the values before ProcessTransaction compares Amount and Fee
+	// with the sender's balance
+	var inputsTotal, outputsTotal models.Amount
+	for _, input := range tx.Vin {
+		utxo, err := db.GetUTXO(input.TxID, input.Vout)
+		if err != nil {
+			return fmt.Errorf("input %s:%d not found", input.TxID, input.Vout)
+		}
+		if utxo.WalletID != tx.SenderID || crypto.GenerateWalletID(input.PubKey) != utxo.WalletID {
+			return fmt.Errorf("input %s:%d is not owned by the sender", input.TxID, input.Vout)
+		}
+		if utxo.IsLocked && utxo.LockedBy != tx.ID {
+			return fmt.Errorf("input %s:%d is already spent by pending transaction %s", input.TxID, input.Vout, utxo.LockedBy)
+		}
+		total, ok := blockchain.AddAmounts(inputsTotal, utxo.Amount)
+		if !ok {
+			return errors.New("inputs exceed the maximum amount")
+		}
+		inputsTotal = total
+	}
+	for _, output := range tx.Vout {
+		if output.Value <= 0 {
+			return errors.New("outputs must be positive")
+		}
+		total, ok := blockchain.AddAmounts(outputsTotal, output.Value)
+		if !ok {
+			return errors.New("outputs exceed the maximum amount")
+		}
+		outputsTotal = total
+	}
+	// The first output pays the receiver the transaction's amount
+	if len(tx.Vout) == 0 || tx.Vout[0].PubKeyHash != tx.ReceiverID || tx.Vout[0].Value != tx.Amount {
+		return errors.New("amount does not match the output paying the receiver")
+	}
+	if outputsTotal > inputsTotal {
+		return errors.New("outputs exceed inputs")
+	}
+	if tx.Fee != inputsTotal-outputsTotal {
+		return fmt.Errorf("fee %s does not equal inputs minus outputs (%s)", tx.Fee, inputsTotal-outputsTotal)
+	}
+
+	if err := ProcessTransaction(tx); err != nil {
+		return err
+	}
+
+	if err := db.AddPendingTransaction(&models.PendingTransaction{ID: tx.ID, Transaction: tx}); err != nil {
+		return err
+	}
+	for _, input := range tx.Vin {
+		// Another transaction may have locked the input since it was checked;
+		// release the inputs locked so far and give up
+		if err := db.LockUTXO(input.TxID, input.Vout, tx.ID); err != nil {
+			db.UnlockUTXOsByPendingTx(tx.ID)
+			db.DeletePendingTransaction(tx.ID)
+			return fmt.Errorf("failed to lock input %s:%d: %w", input.TxID, input.Vout, err)
+		}
+	}
+
+	LogSystemEvent("transaction_received", "", map[string]interface{}{
+		"tx_id":    tx.ID,
+		"amount":   tx.Amount.String(),
+		"fee":      tx.Fee.String(),
+		"sender":   tx.SenderID,
+		"receiver": tx.ReceiverID,
+	}, "info")
+
+	notifyTransactionAccepted(tx)
+	return nil
+}
+
+// SortByFeeRate orders pending transactions by fee per byte, highest first,
+// keeping arrival order among equal rates
+func SortByFeeRate(pending []models.PendingTransaction) {
+	rates := make(map[string]float64, len(pending))
+	for _, ptx := range pending {
+		rates[ptx.ID] = blockchain.FeeRate(ptx.Transaction)
+	}
+	sort.SliceStable(pending, func(i, j int) bool {
+		return rates[pending[i].ID] > rates[pending[j].ID]
+	})
+}
+
+// RevalidateMempool marks pending transactions whose inputs are no longer
+// unspent on the active chain as failed and releases their UTXO locks.
+// It returns the number of transactions dropped.
+func RevalidateMempool() (int, error) {
+	pending, err := db.GetPendingTransactions()
+	if err != nil {
+		return 0, err
+	}
+
+	dropped := 0
+	for _, ptx := range pending {
+		reason := ""
+		for _, input := range ptx.Transaction.Vin {
+			utxo, err := db.GetUTXO(input.TxID, input.Vout)
+			if err != nil || utxo.IsSpent {
+				reason = fmt.Sprintf("input %s:%d is no longer available", input.TxID, input.Vout)
+				break
+			}
+			if utxo.IsLocked && utxo.LockedBy != ptx.ID {
+				reason = fmt.Sprintf("input %s:%d is spent by pending transaction %s", input.TxID, input.Vout, utxo.LockedBy)
+				break
+			}
+		}
+
+		if reason == "" {
+			// Re-lock inputs freed while the chain was rolled back
+			for _, input := range ptx.Transaction.Vin {
+				if err := db.LockUTXO(input.TxID, input.Vout, ptx.ID); err != nil {
+					reason = fmt.Sprintf("input %s:%d could not be locked: %v", input.TxID, input.Vout, err)
+					break
+				}
+			}
+		}
+		if reason == "" {
+			continue
+		}
+
+		db.UnlockUTXOsByPendingTx(ptx.ID)
+		db.UpdatePendingTransactionStatus(ptx.ID, "failed")
+		LogSystemEvent("pending_transaction_dropped", "", map[string]interface{}{
+			"tx_id":  ptx.ID,
+			"reason": reason,
+		}, "warning")
+		dropped++
+	}
+
+	return dropped, nil
+}
+
+// SigningInput is one input of an unsigned transaction and the signature
+// hash its owner must sign
+type SigningInput struct {
+	Index   int           `json:"index"`
+	TxID    string        `json:"tx_id"`
+	Vout    int           `json:"vout"`
+	Amount  models.Amount `json:"amount"`
+	SigHash string        `json:"sighash"`
+}
+
+// UnsignedTransaction is a transfer ready to be signed outside the server
+type UnsignedTransaction struct {
+	Transaction     models.Transaction `json:"transaction"`
+	Inputs          []SigningInput     `json:"inputs"`
+	SignatureScheme string             `json:"signature_scheme"`
+}
+
+// PrepareTransaction builds an unsigned transfer and the signature hash of
+// each of its inputs. No UTXOs are locked until the signed transaction is
+// submitted, so building twice may select the same outputs.
+func PrepareTransaction(senderWalletID, receiverWalletID string, amount, fee models.Amount, note string) (*UnsignedTransaction, error) {
+	tx, utxos, err := BuildTransaction(senderWalletID, receiverWalletID, amount, fee, note)
+	if err != nil {
+		return nil, err
+	}
+
+	unsigned := &UnsignedTransaction{
+		Transaction:     *tx,
+		SignatureScheme: crypto.SignatureScheme(crypto.KeyTypeOf(tx.Vin[0].PubKey)),
+	}
+	for i, utxo := range utxos {
+		unsigned.Inputs = append(unsigned.Inputs, SigningInput{
+			Index:   i,
+			TxID:    utxo.TxID,
+			Vout:    utxo.Vout,
+			Amount:  utxo.Amount,
+			SigHash: blockchain.InputSignatureHash(*tx, i, utxo),
+		})
+	}
+	return unsigned, nil
+}
+
+// SubmitTransaction verifies a transfer signed outside the server and adds
+// it to the pending pool. It must spend from the user's primary wallet or
+// one of their HD wallets.
+func SubmitTransaction(userID, walletID string, tx models.Transaction) error {
+	if tx.SenderID != walletID && !OwnsWallet(userID, tx.SenderID) {
+		return errors.New("transaction does not spend from your wallet")
+	}
+	return AcceptTransaction(tx)
+}
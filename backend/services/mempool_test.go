@@ -0,0 +1,87 @@
+package services
+
+import (
+	"crypto-wallet/blockchain"
+	"crypto-wallet/db"
+	"crypto-wallet/models"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAcceptTransactionRejections(t *testing.T) {
+	alice := newTestChain(t)
+	reward := matureReward(t, alice)
+
+	old := alice.spend(t, reward, "bob", 10*models.Coin, models.Coin)
+	old.Version = blockchain.TransactionVersion - 1
+	old.ID = blockchain.TransactionID(old)
+	if err := AcceptTransaction(old); err == nil || !strings.Contains(err.Error(), "is not accepted") {
+		t.Fatalf("old version: %v", err)
+	}
+
+	// The receiver's output pays less than the claimed amount
+	short := alice.spend(t, reward, "bob", 10*models.Coin, models.Coin)
+	short.Amount = 20 * models.Coin
+	short.ID = blockchain.TransactionID(short)
+	if err := AcceptTransaction(short); err == nil || !strings.Contains(err.Error(), "amount does not match") {
+		t.Fatalf("amount mismatch: %v", err)
+	}
+
+	valid := alice.spend(t, reward, "bob", 10*models.Coin, models.Coin)
+	if err := AcceptTransaction(valid); err != nil {
+		t.Fatal(err)
+	}
+	if err := AcceptTransaction(valid); err != ErrKnownTransaction {
+		t.Fatalf("resubmission: %v", err)
+	}
+}
+
+func TestConcurrentDoubleSpendLocksOnce(t *testing.T) {
+	alice := newTestChain(t)
+	reward := matureReward(t, alice)
+
+	// Every transaction spends the same output to a different receiver
+	const attempts = 16
+	txs := make([]models.Transaction, attempts)
+	for i := range txs {
+		txs[i] = alice.spend(t, reward, fmt.Sprintf("receiver-%d", i), models.Coin, 0)
+	}
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make([]error, attempts)
+	for i := range txs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs[i] = AcceptTransaction(txs[i])
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	winner := ""
+	for i, err := range errs {
+		if err == nil {
+			if winner != "" {
+				t.Fatalf("both %s and %s were accepted", winner, txs[i].ID)
+			}
+			winner = txs[i].ID
+		}
+	}
+	if winner == "" {
+		t.Fatalf("no transaction was accepted: %v", errs)
+	}
+
+	utxo, err := db.GetUTXO(reward.TxID, reward.Vout)
+	if err != nil || !utxo.IsLocked || utxo.LockedBy != winner {
+		t.Fatalf("spent output = %+v, %v, want locked by %s", utxo, err, winner)
+	}
+	pending, err := db.GetPendingTransactions()
+	if err != nil || len(pending) != 1 || pending[0].ID != winner {
+		t.Fatalf("pending = %+v, %v, want only %s", pending, err, winner)
+	}
+}
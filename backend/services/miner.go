@@ -0,0 +1,475 @@
+package services
+
+import (
+	"context"
+	"crypto-wallet/blockchain"
+	"crypto-wallet/config"
+	"crypto-wallet/db"
+	"crypto-wallet/models"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Mining job states
+const (
+	MiningJobQueued    = "queued"
+	MiningJobMining    = "mining"
+	MiningJobMined     = "mined"
+	MiningJobAbandoned = "abandoned" // The tip moved and nothing was left to mine
+	MiningJobCancelled = "cancelled"
+	MiningJobFailed    = "failed"
+)
+
+const (
+	// miningQueueSize bounds the number of jobs waiting for the miner
+	miningQueueSize = 16
+
+	// maxFinishedMiningJobs is how many finished jobs are kept for status queries
+	maxFinishedMiningJobs = 100
+)
+
+var (
+	ErrNoPendingTransactions = errors.New("no pending transactions to mine")
+	ErrMiningQueueFull       = errors.New("mining queue is full, try again later")
+	ErrMiningJobNotFound     = errors.New("mining job not found")
+	ErrMiningJobFinished     = errors.New("mining job has already finished")
+)
+
+// MiningJob is the public view of a mining job
+type MiningJob struct {
+	ID           string        `json:"id"`
+	UserID       string        `json:"user_id"`
+	WalletID     string        `json:"wallet_id"`
+	Status       string        `json:"status"`
+	Height       int           `json:"height"`
+	PrevHash     string        `json:"prev_hash"`
+	Bits         uint32        `json:"bits"`
+	Transactions int           `json:"transactions"`
+	Workers      int           `json:"workers"`
+	Hashes       uint64        `json:"hashes"`
+	HashRate     float64       `json:"hash_rate"` // Hashes per second
+	Restarts     int           `json:"restarts"`  // Times the job moved to a new tip
+	BlockHash    string        `json:"block_hash,omitempty"`
+	Chain        *AcceptResult `json:"chain,omitempty"`
+	Error        string        `json:"error,omitempty"`
+	CreatedAt    time.Time     `json:"created_at"`
+	StartedAt    *time.Time    `json:"started_at,omitempty"`
+	FinishedAt   *time.Time    `json:"finished_at,omitempty"`
+}
+
+// Finished reports whether the job has stopped for good
+func (j MiningJob) Finished() bool {
+	return j.FinishedAt != nil
+}
+
+// miningJob is a job with its runtime state, guarded by miner
+type miningJob struct {
+	MiningJob
+	block   models.Block
+	hashes  atomic.Uint64
+	ctx     context.Context
+	cancel  context.CancelFunc // Stops the job
+	restart context.CancelFunc // Abandons the current attempt so it can move to the new tip
+}
+
+// miner runs queued jobs one at a time, each using every worker
+var miner struct {
+	sync.Mutex
+	once    sync.Once
+	queue   chan *miningJob
+	jobs    map[string]*miningJob
+	order   []string
+	current *miningJob
+}
+
+// startMiner launches the mining loop and subscribes it to new tips
+func startMiner() {
+	miner.once.Do(func() {
+		miner.queue = make(chan *miningJob, miningQueueSize)
+		miner.jobs = make(map[string]*miningJob)
+		OnBlockAccepted(abandonStaleWork)
+		go runMiner()
+	})
+}
+
+// SubmitMiningJob builds a block of pending transactions paying the reward to
+// walletID and queues it for mining. The returned job can be followed with
+// GetMiningJob.
+func SubmitMiningJob(userID, walletID string) (*MiningJob, error) {
+	startMiner()
+
+	block, err := NewBlockTemplate(userID, walletID)
+	if err != nil {
+		return nil, err
+	}
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout := config.AppConfig.MiningTimeout; timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	job := &miningJob{block: block, ctx: ctx, cancel: cancel}
+	job.ID = randomID()
+	job.UserID = userID
+	job.WalletID = walletID
+	job.Status = MiningJobQueued
+	job.Workers = miningWorkers()
+	job.CreatedAt = time.Now()
+	job.setTemplate(block)
+
+	miner.Lock()
+	defer miner.Unlock()
+	select {
+	case miner.queue <- job:
+	default:
+		cancel()
+		return nil, ErrMiningQueueFull
+	}
+	miner.jobs[job.ID] = job
+	miner.order = append(miner.order, job.ID)
+	pruneMiningJobs()
+
+	log.Printf("⛏️  Mining job %s queued for block %d", job.ID, block.Index)
+	return job.snapshot(), nil
+}
+
+// MineBlock mines the next block in the calling goroutine and connects it,
+// for callers that cannot keep a background job alive, such as serverless
+// functions. It gives up when ctx is done or MINING_TIMEOUT passes.
+func MineBlock(ctx context.Context, userID, walletID string) (*models.Block, *AcceptResult, error) {
+	if timeout := config.AppConfig.MiningTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
+
+	block, err := NewBlockTemplate(userID, walletID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var hashes atomic.Uint64
+	if err := blockchain.SolveProofOfWork(ctx, &block, miningWorkers(), &hashes); err != nil {
+		return nil, nil, err
+	}
+
+	// A block for a tip that moved meanwhile is kept as a side block
+	result, err := AcceptBlock(block, nil)
+	if err != nil {
+		LogSystemEvent("block_commit_failed", userID, map[string]interface{}{
+			"block_index": block.Index,
+			"error":       err.Error(),
+		}, "error")
+		return nil, nil, fmt.Errorf("failed to commit block: %w", err)
+	}
+	LogMining(walletID, block.Index, block.Hash, len(block.Transactions))
+	return &block, result, nil
+}
+
+// GetMiningJob returns the current state of a job
+func GetMiningJob(id string) (*MiningJob, error) {
+	miner.Lock()
+	defer miner.Unlock()
+	job, ok := miner.jobs[id]
+	if !ok {
+		return nil, ErrMiningJobNotFound
+	}
+	return job.snapshot(), nil
+}
+
+// CancelMiningJob stops a queued or running job
+func CancelMiningJob(id string) (*MiningJob, error) {
+	miner.Lock()
+	defer miner.Unlock()
+	job, ok := miner.jobs[id]
+	if !ok {
+		return nil, ErrMiningJobNotFound
+	}
+	if job.Finished() {
+		return nil, ErrMiningJobFinished
+	}
+
+	job.cancel()
+	if job.Status == MiningJobQueued {
+		job.finish(MiningJobCancelled, "cancelled before mining started")
+	}
+	return job.snapshot(), nil
+}
+
+// NewBlockTemplate assembles the next block from the valid pending
+// transactions with the highest fee per byte that fit within the block
+// limits, plus a coinbase paying the block subsidy and the collected fees to
+// walletID. Once the subsidy has run out, a block without fees has no coinbase. Pending transactions that no longer validate are marked failed.
+// The block still needs proof of work.
+func NewBlockTemplate(userID, walletID string) (models.Block, error) {
+	pendingTxs, err := db.GetPendingTransactions()
+	if err != nil {
+		return models.Block{}, fmt.Errorf("failed to get pending transactions: %w", err)
+	}
+	SortByFeeRate(pendingTxs)
+
+	lastBlock, err := db.GetLastBlock()
+	if err != nil {
+		return models.Block{}, fmt.Errorf("failed to get last block: %w", err)
+	}
+
+	// Create coinbase transaction (mining reward). The note commits to the
+	// block height so that every coinbase has a distinct ID. Its value is
+	// filled in once the fees are known; the encoded size does not change.
+	subsidy := blockchain.BlockSubsidy(lastBlock.Index + 1)
+	coinbaseTx := models.Transaction{
+		Version:    blockchain.TransactionVersion,
+		SenderID:   "coinbase",
+		ReceiverID: userID,
+		Amount:     subsidy,
+		Timestamp:  time.Now().Unix(),
+		Note:       fmt.Sprintf("Block reward for block %d", lastBlock.Index+1),
+		Type:       "mining_reward",
+		Vin:        []models.TXInput{},
+		Vout: []models.TXOutput{
+			{
+				Value:      subsidy,
+				PubKeyHash: walletID,
+				IsSpent:    false,
+			},
+		},
+	}
+	space := blockchain.NewBlockSpace()
+	space.Add(coinbaseTx)
+
+	var transactions []models.Transaction
+	var fees models.Amount
+	for _, ptx := range pendingTxs {
+		if err := ProcessTransaction(ptx.Transaction); err != nil {
+			LogSystemEvent("transaction_validation_failed", userID, map[string]interface{}{
+				"tx_id": ptx.Transaction.ID,
+				"error": err.Error(),
+			}, "error")
+			// Unlock UTXOs that were locked for this failed transaction
+			db.UnlockUTXOsByPendingTx(ptx.Transaction.ID)
+			db.UpdatePendingTransactionStatus(ptx.ID, "failed")
+			continue
+		}
+		// Transactions that do not fit wait for a later block
+		if !space.Add(ptx.Transaction) {
+			continue
+		}
+		transactions = append(transactions, ptx.Transaction)
+		fees += ptx.Transaction.Fee
+	}
+
+	// Require at least one valid pending transaction before mining
+	if len(transactions) == 0 {
+		return models.Block{}, ErrNoPendingTransactions
+	}
+
+	if subsidy+fees > 0 {
+		coinbaseTx.Amount += fees
+		coinbaseTx.Vout[0].Value += fees
+		coinbaseTx.ID = blockchain.TransactionID(coinbaseTx)
+		transactions = append(transactions, coinbaseTx)
+	}
+
+	// Difficulty target for the next height
+	bits, err := blockchain.NextBits(*lastBlock, blockchain.LookupStoredBlock)
+	if err != nil {
+		return models.Block{}, fmt.Errorf("failed to compute difficulty: %w", err)
+	}
+
+	timestamp, err := blockchain.NextBlockTime(*lastBlock, blockchain.LookupStoredBlock)
+	if err != nil {
+		return models.Block{}, fmt.Errorf("failed to compute block time: %w", err)
+	}
+
+	return models.Block{
+		Version:      blockchain.BlockVersion,
+		Index:        lastBlock.Index + 1,
+		Timestamp:    timestamp,
+		Transactions: transactions,
+		PrevHash:     lastBlock.Hash,
+		Bits:         bits,
+		MerkleRoot:   blockchain.CalculateMerkleRoot(transactions),
+		MinedBy:      walletID,
+	}, nil
+}
+
+// runMiner mines queued jobs in order
+func runMiner() {
+	for job := range miner.queue {
+		miner.Lock()
+		if job.Status != MiningJobQueued {
+			miner.Unlock()
+			continue
+		}
+		miner.current = job
+		now := time.Now()
+		job.StartedAt = &now
+		job.Status = MiningJobMining
+		miner.Unlock()
+
+		mine(job)
+
+		miner.Lock()
+		miner.current = nil
+		miner.Unlock()
+		job.cancel()
+	}
+}
+
+// mine searches for proof of work on the job's block, rebuilding the block
+// whenever the tip moves, until it is mined, cancelled or times out
+func mine(job *miningJob) {
+	block := job.block
+	for {
+		attempt, restart := context.WithCancel(job.ctx)
+		miner.Lock()
+		job.restart = restart
+		job.setTemplate(block)
+		miner.Unlock()
+
+		// The tip may have moved while the job was queued or being rebuilt
+		if tip, err := db.GetLastBlock(); err == nil && tip.Hash != block.PrevHash {
+			restart()
+		}
+
+		err := blockchain.SolveProofOfWork(attempt, &block, job.Workers, &job.hashes)
+		restart()
+
+		if err == nil {
+			result, err := AcceptBlock(block, nil)
+			miner.Lock()
+			defer miner.Unlock()
+			if err != nil {
+				LogSystemEvent("block_commit_failed", job.UserID, map[string]interface{}{
+					"block_index": block.Index,
+					"error":       err.Error(),
+				}, "error")
+				job.finish(MiningJobFailed, "failed to commit block: "+err.Error())
+				return
+			}
+			job.BlockHash = block.Hash
+			job.Chain = result
+			job.finish(MiningJobMined, "")
+			LogMining(job.WalletID, block.Index, block.Hash, len(block.Transactions))
+			return
+		}
+
+		if errors.Is(job.ctx.Err(), context.DeadlineExceeded) {
+			miner.Lock()
+			job.finish(MiningJobFailed, fmt.Sprintf("mining timed out after %ds", config.AppConfig.MiningTimeout))
+			miner.Unlock()
+			return
+		}
+		if job.ctx.Err() != nil {
+			miner.Lock()
+			job.finish(MiningJobCancelled, "")
+			miner.Unlock()
+			return
+		}
+
+		// A new tip arrived: start again on top of it
+		block, err = NewBlockTemplate(job.UserID, job.WalletID)
+		miner.Lock()
+		job.Restarts++
+		if errors.Is(err, ErrNoPendingTransactions) {
+			job.finish(MiningJobAbandoned, "the new tip already includes every pending transaction")
+			miner.Unlock()
+			return
+		}
+		if err != nil {
+			job.finish(MiningJobFailed, err.Error())
+			miner.Unlock()
+			return
+		}
+		miner.Unlock()
+		log.Printf("⛏️  Mining job %s moved to block %d on the new tip", job.ID, block.Index)
+	}
+}
+
+// abandonStaleWork stops the running attempt when a block changes the tip
+// it is building on
+func abandonStaleWork(block models.Block, result *AcceptResult) {
+	miner.Lock()
+	defer miner.Unlock()
+	job := miner.current
+	if job != nil && job.restart != nil && result.TipHash != job.PrevHash {
+		job.restart()
+	}
+}
+
+// setTemplate records the block being mined
+func (j *miningJob) setTemplate(block models.Block) {
+	j.Height = block.Index
+	j.PrevHash = block.PrevHash
+	j.Bits = block.Bits
+	j.Transactions = len(block.Transactions)
+}
+
+// finish marks the job as stopped
+func (j *miningJob) finish(status, reason string) {
+	now := time.Now()
+	j.Status = status
+	j.Error = reason
+	j.FinishedAt = &now
+}
+
+// snapshot copies the public state, including the current hash count
+func (j *miningJob) snapshot() *MiningJob {
+	view := j.MiningJob
+	view.Hashes = j.hashes.Load()
+	if view.StartedAt != nil {
+		end := time.Now()
+		if view.FinishedAt != nil {
+			end = *view.FinishedAt
+		}
+		if elapsed := end.Sub(*view.StartedAt).Seconds(); elapsed > 0 {
+			view.HashRate = float64(view.Hashes) / elapsed
+		}
+	}
+	return &view
+}
+
+// pruneMiningJobs forgets the oldest finished jobs beyond the retention limit
+func pruneMiningJobs() {
+	finished := 0
+	for _, id := range miner.order {
+		if miner.jobs[id].Finished() {
+			finished++
+		}
+	}
+
+	kept := miner.order[:0]
+	for _, id := range miner.order {
+		if finished > maxFinishedMiningJobs && miner.jobs[id].Finished() {
+			delete(miner.jobs, id)
+			finished--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	miner.order = kept
+}
+
+// miningWorkers is the number of proof-of-work goroutines per job
+func miningWorkers() int {
+	if workers := config.AppConfig.MiningWorkers; workers > 0 {
+		return workers
+	}
+	return runtime.NumCPU()
+}
+
+// randomID returns a random hex identifier for jobs and templates
+func randomID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
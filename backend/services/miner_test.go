@@ -0,0 +1,37 @@
+package services
+
+import (
+	"context"
+	"crypto-wallet/db"
+	"crypto-wallet/models"
+	"errors"
+	"testing"
+)
+
+func TestMineBlockConnectsPendingTransactions(t *testing.T) {
+	alice := newTestChain(t)
+	if _, _, err := MineBlock(context.Background(), "alice", alice.id); !errors.Is(err, ErrNoPendingTransactions) {
+		t.Fatalf("empty pool: %v", err)
+	}
+
+	reward := matureReward(t, alice)
+	tx := alice.spend(t, reward, "bob", 10*models.Coin, models.Coin)
+	if err := AcceptTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	block, result, err := MineBlock(context.Background(), "alice", alice.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != "connected" || result.TipHash != block.Hash {
+		t.Fatalf("mined block was %+v", result)
+	}
+	if len(block.Transactions) != 2 || block.Transactions[0].ID != tx.ID {
+		t.Fatalf("mined transactions = %+v", block.Transactions)
+	}
+	if _, err := db.GetUTXO(tx.ID, 0); err != nil {
+		t.Fatalf("transfer output missing: %v", err)
+	}
+	expectConsistent(t)
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"crypto-wallet/blockchain"
+	"crypto-wallet/db"
+	"crypto-wallet/models"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// ReindexDiscrepancy describes one difference between the stored derived data
+// and the state obtained by replaying the chain
+type ReindexDiscrepancy struct {
+	Kind     string        `json:"kind"` // "missing_utxo", "extra_utxo", "utxo_mismatch", "duplicate_utxo", "invalid_input", "balance_mismatch"
+	TxID     string        `json:"tx_id,omitempty"`
+	Vout     int           `json:"vout,omitempty"`
+	WalletID string        `json:"wallet_id,omitempty"`
+	Expected models.Amount `json:"expected,omitempty"`
+	Actual   models.Amount `json:"actual,omitempty"`
+	Detail   string        `json:"detail"`
+}
+
+// ReindexReport summarises a reindex run
+type ReindexReport struct {
+	DryRun               bool                 `json:"dry_run"`
+	BlocksScanned        int                  `json:"blocks_scanned"`
+	TransactionsReplayed int                  `json:"transactions_replayed"`
+	UTXOsRebuilt         int                  `json:"utxos_rebuilt"`
+	UnspentUTXOs         int                  `json:"unspent_utxos"`
+	TransactionLogs      int                  `json:"transaction_logs"`
+	WalletsUpdated       int                  `json:"wallets_updated"`
+	Discrepancies        []ReindexDiscrepancy `json:"discrepancies"`
+}
+
+// ReindexUTXOs rebuilds the UTXO set, cached wallet balances and transaction
+// logs by replaying every block from genesis. The replay is deterministic:
+// timestamps come from the blocks, not the wall clock. With dryRun set the
+// stored state is only compared, never modified.
+func ReindexUTXOs(dryRun bool) (*ReindexReport, error) {
+	blocks, err := db.GetAllBlocks()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReindexReport{DryRun: dryRun, Discrepancies: []ReindexDiscrepancy{}}
+	utxoSet := make(map[string]*models.UTXO)
+	var utxoOrder []string
+	var txLogs []models.TransactionLog
+
+	for _, block := range blocks {
+		report.BlocksScanned++
+		blockTime := time.Unix(block.Timestamp, 0)
+
+		for _, tx := range block.Transactions {
+			report.TransactionsReplayed++
+
+			for _, input := range tx.Vin {
+				utxo, ok := utxoSet[utxoKey(input.TxID, input.Vout)]
+				if !ok || utxo.IsSpent {
+					report.Discrepancies = append(report.Discrepancies, ReindexDiscrepancy{
+						Kind:   "invalid_input",
+						TxID:   input.TxID,
+						Vout:   input.Vout,
+						Detail: fmt.Sprintf("block %d transaction %s spends a missing or already spent output", block.Index, tx.ID),
+					})
+					continue
+				}
+				utxo.IsSpent = true
+				utxo.SpentInTx = tx.ID
+			}
+
+			for vout, output := range tx.Vout {
+				key := utxoKey(tx.ID, vout)
+				if _, exists := utxoSet[key]; exists {
+					report.Discrepancies = append(report.Discrepancies, ReindexDiscrepancy{
+						Kind:   "duplicate_utxo",
+						TxID:   tx.ID,
+						Vout:   vout,
+						Detail: fmt.Sprintf("block %d recreates an existing output", block.Index),
+					})
+					continue
+				}
+				utxoSet[key] = &models.UTXO{
+					TxID:       tx.ID,
+					Vout:       vout,
+					WalletID:   output.PubKeyHash,
+					Amount:     output.Value,
+					IsCoinbase: blockchain.IsCoinbase(tx),
+					BlockIndex: block.Index,
+					CreatedAt:  blockTime,
+				}
+				utxoOrder = append(utxoOrder, key)
+			}
+
+			for _, txLog := range BuildTransactionLogs(tx, block.Hash, block.Index, "success") {
+				txLog.Timestamp = blockTime
+				txLogs = append(txLogs, txLog)
+			}
+		}
+	}
+
+	// Re-lock outputs referenced by transactions still waiting in the pool
+	pendingTxs, err := db.GetPendingTransactions()
+	if err != nil {
+		return nil, err
+	}
+	for _, ptx := range pendingTxs {
+		for _, input := range ptx.Transaction.Vin {
+			if utxo, ok := utxoSet[utxoKey(input.TxID, input.Vout)]; ok && !utxo.IsSpent {
+				utxo.IsLocked = true
+				utxo.LockedBy = ptx.ID
+			}
+		}
+	}
+
+	state := &db.LedgerState{Balances: make(map[string]models.Amount)}
+	for _, key := range utxoOrder {
+		utxo := utxoSet[key]
+		state.UTXOs = append(state.UTXOs, *utxo)
+		if !utxo.IsSpent {
+			state.Balances[utxo.WalletID] += utxo.Amount
+			report.UnspentUTXOs++
+		}
+	}
+	state.TransactionLogs = txLogs
+	report.UTXOsRebuilt = len(state.UTXOs)
+	report.TransactionLogs = len(txLogs)
+
+	if err := compareLedgerState(utxoSet, state.Balances, report); err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	if err := db.ReplaceLedgerState(state); err != nil {
+		return nil, err
+	}
+
+	wallets, err := db.GetAllWallets()
+	if err == nil {
+		report.WalletsUpdated = len(wallets)
+	}
+
+	log.Printf("🔁 Reindex complete: %d blocks, %d UTXOs, %d discrepancies", report.BlocksScanned, report.UTXOsRebuilt, len(report.Discrepancies))
+	LogSystemEvent("utxo_reindex", "", map[string]interface{}{
+		"blocks":        report.BlocksScanned,
+		"utxos":         report.UTXOsRebuilt,
+		"discrepancies": len(report.Discrepancies),
+	}, "info")
+
+	return report, nil
+}
+
+// compareLedgerState records differences between the stored UTXOs/balances and the replayed ones
+func compareLedgerState(expected map[string]*models.UTXO, balances map[string]models.Amount, report *ReindexReport) error {
+	existing, err := db.GetAllUTXOs()
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for _, actual := range existing {
+		key := utxoKey(actual.TxID, actual.Vout)
+		if seen[key] {
+			report.Discrepancies = append(report.Discrepancies, ReindexDiscrepancy{
+				Kind:     "duplicate_utxo",
+				TxID:     actual.TxID,
+				Vout:     actual.Vout,
+				WalletID: actual.WalletID,
+				Actual:   actual.Amount,
+				Detail:   "output is stored more than once",
+			})
+			continue
+		}
+		seen[key] = true
+
+		want, ok := expected[key]
+		if !ok {
+			report.Discrepancies = append(report.Discrepancies, ReindexDiscrepancy{
+				Kind:     "extra_utxo",
+				TxID:     actual.TxID,
+				Vout:     actual.Vout,
+				WalletID: actual.WalletID,
+				Actual:   actual.Amount,
+				Detail:   "output does not exist on the chain",
+			})
+			continue
+		}
+
+		if want.WalletID != actual.WalletID || want.Amount != actual.Amount || want.IsSpent != actual.IsSpent {
+			report.Discrepancies = append(report.Discrepancies, ReindexDiscrepancy{
+				Kind:     "utxo_mismatch",
+				TxID:     actual.TxID,
+				Vout:     actual.Vout,
+				WalletID: actual.WalletID,
+				Expected: want.Amount,
+				Actual:   actual.Amount,
+				Detail: fmt.Sprintf("expected owner=%s spent=%t, stored owner=%s spent=%t",
+					want.WalletID, want.IsSpent, actual.WalletID, actual.IsSpent),
+			})
+		}
+	}
+
+	var missing []string
+	for key := range expected {
+		if !seen[key] {
+			missing = append(missing, key)
+		}
+	}
+	sort.Strings(missing)
+	for _, key := range missing {
+		want := expected[key]
+		report.Discrepancies = append(report.Discrepancies, ReindexDiscrepancy{
+			Kind:     "missing_utxo",
+			TxID:     want.TxID,
+			Vout:     want.Vout,
+			WalletID: want.WalletID,
+			Expected: want.Amount,
+			Detail:   "output on the chain is not stored",
+		})
+	}
+
+	wallets, err := db.GetAllWallets()
+	if err != nil {
+		return err
+	}
+	for _, wallet := range wallets {
+		if wallet.Balance != balances[wallet.WalletID] {
+			report.Discrepancies = append(report.Discrepancies, ReindexDiscrepancy{
+				Kind:     "balance_mismatch",
+				WalletID: wallet.WalletID,
+				Expected: balances[wallet.WalletID],
+				Actual:   wallet.Balance,
+				Detail:   "cached wallet balance differs from replayed UTXOs",
+			})
+		}
+	}
+
+	return nil
+}
+
+func utxoKey(txID string, vout int) string {
+	return fmt.Sprintf("%s:%d", txID, vout)
+}
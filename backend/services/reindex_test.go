@@ -0,0 +1,33 @@
+package services
+
+import (
+	"crypto-wallet/db"
+	"crypto-wallet/models"
+	"testing"
+)
+
+func TestReindexRepairsUTXOSet(t *testing.T) {
+	alice := newTestChain(t)
+	reward := matureReward(t, alice)
+	mineBlock(t, "miner", alice.spend(t, reward, "bob", 10*models.Coin, 0))
+	expectConsistent(t)
+
+	if err := db.DeleteUTXOsByTransactionID(reward.TxID); err != nil {
+		t.Fatal(err)
+	}
+	report, err := ReindexUTXOs(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Discrepancies) == 0 {
+		t.Fatal("dry run missed the deleted output")
+	}
+	if _, err := db.GetUTXO(reward.TxID, reward.Vout); err == nil {
+		t.Fatal("dry run modified the UTXO set")
+	}
+
+	if _, err := ReindexUTXOs(false); err != nil {
+		t.Fatal(err)
+	}
+	expectConsistent(t)
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"crypto-wallet/blockchain"
+	"crypto-wallet/db"
+	"crypto-wallet/models"
+	"errors"
+	"testing"
+)
+
+// acceptStatus adds a block to the block tree and checks what happened to it
+func acceptStatus(t *testing.T, block models.Block, status string) *AcceptResult {
+	t.Helper()
+	result, err := AcceptBlock(block, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != status {
+		t.Fatalf("block %d was %s, want %s", block.Index, result.Status, status)
+	}
+	return result
+}
+
+// expectTip fails unless block is the tip of the active chain
+func expectTip(t *testing.T, block models.Block) {
+	t.Helper()
+	tip, err := db.GetLastBlock()
+	if err != nil || tip.Hash != block.Hash {
+		t.Fatalf("tip = %+v, %v, want block %d %s", tip, err, block.Index, block.Hash)
+	}
+}
+
+func TestEqualWorkDoesNotSwitchChains(t *testing.T) {
+	newTestChain(t)
+	first := mineBlock(t, "miner")
+	second := mineBlock(t, "miner")
+
+	rival := buildBlock(t, first, "rival")
+	result := acceptStatus(t, rival, "side_chain")
+	if result.TipHash != second.Hash {
+		t.Fatalf("tip moved to %s on a tie", result.TipHash)
+	}
+	expectTip(t, second)
+	if _, err := db.GetSideBlock(rival.Hash); err != nil {
+		t.Fatalf("rival not kept as a side block: %v", err)
+	}
+	if _, err := db.GetUTXO(rival.Transactions[0].ID, 0); err == nil {
+		t.Fatal("side block's coinbase was added to the UTXO set")
+	}
+	expectConsistent(t)
+}
+
+func TestReorganizeToMoreWork(t *testing.T) {
+	alice := newTestChain(t)
+	reward := matureReward(t, alice)
+	fork, err := db.GetLastBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := alice.spend(t, reward, "bob", 10*models.Coin, models.Coin)
+	spent := mineBlock(t, "miner", tx)
+
+	// A rival branch without the transfer overtakes the active chain
+	rival := buildBlock(t, *fork, "rival")
+	acceptStatus(t, rival, "side_chain")
+	rivalTip := buildBlock(t, rival, "rival")
+	result := acceptStatus(t, rivalTip, "reorganized")
+	if result.Disconnected != 1 || result.Connected != 2 {
+		t.Fatalf("reorganization = %+v", result)
+	}
+	expectTip(t, rivalTip)
+
+	// The transfer is undone and waits to be mined again
+	if utxo, err := db.GetUTXO(reward.TxID, reward.Vout); err != nil || utxo.IsSpent {
+		t.Fatalf("reward after reorganization = %+v, %v", utxo, err)
+	}
+	if _, err := db.GetUTXO(tx.ID, 0); err == nil {
+		t.Fatal("transfer output survived the reorganization")
+	}
+	if _, err := db.GetUTXO(rivalTip.Transactions[0].ID, 0); err != nil {
+		t.Fatalf("rival coinbase missing: %v", err)
+	}
+	pending, err := db.GetPendingTransactions()
+	if err != nil || len(pending) != 1 || pending[0].ID != tx.ID {
+		t.Fatalf("pending after reorganization = %+v, %v", pending, err)
+	}
+	if _, err := db.GetSideBlock(spent.Hash); err != nil {
+		t.Fatalf("old block not kept as a side block: %v", err)
+	}
+	for _, block := range []models.Block{rival, rivalTip} {
+		if _, err := db.GetSideBlock(block.Hash); err == nil {
+			t.Fatalf("connected block %d still stored as a side block", block.Index)
+		}
+	}
+	expectConsistent(t)
+}
+
+func TestFailedReorganizationRestoresChain(t *testing.T) {
+	alice := newTestChain(t)
+	reward := matureReward(t, alice)
+	fork, err := db.GetLastBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := alice.spend(t, reward, "bob", 10*models.Coin, models.Coin)
+	spent := mineBlock(t, "miner", tx)
+
+	// The second rival block overpays its coinbase, which is only checked
+	// once the branch is being connected
+	rival := buildBlock(t, *fork, "rival")
+	acceptStatus(t, rival, "side_chain")
+	invalid := buildBlock(t, rival, "rival")
+	coinbase := &invalid.Transactions[0]
+	coinbase.Amount += models.Coin
+	coinbase.Vout[0].Value += models.Coin
+	coinbase.ID = blockchain.TransactionID(*coinbase)
+	invalid.MerkleRoot = blockchain.CalculateMerkleRoot(invalid.Transactions)
+	blockchain.RunProofOfWork(&invalid)
+
+	var verr *blockchain.BlockValidationError
+	if _, err := AcceptBlock(invalid, nil); !errors.As(err, &verr) || verr.BlockHash != invalid.Hash {
+		t.Fatalf("branch with an invalid block: %v", err)
+	}
+
+	expectTip(t, spent)
+	if utxo, err := db.GetUTXO(reward.TxID, reward.Vout); err != nil || !utxo.IsSpent {
+		t.Fatalf("reward after restore = %+v, %v", utxo, err)
+	}
+	if _, err := db.GetUTXO(tx.ID, 0); err != nil {
+		t.Fatalf("transfer output missing after restore: %v", err)
+	}
+	if _, err := db.GetUTXO(rival.Transactions[0].ID, 0); err == nil {
+		t.Fatal("rival coinbase survived the restore")
+	}
+	if _, err := db.GetSideBlock(invalid.Hash); err == nil {
+		t.Fatal("invalid block kept as a side block")
+	}
+	if _, err := db.GetSideBlock(rival.Hash); err != nil {
+		t.Fatalf("valid rival block dropped: %v", err)
+	}
+	expectConsistent(t)
+}
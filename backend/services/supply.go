@@ -0,0 +1,96 @@
+package services
+
+import (
+	"crypto-wallet/blockchain"
+	"crypto-wallet/config"
+	"crypto-wallet/db"
+	"crypto-wallet/models"
+	"errors"
+)
+
+// SupplyReport describes the coins created by the active chain and where
+// they are now
+type SupplyReport struct {
+	Height             int           `json:"height"`
+	MaxSupply          models.Amount `json:"max_supply"`
+	Issued             models.Amount `json:"issued"`
+	Circulating        models.Amount `json:"circulating"`
+	Burned             models.Amount `json:"burned"`
+	ZakatPool          models.Amount `json:"zakat_pool"`
+	ZakatDeducted      models.Amount `json:"zakat_deducted"`
+	FeesPaid           models.Amount `json:"fees_paid"`
+	CurrentSubsidy     models.Amount `json:"current_subsidy"`
+	NextSubsidy        models.Amount `json:"next_subsidy"`
+	HalvingInterval    int           `json:"halving_interval"`
+	NextHalvingHeight  int           `json:"next_halving_height"`
+	BlocksUntilHalving int           `json:"blocks_until_halving"`
+}
+
+// GetSupply totals the new coins created by the coinbases of the active chain
+// and compares them with the unspent outputs. Coins that were issued but are
+// no longer held by any output, such as fees no coinbase claimed, are
+// reported as burned. Zakat deductions are recorded on chain without moving
+// coins; ZakatDeducted is their total and ZakatPool the balance held by the
+// zakat pool wallet.
+func GetSupply() (*SupplyReport, error) {
+	blocks, err := db.GetAllBlocks()
+	if err != nil {
+		return nil, err
+	}
+	if len(blocks) == 0 {
+		return nil, errors.New("blockchain is empty")
+	}
+
+	tip := blocks[len(blocks)-1]
+	report := &SupplyReport{
+		Height:            tip.Index,
+		MaxSupply:         config.AppConfig.MaxSupply,
+		CurrentSubsidy:    blockchain.BlockSubsidy(tip.Index),
+		NextSubsidy:       blockchain.BlockSubsidy(tip.Index + 1),
+		HalvingInterval:   config.AppConfig.HalvingInterval,
+		NextHalvingHeight: blockchain.NextHalvingHeight(tip.Index),
+	}
+	if report.NextHalvingHeight >= 0 {
+		report.BlocksUntilHalving = report.NextHalvingHeight - tip.Index
+	}
+
+	for _, block := range blocks {
+		var paid, fees models.Amount
+		for _, tx := range block.Transactions {
+			switch {
+			case blockchain.IsCoinbase(tx):
+				for _, output := range tx.Vout {
+					paid += output.Value
+				}
+			case tx.IsZakat:
+				report.ZakatDeducted += tx.Amount
+			default:
+				fees += tx.Fee
+			}
+		}
+		// The part of the coinbase that collects fees moves existing coins
+		if paid > fees {
+			report.Issued += paid - fees
+		}
+		report.FeesPaid += fees
+	}
+
+	utxos, err := db.GetAllUTXOs()
+	if err != nil {
+		return nil, err
+	}
+	for _, utxo := range utxos {
+		if utxo.IsSpent {
+			continue
+		}
+		report.Circulating += utxo.Amount
+		if utxo.WalletID == config.AppConfig.ZakatWalletID {
+			report.ZakatPool += utxo.Amount
+		}
+	}
+	if report.Issued > report.Circulating {
+		report.Burned = report.Issued - report.Circulating
+	}
+
+	return report, nil
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"crypto-wallet/blockchain"
+	"crypto-wallet/models"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// blockTemplateTTL is how long a template can be submitted against
+	blockTemplateTTL = 10 * time.Minute
+
+	// maxBlockTemplates bounds the templates kept awaiting a solution
+	maxBlockTemplates = 1000
+)
+
+var (
+	ErrTemplateNotFound   = errors.New("block template not found or expired")
+	ErrInvalidProofOfWork = errors.New("nonce does not satisfy the proof-of-work target")
+)
+
+// BlockTemplate is a block ready for proof of work by an external miner. The
+// block hash is the SHA-256 of Header with the 8-byte little-endian nonce
+// written at NonceOffset; it must not exceed Target.
+type BlockTemplate struct {
+	ID           string               `json:"template_id"`
+	Height       int                  `json:"height"`
+	Version      int                  `json:"version"`
+	PrevHash     string               `json:"prev_hash"`
+	MerkleRoot   string               `json:"merkle_root"`
+	Timestamp    int64                `json:"timestamp"`
+	Bits         uint32               `json:"bits"`
+	Target       string               `json:"target"`
+	MinedBy      string               `json:"mined_by"`
+	Header       string               `json:"header"`
+	NonceOffset  int                  `json:"nonce_offset"`
+	Coinbase     *models.Transaction  `json:"coinbase,omitempty"`
+	Transactions []models.Transaction `json:"transactions"`
+	ExpiresAt    time.Time            `json:"expires_at"`
+
+	userID string
+	block  models.Block
+}
+
+// blockTemplates holds issued templates until they are solved or expire
+var blockTemplates = struct {
+	sync.Mutex
+	byID map[string]*BlockTemplate
+}{byID: make(map[string]*BlockTemplate)}
+
+// GetBlockTemplate builds the next block for an external miner, with a
+// coinbase paying the reward to walletID
+func GetBlockTemplate(userID, walletID string) (*BlockTemplate, error) {
+	block, err := NewBlockTemplate(userID, walletID)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &BlockTemplate{
+		ID:           randomID(),
+		Height:       block.Index,
+		Version:      block.Version,
+		PrevHash:     block.PrevHash,
+		MerkleRoot:   block.MerkleRoot,
+		Timestamp:    block.Timestamp,
+		Bits:         block.Bits,
+		Target:       fmt.Sprintf("%064x", blockchain.CompactToBig(block.Bits)),
+		MinedBy:      block.MinedBy,
+		Header:       hex.EncodeToString(blockchain.SerializeBlockHeader(block)),
+		NonceOffset:  blockchain.HeaderNonceOffset(block),
+		Transactions: block.Transactions,
+		ExpiresAt:    time.Now().Add(blockTemplateTTL),
+		userID:       userID,
+		block:        block,
+	}
+
+	if last := block.Transactions[len(block.Transactions)-1]; blockchain.IsCoinbase(last) {
+		template.Coinbase = &last
+	}
+
+	blockTemplates.Lock()
+	defer blockTemplates.Unlock()
+	pruneBlockTemplates()
+	if len(blockTemplates.byID) >= maxBlockTemplates {
+		return nil, errors.New("too many outstanding block templates, try again later")
+	}
+	blockTemplates.byID[template.ID] = template
+	return template, nil
+}
+
+// SubmitBlockSolution completes a template with the miner's nonce and adds the
+// block to the chain. A template can only be solved once.
+func SubmitBlockSolution(userID, templateID string, nonce int64) (*models.Block, *AcceptResult, error) {
+	blockTemplates.Lock()
+	template, ok := blockTemplates.byID[templateID]
+	if !ok || template.userID != userID || time.Now().After(template.ExpiresAt) {
+		blockTemplates.Unlock()
+		return nil, nil, ErrTemplateNotFound
+	}
+	block := template.block
+	block.Nonce = int(nonce)
+	block.Hash = blockchain.CalculateHash(block)
+	if !blockchain.MeetsProofOfWork(block) {
+		blockTemplates.Unlock()
+		return nil, nil, ErrInvalidProofOfWork
+	}
+	delete(blockTemplates.byID, templateID)
+	blockTemplates.Unlock()
+
+	// A solution for an old tip still counts, as a side block
+	result, err := AcceptBlock(block, nil)
+	if err != nil {
+		LogSystemEvent("block_commit_failed", userID, map[string]interface{}{
+			"block_index": block.Index,
+			"error":       err.Error(),
+		}, "error")
+		return nil, nil, err
+	}
+
+	log.Printf("⛏️  Block %d submitted by external miner %s", block.Index, block.MinedBy)
+	LogMining(block.MinedBy, block.Index, block.Hash, len(block.Transactions))
+	return &block, result, nil
+}
+
+// pruneBlockTemplates drops expired templates
+func pruneBlockTemplates() {
+	now := time.Now()
+	for id, template := range blockTemplates.byID {
+		if now.After(template.ExpiresAt) {
+			delete(blockTemplates.byID, id)
+		}
+	}
+}
@@ -4,104 +4,153 @@ import (
 	"crypto-wallet/blockchain"
 	"crypto-wallet/crypto"
 	"crypto-wallet/db"
+	"crypto-wallet/keymanager"
 	"crypto-wallet/models"
 	"errors"
-	"fmt"
 	"time"
-
-	"crypto/sha256"
-	"encoding/hex"
 )
 
 // CreateTransaction creates a new transaction with digital signature verification
-func CreateTransaction(senderWalletID, receiverWalletID string, amount float64, note string, privateKeyStr string) (*models.Transaction, error) {
-	// Validate sender wallet exists
+func CreateTransaction(senderWalletID, receiverWalletID string, amount, fee models.Amount, note string, signer keymanager.Signer) (*models.Transaction, error) {
+	transaction, selectedUTXOs, err := BuildTransaction(senderWalletID, receiverWalletID, amount, fee, note)
+	if err != nil {
+		return nil, err
+	}
 	sender, err := db.GetUserByWalletID(senderWalletID)
+	if err != nil {
+		return nil, errors.New("sender wallet not found")
+	}
+
+	// Sign each input over its own signature hash, which commits to all
+	// inputs and outputs and to the output the input spends
+	for i, utxo := range selectedUTXOs {
+		signatureHash := blockchain.InputSignatureHash(*transaction, i, utxo)
+		signature, err := signer.Sign(signatureHash)
+		if err != nil {
+			LogSystemEvent("signature_failure", sender.ID, map[string]interface{}{
+				"error": err.Error(),
+			}, "error")
+			return nil, errors.New("failed to sign transaction")
+		}
+
+		// Verify signature with public key
+		err = crypto.VerifySignature(signatureHash, signature, sender.PublicKey)
+		if err != nil {
+			LogSystemEvent("signature_verification_failed", sender.ID, map[string]interface{}{
+				"error": err.Error(),
+			}, "error")
+			return nil, errors.New("signature verification failed")
+		}
+
+		transaction.Vin[i].Signature = signature
+	}
+
+	// Add to pending transactions
+	txID := transaction.ID
+	pendingTx := &models.PendingTransaction{
+		ID:          txID,
+		Transaction: *transaction,
+	}
+
+	err = db.AddPendingTransaction(pendingTx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Lock the UTXOs to prevent double-spending in other pending transactions
+	err = blockchain.LockUTXOs(selectedUTXOs, txID)
+	if err != nil {
+		// If locking fails, release the inputs already locked and remove the pending transaction
+		db.UnlockUTXOsByPendingTx(txID)
+		db.DeletePendingTransaction(txID)
+		LogSystemEvent("utxo_lock_failed", sender.ID, map[string]interface{}{
+			"tx_id": txID,
+			"error": err.Error(),
+		}, "error")
+		return nil, errors.New("failed to lock UTXOs: " + err.Error())
+	}
+
+	// Log the transaction
+	LogSystemEvent("transaction_created", sender.ID, map[string]interface{}{
+		"tx_id":    txID,
+		"amount":   amount.String(),
+		"fee":      fee.String(),
+		"receiver": receiverWalletID,
+	}, "info")
+
+	notifyTransactionAccepted(*transaction)
+	return transaction, nil
+}
+
+// BuildTransaction selects the sender's UTXOs and assembles an unsigned
+// transfer paying amount to the receiver, leaving fee for the miner and
+// returning the change to the sender. The UTXOs spent by each input are
+// returned in input order. Nothing is locked or stored. The sender may be a
+// primary wallet or one derived from an HD account.
+func BuildTransaction(senderWalletID, receiverWalletID string, amount, fee models.Amount, note string) (*models.Transaction, []models.UTXO, error) {
+	// Validate sender wallet exists
+	sender, err := db.GetWallet(senderWalletID)
 	if err != nil {
 		LogSystemEvent("invalid_wallet", "", map[string]interface{}{
 			"wallet_id": senderWalletID,
 			"error":     "sender wallet not found",
 		}, "error")
-		return nil, errors.New("sender wallet not found")
+		return nil, nil, errors.New("sender wallet not found")
 	}
 
 	// Validate receiver wallet exists
 	if err := db.ValidateWalletExists(receiverWalletID); err != nil {
-		LogSystemEvent("invalid_wallet", sender.ID, map[string]interface{}{
+		LogSystemEvent("invalid_wallet", sender.UserID, map[string]interface{}{
 			"wallet_id": receiverWalletID,
 			"error":     "receiver wallet not found",
 		}, "error")
-		return nil, errors.New("receiver wallet not found")
+		return nil, nil, errors.New("receiver wallet not found")
 	}
 
 	// Check if amount is positive
 	if amount <= 0 {
-		return nil, errors.New("amount must be positive")
+		return nil, nil, errors.New("amount must be positive")
+	}
+	if fee < 0 {
+		return nil, nil, errors.New("fee must not be negative")
+	}
+	total, ok := blockchain.AddAmounts(amount, fee)
+	if !ok {
+		return nil, nil, errors.New("amount plus fee exceeds the maximum money supply")
 	}
 
-	// Select UTXOs to cover the amount
-	selectedUTXOs, change, err := blockchain.SelectUTXOs(senderWalletID, amount)
+	// Select UTXOs to cover the amount and fee; the fee is whatever the
+	// outputs leave unclaimed
+	selectedUTXOs, change, err := blockchain.SelectUTXOs(senderWalletID, total)
 	if err != nil {
-		LogSystemEvent("insufficient_balance", sender.ID, map[string]interface{}{
+		LogSystemEvent("insufficient_balance", sender.UserID, map[string]interface{}{
 			"wallet_id": senderWalletID,
-			"amount":    amount,
+			"amount":    amount.String(),
 			"error":     err.Error(),
 		}, "warning")
-		return nil, err
-	}
-
-	// Create transaction inputs from selected UTXOs
-	var inputs []models.TXInput
-	timestamp := time.Now().Unix()
-
-	// Create signature data
-	signatureData := crypto.CreateTransactionSignatureData(
-		senderWalletID,
-		receiverWalletID,
-		amount,
-		timestamp,
-		note,
-	)
-
-	// Sign the transaction
-	signature, err := crypto.SignData(signatureData, privateKeyStr)
-	if err != nil {
-		LogSystemEvent("signature_failure", sender.ID, map[string]interface{}{
-			"error": err.Error(),
-		}, "error")
-		return nil, errors.New("failed to sign transaction")
-	}
-
-	// Verify signature with public key
-	err = crypto.VerifySignature(signatureData, signature, sender.PublicKey)
-	if err != nil {
-		LogSystemEvent("signature_verification_failed", sender.ID, map[string]interface{}{
-			"error": err.Error(),
-		}, "error")
-		return nil, errors.New("signature verification failed")
+		return nil, nil, err
 	}
 
 	// Double-spend prevention: Validate all UTXOs are not spent
 	for _, utxo := range selectedUTXOs {
 		if err := blockchain.ValidateUTXONotSpent(utxo.TxID, utxo.Vout); err != nil {
-			LogSystemEvent("double_spend_attempt", sender.ID, map[string]interface{}{
+			LogSystemEvent("double_spend_attempt", sender.UserID, map[string]interface{}{
 				"tx_id": utxo.TxID,
 				"vout":  utxo.Vout,
 				"error": err.Error(),
 			}, "error")
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
-	// Create inputs
+	// Create inputs from selected UTXOs
+	var inputs []models.TXInput
 	for _, utxo := range selectedUTXOs {
-		input := models.TXInput{
-			TxID:      utxo.TxID,
-			Vout:      utxo.Vout,
-			Signature: signature,
-			PubKey:    sender.PublicKey,
-		}
-		inputs = append(inputs, input)
+		inputs = append(inputs, models.TXInput{
+			TxID:   utxo.TxID,
+			Vout:   utxo.Vout,
+			PubKey: sender.PublicKey,
+		})
 	}
 
 	// Create outputs
@@ -123,72 +172,40 @@ func CreateTransaction(senderWalletID, receiverWalletID string, amount float64,
 		})
 	}
 
-	// Create transaction ID
-	txID := generateTransactionID(senderWalletID, receiverWalletID, amount, timestamp)
-
 	transaction := &models.Transaction{
-		ID:         txID,
+		Version:    blockchain.TransactionVersion,
 		Vin:        inputs,
 		Vout:       outputs,
-		Timestamp:  timestamp,
+		Timestamp:  time.Now().Unix(),
 		SenderID:   senderWalletID,
 		ReceiverID: receiverWalletID,
 		Amount:     amount,
+		Fee:        fee,
 		Note:       note,
 		IsZakat:    false,
 		Type:       "transfer",
 	}
 
-	// Add to pending transactions
-	pendingTx := &models.PendingTransaction{
-		ID:          txID,
-		Transaction: *transaction,
-	}
-
-	err = db.AddPendingTransaction(pendingTx)
-	if err != nil {
-		return nil, err
-	}
+	// The ID is derived from the canonical encoding (signatures excluded)
+	transaction.ID = blockchain.TransactionID(*transaction)
 
-	// Lock the UTXOs to prevent double-spending in other pending transactions
-	err = blockchain.LockUTXOs(selectedUTXOs, txID)
-	if err != nil {
-		// If locking fails, remove the pending transaction
-		db.DeletePendingTransaction(txID)
-		LogSystemEvent("utxo_lock_failed", sender.ID, map[string]interface{}{
-			"tx_id": txID,
-			"error": err.Error(),
-		}, "error")
-		return nil, errors.New("failed to lock UTXOs: " + err.Error())
-	}
-
-	// Log the transaction
-	LogSystemEvent("transaction_created", sender.ID, map[string]interface{}{
-		"tx_id":    txID,
-		"amount":   amount,
-		"receiver": receiverWalletID,
-	}, "info")
-
-	return transaction, nil
+	return transaction, selectedUTXOs, nil
 }
 
 // CreateZakatTransaction creates a Zakat deduction transaction
-func CreateZakatTransaction(walletID string, amount float64) (*models.Transaction, error) {
+func CreateZakatTransaction(walletID string, amount models.Amount) (*models.Transaction, error) {
 	user, err := db.GetUserByWalletID(walletID)
 	if err != nil {
 		return nil, errors.New("wallet not found")
 	}
 
-	timestamp := time.Now().Unix()
-	txID := generateTransactionID(walletID, "zakat_pool", amount, timestamp)
-
 	// For Zakat, we create a simplified transaction without inputs
 	// In a real system, you'd still select UTXOs
 	transaction := &models.Transaction{
-		ID:         txID,
+		Version:    blockchain.TransactionVersion,
 		Vin:        []models.TXInput{},
 		Vout:       []models.TXOutput{},
-		Timestamp:  timestamp,
+		Timestamp:  time.Now().Unix(),
 		SenderID:   walletID,
 		ReceiverID: "zakat_pool",
 		Amount:     amount,
@@ -196,39 +213,26 @@ func CreateZakatTransaction(walletID string, amount float64) (*models.Transactio
 		IsZakat:    true,
 		Type:       "zakat_deduction",
 	}
+	txID := blockchain.TransactionID(*transaction)
+	transaction.ID = txID
 
 	LogSystemEvent("zakat_deduction", user.ID, map[string]interface{}{
 		"tx_id":     txID,
-		"amount":    amount,
+		"amount":    amount.String(),
 		"wallet_id": walletID,
 	}, "info")
 
 	return transaction, nil
 }
 
-// VerifyTransactionSignature verifies the digital signature of a transaction
+// VerifyTransactionSignature verifies the signature of every input of a
+// transaction against the owner of the output it spends
 func VerifyTransactionSignature(tx models.Transaction) error {
-	if len(tx.Vin) == 0 {
-		// Zakat or genesis transactions might not have inputs
-		return nil
-	}
-
-	// Get the first input's public key
-	pubKey := tx.Vin[0].PubKey
-	signature := tx.Vin[0].Signature
-
-	// Recreate signature data
-	signatureData := crypto.CreateTransactionSignatureData(
-		tx.SenderID,
-		tx.ReceiverID,
-		tx.Amount,
-		tx.Timestamp,
-		tx.Note,
-	)
-
-	// Verify signature
-	err := crypto.VerifySignature(signatureData, signature, pubKey)
+	spent, err := blockchain.SpentOutputs(tx)
 	if err != nil {
+		return err
+	}
+	if err := blockchain.VerifyTransactionSignature(tx, spent); err != nil {
 		return errors.New("invalid transaction signature")
 	}
 
@@ -237,6 +241,11 @@ func VerifyTransactionSignature(tx models.Transaction) error {
 
 // ProcessTransaction validates and processes a transaction
 func ProcessTransaction(tx models.Transaction) error {
+	// The ID must match the transaction's canonical contents
+	if tx.ID != blockchain.TransactionID(tx) {
+		return errors.New("transaction ID does not match its contents")
+	}
+
 	// Verify signature
 	if err := VerifyTransactionSignature(tx); err != nil {
 		LogSystemEvent("transaction_validation_failed", "", map[string]interface{}{
@@ -246,35 +255,36 @@ func ProcessTransaction(tx models.Transaction) error {
 		return err
 	}
 
-	// Validate sender has sufficient balance
-	balance, err := blockchain.GetBalance(tx.SenderID)
+	// Validate sender has sufficient balance, not counting immature mining rewards
+	balance, _, err := blockchain.GetSpendableBalance(tx.SenderID)
 	if err != nil {
 		return err
 	}
 
-	if balance < tx.Amount {
+	if balance < tx.Amount+tx.Fee {
 		return errors.New("insufficient balance")
 	}
 
-	// Double-spend check
+	height, err := blockchain.NextBlockHeight()
+	if err != nil {
+		return err
+	}
+
+	// Double-spend and coinbase maturity checks
 	for _, input := range tx.Vin {
 		if err := blockchain.ValidateUTXONotSpent(input.TxID, input.Vout); err != nil {
 			return err
 		}
+		if err := blockchain.ValidateUTXOMature(input.TxID, input.Vout, height); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// generateTransactionID generates a unique transaction ID
-func generateTransactionID(senderID, receiverID string, amount float64, timestamp int64) string {
-	data := fmt.Sprintf("%s%s%.8f%d", senderID, receiverID, amount, timestamp)
-	hash := sha256.Sum256([]byte(data))
-	return hex.EncodeToString(hash[:])
-}
-
-// CreateTransactionLogs creates transaction logs for sender and receiver
-func CreateTransactionLogs(tx models.Transaction, blockHash string, blockIndex int, status string) error {
+// BuildTransactionLogs prepares the sender and receiver logs for a transaction
+func BuildTransactionLogs(tx models.Transaction, blockHash string, blockIndex int, status string) []models.TransactionLog {
 	// Log for sender
 	senderLog := models.TransactionLog{
 		WalletID:     tx.SenderID,
@@ -287,15 +297,13 @@ func CreateTransactionLogs(tx models.Transaction, blockHash string, blockIndex i
 		Status:       status,
 		Note:         tx.Note,
 	}
-	
+
 	sender, err := db.GetUserByWalletID(tx.SenderID)
 	if err == nil {
 		senderLog.UserID = sender.ID
 	}
-	
-	if err := db.CreateTransactionLog(&senderLog); err != nil {
-		return err
-	}
+
+	logs := []models.TransactionLog{senderLog}
 
 	// Log for receiver (skip for zakat pool)
 	if tx.ReceiverID != "zakat_pool" {
@@ -310,20 +318,53 @@ func CreateTransactionLogs(tx models.Transaction, blockHash string, blockIndex i
 			Status:       status,
 			Note:         tx.Note,
 		}
-		
+
 		receiver, err := db.GetUserByWalletID(tx.ReceiverID)
 		if err == nil {
 			receiverLog.UserID = receiver.ID
 		}
-		
-		if err := db.CreateTransactionLog(&receiverLog); err != nil {
+
+		logs = append(logs, receiverLog)
+	}
+
+	return logs
+}
+
+// CreateTransactionLogs creates transaction logs for sender and receiver
+func CreateTransactionLogs(tx models.Transaction, blockHash string, blockIndex int, status string) error {
+	logs := BuildTransactionLogs(tx, blockHash, blockIndex, status)
+	for i := range logs {
+		if err := db.CreateTransactionLog(&logs[i]); err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
 
+// ConnectBlock commits a mined block and every state change it causes
+// (UTXOs, pending pool, logs, zakat records, balances) as one unit of work
+func ConnectBlock(block models.Block, zakatRecords []models.ZakatRecord) error {
+	commit := db.NewBlockCommit(block)
+
+	for _, tx := range block.Transactions {
+		commit.TransactionLogs = append(commit.TransactionLogs, BuildTransactionLogs(tx, block.Hash, block.Index, "success")...)
+	}
+
+	for _, record := range zakatRecords {
+		record.BlockHash = block.Hash
+		record.BlockIndex = block.Index
+		commit.ZakatRecords = append(commit.ZakatRecords, record)
+	}
+
+	return db.CommitBlock(commit)
+}
+
+// DisconnectBlock rolls back a tip block, restoring the UTXOs it spent and
+// returning its transfers to the pending pool
+func DisconnectBlock(block models.Block) error {
+	return db.RollbackBlock(db.NewBlockCommit(block))
+}
+
 // RecalculateUserBalance recalculates a user's balance based on their UTXOs
 func RecalculateUserBalance(walletID string) error {
 	user, err := db.GetUserByWalletID(walletID)
@@ -338,7 +379,7 @@ func RecalculateUserBalance(walletID string) error {
 	}
 
 	// Calculate total balance
-	var balance float64
+	var balance models.Amount
 	for _, utxo := range utxos {
 		if !utxo.IsSpent {
 			balance += utxo.Amount
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"crypto-wallet/blockchain"
+	"crypto-wallet/db"
+	"crypto-wallet/models"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestBuildTransactionRejectsAmountOverflow(t *testing.T) {
+	alice := newTestChain(t)
+	matureReward(t, alice)
+	if err := db.CreateWallet(&models.Wallet{WalletID: "bob", UserID: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The sum is checked before selection, so it can neither wrap negative
+	// nor exceed the money supply
+	for _, amount := range []models.Amount{math.MaxInt64, blockchain.MaxMoney()} {
+		if _, _, err := BuildTransaction(alice.id, "bob", amount, 1, ""); err == nil || !strings.Contains(err.Error(), "exceeds the maximum") {
+			t.Fatalf("amount %d: %v", amount, err)
+		}
+	}
+	if _, _, err := BuildTransaction(alice.id, "bob", 10*models.Coin, models.Coin, ""); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"crypto-wallet/config"
 	"crypto-wallet/db"
 	"crypto-wallet/models"
+	"errors"
 	"log"
+	"math"
 	"time"
 )
 
@@ -19,7 +21,7 @@ func RunZakatDeduction() error {
 		return err
 	}
 
-	zakatPercentage := config.AppConfig.ZakatPercentage / 100.0 // Convert 2.5 to 0.025
+	zakatBasisPoints := int64(math.Round(config.AppConfig.ZakatPercentage * 100)) // Convert 2.5% to 250 bps
 	now := time.Now()
 	month := int(now.Month())
 	year := now.Year()
@@ -40,10 +42,10 @@ func RunZakatDeduction() error {
 		}
 
 		// Calculate Zakat amount (2.5%)
-		zakatAmount := balance * zakatPercentage
+		zakatAmount := balance.MulFraction(zakatBasisPoints, 10000)
 
-		// Skip if zakat amount is negligible
-		if zakatAmount < 0.01 {
+		// Skip if zakat amount is negligible (below 0.01)
+		if zakatAmount < models.Coin/100 {
 			continue
 		}
 
@@ -65,24 +67,17 @@ func RunZakatDeduction() error {
 		// Log Zakat deduction
 		LogZakatDeduction(user.ID, user.WalletID, zakatAmount, balance)
 
-		log.Printf("✅ Zakat deducted from %s: %.2f (Balance: %.2f)", user.WalletID, zakatAmount, balance)
+		log.Printf("✅ Zakat deducted from %s: %s (Balance: %s)", user.WalletID, zakatAmount, balance)
 	}
 
-	// If there are Zakat transactions, mine them into a block
+	// If there are Zakat transactions, mine them into a block together with their records
 	if len(zakatTransactions) > 0 {
-		err := MineZakatBlock(zakatTransactions)
-		if err != nil {
-			log.Printf("Error mining Zakat block: %v", err)
-			return err
-		}
-
-		// Create Zakat records
+		var zakatRecords []models.ZakatRecord
 		for _, tx := range zakatTransactions {
 			user, _ := db.GetUserByWalletID(tx.SenderID)
 			balance, _ := blockchain.GetBalance(tx.SenderID)
-			
+
 			zakatRecord := models.ZakatRecord{
-				UserID:   user.ID,
 				WalletID: tx.SenderID,
 				Amount:   tx.Amount,
 				Balance:  balance,
@@ -90,11 +85,16 @@ func RunZakatDeduction() error {
 				Month:    month,
 				Year:     year,
 			}
-
-			err := db.CreateZakatRecord(&zakatRecord)
-			if err != nil {
-				log.Printf("Error creating Zakat record: %v", err)
+			if user != nil {
+				zakatRecord.UserID = user.ID
 			}
+			zakatRecords = append(zakatRecords, zakatRecord)
+		}
+
+		err := MineZakatBlock(zakatTransactions, zakatRecords)
+		if err != nil {
+			log.Printf("Error mining Zakat block: %v", err)
+			return err
 		}
 
 		log.Printf("🕌 Zakat deduction completed. %d transactions processed.", len(zakatTransactions))
@@ -105,8 +105,40 @@ func RunZakatDeduction() error {
 	return nil
 }
 
-// MineZakatBlock mines a special block containing only Zakat transactions
-func MineZakatBlock(transactions []models.Transaction) error {
+// MineZakatBlock mines the Zakat transactions into special blocks containing
+// only Zakat transactions, as many as the block size limits require
+func MineZakatBlock(transactions []models.Transaction, zakatRecords []models.ZakatRecord) error {
+	for len(transactions) > 0 {
+		space := blockchain.NewBlockSpace()
+		n := 0
+		for n < len(transactions) && space.Add(transactions[n]) {
+			n++
+		}
+		if n == 0 {
+			return errors.New("zakat transaction does not fit in a block")
+		}
+
+		batch := make(map[string]bool, n)
+		for _, tx := range transactions[:n] {
+			batch[tx.ID] = true
+		}
+		var records []models.ZakatRecord
+		for _, record := range zakatRecords {
+			if batch[record.TxID] {
+				records = append(records, record)
+			}
+		}
+
+		if err := mineZakatBatch(transactions[:n], records); err != nil {
+			return err
+		}
+		transactions = transactions[n:]
+	}
+	return nil
+}
+
+// mineZakatBatch mines one block of Zakat transactions
+func mineZakatBatch(transactions []models.Transaction, zakatRecords []models.ZakatRecord) error {
 	// Get last block
 	lastBlock, err := db.GetLastBlock()
 	if err != nil {
@@ -117,13 +149,28 @@ func MineZakatBlock(transactions []models.Transaction) error {
 	// Calculate merkle root
 	merkleRoot := blockchain.CalculateMerkleRoot(transactions)
 
+	// Difficulty target for the next height
+	bits, err := blockchain.NextBits(*lastBlock, blockchain.LookupStoredBlock)
+	if err != nil {
+		log.Printf("Error computing difficulty: %v", err)
+		return err
+	}
+
+	// Stamp it after the median time of the previous blocks
+	timestamp, err := blockchain.NextBlockTime(*lastBlock, blockchain.LookupStoredBlock)
+	if err != nil {
+		log.Printf("Error computing block time: %v", err)
+		return err
+	}
+
 	// Create new block
 	newBlock := models.Block{
+		Version:      blockchain.BlockVersion,
 		Index:        lastBlock.Index + 1,
-		Timestamp:    time.Now().Unix(),
+		Timestamp:    timestamp,
 		Transactions: transactions,
 		PrevHash:     lastBlock.Hash,
-		Difficulty:   config.AppConfig.POWDifficulty,
+		Bits:         bits,
 		MerkleRoot:   merkleRoot,
 		MinedBy:      "system",
 	}
@@ -133,40 +180,12 @@ func MineZakatBlock(transactions []models.Transaction) error {
 	blockchain.RunProofOfWork(&newBlock)
 	log.Printf("✅ Zakat block %d mined! Hash: %s", newBlock.Index, newBlock.Hash)
 
-	// Insert block into database
-	err = db.InsertBlock(&newBlock)
+	// Validate and commit the block, its UTXOs, logs, zakat records and balances
+	_, err = AcceptBlock(newBlock, zakatRecords)
 	if err != nil {
 		return err
 	}
 
-	// Create UTXOs for all outputs in all transactions
-	for _, tx := range transactions {
-		err := blockchain.CreateUTXOsFromTransaction(tx, newBlock.Index)
-		if err != nil {
-			log.Printf("Error creating UTXOs for transaction %s: %v", tx.ID, err)
-		}
-
-		// Mark inputs as spent
-		for _, input := range tx.Vin {
-			err := db.MarkUTXOAsSpent(input.TxID, input.Vout, tx.ID)
-			if err != nil {
-				log.Printf("Error marking UTXO as spent: %v", err)
-			}
-		}
-
-		// Create transaction logs
-		err = CreateTransactionLogs(tx, newBlock.Hash, newBlock.Index, "success")
-		if err != nil {
-			log.Printf("Error creating transaction logs: %v", err)
-		}
-
-		// Recalculate balances
-		blockchain.RecalculateBalance(tx.SenderID)
-		if tx.ReceiverID != "zakat_pool" {
-			blockchain.RecalculateBalance(tx.ReceiverID)
-		}
-	}
-
 	// Log mining event
 	LogMining("system", newBlock.Index, newBlock.Hash, len(transactions))
 
@@ -47,10 +47,10 @@ export const AuthProvider = ({ children }) => {
         }
     };
 
-    const verifyOTP = async (email, otp) => {
+    const verifyOTP = async (email, otp, passphrase) => {
         setLoading(true);
         try {
-            const response = await authAPI.verifyOTP({ email, otp });
+            const response = await authAPI.verifyOTP({ email, otp, passphrase });
             const { token: newToken, user: userData } = response.data;
 
             localStorage.setItem('token', newToken);
@@ -67,10 +67,10 @@ export const AuthProvider = ({ children }) => {
         }
     };
 
-    const googleLogin = async (googleToken) => {
+    const googleLogin = async (googleToken, passphrase) => {
         setLoading(true);
         try {
-            const response = await authAPI.googleLogin({ token: googleToken });
+            const response = await authAPI.googleLogin({ token: googleToken, passphrase });
             const { token: newToken, user: userData } = response.data;
 
             localStorage.setItem('token', newToken);
@@ -18,6 +18,37 @@ api.interceptors.request.use((config) => {
     return config;
 });
 
+// Ledger amounts arrive as fixed-point decimal strings ("12.50000000");
+// convert them to numbers for display and arithmetic in the UI
+const AMOUNT_FIELDS = new Set([
+    'amount', 'value', 'balance', 'balance_at_deduction', 'total_balance',
+    'current_balance', 'total_sent', 'total_received', 'total_zakat',
+    'total_zakat_deducted', 'total_volume', 'total_zakat_collected', 'net_change',
+    'expected', 'actual', 'yearly_zakat',
+]);
+const AMOUNT_PATTERN = /^-?\d+\.\d{8}$/;
+
+const parseAmounts = (data, isAmount = false) => {
+    if (Array.isArray(data)) {
+        return data.map((item) => parseAmounts(item, isAmount));
+    }
+    if (data && typeof data === 'object') {
+        return Object.fromEntries(Object.entries(data).map(([key, value]) => [
+            key,
+            parseAmounts(value, isAmount || AMOUNT_FIELDS.has(key)),
+        ]));
+    }
+    if (isAmount && typeof data === 'string' && AMOUNT_PATTERN.test(data)) {
+        return Number(data);
+    }
+    return data;
+};
+
+api.interceptors.response.use((response) => {
+    response.data = parseAmounts(response.data);
+    return response;
+});
+
 // Auth APIs
 export const authAPI = {
     signup: (data) => api.post('/auth/signup', data),
@@ -31,7 +62,12 @@ export const authAPI = {
 export const userAPI = {
     getProfile: () => api.get('/user/profile'),
     updateProfile: (data) => api.put('/user/profile', data),
-    getPrivateKey: () => api.get('/user/private-key'),
+    getPrivateKey: (passphrase) => api.post('/user/private-key', { passphrase }),
+    exportKeystore: () => api.get('/user/keystore'),
+    importKeystore: (data) => api.post('/user/keystore/import', data),
+    changePassphrase: (data) => api.put('/user/passphrase', data),
+    enableCustody: (passphrase) => api.post('/user/custody', { passphrase }),
+    disableCustody: () => api.delete('/user/custody'),
     searchByEmail: (email) => api.get(`/user/search?email=${encodeURIComponent(email)}`),
 };
 
@@ -45,11 +81,16 @@ export const walletAPI = {
     removeBeneficiary: (walletId) => api.delete(`/wallet/beneficiary/${walletId}`),
     validateWallet: (walletId) => api.get(`/wallet/validate/${walletId}`),
     getBalance: (walletId) => api.get(`/wallet/balance/${walletId}`),
+    getHDWallets: () => api.get('/wallet/hd'),
+    createHDWallet: (data) => api.post('/wallet/hd/create', data),
+    restoreHDWallet: (data) => api.post('/wallet/hd/restore', data),
 };
 
 // Transaction APIs
 export const transactionAPI = {
     sendMoney: (data) => api.post('/transaction/send', data),
+    buildTransaction: (data) => api.post('/transaction/build', data),
+    submitTransaction: (transaction) => api.post('/transaction/submit', { transaction }),
     getHistory: () => api.get('/transaction/history'),
     getMyPending: () => api.get('/transaction/my-pending'),
     getZakatHistory: () => api.get('/transaction/zakat-history'),
@@ -65,6 +106,7 @@ export const blockchainAPI = {
     validate: () => api.get('/blockchain/validate'),
     validateAndRevert: () => api.post('/blockchain/validate-and-revert'),
     mine: () => api.post('/mining/mine'),
+    getMiningJob: (id) => api.get(`/mining/jobs/${id}`),
 };
 
 // Reports APIs
@@ -35,10 +35,16 @@ export default function Blockchain() {
         setMineResult(null);
         try {
             const response = await blockchainAPI.mine();
+            let job = response.data.job;
+            // Mining runs in the background; poll the job until it finishes
+            while (!job.finished_at) {
+                await new Promise((resolve) => setTimeout(resolve, 1000));
+                job = (await blockchainAPI.getMiningJob(job.id)).data.job;
+            }
             setMineResult({
-                success: true,
-                message: 'Block mined successfully!',
-                data: response.data
+                success: job.status === 'mined',
+                message: job.status === 'mined' ? 'Block mined successfully!' : job.error || `Mining ${job.status}`,
+                data: job
             });
             fetchBlockchain();
         } catch (error) {
@@ -188,7 +194,7 @@ export default function Blockchain() {
                                             <p className="font-semibold">{mineResult.message}</p>
                                             {mineResult.success && mineResult.data && (
                                                 <p className="text-sm mt-1">
-                                                    Block #{mineResult.data.block.index} | {mineResult.data.transactions_count} transactions
+                                                    Block #{mineResult.data.height} | {mineResult.data.transactions} transactions
                                                 </p>
                                             )}
                                         </div>
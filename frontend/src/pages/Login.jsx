@@ -7,6 +7,7 @@ import { GoogleLogin } from '@react-oauth/google';
 export default function Login() {
     const [email, setEmail] = useState('');
     const [otp, setOtp] = useState('');
+    const [passphrase, setPassphrase] = useState('');
     const [step, setStep] = useState(1); // 1 = email, 2 = OTP
     const [error, setError] = useState('');
     const [message, setMessage] = useState('');
@@ -31,7 +32,7 @@ export default function Login() {
         e.preventDefault();
         setError('');
 
-        const result = await verifyOTP(email, otp);
+        const result = await verifyOTP(email, otp, passphrase);
         if (!result.success) {
             setError(result.error);
         }
@@ -41,7 +42,7 @@ export default function Login() {
         setError('');
         setMessage('');
 
-        const result = await googleLogin(credentialResponse.credential);
+        const result = await googleLogin(credentialResponse.credential, passphrase);
         if (!result.success) {
             setError(result.error || 'Google login failed');
         }
@@ -117,6 +118,22 @@ export default function Login() {
                                 </div>
                             </div>
 
+                            <div>
+                                <label className="block text-gray-300 text-sm font-semibold mb-2">
+                                    Wallet Passphrase
+                                </label>
+                                <div className="relative">
+                                    <Lock className="absolute left-3 top-3 w-5 h-5 text-gray-400" />
+                                    <input
+                                        type="password"
+                                        value={passphrase}
+                                        onChange={(e) => setPassphrase(e.target.value)}
+                                        className="input-field pl-10"
+                                        placeholder="Required for new Google accounts"
+                                    />
+                                </div>
+                            </div>
+
                             <div className="relative">
                                 <button
                                     type="button"
@@ -166,6 +183,22 @@ export default function Login() {
                                 </p>
                             </div>
 
+                            <div>
+                                <label className="block text-gray-300 text-sm font-semibold mb-2">
+                                    Wallet Passphrase (optional)
+                                </label>
+                                <div className="relative">
+                                    <Lock className="absolute left-3 top-3 w-5 h-5 text-gray-400" />
+                                    <input
+                                        type="password"
+                                        value={passphrase}
+                                        onChange={(e) => setPassphrase(e.target.value)}
+                                        className="input-field pl-10"
+                                        placeholder="Sets one if your key has none yet"
+                                    />
+                                </div>
+                            </div>
+
                             <button
                                 type="submit"
                                 disabled={loading}
@@ -10,6 +10,7 @@ export default function Profile() {
     const [loading, setLoading] = useState(true);
     const [showPrivateKey, setShowPrivateKey] = useState(false);
     const [privateKey, setPrivateKey] = useState('');
+    const [passphrase, setPassphrase] = useState('');
     const [modal, setModal] = useState({ open: false, title: '', message: '', variant: 'info' });
 
     useEffect(() => {
@@ -68,8 +69,8 @@ export default function Profile() {
                         </h2>
                         <div className="grid grid-cols-1 md:grid-cols-3 gap-4">
                             <div className="surface p-4 border-white/10">
-                                <label className="text-gray-400 text-sm">Wallet ID</label>
-                                <p className="text-white font-mono text-sm break-all">{user?.wallet_id}</p>
+                                <label className="text-gray-400 text-sm">Wallet Address</label>
+                                <p className="text-white font-mono text-sm break-all">{user?.address || user?.wallet_id}</p>
                             </div>
                             <div className="surface p-4 border-white/10">
                                 <label className="text-gray-400 text-sm">Balance</label>
@@ -102,11 +103,12 @@ export default function Profile() {
                                 onClick={async () => {
                                     if (!showPrivateKey) {
                                         try {
-                                            const response = await userAPI.getPrivateKey();
-                                            setPrivateKey(response.data.private_key || response.data.encrypted_private_key);
+                                            const response = await userAPI.getPrivateKey(passphrase);
+                                            setPrivateKey(response.data.private_key);
                                             setShowPrivateKey(true);
+                                            setPassphrase('');
                                         } catch (error) {
-                                            setModal({ open: true, title: 'Could not retrieve key', message: 'Please try again or relogin.', variant: 'error' });
+                                            setModal({ open: true, title: 'Could not retrieve key', message: error.response?.data?.error || 'Please try again or relogin.', variant: 'error' });
                                         }
                                     } else {
                                         setShowPrivateKey(false);
@@ -140,7 +142,28 @@ export default function Profile() {
                             </div>
                         ) : (
                             <div className="bg-black/40 p-4 rounded-lg text-center border border-white/5">
-                                <p className="text-gray-500">••••••••••••••••••••••••••••</p>
+                                <p className="text-gray-500 mb-3">••••••••••••••••••••••••••••</p>
+                                <input
+                                    type="password"
+                                    value={passphrase}
+                                    onChange={(e) => setPassphrase(e.target.value)}
+                                    className="input-field"
+                                    placeholder="Wallet passphrase to reveal"
+                                />
+                                <button
+                                    onClick={async () => {
+                                        try {
+                                            await userAPI.enableCustody(passphrase);
+                                            setPassphrase('');
+                                            setModal({ open: true, title: 'Custody enabled', message: 'Transfers can now be sent without pasting your private key.', variant: 'success' });
+                                        } catch (error) {
+                                            setModal({ open: true, title: 'Could not enable custody', message: error.response?.data?.error || 'Please try again.', variant: 'error' });
+                                        }
+                                    }}
+                                    className="mt-3 text-sm text-[#7fffd4] hover:text-white"
+                                >
+                                    Let the server sign my transfers
+                                </button>
                             </div>
                         )}
                     </div>
@@ -1,7 +1,7 @@
 import { useState } from 'react';
 import { Link } from 'react-router-dom';
 import { useAuth } from '../AuthContext';
-import { Wallet, User, Mail, CreditCard, Loader } from 'lucide-react';
+import { Wallet, User, Mail, CreditCard, Lock, Loader } from 'lucide-react';
 import InfoModal from '../components/InfoModal';
 
 export default function Signup() {
@@ -9,6 +9,8 @@ export default function Signup() {
         full_name: '',
         email: '',
         cnic: '',
+        key_type: 'rsa',
+        passphrase: '',
     });
     const [otp, setOtp] = useState('');
     const [step, setStep] = useState(1); // 1 = signup, 2 = OTP
@@ -127,6 +129,44 @@ export default function Signup() {
                                 </div>
                             </div>
 
+                            <div>
+                                <label className="block text-gray-300 text-sm font-semibold mb-2">
+                                    Wallet Passphrase
+                                </label>
+                                <div className="relative">
+                                    <Lock className="absolute left-3 top-3 w-5 h-5 text-gray-400" />
+                                    <input
+                                        type="password"
+                                        name="passphrase"
+                                        value={formData.passphrase}
+                                        onChange={handleChange}
+                                        className="input-field pl-10"
+                                        placeholder="At least 8 characters"
+                                        minLength={8}
+                                        required
+                                    />
+                                </div>
+                                <p className="text-sm text-gray-400 mt-2">
+                                    Encrypts your private key. It cannot be recovered if lost.
+                                </p>
+                            </div>
+
+                            <div>
+                                <label className="block text-gray-300 text-sm font-semibold mb-2">
+                                    Wallet Key Type
+                                </label>
+                                <select
+                                    name="key_type"
+                                    value={formData.key_type}
+                                    onChange={handleChange}
+                                    className="input-field"
+                                >
+                                    <option value="rsa">RSA-2048</option>
+                                    <option value="secp256k1">ECDSA secp256k1</option>
+                                    <option value="ed25519">Ed25519</option>
+                                </select>
+                            </div>
+
                             <button
                                 type="submit"
                                 disabled={loading}
@@ -166,8 +206,8 @@ export default function Signup() {
                                         </button>
                                     </div>
                                     <div className="bg-white/5 border border-white/10 rounded-lg p-4">
-                                        <p className="text-gray-300 text-sm font-semibold mb-2">Your Wallet ID:</p>
-                                        <p className="text-white text-xs break-all font-mono">{walletInfo.wallet_id}</p>
+                                        <p className="text-gray-300 text-sm font-semibold mb-2">Your Wallet Address:</p>
+                                        <p className="text-white text-xs break-all font-mono">{walletInfo.address || walletInfo.wallet_id}</p>
                                     </div>
                                 </>
                             )}
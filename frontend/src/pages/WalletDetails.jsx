@@ -93,13 +93,13 @@ const WalletDetails = () => {
                         <div className="space-y-4">
                             {/* Wallet ID */}
                             <div>
-                                <label className="block text-sm text-gray-400 mb-2">Wallet ID</label>
+                                <label className="block text-sm text-gray-400 mb-2">Wallet Address</label>
                                 <div className="flex items-center gap-2">
                                     <code className="flex-1 bg-white/5 px-4 py-2 rounded-lg text-white font-mono text-sm break-all">
-                                        {user?.wallet_id}
+                                        {user?.address || user?.wallet_id}
                                     </code>
                                     <button
-                                        onClick={() => copyToClipboard(user?.wallet_id, 'wallet')}
+                                        onClick={() => copyToClipboard(user?.address || user?.wallet_id, 'wallet')}
                                         className="p-2 bg-white/10 hover:bg-white/20 rounded-lg transition-colors"
                                     >
                                         {copiedField === 'wallet' ? (